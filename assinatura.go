@@ -0,0 +1,244 @@
+package nfe
+
+import (
+	"crypto"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/beevik/etree"
+	dsig "github.com/russellhaering/goxmldsig"
+)
+
+// ErrAssinaturaInvalida indica que ao menos uma das assinaturas do XML não confere (ver AssinaturaVerificada.Motivo).
+var ErrAssinaturaInvalida = errors.New("assinatura XML inválida")
+
+// AssinaturaVerificada é o resultado da verificação de uma das assinaturas (Signature) de um XML.
+type AssinaturaVerificada struct {
+	Elemento     string            `json:"elemento"`         // Nome do elemento assinado (infNFe, infProt, infEvento...)
+	Id           string            `json:"id"`               // Id do elemento assinado, referenciado pela assinatura
+	Certificado  *x509.Certificate `json:"-"`                // Certificado do signatário, do KeyInfo
+	DigestValido bool              `json:"digestValido"`     // O DigestValue confere com o conteúdo atual do elemento assinado (o XML não foi alterado)
+	Valida       bool              `json:"valida"`           // O DigestValue confere e o SignatureValue foi gerado pela chave do certificado
+	Motivo       string            `json:"motivo,omitempty"` // Motivo da falha, quando a assinatura não é válida
+}
+
+// VerifySignature verifica todas as assinaturas XMLDSig do XML: a do emitente no infNFe ou no infEvento e, quando houver, a do autorizador no infProt (protNFe) ou no retEvento. Com isso é possível validar as notas recebidas (por exemplo, na distribuição DF-e) e detectar XMLs adulterados no arquivo.
+//
+// A canonicalização é a mesma usada na assinatura dos eventos (C14N 2001). Cada assinatura é retornada com o seu resultado; se alguma delas não conferir, o erro retornado é um ErrAssinaturaInvalida. A cadeia do certificado não é verificada aqui.
+func VerifySignature(xmlDoc []byte) ([]AssinaturaVerificada, error) {
+	doc := etree.NewDocument()
+	if err := doc.ReadFromBytes(xmlDoc); err != nil {
+		return nil, fmt.Errorf("Erro na leitura do XML: %w", err)
+	}
+	if doc.Root() == nil {
+		return nil, fmt.Errorf("XML vazio")
+	}
+
+	assinaturas := doc.FindElements("//Signature")
+	if len(assinaturas) == 0 {
+		return nil, fmt.Errorf("Nenhuma assinatura encontrada no XML")
+	}
+
+	ids := map[string][]*etree.Element{}
+	for _, el := range doc.FindElements("//*[@Id]") {
+		id := el.SelectAttrValue("Id", "")
+		ids[id] = append(ids[id], el)
+	}
+
+	var r []AssinaturaVerificada
+	var erros []error
+	for _, sig := range assinaturas {
+		v := verificaAssinatura(sig, ids)
+		if !v.Valida {
+			erros = append(erros, fmt.Errorf("%w: %s %s: %s", ErrAssinaturaInvalida, v.Elemento, v.Id, v.Motivo))
+		}
+		r = append(r, v)
+	}
+	return r, errors.Join(erros...)
+}
+
+// AssinaNFe assina o infNFe da NFe (isolada ou em um enviNFe) com o certificado e a chave informados, em PEM (ver FonteCertificado.PEM), incluindo a Signature ao final do elemento NFe. A assinatura usa os mesmos algoritmos da assinatura dos eventos (C14N 2001 e RSA-SHA1).
+func AssinaNFe(xmlNFe, certPEM, keyPEM []byte) ([]byte, error) {
+	doc := etree.NewDocument()
+	if err := doc.ReadFromBytes(xmlNFe); err != nil {
+		return nil, fmt.Errorf("Erro na leitura do XML da NFe: %w", err)
+	}
+	nfe := doc.FindElement("//NFe")
+	if nfe == nil {
+		return nil, fmt.Errorf("Nenhuma NFe encontrada no XML")
+	}
+	infNFe := nfe.SelectElement("infNFe")
+	if infNFe == nil {
+		return nil, fmt.Errorf("NFe sem o grupo infNFe")
+	}
+	if nfe.SelectElement("Signature") != nil {
+		return nil, fmt.Errorf("A NFe %s já está assinada", infNFe.SelectAttrValue("Id", ""))
+	}
+
+	sig, err := buildSignatureForInfEvento(doc, infNFe, certPEM, keyPEM)
+	if err != nil {
+		return nil, fmt.Errorf("Erro na assinatura da NFe. Detalhes: %w", err)
+	}
+	nfe.AddChild(sig)
+	return doc.WriteToBytes()
+}
+
+func verificaAssinatura(sig *etree.Element, ids map[string][]*etree.Element) AssinaturaVerificada {
+	var v AssinaturaVerificada
+	falha := func(motivo string, args ...any) AssinaturaVerificada {
+		v.Motivo = fmt.Sprintf(motivo, args...)
+		return v
+	}
+
+	if certB64 := textoElemento(sig, "KeyInfo/X509Data/X509Certificate"); certB64 != "" {
+		der, err := base64.StdEncoding.DecodeString(removeEspacos(certB64))
+		if err != nil {
+			return falha("certificado do KeyInfo inválido: %v", err)
+		}
+		if v.Certificado, err = x509.ParseCertificate(der); err != nil {
+			return falha("certificado do KeyInfo inválido: %v", err)
+		}
+	}
+
+	signedInfo := sig.FindElement("SignedInfo")
+	if signedInfo == nil {
+		return falha("assinatura sem SignedInfo")
+	}
+	ref := signedInfo.FindElement("Reference")
+	if ref == nil || len(signedInfo.FindElements("Reference")) != 1 {
+		return falha("a assinatura deve ter exatamente uma Reference")
+	}
+	uri := ref.SelectAttrValue("URI", "")
+	if !strings.HasPrefix(uri, "#") {
+		return falha("Reference URI não suportada: %q", uri)
+	}
+	v.Id = uri[1:]
+
+	// A referência precisa ser única e estar no mesmo elemento da assinatura (irmão, como o infNFe, ou pai), para que um elemento assinado não possa ser movido para outra parte do documento.
+	alvos := ids[v.Id]
+	if len(alvos) != 1 {
+		return falha("o Id %q deve identificar exatamente um elemento; encontrados %d", v.Id, len(alvos))
+	}
+	alvo := alvos[0]
+	v.Elemento = alvo.Tag
+	if alvo != sig.Parent() && alvo.Parent() != sig.Parent() {
+		return falha("o elemento assinado não é irmão nem pai da assinatura")
+	}
+
+	// Digest do elemento assinado. A transformação enveloped-signature só tem efeito quando a assinatura está dentro do elemento assinado.
+	hashDigest, err := algoritmoHash(ref.FindElement("DigestMethod"))
+	if err != nil {
+		return falha("%v", err)
+	}
+	var transformacao *etree.Element
+	for _, t := range ref.FindElements("Transforms/Transform") {
+		if t.SelectAttrValue("Algorithm", "") != string(dsig.EnvelopedSignatureAltorithmId) {
+			transformacao = t
+		}
+	}
+	canonRef, err := canonicalizador(transformacao)
+	if err != nil {
+		return falha("%v", err)
+	}
+	var conteudo []byte
+	if alvo == sig.Parent() {
+		i := sig.Index()
+		alvo.RemoveChildAt(i)
+		conteudo, err = canonRef.Canonicalize(alvo)
+		alvo.InsertChildAt(i, sig)
+	} else {
+		conteudo, err = canonRef.Canonicalize(alvo)
+	}
+	if err != nil {
+		return falha("erro na canonicalização do elemento assinado: %v", err)
+	}
+	digest := resumo(hashDigest, conteudo)
+	esperado, err := base64.StdEncoding.DecodeString(removeEspacos(textoElemento(ref, "DigestValue")))
+	if err != nil {
+		return falha("DigestValue inválido: %v", err)
+	}
+	if string(digest) != string(esperado) {
+		return falha("DigestValue divergente: o conteúdo de %s foi alterado após a assinatura", v.Elemento)
+	}
+	v.DigestValido = true
+
+	// SignatureValue sobre o SignedInfo canonicalizado.
+	if v.Certificado == nil {
+		return falha("assinatura sem o certificado (X509Certificate) do signatário")
+	}
+	pub, ok := v.Certificado.PublicKey.(*rsa.PublicKey)
+	if !ok {
+		return falha("a chave pública do certificado não é RSA")
+	}
+	hashAssinatura, err := algoritmoHash(signedInfo.FindElement("SignatureMethod"))
+	if err != nil {
+		return falha("%v", err)
+	}
+	canon, err := canonicalizador(signedInfo.FindElement("CanonicalizationMethod"))
+	if err != nil {
+		return falha("%v", err)
+	}
+	valor, err := base64.StdEncoding.DecodeString(removeEspacos(textoElemento(sig, "SignatureValue")))
+	if err != nil {
+		return falha("SignatureValue inválido: %v", err)
+	}
+	si, err := canon.Canonicalize(signedInfo)
+	if err != nil {
+		return falha("erro na canonicalização do SignedInfo: %v", err)
+	}
+	if err := rsa.VerifyPKCS1v15(pub, hashAssinatura, resumo(hashAssinatura, si), valor); err != nil {
+		return falha("SignatureValue não confere com o certificado de %s", v.Certificado.Subject.CommonName)
+	}
+	v.Valida = true
+	return v
+}
+
+// algoritmoHash retorna o hash do DigestMethod ou do SignatureMethod: SHA-1, usado no leiaute atual, ou SHA-256.
+func algoritmoHash(metodo *etree.Element) (crypto.Hash, error) {
+	if metodo == nil {
+		return 0, fmt.Errorf("algoritmo da assinatura não informado")
+	}
+	alg := metodo.SelectAttrValue("Algorithm", "")
+	switch {
+	case strings.HasSuffix(alg, "#sha1"), strings.HasSuffix(alg, "#rsa-sha1"):
+		return crypto.SHA1, nil
+	case strings.HasSuffix(alg, "#sha256"), strings.HasSuffix(alg, "#rsa-sha256"):
+		return crypto.SHA256, nil
+	}
+	return 0, fmt.Errorf("algoritmo não suportado: %q", alg)
+}
+
+func canonicalizador(metodo *etree.Element) (dsig.Canonicalizer, error) {
+	if metodo == nil {
+		return dsig.MakeC14N10RecCanonicalizer(), nil
+	}
+	switch alg := metodo.SelectAttrValue("Algorithm", ""); dsig.AlgorithmID(alg) {
+	case dsig.CanonicalXML10RecAlgorithmId:
+		return dsig.MakeC14N10RecCanonicalizer(), nil
+	case dsig.CanonicalXML10WithCommentsAlgorithmId:
+		return dsig.MakeC14N10WithCommentsCanonicalizer(), nil
+	case dsig.CanonicalXML10ExclusiveAlgorithmId:
+		return dsig.MakeC14N10ExclusiveCanonicalizerWithPrefixList(""), nil
+	default:
+		return nil, fmt.Errorf("canonicalização não suportada: %q", alg)
+	}
+}
+
+func resumo(h crypto.Hash, b []byte) []byte {
+	if h == crypto.SHA256 {
+		s := sha256.Sum256(b)
+		return s[:]
+	}
+	s := sha1.Sum(b)
+	return s[:]
+}
+
+func removeEspacos(s string) string {
+	return strings.Join(strings.Fields(s), "")
+}
@@ -0,0 +1,171 @@
+package nfe
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/beevik/etree"
+)
+
+// certificadoTeste gera um certificado autoassinado e a sua chave, em PEM.
+func certificadoTeste(t *testing.T, nome string) ([]byte, []byte) {
+	t.Helper()
+	chave, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	modelo := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: nome},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, modelo, modelo, &chave.PublicKey, chave)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(chave)})
+}
+
+// nfeProcAssinadoTeste monta um nfeProc com o infNFe assinado pelo emitente e o infProt assinado pela Sefaz.
+func nfeProcAssinadoTeste(t *testing.T) string {
+	t.Helper()
+	certEmit, chaveEmit := certificadoTeste(t, "EMITENTE LTDA:11222333000181")
+	certSefaz, chaveSefaz := certificadoTeste(t, "sefaz.sp.gov.br")
+
+	doc := etree.NewDocument()
+	proc := doc.CreateElement("nfeProc")
+	proc.CreateAttr("xmlns", xmlnsNFe)
+	proc.CreateAttr("versao", VerNFeProc)
+
+	nfe := proc.CreateElement("NFe")
+	infNFe := nfe.CreateElement("infNFe")
+	infNFe.CreateAttr("Id", "NFe"+chaveProcTeste)
+	infNFe.CreateAttr("versao", "4.00")
+	infNFe.CreateElement("emit").CreateElement("xNome").SetText("A & B LTDA")
+	infNFe.CreateElement("total").CreateElement("vNF").SetText("100.00")
+	sig, err := buildSignatureForInfEvento(doc, infNFe, certEmit, chaveEmit)
+	if err != nil {
+		t.Fatal(err)
+	}
+	nfe.AddChild(sig)
+
+	prot := proc.CreateElement("protNFe")
+	prot.CreateAttr("versao", "4.00")
+	infProt := prot.CreateElement("infProt")
+	infProt.CreateAttr("Id", "ID135240000000001")
+	infProt.CreateElement("chNFe").SetText(chaveProcTeste)
+	infProt.CreateElement("cStat").SetText("100")
+	sig, err = buildSignatureForInfEvento(doc, infProt, certSefaz, chaveSefaz)
+	if err != nil {
+		t.Fatal(err)
+	}
+	prot.AddChild(sig)
+
+	s, err := doc.WriteToString()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func TestVerifySignature(t *testing.T) {
+	proc := nfeProcAssinadoTeste(t)
+
+	assinaturas, err := VerifySignature([]byte(proc))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(assinaturas) != 2 {
+		t.Fatalf("%d assinaturas; esperadas 2", len(assinaturas))
+	}
+	for i, want := range []struct{ elemento, id, cn string }{
+		{"infNFe", "NFe" + chaveProcTeste, "EMITENTE LTDA:11222333000181"},
+		{"infProt", "ID135240000000001", "sefaz.sp.gov.br"},
+	} {
+		a := assinaturas[i]
+		if !a.Valida || !a.DigestValido || a.Elemento != want.elemento || a.Id != want.id || a.Certificado.Subject.CommonName != want.cn {
+			t.Errorf("assinatura %d = %+v; esperado %+v", i, a, want)
+		}
+	}
+
+	tests := []struct {
+		nome     string
+		xml      string
+		elemento string
+		digest   bool
+		motivo   string
+	}{
+		{"valor alterado", strings.Replace(proc, "100.00", "10.00", 1), "infNFe", false, "DigestValue divergente"},
+		{"situação alterada", strings.Replace(proc, "<cStat>100", "<cStat>101", 1), "infProt", false, "DigestValue divergente"},
+		{"SignatureValue alterado", strings.Replace(proc, "<SignatureValue>", "<SignatureValue>AAAA", 1), "infNFe", true, "SignatureValue não confere"},
+		{"Id duplicado", strings.Replace(proc, "<total>", `<total Id="NFe`+chaveProcTeste+`">`, 1), "", false, "exatamente um elemento"},
+	}
+	for _, tt := range tests {
+		assinaturas, err := VerifySignature([]byte(tt.xml))
+		if !errors.Is(err, ErrAssinaturaInvalida) {
+			t.Errorf("%s: erro %v; esperado ErrAssinaturaInvalida", tt.nome, err)
+			continue
+		}
+		var invalida *AssinaturaVerificada
+		for i := range assinaturas {
+			if !assinaturas[i].Valida {
+				invalida = &assinaturas[i]
+				break
+			}
+		}
+		if invalida == nil || invalida.Elemento != tt.elemento || invalida.DigestValido != tt.digest || !strings.Contains(invalida.Motivo, tt.motivo) {
+			t.Errorf("%s: %+v; esperado %s, digest %v, %q", tt.nome, invalida, tt.elemento, tt.digest, tt.motivo)
+		}
+	}
+
+	if _, err := VerifySignature([]byte(`<NFe><infNFe Id="NFe1"/></NFe>`)); err == nil || errors.Is(err, ErrAssinaturaInvalida) {
+		t.Errorf("XML sem assinatura: erro %v", err)
+	}
+}
+
+func TestVerifySignatureEvento(t *testing.T) {
+	cert, chave := certificadoTeste(t, "EMITENTE LTDA:11222333000181")
+	doc, err := buildEnvEventoDoc("1", []ManifestacaoEvento{{
+		COrgao: 91, TpAmb: 2, CNPJ: "11222333000181", ChNFe: chaveProcTeste,
+		DhEvento: time.Date(2024, 5, 20, 9, 0, 0, 0, time.FixedZone("", -3*3600)),
+		TpEvento: TpEventoCienciaOperacao, NSeqEvento: 1, VerEvento: "1.00", DescEvento: "Ciencia da Operacao",
+	}}, cert, chave)
+	if err != nil {
+		t.Fatal(err)
+	}
+	xmlEnv, err := doc.WriteToBytes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	assinaturas, err := VerifySignature(xmlEnv)
+	if err != nil || len(assinaturas) != 1 || assinaturas[0].Elemento != "infEvento" {
+		t.Errorf("VerifySignature(envEvento) = %+v, %v", assinaturas, err)
+	}
+}
+
+func TestAssinaNFe(t *testing.T) {
+	cert, key := certificadoTeste(t, "EMITENTE LTDA:11222333000181")
+	chave, xmlNFe := xmlNFeEmissao(t, 1, "100.00")
+
+	assinado, err := AssinaNFe(xmlNFe, cert, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assinaturas, err := VerifySignature(assinado)
+	if err != nil || len(assinaturas) != 1 || assinaturas[0].Id != "NFe"+chave || !assinaturas[0].Valida {
+		t.Errorf("assinaturas %+v, erro %v", assinaturas, err)
+	}
+	if _, err := AssinaNFe(assinado, cert, key); err == nil {
+		t.Error("NFe assinada duas vezes")
+	}
+}
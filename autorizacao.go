@@ -0,0 +1,184 @@
+package nfe
+
+import (
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/beevik/etree"
+)
+
+const VerEnviNFe = "4.00"
+const xmlnsAutorizacao = "http://www.portalfiscal.inf.br/nfe/wsdl/NFeAutorizacao4"
+const soapActionAutorizacao = "http://www.portalfiscal.inf.br/nfe/wsdl/NFeAutorizacao4/nfeAutorizacaoLote"
+const xmlnsRetAutorizacao = "http://www.portalfiscal.inf.br/nfe/wsdl/NFeRetAutorizacao4"
+const soapActionRetAutorizacao = "http://www.portalfiscal.inf.br/nfe/wsdl/NFeRetAutorizacao4/nfeRetAutorizacaoLote"
+
+// EnviNFe representa o XML de envio de um lote de NFe para autorização. As notas (NFe) devem estar assinadas e são incluídas sem alteração.
+type EnviNFe struct {
+	XMLName xml.Name `json:"-" xml:"http://www.portalfiscal.inf.br/nfe enviNFe"`
+	Versao  string   `json:"versao" xml:"versao,attr"`
+	IdLote  string   `json:"idLote" xml:"idLote"`
+	IndSinc int      `json:"indSinc" xml:"indSinc"`
+	NFe     []byte   `json:"-" xml:",innerxml"`
+}
+
+// RetEnviNFe representa o XML de retorno da Sefaz ao envio do lote. No processamento síncrono (indSinc 1) o protocolo da NFe vem no próprio retorno (cStat 104); no assíncrono (cStat 103) vem o recibo a ser consultado (ver ConsultaReciboNFe).
+type RetEnviNFe struct {
+	XMLName  xml.Name `json:"-" xml:"http://www.portalfiscal.inf.br/nfe retEnviNFe"`
+	Versao   string   `json:"versao" xml:"versao,attr"`
+	TpAmb    TAmb     `json:"tpAmb" xml:"tpAmb"`
+	VerAplic string   `json:"verAplic" xml:"verAplic"`
+	CStat    int      `json:"cStat" xml:"cStat"`
+	XMotivo  string   `json:"xMotivo" xml:"xMotivo"`
+	CUF      int      `json:"cUF" xml:"cUF"`
+	DhRecbto DateTime `json:"dhRecbto" xml:"dhRecbto"`
+	InfRec   *struct {
+		NRec string `json:"nRec" xml:"nRec"`
+		TMed int    `json:"tMed" xml:"tMed"`
+	} `json:"infRec,omitempty" xml:"infRec,omitempty"`
+	ProtNFe *ProtNFe `json:"protNFe,omitempty" xml:"protNFe,omitempty"`
+}
+
+// ConsReciNFe representa o XML de consulta do processamento de um lote enviado de forma assíncrona.
+type ConsReciNFe struct {
+	XMLName xml.Name `json:"-" xml:"http://www.portalfiscal.inf.br/nfe consReciNFe"`
+	Versao  string   `json:"versao" xml:"versao,attr"`
+	TpAmb   TAmb     `json:"tpAmb" xml:"tpAmb"`
+	NRec    string   `json:"nRec" xml:"nRec"`
+}
+
+// RetConsReciNFe representa o XML de retorno da Sefaz à consulta do recibo. cStat 105 indica que o lote ainda está em processamento; cStat 104, que os protocolos das notas estão disponíveis.
+type RetConsReciNFe struct {
+	XMLName  xml.Name  `json:"-" xml:"http://www.portalfiscal.inf.br/nfe retConsReciNFe"`
+	Versao   string    `json:"versao" xml:"versao,attr"`
+	TpAmb    TAmb      `json:"tpAmb" xml:"tpAmb"`
+	VerAplic string    `json:"verAplic" xml:"verAplic"`
+	NRec     string    `json:"nRec" xml:"nRec"`
+	CStat    int       `json:"cStat" xml:"cStat"`
+	XMotivo  string    `json:"xMotivo" xml:"xMotivo"`
+	CUF      int       `json:"cUF" xml:"cUF"`
+	DhRecbto DateTime  `json:"dhRecbto" xml:"dhRecbto"`
+	CMsg     string    `json:"cMsg,omitempty" xml:"cMsg,omitempty"`
+	XMsg     string    `json:"xMsg,omitempty" xml:"xMsg,omitempty"`
+	ProtNFe  []ProtNFe `json:"protNFe,omitempty" xml:"protNFe,omitempty"`
+}
+
+// Envia transmite o lote para o autorizador da NFe informada pela chave de acesso (uma das notas do lote), considerando o modelo (NFe ou NFC-e) e o tipo de emissão: notas emitidas em SVC (tpEmis 6/7) são enviadas ao autorizador de contingência da UF.
+func (env EnviNFe) Envia(chNFe string, tpAmb TAmb, client *http.Client, optReq ...func(req *http.Request)) (RetEnviNFe, []byte, error) {
+	ep, err := endpointAutorizacao(chNFe, tpAmb, Autorizacao)
+	if err != nil {
+		return RetEnviNFe{}, nil, err
+	}
+
+	xmlfile, normalizado, err := sendRequest(env, ep, xmlnsAutorizacao, soapActionAutorizacao, client, optReq...)
+	if err != nil {
+		return RetEnviNFe{}, nil, fmt.Errorf("Erro na comunicação com a Sefaz. Detalhes: %w", err)
+	}
+
+	var ret RetEnviNFe
+	err = xml.Unmarshal(normalizado, &ret)
+	if err != nil {
+		return RetEnviNFe{}, xmlfile, fmt.Errorf("Erro na desserialização do arquivo XML: %w. Arquivo: %s", err, xmlfile)
+	}
+
+	return ret, xmlfile, nil
+}
+
+// Função auxiliar para transmitir uma única NFe (XML assinado) de forma síncrona. xmlNFe pode ser a NFe isolada, o enviNFe ou o nfeProc, com exatamente uma NFe, cujos bytes originais são incluídos no lote. O identificador do lote é gerado a partir do horário do envio.
+func AutorizaNFe(xmlNFe []byte, client *http.Client, optReq ...func(req *http.Request)) (RetEnviNFe, []byte, error) {
+	brutos, err := elementosBrutos(xmlNFe, "NFe")
+	if err != nil {
+		return RetEnviNFe{}, nil, err
+	}
+	if len(brutos) != 1 {
+		return RetEnviNFe{}, nil, fmt.Errorf("O XML deve conter exatamente uma NFe; encontradas %d", len(brutos))
+	}
+	nfe, err := lerElemento(brutos[0])
+	if err != nil {
+		return RetEnviNFe{}, nil, err
+	}
+	chNFe, tpAmb, err := lerChaveNFe(nfe)
+	if err != nil {
+		return RetEnviNFe{}, nil, err
+	}
+
+	env := EnviNFe{
+		Versao:  VerEnviNFe,
+		IdLote:  strconv.FormatInt(time.Now().UnixNano()/int64(time.Microsecond)%1e15, 10),
+		IndSinc: 1,
+		NFe:     brutos[0],
+	}
+
+	return env.Envia(chNFe, tpAmb, client, optReq...)
+}
+
+// Consulta obtem o resultado do processamento do lote. A chave de acesso de uma das notas do lote determina o autorizador (ver EnviNFe.Envia).
+func (cons ConsReciNFe) Consulta(chNFe string, client *http.Client, optReq ...func(req *http.Request)) (RetConsReciNFe, []byte, error) {
+	ep, err := endpointAutorizacao(chNFe, cons.TpAmb, RetAutorizacao)
+	if err != nil {
+		return RetConsReciNFe{}, nil, err
+	}
+
+	xmlfile, normalizado, err := sendRequest(cons, ep, xmlnsRetAutorizacao, soapActionRetAutorizacao, client, optReq...)
+	if err != nil {
+		return RetConsReciNFe{}, nil, fmt.Errorf("Erro na comunicação com a Sefaz. Detalhes: %w", err)
+	}
+
+	var ret RetConsReciNFe
+	err = xml.Unmarshal(normalizado, &ret)
+	if err != nil {
+		return RetConsReciNFe{}, xmlfile, fmt.Errorf("Erro na desserialização do arquivo XML: %w. Arquivo: %s", err, xmlfile)
+	}
+
+	return ret, xmlfile, nil
+}
+
+// Função auxiliar para executar a ConsReciNFe.Consulta()
+func ConsultaReciboNFe(nRec string, chNFe string, tpAmb TAmb, client *http.Client, optReq ...func(req *http.Request)) (RetConsReciNFe, []byte, error) {
+	cons := ConsReciNFe{
+		Versao: VerEnviNFe,
+		TpAmb:  tpAmb,
+		NRec:   nRec,
+	}
+
+	return cons.Consulta(chNFe, client, optReq...)
+}
+
+// endpointAutorizacao obtem o WebService de autorização (ou de consulta do recibo) para a NFe da chave de acesso informada, de acordo com o seu tpEmis (ver Contingencia.EndpointChave).
+func endpointAutorizacao(chNFe string, tpAmb TAmb, ws TWebService) (Endpoint, error) {
+	return DefaultContingencia.EndpointChave(chNFe, tpAmb, ws)
+}
+
+// lerNFeXML retorna o elemento NFe de um XML (NFe isolada, enviNFe ou nfeProc).
+func lerNFeXML(xmlNFe []byte) (*etree.Element, error) {
+	doc := etree.NewDocument()
+	if err := doc.ReadFromBytes(xmlNFe); err != nil {
+		return nil, fmt.Errorf("Erro na leitura do XML da NFe: %w", err)
+	}
+	nfe := doc.FindElement("//NFe")
+	if nfe == nil {
+		return nil, fmt.Errorf("Nenhuma NFe encontrada no XML")
+	}
+	return nfe, nil
+}
+
+// lerChaveNFe extrai do elemento NFe, de qualquer modelo, a chave de acesso (do Id do infNFe) e o ambiente (tpAmb do ide), que determinam o autorizador.
+func lerChaveNFe(nfe *etree.Element) (string, TAmb, error) {
+	inf := nfe.SelectElement("infNFe")
+	if inf == nil {
+		return "", 0, fmt.Errorf("NFe sem o grupo infNFe")
+	}
+	chNFe := strings.TrimPrefix(inf.SelectAttrValue("Id", ""), "NFe")
+	if !ValidaChaveDeAcesso(chNFe) {
+		return "", 0, fmt.Errorf("Chave de acesso inválida no Id do infNFe: %q", chNFe)
+	}
+	tpAmb, err := strconv.Atoi(textoElemento(inf, "ide/tpAmb"))
+	if err != nil || (TAmb(tpAmb) != Producao && TAmb(tpAmb) != Homologacao) {
+		return "", 0, fmt.Errorf("tpAmb inválido na NFe %s", chNFe)
+	}
+	return chNFe, TAmb(tpAmb), nil
+}
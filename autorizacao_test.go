@@ -0,0 +1,180 @@
+package nfe
+
+import (
+	"bytes"
+	"encoding/xml"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// xmlNFeEmissao monta uma NFe de SP em homologação, sem assinatura.
+func xmlNFeEmissao(t *testing.T, nNF int, vNF string) (string, []byte) {
+	t.Helper()
+	chave, err := MontaChaveDeAcesso(35, 24, 5, "11222333000181", "55", 1, nNF, 1, 12345678)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return chave, []byte(`<?xml version="1.0" encoding="UTF-8"?><NFe xmlns="http://www.portalfiscal.inf.br/nfe"><infNFe Id="NFe` + chave + `" versao="4.00"><ide><cUF>35</cUF><mod>55</mod><tpAmb>2</tpAmb><dhEmi>2024-05-17T10:00:00-03:00</dhEmi></ide><total><ICMSTot><vNF>` + vNF + `</vNF></ICMSTot></total></infNFe></NFe>`)
+}
+
+func TestEnviNFeEnvia(t *testing.T) {
+	var corpo, soapAction string
+	resposta := fixtureSefaz(t, "SP/Autorizacao.xml")
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := io.ReadAll(r.Body)
+		corpo, soapAction = string(b), r.Header.Get("SOAPAction")
+		w.Write(resposta)
+	}))
+	defer srv.Close()
+	DefaultRegistry.Override(0, Homologacao, Autorizacao, srv.URL)
+	defer DefaultRegistry.RemoveOverride(0, Homologacao, Autorizacao)
+
+	chave, xmlNFe := xmlNFeEmissao(t, 123, "10.00")
+	env := EnviNFe{Versao: VerEnviNFe, IdLote: "1", IndSinc: 1, NFe: xmlNFe[bytes.Index(xmlNFe, []byte("<NFe")):]}
+	ret, xmlfile, err := env.Envia(chave, Homologacao, srv.Client())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.Contains(corpo, `<idLote>1</idLote><indSinc>1</indSinc><NFe xmlns="http://www.portalfiscal.inf.br/nfe"><infNFe Id="NFe`+chave) || strings.Contains(corpo, "<?xml version=\"1.0\" encoding=\"UTF-8\"?><NFe") {
+		t.Errorf("enviNFe sem a NFe incluída sem alteração:\n%s", corpo)
+	}
+	if soapAction != soapActionAutorizacao {
+		t.Errorf("SOAPAction = %s", soapAction)
+	}
+
+	if ret.CStat != 104 || ret.InfRec != nil || ret.ProtNFe == nil || !strings.HasPrefix(string(xmlfile), "<retEnviNFe") {
+		t.Fatalf("Envia = %+v", ret)
+	}
+	inf := ret.ProtNFe.InfProt
+	if inf.CStat != 100 || inf.NProt != "135240000004321" || inf.DigVal == "" || ret.DhRecbto.IsZero() {
+		t.Errorf("protNFe = %+v", inf)
+	}
+}
+
+func TestAutorizaNFe(t *testing.T) {
+	client := respondeFixture(t, Autorizacao, "SP/Autorizacao-103.xml")
+
+	_, xmlNFe := xmlNFeEmissao(t, 123, "10.00")
+	ret, _, err := AutorizaNFe(xmlNFe, client)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ret.CStat != 103 || ret.InfRec == nil || ret.InfRec.NRec != "351000012345678" || ret.ProtNFe != nil {
+		t.Errorf("AutorizaNFe = %+v", ret)
+	}
+
+	if _, _, err := AutorizaNFe([]byte(`<nfeProc/>`), client); err == nil {
+		t.Errorf("AutorizaNFe sem NFe deveria falhar")
+	}
+}
+
+func TestConsultaReciboNFe(t *testing.T) {
+	chave, _ := xmlNFeEmissao(t, 123, "10.00")
+
+	client := respondeFixture(t, RetAutorizacao, "SP/RetAutorizacao-105.xml")
+	ret, _, err := ConsultaReciboNFe("351000012345678", chave, Homologacao, client)
+	if err != nil || ret.CStat != 105 || len(ret.ProtNFe) != 0 {
+		t.Errorf("ConsultaReciboNFe em processamento = %+v, %v", ret, err)
+	}
+
+	client = respondeFixture(t, RetAutorizacao, "SP/RetAutorizacao.xml")
+	ret, _, err = ConsultaReciboNFe("351000012345678", chave, Homologacao, client)
+	if err != nil || ret.CStat != 104 || ret.NRec != "351000012345678" || len(ret.ProtNFe) != 1 {
+		t.Fatalf("ConsultaReciboNFe processado = %+v, %v", ret, err)
+	}
+	if inf := ret.ProtNFe[0].InfProt; inf.ChNFe != "35240511222333000181550010000001231123456785" || inf.CStat != 100 {
+		t.Errorf("protNFe = %+v", inf)
+	}
+}
+
+func TestRegistryLookupModelo(t *testing.T) {
+	r := mustNewRegistry(urlsJSON)
+
+	// Em MG a NFC-e é autorizada por WebServices próprios.
+	nfe, err := r.LookupModelo(31, Homologacao, Autorizacao, "55")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ep, _ := r.Lookup(31, Homologacao, Autorizacao); nfe != ep {
+		t.Errorf("LookupModelo(55) = %+v, esperado o mesmo da Lookup %+v", nfe, ep)
+	}
+	nfce, err := r.LookupModelo(31, Homologacao, Autorizacao, "65")
+	if err != nil || nfce.URL != "https://hnfce.fazenda.mg.gov.br/nfce/services/NFeAutorizacao4" || nfce.CUF != 31 {
+		t.Errorf("LookupModelo(65) = %+v, %v", nfce, err)
+	}
+
+	// Serviços sem WebService específico do modelo usam o da Lookup.
+	status, err := r.LookupModelo(31, Homologacao, ConsultaStatus, "65")
+	if ep, _ := r.Lookup(31, Homologacao, ConsultaStatus); err != nil || status != ep {
+		t.Errorf("LookupModelo(ConsultaStatus, 65) = %+v, %v", status, err)
+	}
+
+	// As substituições valem para os dois modelos.
+	r.Override(31, Homologacao, Autorizacao, "http://localhost/mg")
+	for _, mod := range []string{"55", "65"} {
+		if ep, err := r.LookupModelo(31, Homologacao, Autorizacao, mod); err != nil || ep.URL != "http://localhost/mg" {
+			t.Errorf("override não aplicado ao modelo %s: %+v, %v", mod, ep, err)
+		}
+	}
+}
+
+func FuzzRetEnviNFe(f *testing.F) {
+	for _, b := range fixturesSefaz(f, "Autorizacao") {
+		f.Add(b)
+	}
+	f.Fuzz(func(t *testing.T, data []byte) {
+		xmlfile, err := readSoapEnvelope(data)
+		if err != nil {
+			return
+		}
+		var ret RetEnviNFe
+		xml.Unmarshal(xmlfile, &ret)
+	})
+}
+
+func FuzzRetConsReciNFe(f *testing.F) {
+	for _, b := range fixturesSefaz(f, "RetAutorizacao") {
+		f.Add(b)
+	}
+	f.Fuzz(func(t *testing.T, data []byte) {
+		xmlfile, err := readSoapEnvelope(data)
+		if err != nil {
+			return
+		}
+		var ret RetConsReciNFe
+		xml.Unmarshal(xmlfile, &ret)
+	})
+}
+
+func TestLerChaveNFe(t *testing.T) {
+	chave, xmlNFe := xmlNFeEmissao(t, 123, "10.00")
+	// O dhEmi não é lido: o formato não importa para o envio (a NFC-e exige RFC 3339 apenas no QR Code).
+	xmlNFe = bytes.Replace(xmlNFe, []byte("2024-05-17T10:00:00-03:00"), []byte("2024-05-17 10:00"), 1)
+	nfe, err := lerNFeXML(xmlNFe)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ch, tpAmb, err := lerChaveNFe(nfe); err != nil || ch != chave || tpAmb != Homologacao {
+		t.Errorf("lerChaveNFe = %s, %v, %v", ch, tpAmb, err)
+	}
+
+	casos := map[string]string{
+		"sem infNFe":     `<NFe/>`,
+		"chave inválida": `<NFe><infNFe Id="NFe` + chave[:43] + `0"><ide><tpAmb>2</tpAmb></ide></infNFe></NFe>`,
+		"sem tpAmb":      `<NFe><infNFe Id="NFe` + chave + `"><ide/></infNFe></NFe>`,
+		"tpAmb inválido": `<NFe><infNFe Id="NFe` + chave + `"><ide><tpAmb>3</tpAmb></ide></infNFe></NFe>`,
+	}
+	for nome, x := range casos {
+		nfe, err := lerNFeXML([]byte(x))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, _, err := lerChaveNFe(nfe); err == nil || strings.Contains(err.Error(), "NFC-e") {
+			t.Errorf("%s: lerChaveNFe = %v", nome, err)
+		}
+	}
+}
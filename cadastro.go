@@ -4,8 +4,6 @@ import (
 	"encoding/xml"
 	"fmt"
 	"net/http"
-	"regexp"
-	"time"
 
 	"cloud.google.com/go/civil"
 	"github.com/frones/brdocs"
@@ -68,7 +66,7 @@ type RetConsCad struct {
 		IE       string    `json:"IE,omitempty" xml:"IE,omitempty"`
 		CNPJ     string    `json:"CNPJ,omitempty" xml:"CNPJ,omitempty"`
 		CPF      string    `json:"CPF,omitempty" xml:"CPF,omitempty"`
-		DhCons   time.Time `json:"dhCons" xml:"dhCons"`
+		DhCons   DateTime  `json:"dhCons" xml:"dhCons"`
 		CUF      int       `json:"cUF" xml:"cUF"`
 		InfCad   *[]InfCad `json:"infCad,omitempty" xml:"infCad,omitempty"`
 	} `json:"infCons" xml:"infCons"`
@@ -103,30 +101,27 @@ func (cons ConsCad) Consulta(tpAmb TAmb, client *http.Client, optReq ...func(req
 		}
 	}
 
-	url, err := getURLWS(GetcUF(cons.InfCons.UF), tpAmb, ConsultaCadastro)
+	ep, err := getEndpoint(GetcUF(cons.InfCons.UF), tpAmb, ConsultaCadastro)
 	if err != nil {
 		return RetConsCad{}, nil, err
 	}
 
-	xmlfile, err := sendRequest(cons, url, xmlnsConsCad, soapActionConsCad, client, optReq...)
+	xmlfile, normalizado, err := sendRequest(cons, ep, xmlnsConsCad, soapActionConsCad, client, optReq...)
 	if err != nil {
 		return RetConsCad{}, nil, fmt.Errorf("Erro na comunicação com a Sefaz. Detalhes: %w", err)
 	}
 
-	// normalizando o formato do campo dhCons para incluir a timezone: (yyyy-mm-ddThh:nn:ss-03:00)
-	re := regexp.MustCompile(`(<dhCons>[\d]{4}-[\d]{2}-[\d]{2}T[\d]{2}:[\d]{2}:[\d]{2})(<\/dhCons>)`)
-	xmlfile2 := re.ReplaceAll(xmlfile, []byte("$1-03:00$2"))
-
-	// normalizando o formato dos campos dIniAtiv, dUltSit e dBaixa para civil.Date (yyyy-mm-dd)
-	re = regexp.MustCompile(`(<dIniAtiv>[\d]{4}-[\d]{2}-[\d]{2})[\dT\-:Z]+(<\/dIniAtiv>)`)
-	xmlfile2 = re.ReplaceAll(xmlfile2, []byte("$1$2"))
-	re = regexp.MustCompile(`(<dUltSit>[\d]{4}-[\d]{2}-[\d]{2})[\dT\-:Z]+(<\/dUltSit>)`)
-	xmlfile2 = re.ReplaceAll(xmlfile2, []byte("$1$2"))
-	re = regexp.MustCompile(`(<dBaixa>[\d]{4}-[\d]{2}-[\d]{2})[\dT\-:Z]+(<\/dBaixa>)`)
-	xmlfile2 = re.ReplaceAll(xmlfile2, []byte("$1$2"))
+	ret, _, err := lerRetConsCad(normalizado)
+	if err != nil {
+		return RetConsCad{}, xmlfile, err
+	}
+	return ret, xmlfile, nil
+}
 
+// lerRetConsCad desserializa o retConsCad. Os formatos de data usados por algumas UFs são corrigidos antes, pelo DefaultNormalizador (ver NormalizacoesPadrao). Em caso de erro, retorna também o XML.
+func lerRetConsCad(xmlfile []byte) (RetConsCad, []byte, error) {
 	var ret RetConsCad
-	err = xml.Unmarshal(xmlfile2, &ret)
+	err := xml.Unmarshal(xmlfile, &ret)
 	if err != nil {
 		return RetConsCad{}, xmlfile, fmt.Errorf("Erro na desserialização do arquivo XML: %w. Arquivo: %s", err, xmlfile)
 	}
@@ -0,0 +1,101 @@
+package nfe
+
+import (
+	"testing"
+	"time"
+
+	"cloud.google.com/go/civil"
+)
+
+func TestRetConsCad(t *testing.T) {
+	testes := []struct {
+		arquivo string
+		cUF     int
+		cStat   int
+		dhCons  time.Time
+		infCad  []InfCad
+	}{
+		{"SP/ConsultaCadastro.xml", 35, 111, time.Date(2024, 5, 17, 10, 40, 0, 0, time.FixedZone("", -3*3600)), []InfCad{
+			{IE: "110042490114", CSit: 1, DIniAtiv: civil.Date{Year: 2001, Month: 5, Day: 10}, DUltSit: civil.Date{Year: 2001, Month: 5, Day: 10}},
+		}},
+		// MG responde em consultaCadastro4Result, com dhCons sem fuso e datas com hora
+		{"MG/ConsultaCadastro.xml", 31, 111, time.Date(2024, 5, 17, 10, 40, 0, 0, time.FixedZone("", -3*3600)), []InfCad{
+			{IE: "0623079040081", CSit: 1, DIniAtiv: civil.Date{Year: 2001, Month: 5, Day: 10}, DUltSit: civil.Date{Year: 2015, Month: 3, Day: 2}},
+		}},
+		// MT responde em nfeResultMsg/consultaCadastroResult, com datas em UTC
+		{"MT/ConsultaCadastro.xml", 51, 111, time.Date(2024, 5, 17, 9, 40, 0, 0, time.FixedZone("", -4*3600)), []InfCad{
+			{IE: "131234567", CSit: 0, DIniAtiv: civil.Date{Year: 2001, Month: 5, Day: 10}, DUltSit: civil.Date{Year: 2020, Month: 1, Day: 2}, DBaixa: civil.Date{Year: 2020, Month: 1, Day: 2}},
+		}},
+		{"RS/ConsultaCadastro.xml", 43, 112, time.Date(2024, 5, 17, 10, 40, 0, 0, time.FixedZone("", -3*3600)), []InfCad{
+			{IE: "0960000001", CSit: 1, DIniAtiv: civil.Date{Year: 2001, Month: 5, Day: 10}, DUltSit: civil.Date{Year: 2015, Month: 3, Day: 2}},
+			{IE: "0960000002", CSit: 0, DIniAtiv: civil.Date{Year: 1998, Month: 1, Day: 1}, DUltSit: civil.Date{Year: 2001, Month: 5, Day: 9}, DBaixa: civil.Date{Year: 2001, Month: 5, Day: 9}},
+		}},
+		{"PR/ConsultaCadastro-259.xml", 41, 259, time.Date(2024, 5, 17, 10, 40, 0, 0, time.FixedZone("", -3*3600)), nil},
+	}
+	for _, tt := range testes {
+		t.Run(tt.arquivo, func(t *testing.T) {
+			client := respondeFixture(t, ConsultaCadastro, tt.arquivo)
+			ret, _, err := ConsultaCad("", "11222333000181", "", tt.cUF, Producao, client)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if ret.InfCons.CStat != tt.cStat || ret.InfCons.CUF != tt.cUF || !ret.InfCons.DhCons.Equal(tt.dhCons) {
+				t.Errorf("infCons inesperado: %+v", ret.InfCons)
+			}
+			var infCad []InfCad
+			if ret.InfCons.InfCad != nil {
+				infCad = *ret.InfCons.InfCad
+			}
+			if len(infCad) != len(tt.infCad) {
+				t.Fatalf("%d infCad, esperado %d", len(infCad), len(tt.infCad))
+			}
+			for i, esperado := range tt.infCad {
+				cad := infCad[i]
+				if cad.IE != esperado.IE || cad.CSit != esperado.CSit || cad.DIniAtiv != esperado.DIniAtiv || cad.DUltSit != esperado.DUltSit || cad.DBaixa != esperado.DBaixa {
+					t.Errorf("infCad[%d] = %+v", i, cad)
+				}
+			}
+		})
+	}
+}
+
+func FuzzLerRetConsCad(f *testing.F) {
+	for _, b := range fixturesSefaz(f, "ConsultaCadastro") {
+		f.Add(b)
+	}
+	f.Fuzz(func(t *testing.T, data []byte) {
+		for _, ler := range []func([]byte) ([]byte, error){readSoapEnvelope, readSoapEnvelopeConsCadMT, readSoapEnvelopeConsCadMG} {
+			xmlfile, err := ler(data)
+			if err != nil {
+				continue
+			}
+			if _, orig, err := lerRetConsCad(xmlfile); err != nil && string(orig) != string(xmlfile) {
+				t.Errorf("lerRetConsCad não retornou o XML original junto com o erro")
+			}
+		}
+	})
+}
+
+// FuzzConsultaCadastroMTMG percorre o caminho da resposta da consulta de cadastro de MT e MG em sendRequest: normalização e envelope próprio de cada UF.
+func FuzzConsultaCadastroMTMG(f *testing.F) {
+	for _, arquivo := range []string{"MT/ConsultaCadastro.xml", "MG/ConsultaCadastro.xml"} {
+		f.Add(fixtureSefaz(f, arquivo))
+	}
+	var eps []Endpoint
+	for _, cUF := range []int{51, 31} {
+		ep, err := DefaultRegistry.Lookup(cUF, Homologacao, ConsultaCadastro)
+		if err != nil {
+			f.Fatal(err)
+		}
+		eps = append(eps, ep)
+	}
+	f.Fuzz(func(t *testing.T, data []byte) {
+		for _, ep := range eps {
+			xmlfile, err := desenvelopa(ep, DefaultNormalizador.Normaliza(ep, data))
+			if err != nil {
+				continue
+			}
+			lerRetConsCad(xmlfile)
+		}
+	})
+}
@@ -0,0 +1,293 @@
+package nfe
+
+import (
+	"bytes"
+	"crypto/x509"
+	_ "embed"
+	"encoding/asn1"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+// icpBrasilPEM é a cadeia ICP-Brasil embutida na biblioteca, gerada por icpbrasil_gen.go a partir das ACs publicadas pelo ITI. Ver DefaultCadeiaICPBrasil.
+//
+//go:generate go run icpbrasil_gen.go
+//go:embed icpbrasil.pem
+var icpBrasilPEM []byte
+
+var (
+	// ErrCertificadoForaDaValidade indica que o certificado expirou ou ainda não é válido.
+	ErrCertificadoForaDaValidade = errors.New("certificado fora do período de validade")
+	// ErrCertificadoNaoICPBrasil indica que o certificado não foi emitido por uma AC da ICP-Brasil conhecida.
+	ErrCertificadoNaoICPBrasil = errors.New("certificado não emitido pela ICP-Brasil")
+	// ErrCadeiaICPBrasilVazia indica que a cadeia usada na verificação não tem nenhuma AC Raiz, e por isso nenhum certificado pode ser verificado: o problema é a cadeia, e não o certificado.
+	ErrCadeiaICPBrasilVazia = errors.New("nenhuma AC Raiz ICP-Brasil carregada")
+	// ErrDocumentoCertificadoDivergente indica que o CNPJ (raiz) ou o CPF do certificado é diferente do informado na requisição.
+	ErrDocumentoCertificadoDivergente = errors.New("CNPJ/CPF do certificado diferente do informado")
+)
+
+// OIDs dos campos otherName do SubjectAltName dos certificados ICP-Brasil (DOC-ICP-04).
+var (
+	oidSubjectAltName       = asn1.ObjectIdentifier{2, 5, 29, 17}
+	oidICPDadosTitularPF    = asn1.ObjectIdentifier{2, 16, 76, 1, 3, 1} // nascimento, CPF, NIS, RG e órgão expedidor do titular (e-CPF)
+	oidICPNomeResponsavelPJ = asn1.ObjectIdentifier{2, 16, 76, 1, 3, 2} // nome do responsável pela pessoa jurídica (e-CNPJ)
+	oidICPCNPJ              = asn1.ObjectIdentifier{2, 16, 76, 1, 3, 3} // CNPJ da pessoa jurídica (e-CNPJ)
+	oidICPDadosResponsavel  = asn1.ObjectIdentifier{2, 16, 76, 1, 3, 4} // nascimento, CPF, NIS, RG e órgão expedidor do responsável (e-CNPJ)
+)
+
+// Certificado reúne as informações de um certificado digital ICP-Brasil relevantes para a NF-e: o titular (CNPJ ou CPF), o período de validade e a cadeia de emissão.
+type Certificado struct {
+	X509           *x509.Certificate   // Certificado do titular
+	Intermediarias []*x509.Certificate // Demais certificados do PEM, usados na verificação da cadeia
+	Titular        string              // Nome do titular (CN, sem o documento)
+	CNPJ           string              // CNPJ do titular do e-CNPJ (OID 2.16.76.1.3.3)
+	CPF            string              // CPF do titular do e-CPF (OID 2.16.76.1.3.1)
+	Responsavel    string              // Nome do responsável pelo e-CNPJ (OID 2.16.76.1.3.2)
+	CPFResponsavel string              // CPF do responsável pelo e-CNPJ (OID 2.16.76.1.3.4)
+}
+
+// NewCertificado lê o certificado do titular (o primeiro que não é de uma AC) e os demais certificados da cadeia, quando presentes, a partir de um PEM.
+func NewCertificado(certPEM []byte) (*Certificado, error) {
+	var certs []*x509.Certificate
+	for rest := certPEM; ; {
+		var block *pem.Block
+		block, rest = pem.Decode(rest)
+		if block == nil {
+			break
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("Erro na leitura do certificado digital. Detalhes: %w", err)
+		}
+		certs = append(certs, cert)
+	}
+	if len(certs) == 0 {
+		return nil, fmt.Errorf("Nenhum certificado encontrado no PEM")
+	}
+
+	c := &Certificado{}
+	for _, cert := range certs {
+		if c.X509 == nil && !cert.IsCA {
+			c.X509 = cert
+		} else {
+			c.Intermediarias = append(c.Intermediarias, cert)
+		}
+	}
+	if c.X509 == nil {
+		c.X509, c.Intermediarias = certs[0], certs[1:]
+	}
+
+	c.Titular, _, _ = strings.Cut(c.X509.Subject.CommonName, ":")
+	if err := c.leOtherNames(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// LoadCertificado lê o certificado de um arquivo PEM (ver NewCertificado), como o usado em NewHTTPClient.
+func LoadCertificado(path string) (*Certificado, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("Erro na leitura do certificado digital (%s). Detalhes: %w", path, err)
+	}
+	return NewCertificado(data)
+}
+
+// leOtherNames extrai o CNPJ, o CPF e o responsável dos campos otherName do SubjectAltName.
+func (c *Certificado) leOtherNames() error {
+	for _, ext := range c.X509.Extensions {
+		if !ext.Id.Equal(oidSubjectAltName) {
+			continue
+		}
+		var nomes []asn1.RawValue
+		if _, err := asn1.Unmarshal(ext.Value, &nomes); err != nil {
+			return fmt.Errorf("Erro na leitura do SubjectAltName do certificado. Detalhes: %w", err)
+		}
+		for _, nome := range nomes {
+			// otherName ::= [0] IMPLICIT SEQUENCE { type-id OBJECT IDENTIFIER, value [0] EXPLICIT ANY }
+			if nome.Class != asn1.ClassContextSpecific || nome.Tag != 0 {
+				continue
+			}
+			var oid asn1.ObjectIdentifier
+			rest, err := asn1.Unmarshal(nome.Bytes, &oid)
+			if err != nil {
+				return fmt.Errorf("Erro na leitura do otherName do certificado. Detalhes: %w", err)
+			}
+			var explicito, valor asn1.RawValue
+			if _, err := asn1.Unmarshal(rest, &explicito); err != nil {
+				return fmt.Errorf("Erro na leitura do otherName %v do certificado. Detalhes: %w", oid, err)
+			}
+			if _, err := asn1.Unmarshal(explicito.Bytes, &valor); err != nil {
+				return fmt.Errorf("Erro na leitura do otherName %v do certificado. Detalhes: %w", oid, err)
+			}
+			v := strings.TrimSpace(string(valor.Bytes))
+
+			switch {
+			case oid.Equal(oidICPCNPJ):
+				c.CNPJ = semZeros(v)
+			case oid.Equal(oidICPDadosTitularPF) && len(v) >= 19:
+				c.CPF = semZeros(v[8:19])
+			case oid.Equal(oidICPNomeResponsavelPJ):
+				c.Responsavel = v
+			case oid.Equal(oidICPDadosResponsavel) && len(v) >= 19:
+				c.CPFResponsavel = semZeros(v[8:19])
+			}
+		}
+	}
+	return nil
+}
+
+// semZeros trata os campos preenchidos só com zeros, que a ICP-Brasil usa para os dados não informados, como vazios.
+func semZeros(s string) string {
+	if strings.Trim(s, "0") == "" {
+		return ""
+	}
+	return s
+}
+
+// ICPBrasil indica se o certificado tem os campos de titular da ICP-Brasil (CNPJ ou CPF no SubjectAltName).
+func (c *Certificado) ICPBrasil() bool {
+	return c.CNPJ != "" || c.CPF != ""
+}
+
+// Vigente retorna ErrCertificadoForaDaValidade se o certificado não é válido no instante informado.
+func (c *Certificado) Vigente(agora time.Time) error {
+	if agora.Before(c.X509.NotBefore) {
+		return fmt.Errorf("%w: válido a partir de %s", ErrCertificadoForaDaValidade, c.X509.NotBefore.Format(time.RFC3339))
+	}
+	if agora.After(c.X509.NotAfter) {
+		return fmt.Errorf("%w: expirou em %s", ErrCertificadoForaDaValidade, c.X509.NotAfter.Format(time.RFC3339))
+	}
+	return nil
+}
+
+// ExpiraEm retorna quanto tempo falta para o certificado expirar (negativo se já expirou), para alertar sobre a renovação com antecedência.
+func (c *Certificado) ExpiraEm(agora time.Time) time.Duration {
+	return c.X509.NotAfter.Sub(agora)
+}
+
+// VerificaCadeia verifica se o certificado foi emitido por uma AC da ICP-Brasil, usando as intermediárias do próprio PEM e as da cadeia informada (ou da DefaultCadeiaICPBrasil, se nil). Retorna a cadeia encontrada, do titular até a AC Raiz. Se a cadeia não tiver nenhuma AC Raiz, retorna ErrCadeiaICPBrasilVazia.
+func (c *Certificado) VerificaCadeia(cadeia *CadeiaICPBrasil, agora time.Time) ([]*x509.Certificate, error) {
+	if cadeia == nil {
+		cadeia = DefaultCadeiaICPBrasil
+	}
+	if cadeia.qtdRaizes == 0 {
+		return nil, fmt.Errorf("%w (ver LoadCadeiaICPBrasil)", ErrCadeiaICPBrasilVazia)
+	}
+	intermediarias := cadeia.intermediarias.Clone()
+	for _, cert := range c.Intermediarias {
+		intermediarias.AddCert(cert)
+	}
+	cadeias, err := c.X509.Verify(x509.VerifyOptions{
+		Roots:         cadeia.raizes,
+		Intermediates: intermediarias,
+		CurrentTime:   agora,
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageAny},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrCertificadoNaoICPBrasil, err)
+	}
+	return cadeias[0], nil
+}
+
+// ConfereDocumento verifica se o certificado pertence ao CNPJ ou CPF informado (por exemplo, o do emitente ou do autor do evento). No e-CNPJ basta a mesma raiz (8 primeiros dígitos), já que o certificado da matriz vale para as filiais.
+func (c *Certificado) ConfereDocumento(doc string) error {
+	switch {
+	case len(doc) == 14 && len(c.CNPJ) == 14:
+		if doc[:8] == c.CNPJ[:8] {
+			return nil
+		}
+	case len(doc) == 11 && c.CPF != "":
+		if doc == c.CPF {
+			return nil
+		}
+	case doc == "":
+		return fmt.Errorf("CNPJ/CPF não informado")
+	}
+	return fmt.Errorf("%w: certificado de %s, requisição de %s", ErrDocumentoCertificadoDivergente, documentoCertificado(c), doc)
+}
+
+func documentoCertificado(c *Certificado) string {
+	if c.CNPJ != "" {
+		return "CNPJ " + c.CNPJ
+	}
+	if c.CPF != "" {
+		return "CPF " + c.CPF
+	}
+	return "titular sem CNPJ/CPF"
+}
+
+// Verifica faz todas as verificações do certificado para uso em nome do CNPJ/CPF informado: validade, cadeia ICP-Brasil (ver VerificaCadeia) e titular. Os erros encontrados são retornados juntos.
+func (c *Certificado) Verifica(doc string, cadeia *CadeiaICPBrasil, agora time.Time) error {
+	var erros []error
+	if err := c.Vigente(agora); err != nil {
+		erros = append(erros, err)
+	}
+	if _, err := c.VerificaCadeia(cadeia, agora); err != nil {
+		erros = append(erros, err)
+	}
+	if err := c.ConfereDocumento(doc); err != nil {
+		erros = append(erros, err)
+	}
+	return errors.Join(erros...)
+}
+
+// CadeiaICPBrasil é o conjunto de ACs Raiz e intermediárias usadas em VerificaCadeia.
+type CadeiaICPBrasil struct {
+	raizes         *x509.CertPool
+	intermediarias *x509.CertPool
+	qtdRaizes      int
+}
+
+// DefaultCadeiaICPBrasil é a cadeia usada quando nenhuma outra é informada, carregada do arquivo icpbrasil.pem embutido na biblioteca, com as ACs Raiz (v5, v10 e v11) e intermediárias publicadas pelo ITI. O arquivo é atualizado com go generate, que baixa o ACcompactado.zip do repositório da AC Raiz; para usar outra cadeia, ver LoadCadeiaICPBrasil.
+var DefaultCadeiaICPBrasil = mustNewCadeiaICPBrasil(icpBrasilPEM)
+
+func mustNewCadeiaICPBrasil(data []byte) *CadeiaICPBrasil {
+	c, err := NewCadeiaICPBrasil(data)
+	if err != nil {
+		panic(err)
+	}
+	return c
+}
+
+// NewCadeiaICPBrasil cria uma CadeiaICPBrasil a partir de um PEM com as ACs: os certificados autoassinados são as raízes e os demais, as intermediárias.
+func NewCadeiaICPBrasil(data []byte) (*CadeiaICPBrasil, error) {
+	c := &CadeiaICPBrasil{raizes: x509.NewCertPool(), intermediarias: x509.NewCertPool()}
+	for rest := data; ; {
+		var block *pem.Block
+		block, rest = pem.Decode(rest)
+		if block == nil {
+			break
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("Erro na leitura da cadeia ICP-Brasil. Detalhes: %w", err)
+		}
+		if bytes.Equal(cert.RawIssuer, cert.RawSubject) && cert.CheckSignatureFrom(cert) == nil {
+			c.raizes.AddCert(cert)
+			c.qtdRaizes++
+		} else {
+			c.intermediarias.AddCert(cert)
+		}
+	}
+	return c, nil
+}
+
+// LoadCadeiaICPBrasil cria uma CadeiaICPBrasil a partir de um arquivo PEM (ver NewCadeiaICPBrasil), por exemplo com as ACs baixadas do repositório da AC Raiz.
+func LoadCadeiaICPBrasil(path string) (*CadeiaICPBrasil, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("Erro na leitura da cadeia ICP-Brasil (%s). Detalhes: %w", path, err)
+	}
+	return NewCadeiaICPBrasil(data)
+}
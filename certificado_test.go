@@ -0,0 +1,187 @@
+package nfe
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"testing"
+	"time"
+)
+
+// otherNameTeste codifica um otherName da ICP-Brasil com o valor em OCTET STRING.
+func otherNameTeste(t *testing.T, oid asn1.ObjectIdentifier, valor string) asn1.RawValue {
+	t.Helper()
+	id, err := asn1.Marshal(oid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	v, err := asn1.Marshal([]byte(valor))
+	if err != nil {
+		t.Fatal(err)
+	}
+	explicito, err := asn1.Marshal(asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: v})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: append(id, explicito...)}
+}
+
+// cadeiaTeste gera uma AC Raiz, uma AC intermediária e um e-CNPJ emitido por ela, com validade até notAfter.
+func cadeiaTeste(t *testing.T, notAfter time.Time) (raiz, intermediaria, titular *x509.Certificate, chaveTitular *rsa.PrivateKey) {
+	t.Helper()
+	emite := func(modelo, emissor *x509.Certificate, chaveEmissor *rsa.PrivateKey) (*x509.Certificate, *rsa.PrivateKey) {
+		chave, err := rsa.GenerateKey(rand.Reader, 2048)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if emissor == nil {
+			emissor, chaveEmissor = modelo, chave
+		}
+		der, err := x509.CreateCertificate(rand.Reader, modelo, emissor, &chave.PublicKey, chaveEmissor)
+		if err != nil {
+			t.Fatal(err)
+		}
+		cert, err := x509.ParseCertificate(der)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return cert, chave
+	}
+	ac := func(serial int64, nome string) *x509.Certificate {
+		return &x509.Certificate{
+			SerialNumber: big.NewInt(serial), Subject: pkix.Name{CommonName: nome},
+			NotBefore: time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC), NotAfter: time.Date(2035, 1, 1, 0, 0, 0, 0, time.UTC),
+			IsCA: true, BasicConstraintsValid: true, KeyUsage: x509.KeyUsageCertSign,
+		}
+	}
+
+	raiz, chaveRaiz := emite(ac(1, "Autoridade Certificadora Raiz Brasileira Teste"), nil, nil)
+	intermediaria, chaveIntermediaria := emite(ac(2, "AC Teste RFB"), raiz, chaveRaiz)
+
+	san, err := asn1.Marshal([]asn1.RawValue{
+		otherNameTeste(t, oidICPDadosResponsavel, "0101198012345678901000000000000000000000000000000000"),
+		otherNameTeste(t, oidICPCNPJ, "11222333000181"),
+		otherNameTeste(t, oidICPNomeResponsavelPJ, "FULANO DE TAL"),
+		otherNameTeste(t, asn1.ObjectIdentifier{2, 16, 76, 1, 3, 7}, "000000000000"),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	titular, chaveTitular = emite(&x509.Certificate{
+		SerialNumber: big.NewInt(3), Subject: pkix.Name{CommonName: "EMITENTE LTDA:11222333000181"},
+		NotBefore: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC), NotAfter: notAfter,
+		KeyUsage:        x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:     []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+		ExtraExtensions: []pkix.Extension{{Id: oidSubjectAltName, Value: san}},
+	}, intermediaria, chaveIntermediaria)
+	return raiz, intermediaria, titular, chaveTitular
+}
+
+func pemTeste(certs ...*x509.Certificate) []byte {
+	var b []byte
+	for _, c := range certs {
+		b = append(b, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: c.Raw})...)
+	}
+	return b
+}
+
+func TestCertificado(t *testing.T) {
+	venc := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	raiz, intermediaria, titular, _ := cadeiaTeste(t, venc)
+
+	// O PEM exportado do .pfx pode trazer a cadeia antes do certificado do titular.
+	c, err := NewCertificado(pemTeste(intermediaria, titular))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.X509.SerialNumber.Int64() != 3 || len(c.Intermediarias) != 1 {
+		t.Errorf("certificado do titular não identificado: %v, %d intermediárias", c.X509.Subject, len(c.Intermediarias))
+	}
+	if c.Titular != "EMITENTE LTDA" || c.CNPJ != "11222333000181" || c.CPF != "" || c.Responsavel != "FULANO DE TAL" || c.CPFResponsavel != "12345678901" || !c.ICPBrasil() {
+		t.Errorf("campos ICP-Brasil = %+v", c)
+	}
+
+	agora := time.Date(2024, 12, 27, 0, 0, 0, 0, time.UTC)
+	if d := c.ExpiraEm(agora); d != 5*24*time.Hour {
+		t.Errorf("ExpiraEm() = %v", d)
+	}
+	if err := c.Vigente(agora); err != nil {
+		t.Error(err)
+	}
+	if err := c.Vigente(venc.Add(time.Second)); !errors.Is(err, ErrCertificadoForaDaValidade) {
+		t.Errorf("Vigente(após o vencimento) = %v", err)
+	}
+
+	cadeia, err := NewCadeiaICPBrasil(append([]byte("AC Raiz de teste\n"), pemTeste(raiz)...))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ch, err := c.VerificaCadeia(cadeia, agora); err != nil || len(ch) != 3 || !ch[2].Equal(raiz) {
+		t.Errorf("VerificaCadeia() = %v, %v", ch, err)
+	}
+	outra, _, _, _ := cadeiaTeste(t, venc)
+	outraCadeia, _ := NewCadeiaICPBrasil(pemTeste(outra, intermediaria))
+	if _, err := c.VerificaCadeia(outraCadeia, agora); !errors.Is(err, ErrCertificadoNaoICPBrasil) {
+		t.Errorf("VerificaCadeia(outra raiz) = %v", err)
+	}
+	if _, err := c.VerificaCadeia(&CadeiaICPBrasil{}, agora); !errors.Is(err, ErrCadeiaICPBrasilVazia) || errors.Is(err, ErrCertificadoNaoICPBrasil) {
+		t.Errorf("VerificaCadeia(sem raízes) = %v", err)
+	}
+
+	// Sem a intermediária no PEM, ela vem da cadeia.
+	s, _ := NewCertificado(pemTeste(titular))
+	cadeiaCompleta, _ := NewCadeiaICPBrasil(pemTeste(raiz, intermediaria))
+	if _, err := s.VerificaCadeia(cadeiaCompleta, agora); err != nil {
+		t.Errorf("VerificaCadeia(intermediária da cadeia) = %v", err)
+	}
+
+	for doc, ok := range map[string]bool{"11222333000181": true, "11222333000262": true, "99888777000166": false, "12345678901": false, "": false} {
+		if err := c.ConfereDocumento(doc); (err == nil) != ok {
+			t.Errorf("ConfereDocumento(%q) = %v", doc, err)
+		}
+	}
+
+	err = c.Verifica("99888777000166", cadeia, venc.Add(time.Hour))
+	if !errors.Is(err, ErrCertificadoForaDaValidade) || !errors.Is(err, ErrCertificadoNaoICPBrasil) || !errors.Is(err, ErrDocumentoCertificadoDivergente) {
+		t.Errorf("Verifica() = %v", err)
+	}
+	if err := c.Verifica("11222333000181", cadeia, agora); err != nil {
+		t.Errorf("Verifica() = %v", err)
+	}
+}
+
+func TestDefaultCadeiaICPBrasil(t *testing.T) {
+	raizes := map[string]bool{}
+	for rest := icpBrasilPEM; ; {
+		var block *pem.Block
+		block, rest = pem.Decode(rest)
+		if block == nil {
+			break
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if bytes.Equal(cert.RawIssuer, cert.RawSubject) {
+			raizes[cert.Subject.CommonName] = true
+		}
+	}
+	if len(raizes) == 0 {
+		// O arquivo é gerado por go generate, que precisa do repositório da AC Raiz.
+		t.Skip("icpbrasil.pem sem as ACs publicadas pelo ITI: execute go generate")
+	}
+	if DefaultCadeiaICPBrasil.qtdRaizes != len(raizes) {
+		t.Errorf("DefaultCadeiaICPBrasil com %d raízes; esperadas %d", DefaultCadeiaICPBrasil.qtdRaizes, len(raizes))
+	}
+	for _, cn := range []string{"Autoridade Certificadora Raiz Brasileira v5", "Autoridade Certificadora Raiz Brasileira v10", "Autoridade Certificadora Raiz Brasileira v11"} {
+		if !raizes[cn] {
+			t.Errorf("icpbrasil.pem sem a AC Raiz %q", cn)
+		}
+	}
+}
@@ -4,7 +4,6 @@ import (
 	"encoding/xml"
 	"fmt"
 	"net/http"
-	"time"
 )
 
 const VerConsSitNFe = "4.00"
@@ -29,33 +28,29 @@ type RetConsSitNFe struct {
 	CStat         int              `json:"cStat" xml:"cStat"`
 	XMotivo       string           `json:"xMotivo" xml:"xMotivo"`
 	CUF           int              `json:"cUF" xml:"cUF"`
-	DhRecbto      time.Time        `json:"dhRecbto" xml:"dhRecbto"`
+	DhRecbto      DateTime         `json:"dhRecbto" xml:"dhRecbto"`
 	ChNFe         string           `json:"chNFe" xml:"chNFe"`
 	ProtNFe       *ProtNFe         `json:"protNFe" xml:"protNFe"`
 	RetCancNFe    *RetCancNFe      `json:"retCancNFe,omitempty" xml:"retCancNFe,omitempty"`
 	ProcEventoNFe *[]ProcEventoNFe `json:"procEventoNFe,omitempty" xml:"procEventoNFe,omitempty"`
 }
 
-// Realiza a consulta na Sefaz correspondente (determinada automaticamente pelo cUF presente na chave), utilizando o http.Client (ver NewHTTPClient) e as funções de personalização da http.Request fornecidos.
+// Realiza a consulta na Sefaz correspondente (determinada automaticamente pelo cUF e pelo tpEmis presentes na chave, ver Contingencia.EndpointChave), utilizando o http.Client (ver NewHTTPClient) e as funções de personalização da http.Request fornecidos.
 //
 // Ver ConsultaNFe() para uma maneira mais simples de consultar a NFe
 func (cons ConsSitNFe) Consulta(client *http.Client, optReq ...func(req *http.Request)) (RetConsSitNFe, []byte, error) {
-	cUF, _, _, _, _, _, _, _, _, err := GetChaveInfo(cons.ChNFe)
-	if err != nil {
-		return RetConsSitNFe{}, nil, err
-	}
-	url, err := getURLWS(cUF, cons.TpAmb, ConsultaProtocolo)
+	ep, err := DefaultContingencia.EndpointChave(cons.ChNFe, cons.TpAmb, ConsultaProtocolo)
 	if err != nil {
 		return RetConsSitNFe{}, nil, err
 	}
 
-	xmlfile, err := sendRequest(cons, url, xmlnsConsSitNFe, soapActionConsSitNFe, client, optReq...)
+	xmlfile, normalizado, err := sendRequest(cons, ep, xmlnsConsSitNFe, soapActionConsSitNFe, client, optReq...)
 	if err != nil {
 		return RetConsSitNFe{}, nil, fmt.Errorf("Erro na comunicação com a Sefaz. Detalhes: %w", err)
 	}
 
 	var ret RetConsSitNFe
-	err = xml.Unmarshal(xmlfile, &ret)
+	err = xml.Unmarshal(normalizado, &ret)
 	if err != nil {
 		return RetConsSitNFe{}, xmlfile, fmt.Errorf("Erro na desserialização do arquivo XML: %w. Arquivo: %s", err, xmlfile)
 	}
@@ -74,3 +69,33 @@ func ConsultaNFe(dfechave string, tpAmb TAmb, client *http.Client, optReq ...fun
 
 	return cons.Consulta(client, optReq...)
 }
+
+// cStat da consulta de NFe canceladas: cancelamento homologado (101), homologado fora de prazo (151) e evento de cancelamento homologado fora de prazo (155).
+var cStatCancelada = []int{101, 151, 155}
+
+// Cancelada indica se a consulta retornou a NFe como cancelada, seja pelo cStat ou por um evento de cancelamento (inclusive por substituição) registrado entre os procEventoNFe.
+func (ret RetConsSitNFe) Cancelada() bool {
+	if contemInt(cStatCancelada, ret.CStat) {
+		return true
+	}
+	for _, proc := range ret.Eventos() {
+		if proc.Evento == nil || proc.RetEvento == nil {
+			continue
+		}
+		if tp := proc.Evento.InfEvento.TpEvento; tp != TpEventoCancelamento && tp != TpEventoCancelamentoSubstituicao {
+			continue
+		}
+		if cStat := proc.RetEvento.InfEvento.CStat; cStat == cStatEventoVinculado || cStat == 155 {
+			return true
+		}
+	}
+	return false
+}
+
+// Eventos retorna os procEventoNFe da consulta, ou nil se não houver.
+func (ret RetConsSitNFe) Eventos() []ProcEventoNFe {
+	if ret.ProcEventoNFe == nil {
+		return nil
+	}
+	return *ret.ProcEventoNFe
+}
@@ -0,0 +1,133 @@
+package nfe
+
+import (
+	"context"
+	"net/http"
+	"sync"
+)
+
+// concorrenciaConsultaLote é o número padrão de consultas simultâneas por autorizador na ConsultaNFeLote.
+const concorrenciaConsultaLote = 4
+
+// ConfigConsultaLote define como a ConsultaNFeLote executa as consultas.
+type ConfigConsultaLote struct {
+	TpAmb  TAmb
+	Client *http.Client
+	OptReq []func(req *http.Request)
+
+	// Concorrencia é o número máximo de consultas simultâneas em cada autorizador (SP, SVRS, SVAN etc.). Zero usa o padrão, 4.
+	Concorrencia int
+
+	// Limiter, se informado, é aguardado (ver RateLimiter.Aguarda) antes de cada consulta, com o CNPJ do certificado. Um cStat 656 bloqueia as consultas seguintes na mesma UF, que retornam *RateLimitError.
+	Limiter *RateLimiter
+	CNPJ    string
+}
+
+// ResultadoConsultaLote é o resultado da consulta de uma chave de acesso na ConsultaNFeLote.
+type ResultadoConsultaLote struct {
+	Chave string
+	Ret   RetConsSitNFe
+	XML   []byte
+	Err   error
+
+	// Cancelada e Eventos repetem Ret.Cancelada() e Ret.Eventos().
+	Cancelada bool
+	Eventos   []ProcEventoNFe
+}
+
+// ConsultaNFeLote consulta muitas chaves de acesso (ver ConsultaNFe), agrupando-as pelo autorizador da UF de cada chave e executando, em cada autorizador, no máximo cfg.Concorrencia consultas simultâneas. Os autorizadores são consultados em paralelo.
+//
+// Os resultados são enviados no canal retornado à medida que as consultas terminam, e não na ordem das chaves; chaves inválidas resultam em um ResultadoConsultaLote com Err preenchido, sem consulta. O canal é fechado depois da última consulta ou, com o cancelamento do contexto, assim que as consultas em andamento forem interrompidas; nesse caso as chaves ainda não consultadas não são informadas.
+//
+// O canal deve ser lido até o fechamento ou o contexto deve ser cancelado, para que as goroutines terminem.
+func ConsultaNFeLote(ctx context.Context, chaves []string, cfg ConfigConsultaLote) <-chan ResultadoConsultaLote {
+	n := cfg.Concorrencia
+	if n <= 0 {
+		n = concorrenciaConsultaLote
+	}
+
+	var invalidas []ResultadoConsultaLote
+	grupos := map[string][]string{}
+	for _, chave := range chaves {
+		cUF, _, _, _, _, _, _, _, _, err := GetChaveInfo(chave)
+		if err != nil {
+			invalidas = append(invalidas, ResultadoConsultaLote{Chave: chave, Err: err})
+			continue
+		}
+		autorizador := DefaultRegistry.Autorizador(cUF)
+		if autorizador == "" {
+			autorizador = GetUF(cUF)
+		}
+		grupos[autorizador] = append(grupos[autorizador], chave)
+	}
+
+	out := make(chan ResultadoConsultaLote, n*len(grupos))
+	envia := func(r ResultadoConsultaLote) bool {
+		select {
+		case out <- r:
+			return true
+		case <-ctx.Done():
+			return false
+		}
+	}
+
+	var wg sync.WaitGroup
+	for _, grupo := range grupos {
+		fila := make(chan string, len(grupo))
+		for _, chave := range grupo {
+			fila <- chave
+		}
+		close(fila)
+
+		for i := 0; i < n && i < len(grupo); i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for chave := range fila {
+					if ctx.Err() != nil || !envia(consultaLote(ctx, chave, cfg)) {
+						return
+					}
+				}
+			}()
+		}
+	}
+
+	go func() {
+		for _, r := range invalidas {
+			if !envia(r) {
+				break
+			}
+		}
+		wg.Wait()
+		close(out)
+	}()
+
+	return out
+}
+
+// consultaLote consulta uma chave da ConsultaNFeLote, respeitando o RateLimiter e o contexto.
+func consultaLote(ctx context.Context, chave string, cfg ConfigConsultaLote) ResultadoConsultaLote {
+	r := ResultadoConsultaLote{Chave: chave}
+	cUF, _, _, _, _, _, _, _, _, _ := GetChaveInfo(chave)
+
+	if cfg.Limiter != nil {
+		if r.Err = cfg.Limiter.Aguarda(ctx, cfg.CNPJ, ConsultaProtocolo, cUF); r.Err != nil {
+			return r
+		}
+	}
+
+	optReq := append([]func(req *http.Request){func(req *http.Request) {
+		*req = *req.WithContext(ctx)
+	}}, cfg.OptReq...)
+	r.Ret, r.XML, r.Err = ConsultaNFe(chave, cfg.TpAmb, cfg.Client, optReq...)
+	if r.Err != nil {
+		return r
+	}
+
+	if cfg.Limiter != nil && r.Ret.CStat == cStatConsumoIndevido {
+		cfg.Limiter.RegistraConsumoIndevido(cfg.CNPJ, ConsultaProtocolo, cUF)
+	}
+	r.Cancelada = r.Ret.Cancelada()
+	r.Eventos = r.Ret.Eventos()
+	return r
+}
@@ -0,0 +1,158 @@
+package nfe
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+var reChNFe = regexp.MustCompile(`<chNFe>(\d{44})</chNFe>`)
+
+// sefazConsultaLote responde às consultas de protocolo de todas as UFs informadas, registrando o máximo de consultas simultâneas por autorizador. As NFe de número par são retornadas como canceladas.
+type sefazConsultaLote struct {
+	mu        sync.Mutex
+	ativas    map[string]int
+	maximo    map[string]int
+	consultas int
+}
+
+func novaSefazConsultaLote(t *testing.T, ufs ...int) (*sefazConsultaLote, *http.Client) {
+	s := &sefazConsultaLote{ativas: map[string]int{}, maximo: map[string]int{}}
+	srv := httptest.NewServer(s)
+	t.Cleanup(srv.Close)
+	for _, cUF := range ufs {
+		cUF := cUF
+		DefaultRegistry.Override(cUF, Producao, ConsultaProtocolo, fmt.Sprintf("%s/%d", srv.URL, cUF))
+		t.Cleanup(func() { DefaultRegistry.RemoveOverride(cUF, Producao, ConsultaProtocolo) })
+	}
+	return s, srv.Client()
+}
+
+func (s *sefazConsultaLote) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var cUF int
+	fmt.Sscan(strings.TrimPrefix(r.URL.Path, "/"), &cUF)
+	autorizador := DefaultRegistry.Autorizador(cUF)
+
+	s.mu.Lock()
+	s.consultas++
+	s.ativas[autorizador]++
+	if s.ativas[autorizador] > s.maximo[autorizador] {
+		s.maximo[autorizador] = s.ativas[autorizador]
+	}
+	s.mu.Unlock()
+
+	time.Sleep(10 * time.Millisecond)
+
+	s.mu.Lock()
+	s.ativas[autorizador]--
+	s.mu.Unlock()
+
+	body, _ := io.ReadAll(r.Body)
+	chave := string(reChNFe.FindSubmatch(body)[1])
+	cStat, xMotivo := 100, "Autorizado o uso da NF-e"
+	if _, _, _, _, _, _, nNF, _, _, _ := GetChaveInfo(chave); nNF%2 == 0 {
+		cStat, xMotivo = 101, "Cancelamento de NF-e homologado"
+	}
+	fmt.Fprintf(w, `<soap:Envelope xmlns:soap="http://www.w3.org/2003/05/soap-envelope"><soap:Body><nfeResultMsg><retConsSitNFe xmlns="http://www.portalfiscal.inf.br/nfe" versao="4.00"><tpAmb>1</tpAmb><verAplic>TESTE</verAplic><cStat>%d</cStat><xMotivo>%s</xMotivo><cUF>%d</cUF><dhRecbto>2024-05-17T10:31:02-03:00</dhRecbto><chNFe>%s</chNFe></retConsSitNFe></nfeResultMsg></soap:Body></soap:Envelope>`, cStat, xMotivo, cUF, chave)
+}
+
+func chavesConsultaLote(t *testing.T, cUF int, quantidade int) []string {
+	var chaves []string
+	for nNF := 1; nNF <= quantidade; nNF++ {
+		chave, err := MontaChaveDeAcesso(cUF, 24, 5, "11222333000181", "55", 1, nNF, 1, 12345678)
+		if err != nil {
+			t.Fatal(err)
+		}
+		chaves = append(chaves, chave)
+	}
+	return chaves
+}
+
+func TestConsultaNFeLote(t *testing.T) {
+	// RJ e SC usam o mesmo autorizador (SVRS), de maneira que a concorrência é compartilhada entre as duas UFs.
+	ufs := []int{35, 43, 33, 42}
+	sefaz, client := novaSefazConsultaLote(t, ufs...)
+
+	var chaves []string
+	for _, cUF := range ufs {
+		chaves = append(chaves, chavesConsultaLote(t, cUF, 6)...)
+	}
+	chaves = append(chaves, "123")
+
+	resultados := map[string]ResultadoConsultaLote{}
+	for r := range ConsultaNFeLote(context.Background(), chaves, ConfigConsultaLote{TpAmb: Producao, Client: client, Concorrencia: 2}) {
+		if _, ok := resultados[r.Chave]; ok {
+			t.Errorf("chave %s informada duas vezes", r.Chave)
+		}
+		resultados[r.Chave] = r
+	}
+
+	sefaz.mu.Lock()
+	defer sefaz.mu.Unlock()
+	if len(resultados) != len(chaves) || sefaz.consultas != len(chaves)-1 {
+		t.Fatalf("%d resultados e %d consultas para %d chaves", len(resultados), sefaz.consultas, len(chaves))
+	}
+	if resultados["123"].Err == nil {
+		t.Error("chave inválida sem erro")
+	}
+	for _, chave := range chaves[:len(chaves)-1] {
+		r := resultados[chave]
+		_, _, _, _, _, _, nNF, _, _, _ := GetChaveInfo(chave)
+		if r.Err != nil || r.Ret.ChNFe != chave || r.Cancelada != (nNF%2 == 0) {
+			t.Errorf("%s: cStat %d, cancelada %v, erro %v", chave, r.Ret.CStat, r.Cancelada, r.Err)
+		}
+	}
+	for autorizador, maximo := range sefaz.maximo {
+		if maximo > 2 {
+			t.Errorf("%s: %d consultas simultâneas", autorizador, maximo)
+		}
+	}
+	if len(sefaz.maximo) != 3 {
+		t.Errorf("autorizadores consultados: %v", sefaz.maximo)
+	}
+}
+
+func TestConsultaNFeLoteLimiter(t *testing.T) {
+	_, client := novaSefazConsultaLote(t, 35)
+	limiter := NewRateLimiter(RateLimiterConfig{Padrao: Limite{Requisicoes: 2, Periodo: time.Hour}})
+
+	var ok, limitadas int
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	for r := range ConsultaNFeLote(ctx, chavesConsultaLote(t, 35, 5), ConfigConsultaLote{TpAmb: Producao, Client: client, Limiter: limiter, CNPJ: "11222333000181"}) {
+		if r.Err == nil {
+			ok++
+		} else if errors.Is(r.Err, context.DeadlineExceeded) {
+			limitadas++
+		}
+	}
+	// apenas as duas primeiras consultas são permitidas; as demais aguardam o limite até o fim do contexto
+	if ok != 2 || ctx.Err() == nil {
+		t.Errorf("%d consultas realizadas, %d interrompidas", ok, limitadas)
+	}
+}
+
+func TestConsultaNFeLoteCancelamento(t *testing.T) {
+	_, client := novaSefazConsultaLote(t, 35)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	n := 0
+	for range ConsultaNFeLote(ctx, chavesConsultaLote(t, 35, 50), ConfigConsultaLote{TpAmb: Producao, Client: client, Concorrencia: 1}) {
+		n++
+		if n == 3 {
+			cancel()
+		}
+	}
+	if n >= 50 {
+		t.Errorf("%d resultados após o cancelamento", n)
+	}
+}
@@ -0,0 +1,115 @@
+package nfe
+
+import (
+	"encoding/xml"
+	"testing"
+)
+
+func TestRetConsSitNFe(t *testing.T) {
+	t.Run("autorizada", func(t *testing.T) {
+		chave := "35240511222333000181550010000001231123456785"
+		client := respondeFixture(t, ConsultaProtocolo, "SP/ConsultaProtocolo.xml")
+		ret, _, err := ConsultaNFe(chave, Homologacao, client)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ret.CStat != 100 || ret.ChNFe != chave || ret.ProtNFe == nil {
+			t.Fatalf("retorno inesperado: %+v", ret)
+		}
+		if inf := ret.ProtNFe.InfProt; inf.NProt != "135240000000001" || inf.DigVal != "Vh9k1wqBVyT06jXdpf8OP6tpD/U=" || inf.CStat != 100 {
+			t.Errorf("protNFe inesperado: %+v", inf)
+		}
+		if ret.ProcEventoNFe != nil {
+			t.Errorf("procEventoNFe inesperado: %+v", *ret.ProcEventoNFe)
+		}
+	})
+
+	t.Run("cancelada", func(t *testing.T) {
+		chave := "43240511222333000181550010000004561876543218"
+		client := respondeFixture(t, ConsultaProtocolo, "RS/ConsultaProtocolo-101.xml")
+		ret, _, err := ConsultaNFe(chave, Homologacao, client)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ret.CStat != 101 || !ret.Cancelada() || ret.ProtNFe == nil || ret.ProtNFe.InfProt.CStat != 100 {
+			t.Fatalf("retorno inesperado: %+v", ret)
+		}
+		if ret.ProcEventoNFe == nil || len(*ret.ProcEventoNFe) != 1 {
+			t.Fatalf("procEventoNFe = %v", ret.ProcEventoNFe)
+		}
+		proc := (*ret.ProcEventoNFe)[0]
+		if proc.Evento == nil || proc.RetEvento == nil {
+			t.Fatalf("procEventoNFe incompleto: %+v", proc)
+		}
+		if proc.Evento.InfEvento.ChNFe != chave || proc.RetEvento.InfEvento.CStat != 135 || proc.RetEvento.InfEvento.NProt != "143240000000124" {
+			t.Errorf("evento inesperado: %+v / %+v", proc.Evento.InfEvento, proc.RetEvento.InfEvento)
+		}
+		det, err := proc.Evento.Detalhe()
+		if err != nil || det.NProt != "143240000000123" {
+			t.Errorf("Detalhe() = %+v, %v", det, err)
+		}
+	})
+
+	t.Run("inexistente", func(t *testing.T) {
+		client := respondeFixture(t, ConsultaProtocolo, "RS/ConsultaProtocolo-217.xml")
+		ret, _, err := ConsultaNFe("43240511222333000181550010000004561876543218", Homologacao, client)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ret.CStat != 217 || ret.ProtNFe != nil {
+			t.Errorf("retorno inesperado: %+v", ret)
+		}
+	})
+}
+
+func TestRetConsSitNFeCancelada(t *testing.T) {
+	evento := func(tpEvento string, cStat int) ProcEventoNFe {
+		proc := ProcEventoNFe{Evento: &EventoNFe{}, RetEvento: &RetEventoNFe{}}
+		proc.Evento.InfEvento.TpEvento = tpEvento
+		proc.RetEvento.InfEvento.CStat = cStat
+		return proc
+	}
+	testes := []struct {
+		cStat     int
+		eventos   []ProcEventoNFe
+		cancelada bool
+	}{
+		{100, nil, false},
+		{101, nil, true},
+		{151, nil, true},
+		{100, []ProcEventoNFe{evento(TpEventoCCe, 135)}, false},
+		{100, []ProcEventoNFe{evento(TpEventoCCe, 135), evento(TpEventoCancelamento, 135)}, true},
+		{100, []ProcEventoNFe{evento(TpEventoCancelamentoSubstituicao, 155)}, true},
+		{100, []ProcEventoNFe{evento(TpEventoCancelamento, 573)}, false},
+	}
+	for i, tt := range testes {
+		ret := RetConsSitNFe{CStat: tt.cStat}
+		if tt.eventos != nil {
+			ret.ProcEventoNFe = &tt.eventos
+		}
+		if ret.Cancelada() != tt.cancelada || len(ret.Eventos()) != len(tt.eventos) {
+			t.Errorf("%d: Cancelada() = %v", i, ret.Cancelada())
+		}
+	}
+}
+
+func FuzzRetConsSitNFe(f *testing.F) {
+	for _, b := range fixturesSefaz(f, "ConsultaProtocolo") {
+		f.Add(b)
+	}
+	f.Fuzz(func(t *testing.T, data []byte) {
+		xmlfile, err := readSoapEnvelope(data)
+		if err != nil {
+			return
+		}
+		var ret RetConsSitNFe
+		if xml.Unmarshal(xmlfile, &ret) != nil || ret.ProcEventoNFe == nil {
+			return
+		}
+		for _, proc := range *ret.ProcEventoNFe {
+			if proc.Evento != nil {
+				proc.Evento.Detalhe()
+			}
+		}
+	})
+}
@@ -0,0 +1,267 @@
+package nfe
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Tipos de emissão (tpEmis) usados na contingência.
+const (
+	TpEmisNormal      = 1
+	TpEmisEPEC        = 4
+	TpEmisSVCAN       = 6
+	TpEmisSVCRS       = 7
+	TpEmisOfflineNFCe = 9
+)
+
+// layoutDataHora é o formato de data e hora dos campos da NFe e dos eventos (AAAA-MM-DDThh:mm:ssTZD, como dhEmi e dhCont), com o fuso sempre no formato -hh:mm, inclusive em UTC (o time.RFC3339 usaria "Z", recusado pela Sefaz).
+const layoutDataHora = "2006-01-02T15:04:05-07:00"
+
+// justificativaPadraoContingencia é usada quando a contingência é ativada automaticamente.
+const justificativaPadraoContingencia = "Indisponibilidade do WebService de autorização da UF"
+
+// Contingencia controla a emissão em contingência SVC-AN/SVC-RS: marca as UFs cujo autorizador está indisponível (a partir da ConsultaStatServ ou de falhas de comunicação), direciona a autorização e os eventos para o SVC correspondente e volta ao autorizador normal quando a UF se recupera.
+//
+// Pode ser usado concorrentemente.
+type Contingencia struct {
+	// LimiteFalhas é o número de falhas de comunicação consecutivas com o autorizador da UF que ativa a contingência automaticamente. Zero desabilita a ativação por falhas.
+	LimiteFalhas int
+
+	// OnChange, se informada, é chamada sempre que a contingência de uma UF for ativada ou desativada.
+	OnChange func(cUF int, tpAmb TAmb, ativa bool, xJust string)
+
+	registry *Registry
+	mu       sync.Mutex
+	estados  map[estadoKey]*estadoContingencia
+	now      func() time.Time
+}
+
+type estadoKey struct {
+	cUF   int
+	tpAmb TAmb
+}
+
+type estadoContingencia struct {
+	ativa  bool
+	dhCont time.Time
+	xJust  string
+	falhas int
+}
+
+// DefaultContingencia é a Contingencia usada pelos serviços da biblioteca para direcionar a autorização, a consulta do recibo, a consulta do protocolo e os eventos ao autorizador da UF ou ao SVC (ver EndpointChave). As UFs só entram em contingência pela Ativa, pela ObservaStatus ou pelas falhas registradas (por exemplo, pelo Emissor).
+var DefaultContingencia = NewContingencia(nil)
+
+// NewContingencia cria um controle de contingência usando o Registry informado (ou o DefaultRegistry, se nil), que ativa a contingência após 3 falhas consecutivas.
+func NewContingencia(registry *Registry) *Contingencia {
+	if registry == nil {
+		registry = DefaultRegistry
+	}
+	return &Contingencia{
+		LimiteFalhas: 3,
+		registry:     registry,
+		estados:      map[estadoKey]*estadoContingencia{},
+		now:          time.Now,
+	}
+}
+
+func (c *Contingencia) estado(cUF int, tpAmb TAmb) *estadoContingencia {
+	k := estadoKey{cUF, tpAmb}
+	e, ok := c.estados[k]
+	if !ok {
+		e = &estadoContingencia{}
+		c.estados[k] = e
+	}
+	return e
+}
+
+// Ativa coloca a UF em contingência SVC. A justificativa (xJust) deve ter entre 15 e 256 caracteres.
+func (c *Contingencia) Ativa(cUF int, tpAmb TAmb, xJust string) error {
+	if n := len([]rune(xJust)); n < 15 || n > 256 {
+		return fmt.Errorf("Justificativa da contingência deve ter entre 15 e 256 caracteres: %q", xJust)
+	}
+	if c.registry.AutorizadorContingencia(cUF) == "" {
+		return fmt.Errorf("UF sem autorizador de contingência: %d", cUF)
+	}
+
+	c.mu.Lock()
+	e := c.estado(cUF, tpAmb)
+	mudou := !e.ativa
+	if mudou {
+		e.ativa = true
+		e.dhCont = c.now().Truncate(time.Second)
+		e.xJust = xJust
+	}
+	c.mu.Unlock()
+
+	if mudou && c.OnChange != nil {
+		c.OnChange(cUF, tpAmb, true, xJust)
+	}
+	return nil
+}
+
+// Desativa retorna a UF ao autorizador normal.
+func (c *Contingencia) Desativa(cUF int, tpAmb TAmb) {
+	c.mu.Lock()
+	e := c.estado(cUF, tpAmb)
+	mudou := e.ativa
+	*e = estadoContingencia{}
+	c.mu.Unlock()
+
+	if mudou && c.OnChange != nil {
+		c.OnChange(cUF, tpAmb, false, "")
+	}
+}
+
+// EmContingencia indica se a UF está em contingência SVC, retornando também o início (dhCont) e a justificativa (xJust).
+func (c *Contingencia) EmContingencia(cUF int, tpAmb TAmb) (bool, time.Time, string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e := c.estado(cUF, tpAmb)
+	return e.ativa, e.dhCont, e.xJust
+}
+
+// ObservaStatus atualiza o estado da UF a partir do retorno da ConsultaStatServ do seu autorizador normal: cStat 107 (serviço em operação) desativa a contingência e cStat 108/109 (serviço paralisado) a ativa.
+func (c *Contingencia) ObservaStatus(ret RetConsStatServ) {
+	switch ret.CStat {
+	case 107:
+		c.RegistraSucesso(ret.CUF, ret.TpAmb)
+		c.Desativa(ret.CUF, ret.TpAmb)
+	case 108, 109:
+		xJust := justificativaPadraoContingencia
+		if ret.XMotivo != "" {
+			xJust = fmt.Sprintf("%s: %d - %s", justificativaPadraoContingencia, ret.CStat, ret.XMotivo)
+		}
+		if len([]rune(xJust)) > 256 {
+			xJust = string([]rune(xJust)[:256])
+		}
+		c.Ativa(ret.CUF, ret.TpAmb, xJust)
+	}
+}
+
+// ObservaAutorizador atualiza o estado de todas as UFs atendidas pelo autorizador a partir de uma mudança de situação publicada pelo MonitorStatus (ver MonitorStatus.Observa): o cStat da consulta é tratado como na ObservaStatus e a indisponibilidade por falhas de comunicação ativa a contingência. As situações dos autorizadores de contingência são ignoradas.
+func (c *Contingencia) ObservaAutorizador(s StatusAutorizador) {
+	for _, cUF := range c.registry.UFs(s.Autorizador) {
+		if c.registry.Autorizador(cUF) != s.Autorizador {
+			continue
+		}
+		if !s.Saudavel && s.Err != nil {
+			c.Ativa(cUF, s.TpAmb, justificativaPadraoContingencia)
+			continue
+		}
+		ret := s.Ret
+		ret.CUF, ret.TpAmb = cUF, s.TpAmb
+		c.ObservaStatus(ret)
+	}
+}
+
+// RegistraFalha contabiliza uma falha na comunicação com o autorizador da UF. Apenas falhas que indicam indisponibilidade (erros de rede, timeouts e status HTTP 5xx) são consideradas; ao atingir LimiteFalhas consecutivas, a contingência é ativada.
+func (c *Contingencia) RegistraFalha(cUF int, tpAmb TAmb, err error) {
+	if !isFalhaIndisponibilidade(err) {
+		return
+	}
+
+	c.mu.Lock()
+	e := c.estado(cUF, tpAmb)
+	e.falhas++
+	ativar := !e.ativa && c.LimiteFalhas > 0 && e.falhas >= c.LimiteFalhas
+	c.mu.Unlock()
+
+	if ativar {
+		c.Ativa(cUF, tpAmb, justificativaPadraoContingencia)
+	}
+}
+
+// RegistraSucesso zera o contador de falhas consecutivas da UF. Não desativa a contingência, o que deve ser feito pela ObservaStatus ou pela Desativa.
+func (c *Contingencia) RegistraSucesso(cUF int, tpAmb TAmb) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.estado(cUF, tpAmb).falhas = 0
+}
+
+// TpEmis retorna o tipo de emissão a ser usado na UF: 1 (normal), 6 (SVC-AN) ou 7 (SVC-RS).
+func (c *Contingencia) TpEmis(cUF int, tpAmb TAmb) int {
+	if ativa, _, _ := c.EmContingencia(cUF, tpAmb); !ativa {
+		return TpEmisNormal
+	}
+	if c.registry.AutorizadorContingencia(cUF) == "SVC-RS" {
+		return TpEmisSVCRS
+	}
+	return TpEmisSVCAN
+}
+
+// Carimba preenche tpEmis, dhCont e xJust da identificação da NFe (Ide) de acordo com o estado da UF (cUF da Ide). Fora da contingência, tpEmis passa a 1 e dhCont/xJust são limpos.
+func (c *Contingencia) Carimba(ide *Ide) {
+	tpAmb := TAmb(ide.TpAmb)
+	ativa, dhCont, xJust := c.EmContingencia(ide.CUF, tpAmb)
+	ide.TpEmis = c.TpEmis(ide.CUF, tpAmb)
+	if ativa {
+		ide.DhCont = dhCont.Format(layoutDataHora)
+		ide.XJust = xJust
+	} else {
+		ide.DhCont = ""
+		ide.XJust = ""
+	}
+}
+
+// Endpoint retorna o WebService a ser usado para o serviço na UF, para uma nova NFe (carimbada com a Carimba): durante a contingência, a autorização e a consulta do recibo são direcionadas ao SVC da UF. Os demais serviços continuam no autorizador normal (o status, inclusive, deve continuar sendo consultado na UF para detectar a sua recuperação). Os eventos e as consultas de uma NFe já emitida dependem do tpEmis da sua chave de acesso: ver EndpointChave.
+func (c *Contingencia) Endpoint(cUF int, tpAmb TAmb, ws TWebService) (Endpoint, error) {
+	switch ws {
+	case Autorizacao, RetAutorizacao:
+		if ativa, _, _ := c.EmContingencia(cUF, tpAmb); ativa {
+			return c.endpointSVC(cUF, tpAmb, ws, c.TpEmis(cUF, tpAmb))
+		}
+	}
+	return c.registry.Lookup(cUF, tpAmb, ws)
+}
+
+// EndpointChave retorna o WebService para um serviço relacionado a uma NFe, a partir do tpEmis da chave de acesso: a autorização e a consulta do recibo de uma NFe emitida em SVC (tpEmis 6/7) são sempre feitas no SVC indicado, e a consulta do protocolo e os eventos, no SVC enquanto a contingência da UF estiver ativa. As demais notas, inclusive as emitidas durante a contingência com outro tpEmis, usam o autorizador normal da UF, com os WebServices específicos do modelo (ver Registry.LookupModelo).
+func (c *Contingencia) EndpointChave(chave string, tpAmb TAmb, ws TWebService) (Endpoint, error) {
+	cUF, _, _, _, mod, _, _, tpEmis, _, err := GetChaveInfo(chave)
+	if err != nil {
+		return Endpoint{}, err
+	}
+	if tpEmis == strconv.Itoa(TpEmisSVCAN) || tpEmis == strconv.Itoa(TpEmisSVCRS) {
+		svc, _ := strconv.Atoi(tpEmis)
+		switch ws {
+		case Autorizacao, RetAutorizacao:
+			return c.endpointSVC(cUF, tpAmb, ws, svc)
+		case ConsultaProtocolo, Evento:
+			if ativa, _, _ := c.EmContingencia(cUF, tpAmb); ativa {
+				return c.endpointSVC(cUF, tpAmb, ws, svc)
+			}
+		}
+	}
+	return c.registry.LookupModelo(cUF, tpAmb, ws, mod)
+}
+
+// endpointSVC retorna o WebService do SVC do tpEmis informado (6 = SVC-AN, 7 = SVC-RS) para a UF.
+func (c *Contingencia) endpointSVC(cUF int, tpAmb TAmb, ws TWebService, tpEmis int) (Endpoint, error) {
+	svc := "SVC-AN"
+	if tpEmis == TpEmisSVCRS {
+		svc = "SVC-RS"
+	}
+	ep, err := c.registry.LookupAutorizador(svc, tpAmb, ws)
+	if err != nil {
+		return Endpoint{}, err
+	}
+	ep.CUF = cUF
+	return ep, nil
+}
+
+// isFalhaIndisponibilidade indica se o erro caracteriza indisponibilidade do autorizador.
+func isFalhaIndisponibilidade(err error) bool {
+	if err == nil {
+		return false
+	}
+	var wsErr *WSError
+	if errors.As(err, &wsErr) {
+		return wsErr.StatusCode >= http.StatusInternalServerError
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr)
+}
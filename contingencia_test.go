@@ -0,0 +1,280 @@
+package nfe
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+// chaveContingenciaTeste monta uma chave de acesso de SP (modelo 55) com o tpEmis informado.
+func chaveContingenciaTeste(t *testing.T, tpEmis int) string {
+	t.Helper()
+	chave, err := MontaChaveDeAcesso(35, 24, 5, "11222333000181", "55", 1, 1, tpEmis, 12345678)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return chave
+}
+
+func TestContingenciaAtiva(t *testing.T) {
+	c := NewContingencia(nil)
+	inicio := time.Date(2024, 5, 17, 10, 31, 2, 500, time.FixedZone("BRT", -3*3600))
+	c.now = func() time.Time { return inicio }
+
+	var mudancas []bool
+	c.OnChange = func(cUF int, tpAmb TAmb, ativa bool, xJust string) { mudancas = append(mudancas, ativa) }
+
+	for _, xJust := range []string{"curta demais", strings.Repeat("x", 257)} {
+		if err := c.Ativa(35, Producao, xJust); err == nil {
+			t.Errorf("Ativa com justificativa de %d caracteres deveria falhar", len(xJust))
+		}
+	}
+	if err := c.Ativa(99, Producao, justificativaPadraoContingencia); err == nil {
+		t.Errorf("Ativa em UF sem autorizador de contingência deveria falhar")
+	}
+
+	if err := c.Ativa(35, Producao, justificativaPadraoContingencia); err != nil {
+		t.Fatal(err)
+	}
+	c.now = func() time.Time { return inicio.Add(time.Hour) }
+	if err := c.Ativa(35, Producao, "Outra justificativa para a contingência"); err != nil {
+		t.Fatal(err)
+	}
+	ativa, dhCont, xJust := c.EmContingencia(35, Producao)
+	if !ativa || !dhCont.Equal(inicio.Truncate(time.Second)) || xJust != justificativaPadraoContingencia {
+		t.Errorf("EmContingencia = %v, %v, %q: a segunda ativação não deveria mudar o início nem a justificativa", ativa, dhCont, xJust)
+	}
+	if ativa, _, _ := c.EmContingencia(35, Homologacao); ativa {
+		t.Errorf("contingência ativada em produção não deveria valer em homologação")
+	}
+	if tpEmis := c.TpEmis(35, Producao); tpEmis != TpEmisSVCAN {
+		t.Errorf("TpEmis(SP) = %d, esperado %d", tpEmis, TpEmisSVCAN)
+	}
+
+	c.Desativa(35, Producao)
+	c.Desativa(35, Producao)
+	if ativa, _, _ := c.EmContingencia(35, Producao); ativa || c.TpEmis(35, Producao) != TpEmisNormal {
+		t.Errorf("Desativa não retornou a UF ao autorizador normal")
+	}
+	if len(mudancas) != 2 || !mudancas[0] || mudancas[1] {
+		t.Errorf("OnChange chamada com %v, esperado [true false]", mudancas)
+	}
+
+	c.Ativa(41, Producao, justificativaPadraoContingencia)
+	if tpEmis := c.TpEmis(41, Producao); tpEmis != TpEmisSVCRS {
+		t.Errorf("TpEmis(PR) = %d, esperado %d", tpEmis, TpEmisSVCRS)
+	}
+}
+
+func TestContingenciaRegistraFalha(t *testing.T) {
+	indisponivel := &WSError{StatusCode: http.StatusServiceUnavailable}
+	casos := []struct {
+		nome   string
+		limite int
+		falhas []error
+		ativa  bool
+	}{
+		{"abaixo do limite", 3, []error{indisponivel, indisponivel}, false},
+		{"no limite", 3, []error{indisponivel, &net.OpError{Op: "dial", Err: errors.New("connection refused")}, indisponivel}, true},
+		{"erros que não indicam indisponibilidade", 1, []error{&WSError{StatusCode: http.StatusForbidden}, errors.New("XML inválido"), nil}, false},
+		{"limite zero", 0, []error{indisponivel, indisponivel, indisponivel, indisponivel}, false},
+		{"limite um", 1, []error{indisponivel}, true},
+	}
+	for _, c := range casos {
+		t.Run(c.nome, func(t *testing.T) {
+			cont := NewContingencia(nil)
+			cont.LimiteFalhas = c.limite
+			for _, err := range c.falhas {
+				cont.RegistraFalha(35, Producao, err)
+			}
+			if ativa, _, xJust := cont.EmContingencia(35, Producao); ativa != c.ativa || (ativa && xJust != justificativaPadraoContingencia) {
+				t.Errorf("EmContingencia = %v, %q; esperado %v", ativa, xJust, c.ativa)
+			}
+		})
+	}
+
+	// As falhas devem ser consecutivas: um sucesso zera o contador.
+	c := NewContingencia(nil)
+	c.RegistraFalha(35, Producao, indisponivel)
+	c.RegistraFalha(35, Producao, indisponivel)
+	c.RegistraSucesso(35, Producao)
+	c.RegistraFalha(35, Producao, indisponivel)
+	c.RegistraFalha(35, Producao, indisponivel)
+	if ativa, _, _ := c.EmContingencia(35, Producao); ativa {
+		t.Errorf("falhas não consecutivas ativaram a contingência")
+	}
+	c.RegistraFalha(35, Producao, indisponivel)
+	if ativa, _, _ := c.EmContingencia(35, Producao); !ativa {
+		t.Errorf("a terceira falha consecutiva deveria ativar a contingência")
+	}
+}
+
+func TestContingenciaObservaStatus(t *testing.T) {
+	c := NewContingencia(nil)
+	c.ObservaStatus(RetConsStatServ{CUF: 35, TpAmb: Producao, CStat: 108, XMotivo: "Servico Paralisado Momentaneamente"})
+	if ativa, _, xJust := c.EmContingencia(35, Producao); !ativa || !strings.Contains(xJust, "108 - Servico Paralisado") {
+		t.Errorf("cStat 108: %v, %q", ativa, xJust)
+	}
+	c.ObservaStatus(RetConsStatServ{CUF: 35, TpAmb: Producao, CStat: 107})
+	if ativa, _, _ := c.EmContingencia(35, Producao); ativa {
+		t.Errorf("cStat 107 deveria desativar a contingência")
+	}
+}
+
+func TestContingenciaCarimba(t *testing.T) {
+	casos := []struct {
+		nome   string
+		inicio time.Time
+		dhCont string
+	}{
+		{"horário de Brasília", time.Date(2024, 5, 17, 10, 31, 2, 900, time.FixedZone("BRT", -3*3600)), "2024-05-17T10:31:02-03:00"},
+		{"UTC", time.Date(2024, 5, 17, 13, 31, 2, 0, time.UTC), "2024-05-17T13:31:02+00:00"},
+	}
+	for _, caso := range casos {
+		t.Run(caso.nome, func(t *testing.T) {
+			c := NewContingencia(nil)
+			c.now = func() time.Time { return caso.inicio }
+			c.Ativa(35, Homologacao, justificativaPadraoContingencia)
+
+			ide := Ide{CUF: 35, TpAmb: int(Homologacao), TpEmis: TpEmisNormal}
+			c.Carimba(&ide)
+			if ide.TpEmis != TpEmisSVCAN || ide.DhCont != caso.dhCont || ide.XJust != justificativaPadraoContingencia {
+				t.Errorf("Carimba em contingência = tpEmis %d, dhCont %q, xJust %q; esperado dhCont %q", ide.TpEmis, ide.DhCont, ide.XJust, caso.dhCont)
+			}
+
+			c.Desativa(35, Homologacao)
+			c.Carimba(&ide)
+			if ide.TpEmis != TpEmisNormal || ide.DhCont != "" || ide.XJust != "" {
+				t.Errorf("Carimba fora da contingência = tpEmis %d, dhCont %q, xJust %q", ide.TpEmis, ide.DhCont, ide.XJust)
+			}
+		})
+	}
+}
+
+func TestContingenciaEndpoint(t *testing.T) {
+	c := NewContingencia(nil)
+	url := func(autorizador string, ws TWebService) string {
+		ep, err := DefaultRegistry.LookupAutorizador(autorizador, Producao, ws)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return ep.URL
+	}
+	normal := func(ws TWebService) string {
+		ep, err := DefaultRegistry.Lookup(35, Producao, ws)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return ep.URL
+	}
+
+	for _, ws := range []TWebService{Autorizacao, RetAutorizacao, Evento, ConsultaStatus} {
+		if ep, err := c.Endpoint(35, Producao, ws); err != nil || ep.URL != normal(ws) {
+			t.Errorf("Endpoint(%v) fora da contingência = %v, %v", ws, ep.URL, err)
+		}
+	}
+
+	c.Ativa(35, Producao, justificativaPadraoContingencia)
+	casos := []struct {
+		ws  TWebService
+		url string
+	}{
+		{Autorizacao, url("SVC-AN", Autorizacao)},
+		{RetAutorizacao, url("SVC-AN", RetAutorizacao)},
+		// Os eventos dependem da chave de acesso (ver EndpointChave), e o status continua sendo consultado na UF.
+		{Evento, normal(Evento)},
+		{ConsultaStatus, normal(ConsultaStatus)},
+		{ConsultaProtocolo, normal(ConsultaProtocolo)},
+	}
+	for _, caso := range casos {
+		ep, err := c.Endpoint(35, Producao, caso.ws)
+		if err != nil || ep.URL != caso.url || ep.CUF != 35 {
+			t.Errorf("Endpoint(%v) em contingência = %v (UF %d), %v; esperado %v", caso.ws, ep.URL, ep.CUF, err, caso.url)
+		}
+	}
+}
+
+func TestContingenciaEndpointChave(t *testing.T) {
+	c := NewContingencia(nil)
+	normal := chaveContingenciaTeste(t, TpEmisNormal)
+	svcAN := chaveContingenciaTeste(t, TpEmisSVCAN)
+	svcRS := chaveContingenciaTeste(t, TpEmisSVCRS)
+
+	url := func(autorizador string, ws TWebService) string {
+		ep, err := DefaultRegistry.LookupAutorizador(autorizador, Producao, ws)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return ep.URL
+	}
+	uf := func(ws TWebService) string {
+		ep, err := DefaultRegistry.LookupModelo(35, Producao, ws, "55")
+		if err != nil {
+			t.Fatal(err)
+		}
+		return ep.URL
+	}
+
+	casos := []struct {
+		nome  string
+		ativa bool
+		chave string
+		ws    TWebService
+		url   string
+	}{
+		{"NFe normal, autorização", false, normal, Autorizacao, uf(Autorizacao)},
+		{"NFe normal, evento em contingência", true, normal, Evento, uf(Evento)},
+		{"NFe normal, consulta em contingência", true, normal, ConsultaProtocolo, uf(ConsultaProtocolo)},
+		{"SVC-AN, autorização fora da contingência", false, svcAN, Autorizacao, url("SVC-AN", Autorizacao)},
+		{"SVC-AN, recibo", false, svcAN, RetAutorizacao, url("SVC-AN", RetAutorizacao)},
+		{"SVC-RS, autorização", false, svcRS, Autorizacao, url("SVC-RS", Autorizacao)},
+		{"SVC-AN, evento em contingência", true, svcAN, Evento, url("SVC-AN", Evento)},
+		{"SVC-AN, consulta em contingência", true, svcAN, ConsultaProtocolo, url("SVC-AN", ConsultaProtocolo)},
+		{"SVC-AN, evento após a contingência", false, svcAN, Evento, uf(Evento)},
+		{"SVC-AN, consulta após a contingência", false, svcAN, ConsultaProtocolo, uf(ConsultaProtocolo)},
+	}
+	for _, caso := range casos {
+		t.Run(caso.nome, func(t *testing.T) {
+			if caso.ativa {
+				c.Ativa(35, Producao, justificativaPadraoContingencia)
+			} else {
+				c.Desativa(35, Producao)
+			}
+			ep, err := c.EndpointChave(caso.chave, Producao, caso.ws)
+			if err != nil || ep.URL != caso.url || ep.CUF != 35 {
+				t.Errorf("EndpointChave = %v (UF %d), %v; esperado %v", ep.URL, ep.CUF, err, caso.url)
+			}
+		})
+	}
+
+	if _, err := c.EndpointChave("123", Producao, Evento); err == nil {
+		t.Errorf("EndpointChave com chave inválida deveria falhar")
+	}
+}
+
+func TestEndpointEvento(t *testing.T) {
+	DefaultContingencia.Ativa(35, Producao, justificativaPadraoContingencia)
+	t.Cleanup(func() { DefaultContingencia.Desativa(35, Producao) })
+
+	svc, _ := DefaultRegistry.LookupAutorizador("SVC-AN", Producao, Evento)
+	uf, _ := DefaultRegistry.Lookup(35, Producao, Evento)
+	an, _ := DefaultRegistry.Lookup(91, Producao, Evento)
+
+	casos := []struct {
+		nome string
+		ev   ManifestacaoEvento
+		url  string
+	}{
+		{"NFe normal", ManifestacaoEvento{COrgao: 35, TpAmb: int(Producao), ChNFe: chaveContingenciaTeste(t, TpEmisNormal)}, uf.URL},
+		{"NFe emitida em SVC", ManifestacaoEvento{COrgao: 35, TpAmb: int(Producao), ChNFe: chaveContingenciaTeste(t, TpEmisSVCAN)}, svc.URL},
+		{"Ambiente Nacional", ManifestacaoEvento{COrgao: 91, TpAmb: int(Producao), ChNFe: chaveContingenciaTeste(t, TpEmisSVCAN)}, an.URL},
+	}
+	for _, caso := range casos {
+		if ep, err := endpointEvento(caso.ev); err != nil || ep.URL != caso.url {
+			t.Errorf("%s: endpointEvento = %v, %v; esperado %v", caso.nome, ep.URL, err, caso.url)
+		}
+	}
+}
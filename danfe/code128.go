@@ -0,0 +1,72 @@
+package danfe
+
+import "fmt"
+
+// padroesCode128 contém as larguras (em módulos) de barra, espaço, barra, espaço, barra e espaço de cada símbolo do Code 128, pelo seu valor. O último é o símbolo de parada, que tem uma barra a mais.
+var padroesCode128 = [...]string{
+	"212222", "222122", "222221", "121223", "121322", "131222", "122213", "122312", "132212", "221213",
+	"221312", "231212", "112232", "122132", "122231", "113222", "123122", "123221", "223211", "221132",
+	"221231", "213212", "223112", "312131", "311222", "321122", "321221", "312212", "322112", "322211",
+	"212123", "212321", "232121", "111323", "131123", "131321", "112313", "132113", "132311", "211313",
+	"231113", "231311", "112133", "112331", "132131", "113123", "113321", "133121", "313121", "211331",
+	"231131", "213113", "213311", "213131", "311123", "311321", "331121", "312113", "312311", "332111",
+	"314111", "221411", "431111", "111224", "111422", "121124", "121421", "141122", "141221", "112214",
+	"112412", "122114", "122411", "142112", "142211", "241211", "221114", "413111", "241112", "134111",
+	"111242", "121142", "121241", "114212", "124112", "124211", "411212", "421112", "421211", "212141",
+	"214121", "412121", "111143", "111341", "131141", "114113", "114311", "411113", "411311", "113141",
+	"114131", "311141", "411131", "211412", "211214", "211232", "2331112",
+}
+
+const (
+	code128StartC = 105
+	code128Stop   = 106
+)
+
+// code128C codifica uma sequência de dígitos (em quantidade par) no Code 128, conjunto C, como é feito com a chave de acesso no DANFE. Retorna as larguras, em módulos, alternando barras e espaços e começando por uma barra (incluindo o símbolo de início, o dígito verificador e a parada).
+func code128C(digitos string) ([]int, error) {
+	if len(digitos)%2 != 0 {
+		return nil, fmt.Errorf("Code 128C exige uma quantidade par de dígitos: %q", digitos)
+	}
+
+	valores := []int{code128StartC}
+	soma := code128StartC
+	for i := 0; i < len(digitos); i += 2 {
+		d1, d2 := digitos[i], digitos[i+1]
+		if d1 < '0' || d1 > '9' || d2 < '0' || d2 > '9' {
+			return nil, fmt.Errorf("Code 128C aceita apenas dígitos: %q", digitos)
+		}
+		v := int(d1-'0')*10 + int(d2-'0')
+		valores = append(valores, v)
+		soma += v * (i/2 + 1)
+	}
+	valores = append(valores, soma%103, code128Stop)
+
+	var larguras []int
+	for _, v := range valores {
+		for _, c := range padroesCode128[v] {
+			larguras = append(larguras, int(c-'0'))
+		}
+	}
+	return larguras, nil
+}
+
+// codigoDeBarras desenha o Code 128C dos dígitos, ocupando a largura w a partir de x.
+func (p *pagina) codigoDeBarras(x, y, w, h float64, digitos string) error {
+	larguras, err := code128C(digitos)
+	if err != nil {
+		return err
+	}
+	modulos := 0
+	for _, l := range larguras {
+		modulos += l
+	}
+
+	modulo := w / float64(modulos)
+	for i, l := range larguras {
+		if i%2 == 0 {
+			p.preenche(x, y, float64(l)*modulo, h)
+		}
+		x += float64(l) * modulo
+	}
+	return nil
+}
@@ -0,0 +1,708 @@
+// O package danfe gera os documentos auxiliares da NFe em PDF, sem dependências externas: o DANFE a partir do XML (nfeProc) ou do modelo semântico da biblioteca (nfe.NotaFiscalDistribuida).
+package danfe
+
+import (
+	"fmt"
+	"math"
+	"strings"
+
+	"github.com/eduardotorresdev/nfe"
+)
+
+// Orientações do DANFE.
+const (
+	Automatica = 0 // de acordo com o tpImp da nota (1 = retrato, 2 = paisagem)
+	Retrato    = 1
+	Paisagem   = 2
+)
+
+// Opcoes personaliza a geração do DANFE.
+type Opcoes struct {
+	Orientacao int
+
+	// Cancelada aplica o carimbo de nota cancelada (a situação da nota não consta do nfeProc e deve ser obtida, por exemplo, pela ConsultaNFe).
+	Cancelada bool
+}
+
+const (
+	margem        = 5.0
+	alturaLinha   = 7.0 // altura dos campos
+	alturaTitulo  = 3.0 // altura dos títulos das seções
+	alturaAdic    = 30.0
+	entrelinhaItm = 2.6
+	tamItem       = 6.0
+)
+
+// GeraXML gera o DANFE em PDF a partir do XML da NFe (nfeProc, ou NFe ainda sem protocolo, por exemplo na contingência).
+func GeraXML(xmlNFe []byte, opts Opcoes) ([]byte, error) {
+	nota, err := nfe.LeNotaFiscal(xmlNFe)
+	if err != nil {
+		return nil, err
+	}
+	return Gera(nota, opts)
+}
+
+// Gera gera o DANFE em PDF a partir do modelo semântico da nota.
+func Gera(nota nfe.NotaFiscalDistribuida, opts Opcoes) ([]byte, error) {
+	if len(nota.Chave) != 44 {
+		return nil, fmt.Errorf("Chave de acesso inválida para o DANFE: %q", nota.Chave)
+	}
+
+	orientacao := opts.Orientacao
+	if orientacao == Automatica {
+		orientacao = Retrato
+		if nota.TipoImpressao == 2 {
+			orientacao = Paisagem
+		}
+	}
+
+	g := &gerador{nota: nota, opts: opts}
+	if orientacao == Paisagem {
+		// Na paisagem, o canhoto fica na lateral esquerda.
+		g.doc = novoDocumento(297, 210)
+		g.paisagem = true
+		g.x0 = margem + 19
+	} else {
+		g.doc = novoDocumento(210, 297)
+		g.x0 = margem
+	}
+	g.larg = g.doc.largura - margem - g.x0
+
+	if err := g.gera(); err != nil {
+		return nil, err
+	}
+	return g.doc.bytes(), nil
+}
+
+type gerador struct {
+	nota     nfe.NotaFiscalDistribuida
+	opts     Opcoes
+	doc      *documento
+	paisagem bool
+	x0, larg float64
+}
+
+type linhaItem struct {
+	item      nfe.ItemNotaFiscal
+	descricao []string
+	altura    float64
+}
+
+type coluna struct {
+	rotulo  string
+	largura float64
+	alin    alinhamento
+	valor   func(it nfe.ItemNotaFiscal) string
+}
+
+func (g *gerador) colunas() []coluna {
+	cst := func(it nfe.ItemNotaFiscal) string {
+		if it.ICMS == nil {
+			return ""
+		}
+		return fmt.Sprintf("%d%s%s", it.ICMS.Origem, it.ICMS.CST, it.ICMS.CSOSN)
+	}
+	icms := func(f func(*nfe.ICMSItem) float64) func(it nfe.ItemNotaFiscal) string {
+		return func(it nfe.ItemNotaFiscal) string {
+			if it.ICMS == nil {
+				return moeda(0)
+			}
+			return moeda(f(it.ICMS))
+		}
+	}
+	ipi := func(f func(*nfe.IPIItem) float64) func(it nfe.ItemNotaFiscal) string {
+		return func(it nfe.ItemNotaFiscal) string {
+			if it.IPI == nil {
+				return moeda(0)
+			}
+			return moeda(f(it.IPI))
+		}
+	}
+
+	cols := []coluna{
+		{"CÓDIGO PRODUTO", 17, esquerda, func(it nfe.ItemNotaFiscal) string { return it.Codigo }},
+		{"DESCRIÇÃO DO PRODUTO / SERVIÇO", 0, esquerda, nil},
+		{"NCM/SH", 12, centro, func(it nfe.ItemNotaFiscal) string { return it.NCM }},
+		{"O/CST", 8, centro, cst},
+		{"CFOP", 8, centro, func(it nfe.ItemNotaFiscal) string { return it.CFOP }},
+		{"UN", 8, centro, func(it nfe.ItemNotaFiscal) string { return it.Unidade }},
+		{"QUANT.", 14, direita, func(it nfe.ItemNotaFiscal) string { return decimal(it.Quantidade, 4) }},
+		{"VALOR UNIT.", 15, direita, func(it nfe.ItemNotaFiscal) string { return decimal(it.ValorUnitario, 4) }},
+		{"VALOR TOTAL", 15, direita, func(it nfe.ItemNotaFiscal) string { return moeda(it.ValorTotal) }},
+		{"B.CÁLC ICMS", 14, direita, icms(func(i *nfe.ICMSItem) float64 { return i.BaseCalculo })},
+		{"VALOR ICMS", 12, direita, icms(func(i *nfe.ICMSItem) float64 { return i.Valor })},
+		{"VALOR IPI", 11, direita, ipi(func(i *nfe.IPIItem) float64 { return i.Valor })},
+		{"ALÍQ. ICMS", 8, direita, icms(func(i *nfe.ICMSItem) float64 { return i.Aliquota })},
+		{"ALÍQ. IPI", 7, direita, ipi(func(i *nfe.IPIItem) float64 { return i.Aliquota })},
+	}
+
+	fixas := 0.0
+	for _, c := range cols {
+		fixas += c.largura
+	}
+	cols[1].largura = g.larg - fixas
+	return cols
+}
+
+// Posições verticais das áreas de itens na primeira página e nas seguintes.
+func (g *gerador) topoItensPrimeira() float64 {
+	y := margem
+	if !g.paisagem {
+		y += 19 // canhoto
+	}
+	y += 48 + 3*alturaLinha + alturaTitulo // cabeçalho e destinatário
+	y += g.alturaFatura() + alturaTitulo
+	y += 2*alturaLinha + alturaTitulo // cálculo do imposto
+	y += 3*alturaLinha + alturaTitulo // transportador
+	return y + alturaTitulo + 6       // título e cabeçalho da tabela de itens
+}
+
+func (g *gerador) baseItensPrimeira() float64 {
+	return g.doc.altura - margem - alturaAdic - alturaTitulo
+}
+
+func (g *gerador) topoItensDemais() float64 {
+	return margem + 48 + alturaTitulo + 6
+}
+
+func (g *gerador) baseItensDemais() float64 {
+	return g.doc.altura - margem
+}
+
+// pagina distribui os itens pelas páginas.
+func (g *gerador) paginacao(cols []coluna) [][]linhaItem {
+	var linhas []linhaItem
+	for _, it := range g.nota.Itens {
+		desc := it.Descricao
+		if it.Observacao != "" {
+			desc += "\n" + it.Observacao
+		}
+		l := quebraLinhas(normal, tamItem, cols[1].largura-1, desc)
+		if len(l) == 0 {
+			l = []string{""}
+		}
+		linhas = append(linhas, linhaItem{item: it, descricao: l, altura: float64(len(l))*entrelinhaItm + 1})
+	}
+
+	paginas := [][]linhaItem{nil}
+	disponivel := g.baseItensPrimeira() - g.topoItensPrimeira()
+	for _, l := range linhas {
+		if l.altura > disponivel && len(paginas[len(paginas)-1]) > 0 {
+			paginas = append(paginas, nil)
+			disponivel = g.baseItensDemais() - g.topoItensDemais()
+		}
+		if l.altura > disponivel {
+			// Item que não cabe nem em uma página inteira: a descrição é cortada.
+			n := int((disponivel - 1) / entrelinhaItm)
+			l.descricao, l.altura = l.descricao[:n], float64(n)*entrelinhaItm+1
+		}
+		paginas[len(paginas)-1] = append(paginas[len(paginas)-1], l)
+		disponivel -= l.altura
+	}
+	return paginas
+}
+
+func (g *gerador) gera() error {
+	cols := g.colunas()
+	paginas := g.paginacao(cols)
+
+	for i, itens := range paginas {
+		p := g.doc.novaPagina()
+		g.marcaDagua(p)
+		p.espessura(0.2)
+
+		y := margem
+		if i == 0 {
+			if g.paisagem {
+				g.canhotoLateral(p)
+			} else {
+				y = g.canhoto(p, y)
+			}
+		}
+		y, err := g.cabecalho(p, y, i+1, len(paginas))
+		if err != nil {
+			return err
+		}
+
+		base := g.baseItensDemais()
+		if i == 0 {
+			y = g.destinatario(p, y)
+			y = g.fatura(p, y)
+			y = g.imposto(p, y)
+			y = g.transportador(p, y)
+			base = g.baseItensPrimeira()
+		}
+		g.itens(p, y, base, cols, itens)
+		if i == 0 {
+			g.dadosAdicionais(p, base)
+		}
+
+		if g.opts.Cancelada {
+			g.carimbo(p, "CANCELADA", 1, 0, 0)
+		}
+	}
+	return nil
+}
+
+// campo desenha um campo do DANFE: um retângulo com o rótulo na parte superior e o valor na inferior.
+func (g *gerador) campo(p *pagina, x, y, w, h float64, rotulo, valor string, alin alinhamento) {
+	p.retangulo(x, y, w, h)
+	p.textoAlinhado(x+0.8, y+2.2, w-1.6, normal, 5, rotulo, esquerda)
+	p.textoAlinhado(x+0.8, y+h-1.4, w-1.6, normal, 8, valor, alin)
+}
+
+type defCampo struct {
+	rotulo string
+	valor  string
+	fracao float64 // fração da largura útil; o último campo ocupa o restante
+	alin   alinhamento
+}
+
+func (g *gerador) linhaCampos(p *pagina, y float64, campos ...defCampo) float64 {
+	x := g.x0
+	for i, c := range campos {
+		w := c.fracao * g.larg
+		if i == len(campos)-1 {
+			w = g.x0 + g.larg - x
+		}
+		g.campo(p, x, y, w, alturaLinha, c.rotulo, c.valor, c.alin)
+		x += w
+	}
+	return y + alturaLinha
+}
+
+func (g *gerador) titulo(p *pagina, y float64, titulo string) float64 {
+	p.texto(g.x0, y+2.4, negrito, 6, titulo)
+	return y + alturaTitulo
+}
+
+func (g *gerador) canhoto(p *pagina, y float64) float64 {
+	n := g.nota
+	wNF := 0.2 * g.larg
+	w := g.larg - wNF
+
+	p.retangulo(g.x0, y, w, 8)
+	for i, l := range quebraLinhas(normal, 5.5, w-2, g.textoCanhoto()) {
+		if i == 3 {
+			break
+		}
+		p.texto(g.x0+1, y+2.4+float64(i)*2, normal, 5.5, l)
+	}
+	g.campo(p, g.x0, y+8, 0.25*g.larg, 9, "DATA DE RECEBIMENTO", "", esquerda)
+	g.campo(p, g.x0+0.25*g.larg, y+8, w-0.25*g.larg, 9, "IDENTIFICAÇÃO E ASSINATURA DO RECEBEDOR", "", esquerda)
+
+	p.retangulo(g.x0+w, y, wNF, 17)
+	p.textoAlinhado(g.x0+w, y+5, wNF, negrito, 10, "NF-e", centro)
+	p.textoAlinhado(g.x0+w, y+10, wNF, negrito, 8, "Nº "+numeroNF(n.Numero), centro)
+	p.textoAlinhado(g.x0+w, y+14, wNF, negrito, 8, fmt.Sprintf("SÉRIE %03d", n.Serie), centro)
+
+	p.tracejado(1, 1)
+	p.linha(g.x0, y+18.5, g.x0+g.larg, y+18.5)
+	p.tracejado()
+	return y + 19
+}
+
+// canhotoLateral desenha o canhoto na lateral esquerda (DANFE paisagem), com o texto girado.
+func (g *gerador) canhotoLateral(p *pagina) {
+	n := g.nota
+	x, y, w, h := margem, margem, 17.0, g.doc.altura-2*margem
+	hNF := 30.0
+
+	// De baixo para cima: número da nota, texto, data e assinatura.
+	p.retangulo(x, y, w, h)
+	p.linha(x, y+hNF, x+w, y+hNF)
+	p.textoRotacionado(x+5, y+hNF-3, 90, negrito, 9, "NF-e")
+	p.textoRotacionado(x+9.5, y+hNF-3, 90, negrito, 7, "Nº "+numeroNF(n.Numero))
+	p.textoRotacionado(x+13.5, y+hNF-3, 90, negrito, 7, fmt.Sprintf("SÉRIE %03d", n.Serie))
+
+	p.linha(x+8, y+hNF, x+8, y+h)
+	for i, l := range quebraLinhas(normal, 5.5, h-hNF-2, g.textoCanhoto()) {
+		if i == 3 {
+			break
+		}
+		p.textoRotacionado(x+2.4+float64(i)*2, y+h-1, 90, normal, 5.5, l)
+	}
+	meio := y + hNF + (h-hNF)*0.3
+	p.linha(x+8, meio, x+w, meio)
+	p.textoRotacionado(x+10.2, y+h-1, 90, normal, 5, "DATA DE RECEBIMENTO")
+	p.textoRotacionado(x+10.2, meio-1, 90, normal, 5, "IDENTIFICAÇÃO E ASSINATURA DO RECEBEDOR")
+
+	p.tracejado(1, 1)
+	p.linha(x+w+1, y, x+w+1, y+h)
+	p.tracejado()
+}
+
+func (g *gerador) textoCanhoto() string {
+	n := g.nota
+	dest := n.Destinatario
+	end := strings.Join(naoVazios(dest.Endereco.Logradouro, dest.Endereco.Numero, dest.Endereco.Bairro, dest.Endereco.Municipio, dest.Endereco.UF), ", ")
+	return fmt.Sprintf("RECEBEMOS DE %s OS PRODUTOS E/OU SERVIÇOS CONSTANTES DA NOTA FISCAL ELETRÔNICA INDICADA AO LADO. EMISSÃO: %s VALOR TOTAL: R$ %s DESTINATÁRIO: %s - %s",
+		n.Emitente.Nome, data(n.DataEmissao), moeda(n.Totais.ValorNota), dest.Nome, end)
+}
+
+func (g *gerador) cabecalho(p *pagina, y float64, folha, folhas int) (float64, error) {
+	n := g.nota
+	e := n.Emitente
+	h := 34.0
+
+	// Emitente
+	wEmit := 0.40 * g.larg
+	p.retangulo(g.x0, y, wEmit, h)
+	yl := y + 6
+	for i, l := range quebraLinhas(negrito, 9, wEmit-4, e.Nome) {
+		if i == 2 {
+			break
+		}
+		p.textoAlinhado(g.x0+2, yl, wEmit-4, negrito, 9, l, centro)
+		yl += 4
+	}
+	yl += 1
+	end := e.Endereco
+	for _, l := range []string{
+		strings.Join(naoVazios(end.Logradouro, end.Numero, end.Complemento), ", "),
+		strings.Join(naoVazios(end.Bairro, cep(end.CEP)), " - "),
+		strings.Join(naoVazios(end.Municipio, end.UF), " - "),
+		prefixo("Fone: ", end.Telefone),
+	} {
+		if l != "" {
+			p.textoAlinhado(g.x0+2, yl, wEmit-4, normal, 7, l, centro)
+			yl += 3.2
+		}
+	}
+
+	// Identificação do DANFE
+	xD, wD := g.x0+wEmit, 0.16*g.larg
+	p.retangulo(xD, y, wD, h)
+	p.textoAlinhado(xD, y+6, wD, negrito, 12, "DANFE", centro)
+	p.textoAlinhado(xD, y+9.5, wD, normal, 6, "DOCUMENTO AUXILIAR DA", centro)
+	p.textoAlinhado(xD, y+12, wD, normal, 6, "NOTA FISCAL ELETRÔNICA", centro)
+	p.texto(xD+2, y+16.5, normal, 6, "0 - ENTRADA")
+	p.texto(xD+2, y+19.5, normal, 6, "1 - SAÍDA")
+	p.retangulo(xD+wD-8, y+14.5, 5, 5.5)
+	p.textoAlinhado(xD+wD-8, y+18.6, 5, negrito, 10, fmt.Sprint(n.TipoOperacao), centro)
+	p.textoAlinhado(xD, y+25, wD, negrito, 8, "Nº "+numeroNF(n.Numero), centro)
+	p.textoAlinhado(xD, y+28.5, wD, negrito, 8, fmt.Sprintf("SÉRIE %03d", n.Serie), centro)
+	p.textoAlinhado(xD, y+32, wD, normal, 7, fmt.Sprintf("FOLHA %d/%d", folha, folhas), centro)
+
+	// Código de barras e chave de acesso
+	xC := xD + wD
+	wC := g.x0 + g.larg - xC
+	p.retangulo(xC, y, wC, 13)
+	if err := p.codigoDeBarras(xC+3, y+1.5, wC-6, 10, n.Chave); err != nil {
+		return 0, err
+	}
+	g.campo(p, xC, y+13, wC, 8, "CHAVE DE ACESSO", chave(n.Chave), centro)
+	p.retangulo(xC, y+21, wC, 13)
+	consulta := "Consulta de autenticidade no portal nacional da NF-e www.nfe.fazenda.gov.br/portal ou no site da Sefaz Autorizadora"
+	if n.Protocolo.Numero == "" && n.TipoEmissao != 1 && n.TipoEmissao != 0 {
+		consulta = "DANFE EM CONTINGÊNCIA. IMPRESSO EM DECORRÊNCIA DE PROBLEMAS TÉCNICOS"
+	}
+	for i, l := range quebraLinhas(normal, 7, wC-4, consulta) {
+		p.textoAlinhado(xC+2, y+26+float64(i)*3.2, wC-4, normal, 7, l, centro)
+	}
+	y += h
+
+	y = g.linhaCampos(p, y,
+		defCampo{"NATUREZA DA OPERAÇÃO", n.NaturezaOperacao, 0.56, esquerda},
+		defCampo{"PROTOCOLO DE AUTORIZAÇÃO DE USO", g.protocolo(), 0, centro},
+	)
+	y = g.linhaCampos(p, y,
+		defCampo{"INSCRIÇÃO ESTADUAL", e.IE, 1.0 / 3, esquerda},
+		defCampo{"INSC. ESTADUAL DO SUBST. TRIB.", e.IEST, 1.0 / 3, esquerda},
+		defCampo{"CNPJ / CPF", documentoFederal(e.CNPJ, e.CPF), 0, esquerda},
+	)
+	return y, nil
+}
+
+func (g *gerador) protocolo() string {
+	prot := g.nota.Protocolo
+	if prot.Numero == "" {
+		if g.nota.TipoEmissao != 1 && g.nota.TipoEmissao != 0 {
+			return "EMISSÃO EM CONTINGÊNCIA"
+		}
+		return ""
+	}
+	return strings.TrimSpace(prot.Numero + " - " + dataHora(prot.DataRecebimento))
+}
+
+func (g *gerador) destinatario(p *pagina, y float64) float64 {
+	n := g.nota
+	d := n.Destinatario
+	end := d.Endereco
+
+	y = g.titulo(p, y, "DESTINATÁRIO / REMETENTE")
+	y = g.linhaCampos(p, y,
+		defCampo{"NOME / RAZÃO SOCIAL", d.Nome, 0.60, esquerda},
+		defCampo{"CNPJ / CPF", documentoFederal(d.CNPJ, d.CPF), 0.25, centro},
+		defCampo{"DATA DA EMISSÃO", data(n.DataEmissao), 0, centro},
+	)
+	y = g.linhaCampos(p, y,
+		defCampo{"ENDEREÇO", strings.Join(naoVazios(end.Logradouro, end.Numero, end.Complemento), ", "), 0.45, esquerda},
+		defCampo{"BAIRRO / DISTRITO", end.Bairro, 0.25, esquerda},
+		defCampo{"CEP", cep(end.CEP), 0.15, centro},
+		defCampo{"DATA DA SAÍDA/ENTRADA", data(n.DataSaidaEntrada), 0, centro},
+	)
+	y = g.linhaCampos(p, y,
+		defCampo{"MUNICÍPIO", end.Municipio, 0.40, esquerda},
+		defCampo{"FONE / FAX", end.Telefone, 0.20, esquerda},
+		defCampo{"UF", end.UF, 0.05, centro},
+		defCampo{"INSCRIÇÃO ESTADUAL", d.IE, 0.20, esquerda},
+		defCampo{"HORA DA SAÍDA/ENTRADA", hora(n.DataSaidaEntrada), 0, centro},
+	)
+	return y
+}
+
+func (g *gerador) textoFatura() string {
+	n := g.nota
+	var partes []string
+	if c := n.Cobranca; c != nil {
+		if c.NumeroFatura != "" || c.ValorOriginal != 0 {
+			partes = append(partes, fmt.Sprintf("Fatura %s - Valor original R$ %s - Desconto R$ %s - Valor líquido R$ %s",
+				c.NumeroFatura, moeda(c.ValorOriginal), moeda(c.Desconto), moeda(c.ValorLiquido)))
+		}
+		for _, d := range c.Duplicatas {
+			partes = append(partes, fmt.Sprintf("Dup. %s venc. %s R$ %s", d.Numero, data(d.Vencimento), moeda(d.Valor)))
+		}
+	}
+	if len(partes) == 0 {
+		for _, pg := range n.Pagamentos {
+			forma := "PAGAMENTO À VISTA"
+			if pg.Indicador == 1 {
+				forma = "PAGAMENTO A PRAZO"
+			}
+			partes = append(partes, fmt.Sprintf("%s R$ %s", forma, moeda(pg.Valor)))
+		}
+	}
+	return strings.Join(partes, "   ")
+}
+
+// alturaFatura retorna a altura do quadro de fatura/duplicatas, que depende da quantidade de duplicatas (até 4 linhas).
+func (g *gerador) alturaFatura() float64 {
+	n := len(quebraLinhas(normal, 7, g.larg-2, g.textoFatura()))
+	n = max(1, min(n, 4))
+	return float64(n)*3 + 2
+}
+
+func (g *gerador) fatura(p *pagina, y float64) float64 {
+	y = g.titulo(p, y, "FATURA / DUPLICATA")
+	h := g.alturaFatura()
+	p.retangulo(g.x0, y, g.larg, h)
+	for i, l := range quebraLinhas(normal, 7, g.larg-2, g.textoFatura()) {
+		if i == 4 {
+			break
+		}
+		p.texto(g.x0+1, y+3.2+float64(i)*3, normal, 7, l)
+	}
+	return y + h
+}
+
+func (g *gerador) imposto(p *pagina, y float64) float64 {
+	t := g.nota.Totais
+	y = g.titulo(p, y, "CÁLCULO DO IMPOSTO")
+	y = g.linhaCampos(p, y,
+		defCampo{"BASE DE CÁLC. DO ICMS", moeda(t.ValorBaseICMS), 0.2, direita},
+		defCampo{"VALOR DO ICMS", moeda(t.ValorICMS), 0.2, direita},
+		defCampo{"BASE DE CÁLC. ICMS S.T.", moeda(t.ValorBaseICMSST), 0.2, direita},
+		defCampo{"VALOR DO ICMS SUBST.", moeda(t.ValorICMSST), 0.2, direita},
+		defCampo{"V. TOTAL PRODUTOS", moeda(t.ValorProdutos), 0, direita},
+	)
+	y = g.linhaCampos(p, y,
+		defCampo{"VALOR DO FRETE", moeda(t.ValorFrete), 1.0 / 6, direita},
+		defCampo{"VALOR DO SEGURO", moeda(t.ValorSeguro), 1.0 / 6, direita},
+		defCampo{"DESCONTO", moeda(t.ValorDesconto), 1.0 / 6, direita},
+		defCampo{"OUTRAS DESPESAS", moeda(t.ValorOutros), 1.0 / 6, direita},
+		defCampo{"VALOR TOTAL IPI", moeda(t.ValorIPI), 1.0 / 6, direita},
+		defCampo{"V. TOTAL DA NOTA", moeda(t.ValorNota), 0, direita},
+	)
+	return y
+}
+
+var modalidadesFrete = map[int]string{
+	0: "0-Por conta do Rem",
+	1: "1-Por conta do Dest",
+	2: "2-Por conta de Terceiros",
+	3: "3-Próprio por conta do Rem",
+	4: "4-Próprio por conta do Dest",
+	9: "9-Sem Transporte",
+}
+
+func (g *gerador) transportador(p *pagina, y float64) float64 {
+	var tr nfe.TransporteNotaFiscal
+	if g.nota.Transporte != nil {
+		tr = *g.nota.Transporte
+	}
+	var tp nfe.TransportadoraNotaFiscal
+	if tr.Transportadora != nil {
+		tp = *tr.Transportadora
+	}
+	frete := modalidadesFrete[tr.ModalidadeFrete]
+	if g.nota.Transporte == nil {
+		frete = modalidadesFrete[9]
+	}
+	peso := func(v float64) string {
+		if v == 0 {
+			return ""
+		}
+		return decimal(v, 3)
+	}
+	qtd := ""
+	if tr.QuantidadeVolumes != 0 {
+		qtd = decimal(tr.QuantidadeVolumes, 0)
+	}
+
+	y = g.titulo(p, y, "TRANSPORTADOR / VOLUMES TRANSPORTADOS")
+	y = g.linhaCampos(p, y,
+		defCampo{"NOME / RAZÃO SOCIAL", tp.Nome, 0.35, esquerda},
+		defCampo{"FRETE POR CONTA", frete, 0.17, centro},
+		defCampo{"CÓDIGO ANTT", "", 0.1, centro},
+		defCampo{"PLACA DO VEÍCULO", "", 0.11, centro},
+		defCampo{"UF", "", 0.05, centro},
+		defCampo{"CNPJ / CPF", documentoFederal(tp.CNPJ, ""), 0, centro},
+	)
+	y = g.linhaCampos(p, y,
+		defCampo{"ENDEREÇO", tp.Endereco, 0.45, esquerda},
+		defCampo{"MUNICÍPIO", tp.Municipio, 0.28, esquerda},
+		defCampo{"UF", tp.UF, 0.05, centro},
+		defCampo{"INSCRIÇÃO ESTADUAL", tp.IE, 0, esquerda},
+	)
+	y = g.linhaCampos(p, y,
+		defCampo{"QUANTIDADE", qtd, 1.0 / 6, direita},
+		defCampo{"ESPÉCIE", tr.Especie, 1.0 / 6, esquerda},
+		defCampo{"MARCA", tr.Marca, 1.0 / 6, esquerda},
+		defCampo{"NUMERAÇÃO", "", 1.0 / 6, esquerda},
+		defCampo{"PESO BRUTO", peso(tr.PesoBruto), 1.0 / 6, direita},
+		defCampo{"PESO LÍQUIDO", peso(tr.PesoLiquido), 0, direita},
+	)
+	return y
+}
+
+func (g *gerador) itens(p *pagina, y, base float64, cols []coluna, itens []linhaItem) {
+	y = g.titulo(p, y, "DADOS DOS PRODUTOS / SERVIÇOS")
+
+	// Cabeçalho da tabela
+	x := g.x0
+	for _, c := range cols {
+		p.retangulo(x, y, c.largura, 6)
+		rot := quebraLinhas(normal, 5, c.largura-1, c.rotulo)
+		for i, l := range rot {
+			if i == 2 {
+				break
+			}
+			p.textoAlinhado(x+0.5, y+2.6+float64(i)*2.2+float64(2-min(len(rot), 2))*1.1, c.largura-1, normal, 5, l, centro)
+		}
+		x += c.largura
+	}
+	y += 6
+
+	// Colunas até a base da área de itens
+	x = g.x0
+	for _, c := range cols {
+		p.retangulo(x, y, c.largura, base-y)
+		x += c.largura
+	}
+
+	for _, l := range itens {
+		x = g.x0
+		for i, c := range cols {
+			if i == 1 {
+				for j, d := range l.descricao {
+					p.textoAlinhado(x+0.5, y+2.4+float64(j)*entrelinhaItm, c.largura-1, normal, tamItem, d, esquerda)
+				}
+			} else {
+				p.textoAlinhado(x+0.5, y+2.4, c.largura-1, normal, tamItem, c.valor(l.item), c.alin)
+			}
+			x += c.largura
+		}
+		y += l.altura
+		p.corTraco(0.8, 0.8, 0.8)
+		p.linha(g.x0, y, g.x0+g.larg, y)
+		p.corTraco(0, 0, 0)
+	}
+}
+
+func (g *gerador) dadosAdicionais(p *pagina, y float64) {
+	n := g.nota
+	y = g.titulo(p, y, "DADOS ADICIONAIS")
+
+	var info []string
+	if !n.DataContingencia.IsZero() || n.JustificativaContingencia != "" {
+		info = append(info, fmt.Sprintf("Contingência (tpEmis %d) desde %s: %s", n.TipoEmissao, dataHora(n.DataContingencia), n.JustificativaContingencia))
+	}
+	if n.Totais.ValorTributosAproximado != 0 {
+		info = append(info, fmt.Sprintf("Valor aproximado dos tributos: R$ %s", moeda(n.Totais.ValorTributosAproximado)))
+	}
+	if n.InformacoesComplementares != "" {
+		info = append(info, n.InformacoesComplementares)
+	}
+
+	wInf := 0.65 * g.larg
+	p.retangulo(g.x0, y, wInf, alturaAdic)
+	p.texto(g.x0+0.8, y+2.2, normal, 5, "INFORMAÇÕES COMPLEMENTARES")
+	maxLinhas := int((alturaAdic - 4) / 2.6)
+	for i, l := range quebraLinhas(normal, 6, wInf-2, strings.Join(info, "\n")) {
+		if i == maxLinhas {
+			break
+		}
+		p.texto(g.x0+1, y+5+float64(i)*2.6, normal, 6, l)
+	}
+
+	p.retangulo(g.x0+wInf, y, g.larg-wInf, alturaAdic)
+	p.texto(g.x0+wInf+0.8, y+2.2, normal, 5, "RESERVADO AO FISCO")
+}
+
+// marcaDagua escreve, em cinza e sob o conteúdo da página, os avisos de homologação e de contingência.
+func (g *gerador) marcaDagua(p *pagina) {
+	n := g.nota
+	var textos []string
+	if n.Ambiente == 2 {
+		textos = append(textos, "SEM VALOR FISCAL")
+	}
+	if n.Protocolo.Numero == "" && n.TipoEmissao != 1 && n.TipoEmissao != 0 {
+		textos = append(textos, "CONTINGÊNCIA")
+	}
+
+	for i, t := range textos {
+		g.carimbo(p, t, 0.6, 0.6, 0.6)
+		if i == 0 && n.Ambiente == 2 {
+			p.cor(0, 0, 0)
+			p.textoAlinhado(0, g.doc.altura-1.5, g.doc.largura, negrito, 7, "NF-E EMITIDA EM AMBIENTE DE HOMOLOGAÇÃO - SEM VALOR FISCAL", centro)
+		}
+	}
+}
+
+// carimbo escreve o texto em letras grandes, na diagonal e com transparência, no centro da página.
+func (g *gerador) carimbo(p *pagina, texto string, r, gr, b float64) {
+	const angulo = 40.0
+	tam := 70.0
+	if w := larguraTexto(negrito, tam, texto); w > g.doc.largura*0.9 {
+		tam *= g.doc.largura * 0.9 / w
+	}
+	w := larguraTexto(negrito, tam, texto)
+	rad := angulo * math.Pi / 180
+	cx, cy := g.doc.largura/2, g.doc.altura/2
+	x := cx - w/2*math.Cos(rad)
+	y := cy + w/2*math.Sin(rad)
+
+	p.transparencia(false)
+	p.cor(r, gr, b)
+	p.textoRotacionado(x, y, angulo, negrito, tam, texto)
+	p.cor(0, 0, 0)
+	p.transparencia(true)
+}
+
+func naoVazios(s ...string) []string {
+	var r []string
+	for _, v := range s {
+		if v = strings.TrimSpace(v); v != "" {
+			r = append(r, v)
+		}
+	}
+	return r
+}
+
+func prefixo(p, s string) string {
+	if s == "" {
+		return ""
+	}
+	return p + s
+}
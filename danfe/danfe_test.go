@@ -0,0 +1,115 @@
+package danfe
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+const chaveTeste = "35240511222333000181550010000001231123456781"
+
+// nfeProcTeste monta um nfeProc com a quantidade de itens informada.
+func nfeProcTeste(itens, tpAmb, tpEmis int, protocolo bool) []byte {
+	var det strings.Builder
+	for i := 1; i <= itens; i++ {
+		fmt.Fprintf(&det, `<det nItem="%d"><prod><cProd>%05d</cProd><cEAN>SEM GTIN</cEAN><xProd>PRODUTO DE TESTE NÚMERO %d COM DESCRIÇÃO LONGA O SUFICIENTE PARA OCUPAR MAIS DE UMA LINHA NA TABELA</xProd><NCM>61091000</NCM><CFOP>5102</CFOP><uCom>UN</uCom><qCom>2.0000</qCom><vUnCom>10.5000</vUnCom><vProd>21.00</vProd></prod><imposto><ICMS><ICMSSN102><orig>0</orig><CSOSN>102</CSOSN></ICMSSN102></ICMS></imposto></det>`, i, i, i)
+	}
+	prot := ""
+	if protocolo {
+		prot = `<protNFe versao="4.00"><infProt><tpAmb>2</tpAmb><verAplic>SP_NFE_PL009_V4</verAplic><chNFe>` + chaveTeste + `</chNFe><dhRecbto>2024-05-17T10:31:02-03:00</dhRecbto><nProt>135240000000001</nProt><digVal>Vh9k1wqBVyT06jXdpf8OP6tpD/U=</digVal><cStat>100</cStat><xMotivo>Autorizado o uso da NF-e</xMotivo></infProt></protNFe>`
+	}
+	return []byte(fmt.Sprintf(`<?xml version="1.0" encoding="UTF-8"?><nfeProc xmlns="http://www.portalfiscal.inf.br/nfe" versao="4.00"><NFe><infNFe Id="NFe%s" versao="4.00"><ide><cUF>35</cUF><cNF>12345678</cNF><natOp>VENDA DE MERCADORIA</natOp><mod>55</mod><serie>1</serie><nNF>123</nNF><dhEmi>2024-05-17T10:30:00-03:00</dhEmi><tpNF>1</tpNF><tpImp>1</tpImp><tpEmis>%d</tpEmis><tpAmb>%d</tpAmb></ide><emit><CNPJ>11222333000181</CNPJ><xNome>EMPRESA EMITENTE LTDA</xNome><enderEmit><xLgr>RUA DAS FLORES</xLgr><nro>100</nro><xBairro>CENTRO</xBairro><xMun>SÃO PAULO</xMun><UF>SP</UF><CEP>01001000</CEP></enderEmit><IE>111222333444</IE></emit><dest><CPF>12345678909</CPF><xNome>JOSÉ DA SILVA</xNome><enderDest><xLgr>AV. BRASIL</xLgr><nro>2000</nro><xBairro>JARDIM</xBairro><xMun>CAMPINAS</xMun><UF>SP</UF><CEP>13000000</CEP></enderDest></dest>%s<total><ICMSTot><vProd>%d.00</vProd><vNF>%d.00</vNF></ICMSTot></total><transp><modFrete>9</modFrete></transp><cobr><dup><nDup>001</nDup><dVenc>2024-06-17</dVenc><vDup>%d.00</vDup></dup></cobr><infAdic><infCpl>Documento emitido por ME ou EPP optante pelo Simples Nacional.</infCpl></infAdic></infNFe></NFe>%s</nfeProc>`,
+		chaveTeste, tpEmis, tpAmb, det.String(), 21*itens, 21*itens, 21*itens, prot))
+}
+
+func paginas(pdf []byte) int {
+	return bytes.Count(pdf, []byte("/Type /Page /Parent"))
+}
+
+func TestCode128C(t *testing.T) {
+	for v, p := range padroesCode128 {
+		soma, barras := 0, 0
+		for i, c := range p {
+			soma += int(c - '0')
+			if i%2 == 0 {
+				barras += int(c - '0')
+			}
+		}
+		if want := 11 + 2*(v/106); soma != want || barras%2 != 0 {
+			t.Errorf("padrão %d (%s) inválido: %d módulos, %d em barras", v, p, soma, barras)
+		}
+	}
+
+	// Início C (105), 12, 34, dígito verificador (105 + 12*1 + 34*2 = 185 % 103 = 82) e parada.
+	got, err := code128C("1234")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var want []int
+	for _, v := range []int{105, 12, 34, 82, 106} {
+		for _, c := range padroesCode128[v] {
+			want = append(want, int(c-'0'))
+		}
+	}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("code128C(1234) = %v; esperado %v", got, want)
+	}
+
+	if _, err := code128C("123"); err == nil {
+		t.Errorf("quantidade ímpar de dígitos deveria falhar")
+	}
+}
+
+func TestGeraXML(t *testing.T) {
+	tests := []struct {
+		nome          string
+		xml           []byte
+		opts          Opcoes
+		paginas       int
+		contem, falta []string
+	}{
+		{"uma página", nfeProcTeste(3, 1, 1, true), Opcoes{}, 1, []string{"135240000000001", "FOLHA 1/1"}, []string{"SEM VALOR FISCAL", "CANCELADA"}},
+		{"várias páginas", nfeProcTeste(80, 1, 1, true), Opcoes{}, 4, []string{"FOLHA 4/4", "PRODUTO DE TESTE N\xdaMERO 80"}, nil},
+		{"paisagem", nfeProcTeste(10, 1, 1, true), Opcoes{Orientacao: Paisagem}, 2, []string{"/MediaBox [0 0 841.89 595.28]"}, nil},
+		{"homologação", nfeProcTeste(3, 2, 1, true), Opcoes{}, 1, []string{"SEM VALOR FISCAL", "HOMOLOGA\xc7\xc3O"}, nil},
+		{"contingência", nfeProcTeste(3, 1, 5, false), Opcoes{}, 1, []string{"CONTING\xcaNCIA"}, nil},
+		{"cancelada", nfeProcTeste(3, 1, 1, true), Opcoes{Cancelada: true}, 1, []string{"CANCELADA"}, nil},
+	}
+
+	for _, tt := range tests {
+		pdf, err := GeraXML(tt.xml, tt.opts)
+		if err != nil {
+			t.Errorf("%s: %v", tt.nome, err)
+			continue
+		}
+		if !bytes.HasPrefix(pdf, []byte("%PDF-1.4")) || !bytes.HasSuffix(pdf, []byte("%%EOF\n")) {
+			t.Errorf("%s: PDF inválido", tt.nome)
+		}
+		if n := paginas(pdf); n != tt.paginas {
+			t.Errorf("%s: %d páginas; esperadas %d", tt.nome, n, tt.paginas)
+		}
+		for _, s := range tt.contem {
+			if !bytes.Contains(pdf, []byte(s)) {
+				t.Errorf("%s: PDF não contém %q", tt.nome, s)
+			}
+		}
+		for _, s := range tt.falta {
+			if bytes.Contains(pdf, []byte(s)) {
+				t.Errorf("%s: PDF não deveria conter %q", tt.nome, s)
+			}
+		}
+	}
+}
+
+func TestQuebraLinhas(t *testing.T) {
+	linhas := quebraLinhas(normal, 8, 30, "PRODUTO COM UMA DESCRIÇÃO BASTANTE LONGA\nSEGUNDA LINHA")
+	if len(linhas) < 3 || linhas[len(linhas)-1] != "SEGUNDA LINHA" {
+		t.Errorf("quebraLinhas() = %q", linhas)
+	}
+	for _, l := range linhas {
+		if larguraTexto(normal, 8, l) > 30 {
+			t.Errorf("linha maior que a largura: %q", l)
+		}
+	}
+}
@@ -0,0 +1,139 @@
+package danfe
+
+import (
+	"bytes"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/eduardotorresdev/nfe"
+)
+
+// Comandos ESC/POS usados no DANFE NFC-e.
+var (
+	escInicializa = []byte{0x1b, '@'}
+	escCP1252     = []byte{0x1b, 't', 16} // tabela de caracteres WPC1252, a mesma codificação do texto
+	escCorta      = []byte{0x1b, 'd', 4, 0x1d, 'V', 1}
+)
+
+// EscPosNFCeXML gera o DANFE NFC-e em comandos ESC/POS, para impressoras térmicas, a partir do XML da NFC-e.
+func EscPosNFCeXML(xmlNFCe []byte, opts OpcoesNFCe) ([]byte, error) {
+	nota, err := nfe.LeNotaFiscal(xmlNFCe)
+	if err != nil {
+		return nil, err
+	}
+	return EscPosNFCe(nota, opts)
+}
+
+// EscPosNFCe gera o DANFE NFC-e em comandos ESC/POS a partir do modelo semântico da nota. O texto vai na codificação WPC1252; o QR Code usa o comando nativo da impressora, ou uma imagem com OpcoesNFCe.QRCodeImagem.
+func EscPosNFCe(nota nfe.NotaFiscalDistribuida, opts OpcoesNFCe) ([]byte, error) {
+	elementos, err := elementosNFCe(nota)
+	if err != nil {
+		return nil, err
+	}
+
+	var b bytes.Buffer
+	b.Write(escInicializa)
+	b.Write(escCP1252)
+
+	colunas := opts.colunas()
+	for _, e := range elementos {
+		switch {
+		case e.separador:
+			escPosAlinhamento(&b, esquerda)
+			b.WriteString(strings.Repeat("-", colunas) + "\n")
+
+		case e.qrCode != nil:
+			escPosAlinhamento(&b, centro)
+			// Pontos úteis da cabeça de impressão: 8 por milímetro, em 48 mm (58) ou 72 mm (80).
+			pontos := 576
+			if opts.largura() == Bobina58 {
+				pontos = 384
+			}
+			modulo := min(6, max(1, pontos*3/4/e.qrCode.tamanho))
+			if opts.QRCodeImagem {
+				escPosQRCodeImagem(&b, e.qrCode, modulo)
+			} else {
+				escPosQRCode(&b, nota.QRCode, modulo)
+			}
+
+		default:
+			// A fonte B (pequena) tem 4/3 das colunas da fonte A.
+			cols := colunas
+			if e.pequeno {
+				cols = colunas * 4 / 3
+				b.Write([]byte{0x1b, 'M', 1})
+			}
+			if e.negrito {
+				b.Write([]byte{0x1b, 'E', 1})
+			}
+			escPosAlinhamento(&b, e.alin)
+			for _, l := range escPosLinhas(e, cols) {
+				b.WriteString(winAnsi(l) + "\n")
+			}
+			if e.negrito {
+				b.Write([]byte{0x1b, 'E', 0})
+			}
+			if e.pequeno {
+				b.Write([]byte{0x1b, 'M', 0})
+			}
+		}
+	}
+
+	b.Write(escCorta)
+	return b.Bytes(), nil
+}
+
+// escPosLinhas quebra o texto do elemento nas colunas da impressora, com o valor alinhado à direita na última linha.
+func escPosLinhas(e elementoCupom, colunas int) []string {
+	cabe := func(w int) func(string) bool {
+		return func(s string) bool { return utf8.RuneCountInString(s) <= w }
+	}
+	if e.valor == "" {
+		return quebraTexto(e.texto, cabe(colunas))
+	}
+
+	w := colunas - utf8.RuneCountInString(e.valor) - 1
+	linhas := quebraTexto(e.texto, cabe(w))
+	if len(linhas) == 0 {
+		linhas = []string{""}
+	}
+	ultima := linhas[len(linhas)-1]
+	espacos := colunas - utf8.RuneCountInString(ultima) - utf8.RuneCountInString(e.valor)
+	linhas[len(linhas)-1] = ultima + strings.Repeat(" ", max(1, espacos)) + e.valor
+	return linhas
+}
+
+// escPosAlinhamento usa o comando ESC a, cujos valores (0, 1 e 2) coincidem com os de alinhamento.
+func escPosAlinhamento(b *bytes.Buffer, alin alinhamento) {
+	b.Write([]byte{0x1b, 'a', byte(alin)})
+}
+
+// escPosQRCode usa o comando GS ( k da impressora: modelo 2, tamanho do módulo, nível de correção M, armazenamento dos dados e impressão.
+func escPosQRCode(b *bytes.Buffer, dados string, modulo int) {
+	b.Write([]byte{0x1d, '(', 'k', 4, 0, '1', 'A', '2', 0})
+	b.Write([]byte{0x1d, '(', 'k', 3, 0, '1', 'C', byte(modulo)})
+	b.Write([]byte{0x1d, '(', 'k', 3, 0, '1', 'E', '1'})
+	n := len(dados) + 3
+	b.Write([]byte{0x1d, '(', 'k', byte(n), byte(n >> 8), '1', 'P', '0'})
+	b.WriteString(dados)
+	b.Write([]byte{0x1d, '(', 'k', 3, 0, '1', 'Q', '0'})
+	b.WriteByte('\n')
+}
+
+// escPosQRCodeImagem envia o QR Code já codificado como imagem de bits (GS v 0), com cada módulo ocupando modulo x modulo pontos.
+func escPosQRCodeImagem(b *bytes.Buffer, q *qrCode, modulo int) {
+	lado := q.tamanho * modulo
+	bytesLinha := (lado + 7) / 8
+	b.Write([]byte{0x1d, 'v', '0', 0, byte(bytesLinha), byte(bytesLinha >> 8), byte(lado), byte(lado >> 8)})
+	linha := make([]byte, bytesLinha)
+	for y := 0; y < lado; y++ {
+		clear(linha)
+		for x := 0; x < lado; x++ {
+			if q.modulos[y/modulo][x/modulo] {
+				linha[x/8] |= 0x80 >> (x % 8)
+			}
+		}
+		b.Write(linha)
+	}
+	b.WriteByte('\n')
+}
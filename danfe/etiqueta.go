@@ -0,0 +1,157 @@
+package danfe
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/eduardotorresdev/nfe"
+)
+
+// Dimensões da etiqueta do DANFE Simplificado, em milímetros (10 x 15 cm).
+const (
+	larguraEtiqueta = 100.0
+	alturaEtiqueta  = 150.0
+)
+
+// OpcoesEtiqueta personaliza a geração do DANFE Simplificado - Etiqueta.
+type OpcoesEtiqueta struct {
+	// DPI é a resolução da impressora, usada no ZPL: 203 (padrão) ou 300.
+	DPI int
+}
+
+func (o OpcoesEtiqueta) dpi() int {
+	if o.DPI > 0 {
+		return o.DPI
+	}
+	return 203
+}
+
+// GeraEtiquetaXML gera o DANFE Simplificado - Etiqueta (NT 2020.004) em PDF, no tamanho 10 x 15 cm, a partir do XML da NFe.
+func GeraEtiquetaXML(xmlNFe []byte, opts OpcoesEtiqueta) ([]byte, error) {
+	nota, err := nfe.LeNotaFiscal(xmlNFe)
+	if err != nil {
+		return nil, err
+	}
+	return GeraEtiqueta(nota, opts)
+}
+
+// GeraEtiqueta gera o DANFE Simplificado - Etiqueta em PDF a partir do modelo semântico da nota.
+func GeraEtiqueta(nota nfe.NotaFiscalDistribuida, opts OpcoesEtiqueta) ([]byte, error) {
+	doc := novoDocumento(larguraEtiqueta, alturaEtiqueta)
+	p := doc.novaPagina()
+	p.espessura(0.2)
+	if err := desenhaEtiqueta(p, nota); err != nil {
+		return nil, err
+	}
+	return doc.bytes(), nil
+}
+
+// ZPLEtiquetaXML gera o DANFE Simplificado - Etiqueta em ZPL, para envio direto às impressoras Zebra, a partir do XML da NFe.
+func ZPLEtiquetaXML(xmlNFe []byte, opts OpcoesEtiqueta) ([]byte, error) {
+	nota, err := nfe.LeNotaFiscal(xmlNFe)
+	if err != nil {
+		return nil, err
+	}
+	return ZPLEtiqueta(nota, opts)
+}
+
+// ZPLEtiqueta gera o DANFE Simplificado - Etiqueta em ZPL a partir do modelo semântico da nota, com o mesmo leiaute do PDF.
+func ZPLEtiqueta(nota nfe.NotaFiscalDistribuida, opts OpcoesEtiqueta) ([]byte, error) {
+	z := novaEtiquetaZPL(opts.dpi(), larguraEtiqueta, alturaEtiqueta)
+	if err := desenhaEtiqueta(z, nota); err != nil {
+		return nil, err
+	}
+	return z.bytes(), nil
+}
+
+// desenhaEtiqueta desenha os campos do DANFE Simplificado - Etiqueta: chave de acesso e código de barras, protocolo, tipo, número, série e data de emissão, emitente e destinatário (nome, CNPJ/CPF, IE e UF), valor total e dados adicionais.
+func desenhaEtiqueta(s superficie, n nfe.NotaFiscalDistribuida) error {
+	if len(n.Chave) != 44 {
+		return fmt.Errorf("Chave de acesso inválida para o DANFE: %q", n.Chave)
+	}
+
+	const x, w = margem - 2, larguraEtiqueta - 2*(margem-2)
+	y := x
+
+	s.retangulo(x, y, w, 11)
+	s.textoAlinhado(x, y+5.5, w, negrito, 11, "DANFE SIMPLIFICADO - ETIQUETA", centro)
+	subtitulo := "Documento Auxiliar da Nota Fiscal Eletrônica"
+	if n.Ambiente == 2 {
+		subtitulo = "EMITIDA EM AMBIENTE DE HOMOLOGAÇÃO - SEM VALOR FISCAL"
+	}
+	s.textoAlinhado(x, y+9.3, w, negrito, 6, subtitulo, centro)
+	y += 11
+
+	s.retangulo(x, y, w, 27)
+	if err := s.codigoDeBarras(x+4, y+2, w-8, 15, n.Chave); err != nil {
+		return err
+	}
+	s.textoAlinhado(x, y+20.5, w, normal, 5, "CHAVE DE ACESSO", centro)
+	s.textoAlinhado(x, y+24.5, w, negrito, 8, chave(n.Chave), centro)
+	y += 27
+
+	protocolo := strings.TrimSpace(n.Protocolo.Numero + " - " + dataHora(n.Protocolo.DataRecebimento))
+	if n.Protocolo.Numero == "" {
+		protocolo = ""
+		if n.TipoEmissao != 1 && n.TipoEmissao != 0 {
+			protocolo = "EMISSÃO EM CONTINGÊNCIA"
+		}
+	}
+	campoEtiqueta(s, x, y, w, 8, "PROTOCOLO DE AUTORIZAÇÃO DE USO", protocolo, centro)
+	y += 8
+
+	tipo := "1 - SAÍDA"
+	if n.TipoOperacao == 0 {
+		tipo = "0 - ENTRADA"
+	}
+	campoEtiqueta(s, x, y, w*0.28, 8, "TIPO DE OPERAÇÃO", tipo, centro)
+	campoEtiqueta(s, x+w*0.28, y, w*0.27, 8, "NÚMERO", numeroNF(n.Numero), centro)
+	campoEtiqueta(s, x+w*0.55, y, w*0.15, 8, "SÉRIE", fmt.Sprintf("%03d", n.Serie), centro)
+	campoEtiqueta(s, x+w*0.70, y, w*0.30, 8, "DATA DE EMISSÃO", data(n.DataEmissao), centro)
+	y += 8
+
+	for _, parte := range []struct {
+		titulo string
+		p      nfe.ParteNFe
+	}{{"EMITENTE", n.Emitente}, {"DESTINATÁRIO", n.Destinatario}} {
+		s.textoAlinhado(x, y+2.6, w, negrito, 6, parte.titulo, esquerda)
+		y += 3.2
+		campoEtiqueta(s, x, y, w, 8, "NOME / RAZÃO SOCIAL", parte.p.Nome, esquerda)
+		y += 8
+		doc := documentoFederal(parte.p.CNPJ, parte.p.CPF)
+		if doc == "" {
+			doc = parte.p.IdEstrangeiro
+		}
+		campoEtiqueta(s, x, y, w*0.42, 8, "CNPJ / CPF", doc, esquerda)
+		campoEtiqueta(s, x+w*0.42, y, w*0.42, 8, "INSCRIÇÃO ESTADUAL", parte.p.IE, esquerda)
+		campoEtiqueta(s, x+w*0.84, y, w*0.16, 8, "UF", parte.p.Endereco.UF, centro)
+		y += 8
+	}
+
+	y += 1
+	s.retangulo(x, y, w, 11)
+	s.textoAlinhado(x+0.8, y+2.2, w-1.6, normal, 5, "VALOR TOTAL DA NF-E (R$)", esquerda)
+	s.textoAlinhado(x+0.8, y+9, w-1.6, negrito, 14, moeda(n.Totais.ValorNota), centro)
+	y += 12
+
+	// Dados adicionais, facultativos, no espaço que sobrar.
+	base := alturaEtiqueta - x
+	s.retangulo(x, y, w, base-y)
+	s.textoAlinhado(x+0.8, y+2.2, w-1.6, normal, 5, "DADOS ADICIONAIS", esquerda)
+	linhaY := y + 5
+	for _, l := range quebraLinhas(normal, 6, w-1.6, n.InformacoesComplementares) {
+		if linhaY > base-0.8 {
+			break
+		}
+		s.textoAlinhado(x+0.8, linhaY, w-1.6, normal, 6, l, esquerda)
+		linhaY += 2.6
+	}
+	return nil
+}
+
+// campoEtiqueta desenha um campo: um retângulo com o rótulo na parte superior e o valor na inferior.
+func campoEtiqueta(s superficie, x, y, w, h float64, rotulo, valor string, alin alinhamento) {
+	s.retangulo(x, y, w, h)
+	s.textoAlinhado(x+0.8, y+2.2, w-1.6, normal, 5, rotulo, esquerda)
+	s.textoAlinhado(x+0.8, y+h-1.4, w-1.6, negrito, 9, valor, alin)
+}
@@ -0,0 +1,49 @@
+package danfe
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestGeraEtiqueta(t *testing.T) {
+	pdf, err := GeraEtiquetaXML(nfeProcTeste(3, 2, 1, true), OpcoesEtiqueta{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range []string{"/MediaBox [0 0 283.46 425.2]", "DANFE SIMPLIFICADO - ETIQUETA", "3524 0511 2223 3300 0181 5500 1000 0001 2311 2345 6781", "135240000000001", "123.456.789-09", "HOMOLOGA\xc7\xc3O"} {
+		if !bytes.Contains(pdf, []byte(s)) {
+			t.Errorf("PDF não contém %q", s)
+		}
+	}
+	if n := paginas(pdf); n != 1 {
+		t.Errorf("%d páginas; esperada 1", n)
+	}
+}
+
+func TestZPLEtiqueta(t *testing.T) {
+	tests := []struct {
+		dpi    int
+		contem []string
+	}{
+		{0, []string{"^PW800\n^LL1200\n", "^BY2^BCN,120,N,N,N,N^FD>;" + chaveTeste + "^FS"}},
+		{300, []string{"^PW1200\n^LL1800\n", "^BY3^BCN,180,N,N,N,N^FD>;" + chaveTeste + "^FS"}},
+	}
+	for _, tt := range tests {
+		zpl, err := ZPLEtiquetaXML(nfeProcTeste(3, 1, 1, true), OpcoesEtiqueta{DPI: tt.dpi})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.HasPrefix(zpl, []byte("^XA\n^CI28\n")) || !bytes.HasSuffix(zpl, []byte("^XZ\n")) {
+			t.Errorf("%d dpi: ZPL sem ^XA/^CI28 ou ^XZ", tt.dpi)
+		}
+		for _, s := range append(tt.contem, "^FB", "^FDDANFE SIMPLIFICADO - ETIQUETA^FS", "^FDJOSÉ DA SILVA^FS", "^FD1 - SAÍDA^FS") {
+			if !bytes.Contains(zpl, []byte(s)) {
+				t.Errorf("%d dpi: ZPL não contém %q", tt.dpi, s)
+			}
+		}
+	}
+
+	if got := escapaZPL("A^B~C_D"); got != "A_5EB_7EC_5FD" {
+		t.Errorf("escapaZPL() = %q", got)
+	}
+}
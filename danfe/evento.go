@@ -0,0 +1,207 @@
+package danfe
+
+import (
+	"encoding/xml"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/eduardotorresdev/nfe"
+)
+
+// OpcoesEvento personaliza a geração do documento do evento.
+type OpcoesEvento struct {
+	// Nota, quando informada, completa o documento com os dados do emitente e do destinatário que não constam do XML do evento (nome, inscrição estadual, endereço e data de emissão).
+	Nota *nfe.NotaFiscalDistribuida
+}
+
+// GeraEventoXML gera em PDF o documento auxiliar de um evento a partir do XML do procEventoNFe: o DACCE, na carta de correção, ou o comprovante de registro, nos demais eventos (cancelamento, manifestação do destinatário etc.).
+func GeraEventoXML(xmlProcEvento []byte, opts OpcoesEvento) ([]byte, error) {
+	var proc nfe.ProcEventoNFe
+	if err := xml.Unmarshal(xmlProcEvento, &proc); err != nil {
+		return nil, fmt.Errorf("Erro na desserialização do arquivo XML: %w", err)
+	}
+	return GeraEvento(proc, opts)
+}
+
+// GeraEvento gera em PDF o documento auxiliar do evento (DACCE ou comprovante de registro).
+func GeraEvento(proc nfe.ProcEventoNFe, opts OpcoesEvento) ([]byte, error) {
+	if proc.Evento == nil {
+		return nil, fmt.Errorf("procEventoNFe sem o evento")
+	}
+	inf := proc.Evento.InfEvento
+	if len(inf.ChNFe) != 44 {
+		return nil, fmt.Errorf("Chave de acesso inválida no evento: %q", inf.ChNFe)
+	}
+	det, err := proc.Evento.Detalhe()
+	if err != nil {
+		return nil, err
+	}
+
+	g := &gerador{doc: novoDocumento(210, 297), x0: margem}
+	g.larg = g.doc.largura - 2*margem
+	p := g.doc.novaPagina()
+	if inf.TpAmb == 2 {
+		g.carimbo(p, "SEM VALOR FISCAL", 0.6, 0.6, 0.6)
+	}
+	p.espessura(0.2)
+
+	e := eventoPDF{g: g, p: p, proc: proc, det: det, nota: opts.Nota}
+	if err := e.desenha(); err != nil {
+		return nil, err
+	}
+	return g.doc.bytes(), nil
+}
+
+type eventoPDF struct {
+	g    *gerador
+	p    *pagina
+	proc nfe.ProcEventoNFe
+	det  nfe.DetEvento
+	nota *nfe.NotaFiscalDistribuida
+}
+
+func (e eventoPDF) desenha() error {
+	g, p := e.g, e.p
+	inf := e.proc.Evento.InfEvento
+	ch := inf.ChNFe
+	y := margem
+
+	// Cabeçalho
+	titulo, subtitulo := "COMPROVANTE DE REGISTRO DE EVENTO", strings.ToUpper(e.descricao())
+	if inf.TpEvento == nfe.TpEventoCCe {
+		titulo, subtitulo = "DACCE", "DOCUMENTO AUXILIAR DA CARTA DE CORREÇÃO ELETRÔNICA"
+	}
+	p.retangulo(g.x0, y, g.larg, 20)
+	p.textoAlinhado(g.x0, y+7, g.larg, negrito, 14, titulo, centro)
+	p.textoAlinhado(g.x0, y+12.5, g.larg, negrito, 9, subtitulo, centro)
+	p.textoAlinhado(g.x0, y+17, g.larg, normal, 6.5, "Não possui valor fiscal. Simples representação do evento indicado abaixo. Consulte a autenticidade no portal nacional da NF-e ou no site da Sefaz Autorizadora.", centro)
+	y += 20
+
+	// NF-e
+	y = g.titulo(p, y+1, "NOTA FISCAL ELETRÔNICA")
+	wCodigo := g.larg * 0.5
+	p.retangulo(g.x0, y, wCodigo, 14)
+	if err := p.codigoDeBarras(g.x0+3, y+1.5, wCodigo-6, 11, ch); err != nil {
+		return err
+	}
+	g.campo(p, g.x0+wCodigo, y, g.larg-wCodigo, 14, "CHAVE DE ACESSO", chave(ch), centro)
+	y += 14
+	numero, _ := strconv.Atoi(ch[25:34])
+	serie, _ := strconv.Atoi(ch[22:25])
+	emissao := ch[4:6] + "/20" + ch[2:4]
+	if e.nota != nil && !e.nota.DataEmissao.IsZero() {
+		emissao = data(e.nota.DataEmissao)
+	}
+	y = g.linhaCampos(p, y,
+		defCampo{"MODELO", ch[20:22], 0.15, centro},
+		defCampo{"SÉRIE", fmt.Sprintf("%03d", serie), 0.15, centro},
+		defCampo{"NÚMERO", numeroNF(numero), 0.3, centro},
+		defCampo{"EMISSÃO", emissao, 0, centro},
+	)
+
+	// Evento e registro
+	y = g.titulo(p, y+1, "EVENTO")
+	ambiente := "1 - PRODUÇÃO"
+	if inf.TpAmb == 2 {
+		ambiente = "2 - HOMOLOGAÇÃO"
+	}
+	y = g.linhaCampos(p, y,
+		defCampo{"TIPO DE EVENTO", inf.TpEvento + " - " + e.descricao(), 0.5, esquerda},
+		defCampo{"SEQUÊNCIA", strconv.Itoa(inf.NSeqEvento), 0.15, centro},
+		defCampo{"DATA E HORA DO EVENTO", dataHora(inf.DhEvento), 0, centro},
+	)
+	y = g.linhaCampos(p, y,
+		defCampo{"ÓRGÃO", strconv.Itoa(inf.COrgao), 0.15, centro},
+		defCampo{"AMBIENTE", ambiente, 0.35, centro},
+		defCampo{"AUTOR DO EVENTO (CNPJ / CPF)", documentoFederal(inf.CNPJ, inf.CPF), 0, centro},
+	)
+	situacao, protocolo, registro := "EVENTO NÃO REGISTRADO", "", ""
+	if ret := e.proc.RetEvento; ret != nil {
+		situacao = strings.TrimSpace(fmt.Sprintf("%d - %s", ret.InfEvento.CStat, ret.InfEvento.XMotivo))
+		protocolo = ret.InfEvento.NProt
+		registro = dataHora(ret.InfEvento.DhRegEvento.Time)
+	}
+	y = g.linhaCampos(p, y,
+		defCampo{"SITUAÇÃO", situacao, 0.5, esquerda},
+		defCampo{"PROTOCOLO DE REGISTRO", protocolo, 0.25, centro},
+		defCampo{"DATA E HORA DO REGISTRO", registro, 0, centro},
+	)
+
+	// Emitente e destinatário
+	emit := nfe.ParteNFe{CNPJ: ch[6:20]}
+	var dest nfe.ParteNFe
+	if ret := e.proc.RetEvento; ret != nil {
+		dest = nfe.ParteNFe{CNPJ: ret.InfEvento.CNPJDest, CPF: ret.InfEvento.CPFDest}
+	}
+	if e.nota != nil {
+		emit, dest = e.nota.Emitente, e.nota.Destinatario
+	}
+	for _, parte := range []struct {
+		titulo string
+		p      nfe.ParteNFe
+	}{{"EMITENTE", emit}, {"DESTINATÁRIO / REMETENTE", dest}} {
+		end := parte.p.Endereco
+		y = g.titulo(p, y+1, parte.titulo)
+		y = g.linhaCampos(p, y,
+			defCampo{"NOME / RAZÃO SOCIAL", parte.p.Nome, 0.6, esquerda},
+			defCampo{"CNPJ / CPF", documentoFederal(parte.p.CNPJ, parte.p.CPF), 0.22, centro},
+			defCampo{"INSCRIÇÃO ESTADUAL", parte.p.IE, 0, centro},
+		)
+		y = g.linhaCampos(p, y,
+			defCampo{"ENDEREÇO", strings.Join(naoVazios(end.Logradouro, end.Numero, end.Complemento, end.Bairro), ", "), 0.6, esquerda},
+			defCampo{"MUNICÍPIO", end.Municipio, 0.3, esquerda},
+			defCampo{"UF", end.UF, 0, centro},
+		)
+	}
+
+	// Conteúdo do evento
+	for _, bloco := range e.blocos() {
+		y = g.titulo(p, y+1, bloco[0])
+		linhas := quebraLinhas(normal, 8, g.larg-3, bloco[1])
+		h := float64(max(1, len(linhas)))*3.6 + 3
+		p.retangulo(g.x0, y, g.larg, h)
+		for i, l := range linhas {
+			p.texto(g.x0+1.5, y+4.2+float64(i)*3.6, normal, 8, l)
+		}
+		y += h
+	}
+
+	if inf.TpAmb == 2 {
+		p.textoAlinhado(0, g.doc.altura-1.5, g.doc.largura, negrito, 7, "EVENTO REGISTRADO EM AMBIENTE DE HOMOLOGAÇÃO - SEM VALOR FISCAL", centro)
+	}
+	return nil
+}
+
+// descricao retorna a descrição do evento, pela descEvento ou, na falta dela, pelo retorno da Sefaz.
+func (e eventoPDF) descricao() string {
+	if d := strings.TrimSpace(e.det.DescEvento); d != "" {
+		return d
+	}
+	if e.proc.RetEvento != nil {
+		return strings.TrimSpace(e.proc.RetEvento.InfEvento.XEvento)
+	}
+	return ""
+}
+
+// blocos retorna os textos do detEvento (título e conteúdo) que o documento deve mostrar.
+func (e eventoPDF) blocos() [][2]string {
+	d := e.det
+	var b [][2]string
+	if d.XCorrecao != "" {
+		b = append(b, [2]string{"CORREÇÃO", d.XCorrecao})
+	}
+	if d.NProt != "" {
+		b = append(b, [2]string{"PROTOCOLO DE AUTORIZAÇÃO DA NF-E", d.NProt})
+	}
+	if d.ChNFeRef != "" {
+		b = append(b, [2]string{"CHAVE DE ACESSO DA NF-E SUBSTITUTA", chave(d.ChNFeRef)})
+	}
+	if d.XJust != "" {
+		b = append(b, [2]string{"JUSTIFICATIVA", d.XJust})
+	}
+	if d.XCondUso != "" {
+		b = append(b, [2]string{"CONDIÇÕES DE USO", d.XCondUso})
+	}
+	return b
+}
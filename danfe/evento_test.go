@@ -0,0 +1,46 @@
+package danfe
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/eduardotorresdev/nfe"
+)
+
+func procEventoTeste(tpEvento, descEvento, det string) []byte {
+	return []byte(`<procEventoNFe xmlns="http://www.portalfiscal.inf.br/nfe" versao="1.00"><evento versao="1.00"><infEvento Id="ID` + tpEvento + chaveTeste + `01"><cOrgao>35</cOrgao><tpAmb>1</tpAmb><CNPJ>11222333000181</CNPJ><chNFe>` + chaveTeste + `</chNFe><dhEvento>2024-05-18T09:00:00-03:00</dhEvento><tpEvento>` + tpEvento + `</tpEvento><nSeqEvento>1</nSeqEvento><verEvento>1.00</verEvento><detEvento versao="1.00"><descEvento>` + descEvento + `</descEvento>` + det + `</detEvento></infEvento></evento><retEvento versao="1.00"><infEvento><tpAmb>1</tpAmb><verAplic>SP_EVENTOS_PL_100</verAplic><cOrgao>35</cOrgao><cStat>135</cStat><xMotivo>Evento registrado e vinculado a NF-e</xMotivo><chNFe>` + chaveTeste + `</chNFe><tpEvento>` + tpEvento + `</tpEvento><nSeqEvento>1</nSeqEvento><CPFDest>12345678909</CPFDest><dhRegEvento>2024-05-18T09:00:03-03:00</dhRegEvento><nProt>135240000000999</nProt></infEvento></retEvento></procEventoNFe>`)
+}
+
+func TestGeraEvento(t *testing.T) {
+	nota, err := nfe.LeNotaFiscal(nfeProcTeste(1, 1, 1, true))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		nome   string
+		xml    []byte
+		opts   OpcoesEvento
+		contem []string
+	}{
+		{"carta de correção", procEventoTeste(nfe.TpEventoCCe, "Carta de Correcao", "<xCorrecao>Onde se lê CFOP 5102, leia-se CFOP 5405.</xCorrecao><xCondUso>A Carta de Correcao e disciplinada pelo paragrafo 1o-A do art. 7o do Convenio S/N, de 15 de dezembro de 1970</xCondUso>"), OpcoesEvento{},
+			[]string{"DACCE", "CARTA DE CORRE\xc7\xc3O ELETR\xd4NICA", "Onde se l\xea CFOP 5102, leia-se CFOP 5405.", "CONDI\xc7\xd5ES DE USO", "135240000000999", "18/05/2024 09:00:03", "11.222.333/0001-81", "123.456.789-09", "05/2024", "000.000.123"}},
+		{"cancelamento", procEventoTeste(nfe.TpEventoCancelamento, "Cancelamento", "<nProt>135240000000001</nProt><xJust>Pedido cancelado pelo cliente antes da entrega</xJust>"), OpcoesEvento{Nota: &nota},
+			[]string{"COMPROVANTE DE REGISTRO DE EVENTO", "110111 - Cancelamento", "JUSTIFICATIVA", "Pedido cancelado pelo cliente", "135240000000001", "EMPRESA EMITENTE LTDA", "JOS\xc9 DA SILVA", "17/05/2024"}},
+	}
+	for _, tt := range tests {
+		pdf, err := GeraEventoXML(tt.xml, tt.opts)
+		if err != nil {
+			t.Errorf("%s: %v", tt.nome, err)
+			continue
+		}
+		if n := paginas(pdf); n != 1 {
+			t.Errorf("%s: %d páginas; esperada 1", tt.nome, n)
+		}
+		for _, s := range tt.contem {
+			if !bytes.Contains(pdf, []byte(s)) {
+				t.Errorf("%s: PDF não contém %q", tt.nome, s)
+			}
+		}
+	}
+}
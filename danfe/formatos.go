@@ -0,0 +1,94 @@
+package danfe
+
+import (
+	"fmt"
+	"math"
+	"strings"
+	"time"
+)
+
+// moeda formata o valor com separador de milhar e vírgula decimal (ex.: 1.234,56).
+func moeda(v float64) string {
+	return decimal(v, 2)
+}
+
+// decimal formata o valor com a quantidade de casas informada, no padrão brasileiro.
+func decimal(v float64, casas int) string {
+	s := fmt.Sprintf("%.*f", casas, math.Abs(v))
+	inteiro, fracao := s, ""
+	if i := strings.IndexByte(s, '.'); i >= 0 {
+		inteiro, fracao = s[:i], s[i+1:]
+	}
+
+	var b strings.Builder
+	if v < 0 && strings.Trim(s, "0.") != "" {
+		b.WriteByte('-')
+	}
+	for i, c := range inteiro {
+		if i > 0 && (len(inteiro)-i)%3 == 0 {
+			b.WriteByte('.')
+		}
+		b.WriteRune(c)
+	}
+	if fracao != "" {
+		b.WriteByte(',')
+		b.WriteString(fracao)
+	}
+	return b.String()
+}
+
+// chave formata a chave de acesso em grupos de 4 dígitos.
+func chave(ch string) string {
+	var grupos []string
+	for i := 0; i < len(ch); i += 4 {
+		grupos = append(grupos, ch[i:min(i+4, len(ch))])
+	}
+	return strings.Join(grupos, " ")
+}
+
+// documentoFederal formata o CNPJ ou o CPF, mantendo sem alteração valores em outro formato.
+func documentoFederal(cnpj, cpf string) string {
+	switch {
+	case len(cnpj) == 14:
+		return cnpj[0:2] + "." + cnpj[2:5] + "." + cnpj[5:8] + "/" + cnpj[8:12] + "-" + cnpj[12:]
+	case len(cpf) == 11:
+		return cpf[0:3] + "." + cpf[3:6] + "." + cpf[6:9] + "-" + cpf[9:]
+	case cnpj != "":
+		return cnpj
+	}
+	return cpf
+}
+
+func cep(c string) string {
+	if len(c) == 8 {
+		return c[:5] + "-" + c[5:]
+	}
+	return c
+}
+
+// numeroNF formata o número da nota com 9 dígitos, agrupados de 3 em 3 (ex.: 000.001.234).
+func numeroNF(n int) string {
+	s := fmt.Sprintf("%09d", n)
+	return s[0:3] + "." + s[3:6] + "." + s[6:9]
+}
+
+func data(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.Format("02/01/2006")
+}
+
+func hora(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.Format("15:04:05")
+}
+
+func dataHora(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.Format("02/01/2006 15:04:05")
+}
@@ -0,0 +1,327 @@
+package danfe
+
+import (
+	"fmt"
+	"math"
+	"strings"
+
+	"github.com/eduardotorresdev/nfe"
+)
+
+// Larguras de bobina do DANFE NFC-e, em milímetros.
+const (
+	Bobina58 = 58
+	Bobina80 = 80
+)
+
+// OpcoesNFCe personaliza a geração do DANFE NFC-e.
+type OpcoesNFCe struct {
+	// Largura da bobina, em milímetros (Bobina58 ou Bobina80). O padrão é Bobina80.
+	Largura int
+
+	// Colunas de texto da impressora térmica na fonte normal, usadas no ESC/POS. O padrão é 32 na bobina de 58 mm e 48 na de 80 mm.
+	Colunas int
+
+	// QRCodeImagem envia o QR Code à impressora como imagem (GS v 0), para os modelos sem o comando nativo de QR Code (GS ( k).
+	QRCodeImagem bool
+}
+
+func (o OpcoesNFCe) largura() int {
+	if o.Largura == Bobina58 {
+		return Bobina58
+	}
+	return Bobina80
+}
+
+func (o OpcoesNFCe) colunas() int {
+	switch {
+	case o.Colunas > 0:
+		return o.Colunas
+	case o.largura() == Bobina58:
+		return 32
+	}
+	return 48
+}
+
+// elementoCupom é uma linha (ou um bloco) do DANFE NFC-e, independente do meio de impressão.
+type elementoCupom struct {
+	texto   string
+	valor   string // alinhado à direita, na última linha do texto
+	alin    alinhamento
+	negrito bool
+	pequeno bool
+
+	separador bool
+	qrCode    *qrCode
+}
+
+// GeraNFCeXML gera o DANFE NFC-e em PDF, na largura da bobina, a partir do XML da NFC-e (nfeProc, ou NFe ainda sem protocolo na contingência offline).
+func GeraNFCeXML(xmlNFCe []byte, opts OpcoesNFCe) ([]byte, error) {
+	nota, err := nfe.LeNotaFiscal(xmlNFCe)
+	if err != nil {
+		return nil, err
+	}
+	return GeraNFCe(nota, opts)
+}
+
+// GeraNFCe gera o DANFE NFC-e em PDF a partir do modelo semântico da nota. A página tem a largura da bobina e a altura do conteúdo.
+func GeraNFCe(nota nfe.NotaFiscalDistribuida, opts OpcoesNFCe) ([]byte, error) {
+	elementos, err := elementosNFCe(nota)
+	if err != nil {
+		return nil, err
+	}
+
+	largura := float64(opts.largura())
+	c := cupomPDF{margem: 3, tam: 7.5}
+	if opts.largura() == Bobina58 {
+		c = cupomPDF{margem: 2, tam: 6.5}
+	}
+	c.larg = largura - 2*c.margem
+
+	// A primeira passagem só mede a altura, que o PDF precisa conhecer antes do conteúdo.
+	altura := c.desenha(novoDocumento(largura, 0).novaPagina(), elementos)
+	doc := novoDocumento(largura, altura+c.margem)
+	c.desenha(doc.novaPagina(), elementos)
+	return doc.bytes(), nil
+}
+
+// elementosNFCe monta o conteúdo do DANFE NFC-e segundo as divisões do Manual de Especificações Técnicas do DANFE NFC-e e QR Code.
+func elementosNFCe(n nfe.NotaFiscalDistribuida) ([]elementoCupom, error) {
+	if n.Modelo != "65" {
+		return nil, fmt.Errorf("DANFE NFC-e exige uma nota de modelo 65; a nota %s é de modelo %q", n.Chave, n.Modelo)
+	}
+	if n.QRCode == "" {
+		return nil, fmt.Errorf("NFC-e %s sem o QR Code (grupo infNFeSupl)", n.Chave)
+	}
+	qr, err := novoQRCode([]byte(n.QRCode))
+	if err != nil {
+		return nil, err
+	}
+
+	var el []elementoCupom
+	linha := func(e elementoCupom) { el = append(el, e) }
+	centralizado := func(s string, negrito, pequeno bool) {
+		if s != "" {
+			linha(elementoCupom{texto: s, alin: centro, negrito: negrito, pequeno: pequeno})
+		}
+	}
+	valor := func(rotulo, v string, negrito bool) {
+		linha(elementoCupom{texto: rotulo, valor: v, negrito: negrito})
+	}
+	separador := func() { linha(elementoCupom{separador: true}) }
+
+	// I - Identificação do emitente
+	e := n.Emitente
+	centralizado(e.Nome, true, false)
+	centralizado(strings.Join(naoVazios(prefixo("CNPJ: ", documentoFederal(e.CNPJ, e.CPF)), prefixo("IE: ", e.IE)), "  "), false, true)
+	end := e.Endereco
+	centralizado(strings.Join(naoVazios(end.Logradouro, end.Numero, end.Complemento, end.Bairro, strings.Join(naoVazios(end.Municipio, end.UF), " - ")), ", "), false, true)
+	separador()
+	centralizado("Documento Auxiliar da Nota Fiscal de Consumidor Eletrônica", true, false)
+	separador()
+
+	// II - Detalhe da venda
+	linha(elementoCupom{texto: "# CÓDIGO DESCRIÇÃO", negrito: true, pequeno: true})
+	linha(elementoCupom{texto: "QTD. UN. x VL. UNIT. R$", valor: "VL. TOTAL R$", negrito: true, pequeno: true})
+	for _, it := range n.Itens {
+		linha(elementoCupom{texto: fmt.Sprintf("%03d %s %s", it.Numero, it.Codigo, it.Descricao), pequeno: true})
+		linha(elementoCupom{
+			texto:   fmt.Sprintf("%s %s x %s", quantidade(it.Quantidade), it.Unidade, decimal(it.ValorUnitario, casasUnitario(it.ValorUnitario))),
+			valor:   moeda(it.ValorTotal),
+			pequeno: true,
+		})
+	}
+	separador()
+
+	// III - Totais e pagamento
+	t := n.Totais
+	valor("Qtde. total de itens", fmt.Sprint(len(n.Itens)), false)
+	valor("Valor total R$", moeda(t.ValorProdutos), false)
+	if t.ValorDesconto > 0 {
+		valor("Desconto R$", moeda(t.ValorDesconto), false)
+	}
+	if acrescimos := t.ValorFrete + t.ValorSeguro + t.ValorOutros + t.ValorICMSST + t.ValorIPI; acrescimos > 0 {
+		valor("Acréscimos R$", moeda(acrescimos), false)
+	}
+	valor("Valor a Pagar R$", moeda(t.ValorNota), true)
+	linha(elementoCupom{texto: "FORMA DE PAGAMENTO", valor: "VALOR PAGO R$", negrito: true, pequeno: true})
+	for _, p := range n.Pagamentos {
+		valor(formaPagamento(p.Forma), moeda(p.Valor), false)
+	}
+	if n.Troco > 0 {
+		valor("Troco R$", moeda(n.Troco), false)
+	}
+	separador()
+
+	// IV - Tributos (Lei da Transparência)
+	if t.ValorTributosAproximado > 0 {
+		linha(elementoCupom{texto: "Informação dos Tributos Totais Incidentes (Lei Federal 12.741/2012) R$", valor: moeda(t.ValorTributosAproximado), pequeno: true})
+		separador()
+	}
+
+	// V - Mensagem fiscal e consulta pela chave de acesso
+	if n.Ambiente == 2 {
+		centralizado("EMITIDA EM AMBIENTE DE HOMOLOGAÇÃO - SEM VALOR FISCAL", true, false)
+	}
+	if n.TipoEmissao == 9 {
+		centralizado("EMITIDA EM CONTINGÊNCIA", true, false)
+		centralizado("Pendente de autorização", false, false)
+	}
+	centralizado("Consulte pela Chave de Acesso em", true, true)
+	centralizado(n.URLConsulta, false, true)
+	centralizado(chave(n.Chave), false, true)
+	separador()
+
+	// VI - Consumidor
+	d := n.Destinatario
+	switch {
+	case d.CNPJ != "":
+		centralizado("CONSUMIDOR - CNPJ "+documentoFederal(d.CNPJ, ""), true, true)
+	case d.CPF != "":
+		centralizado("CONSUMIDOR - CPF "+documentoFederal("", d.CPF), true, true)
+	case d.IdEstrangeiro != "":
+		centralizado("CONSUMIDOR - Id. Estrangeiro "+d.IdEstrangeiro, true, true)
+	default:
+		centralizado("CONSUMIDOR NÃO IDENTIFICADO", true, true)
+	}
+	centralizado(d.Nome, false, true)
+	end = d.Endereco
+	centralizado(strings.Join(naoVazios(end.Logradouro, end.Numero, end.Bairro, strings.Join(naoVazios(end.Municipio, end.UF), " - ")), ", "), false, true)
+	separador()
+
+	// VII - Identificação da NFC-e e protocolo de autorização
+	centralizado(fmt.Sprintf("NFC-e nº %09d Série %03d %s", n.Numero, n.Serie, dataHora(n.DataEmissao)), true, true)
+	if n.Protocolo.Numero != "" {
+		centralizado("Protocolo de autorização: "+n.Protocolo.Numero, false, true)
+		centralizado("Data de autorização: "+dataHora(n.Protocolo.DataRecebimento), false, true)
+	}
+
+	// VIII - QR Code
+	centralizado("Consulta via leitor de QR Code", false, true)
+	linha(elementoCupom{qrCode: qr})
+
+	// IX - Informações adicionais
+	if n.InformacoesComplementares != "" {
+		separador()
+		linha(elementoCupom{texto: n.InformacoesComplementares, pequeno: true})
+	}
+	return el, nil
+}
+
+// quantidade formata a quantidade com as casas decimais necessárias (até 4).
+func quantidade(v float64) string {
+	s := decimal(v, 4)
+	s = strings.TrimRight(s, "0")
+	return strings.TrimSuffix(s, ",")
+}
+
+// casasUnitario usa 2 casas no valor unitário, a menos que ele tenha mais casas significativas (até 10, o limite do leiaute).
+func casasUnitario(v float64) int {
+	for casas := 2; casas < 10; casas++ {
+		if f := math.Pow10(casas); math.Abs(math.Round(v*f)/f-v) < 1e-11 {
+			return casas
+		}
+	}
+	return 10
+}
+
+// formaPagamento retorna a descrição do meio de pagamento (tPag).
+func formaPagamento(tPag string) string {
+	formas := map[string]string{
+		"01": "Dinheiro",
+		"02": "Cheque",
+		"03": "Cartão de Crédito",
+		"04": "Cartão de Débito",
+		"05": "Crédito Loja",
+		"10": "Vale Alimentação",
+		"11": "Vale Refeição",
+		"12": "Vale Presente",
+		"13": "Vale Combustível",
+		"15": "Boleto Bancário",
+		"16": "Depósito Bancário",
+		"17": "Pagamento Instantâneo (PIX)",
+		"18": "Transferência bancária, Carteira Digital",
+		"19": "Programa de fidelidade, Cashback, Crédito Virtual",
+		"90": "Sem pagamento",
+		"99": "Outros",
+	}
+	if f, ok := formas[tPag]; ok {
+		return f
+	}
+	return tPag
+}
+
+// cupomPDF desenha os elementos do DANFE NFC-e em uma página da largura da bobina.
+type cupomPDF struct {
+	margem, larg float64
+	tam          float64 // tamanho da fonte normal, em pontos
+}
+
+// desenha escreve os elementos na página e retorna a altura ocupada.
+func (c cupomPDF) desenha(p *pagina, elementos []elementoCupom) float64 {
+	y := c.margem
+	for _, e := range elementos {
+		switch {
+		case e.separador:
+			y += 1
+			p.tracejado(1, 0.6)
+			p.espessura(0.2)
+			p.linha(c.margem, y, c.margem+c.larg, y)
+			p.tracejado()
+			y += 1
+
+		case e.qrCode != nil:
+			y += c.qrCode(p, y, e.qrCode)
+
+		default:
+			f, tam := normal, c.tam
+			if e.negrito {
+				f = negrito
+			}
+			if e.pequeno {
+				tam--
+			}
+			entrelinha := tam * 1.25 / pontosPorMM
+
+			w := c.larg
+			if e.valor != "" {
+				w -= larguraTexto(f, tam, e.valor) + 2
+			}
+			linhas := quebraLinhas(f, tam, w, e.texto)
+			if len(linhas) == 0 {
+				linhas = []string{""}
+			}
+			for i, l := range linhas {
+				y += entrelinha
+				p.textoAlinhado(c.margem, y, w, f, tam, l, e.alin)
+				if i == len(linhas)-1 && e.valor != "" {
+					p.textoAlinhado(c.margem, y, c.larg, f, tam, e.valor, direita)
+				}
+			}
+			y += entrelinha * 0.25
+		}
+	}
+	return y
+}
+
+// qrCode desenha o QR Code centralizado, com a zona de silêncio de 4 módulos, e retorna a altura ocupada.
+func (c cupomPDF) qrCode(p *pagina, y float64, q *qrCode) float64 {
+	lado := max(25, c.larg*0.6) // o Manual do DANFE NFC-e pede ao menos 25 mm
+	modulo := lado / float64(q.tamanho+8)
+	x0 := c.margem + (c.larg-lado)/2 + 4*modulo
+	y0 := y + 4*modulo
+	for i, linha := range q.modulos {
+		for j := 0; j < len(linha); j++ {
+			if !linha[j] {
+				continue
+			}
+			k := j
+			for k < len(linha) && linha[k] {
+				k++
+			}
+			p.preenche(x0+float64(j)*modulo, y0+float64(i)*modulo, float64(k-j)*modulo, modulo)
+			j = k
+		}
+	}
+	return lado
+}
@@ -0,0 +1,109 @@
+package danfe
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+const chaveNFCeTeste = "35240511222333000181650010000001231123456788"
+
+// nfceProcTeste monta o XML de uma NFC-e; sem protocolo, a nota é da contingência offline (tpEmis 9).
+func nfceProcTeste(cpf string, protocolo bool) []byte {
+	tpEmis, prot := 9, ""
+	if protocolo {
+		tpEmis = 1
+		prot = `<protNFe versao="4.00"><infProt><tpAmb>2</tpAmb><verAplic>SP_NFCE_PL009_V400</verAplic><chNFe>` + chaveNFCeTeste + `</chNFe><dhRecbto>2024-05-17T10:30:05-03:00</dhRecbto><nProt>135240000000777</nProt><cStat>100</cStat><xMotivo>Autorizado o uso da NF-e</xMotivo></infProt></protNFe>`
+	}
+	dest := ""
+	if cpf != "" {
+		dest = `<dest><CPF>` + cpf + `</CPF><xNome>MARIA SOUZA</xNome><indIEDest>9</indIEDest></dest>`
+	}
+	nfe := fmt.Sprintf(`<NFe xmlns="http://www.portalfiscal.inf.br/nfe"><infNFe Id="NFe%s" versao="4.00"><ide><cUF>35</cUF><natOp>VENDA</natOp><mod>65</mod><serie>1</serie><nNF>123</nNF><dhEmi>2024-05-17T10:30:00-03:00</dhEmi><tpNF>1</tpNF><tpImp>4</tpImp><tpEmis>%d</tpEmis><tpAmb>2</tpAmb></ide><emit><CNPJ>11222333000181</CNPJ><xNome>MERCADO EXEMPLO LTDA</xNome><enderEmit><xLgr>RUA DAS FLORES</xLgr><nro>100</nro><xBairro>CENTRO</xBairro><xMun>SAO PAULO</xMun><UF>SP</UF></enderEmit><IE>111222333444</IE></emit>%s<det nItem="1"><prod><cProd>789</cProd><xProd>ARROZ TIPO 1 5KG</xProd><CFOP>5102</CFOP><uCom>UN</uCom><qCom>2.0000</qCom><vUnCom>10.5000</vUnCom><vProd>21.00</vProd></prod><imposto><vTotTrib>3.15</vTotTrib></imposto></det><det nItem="2"><prod><cProd>1001</cProd><xProd>BANANA PRATA</xProd><CFOP>5102</CFOP><uCom>KG</uCom><qCom>1.2350</qCom><vUnCom>5.9900</vUnCom><vProd>7.40</vProd></prod><imposto><vTotTrib>1.11</vTotTrib></imposto></det><total><ICMSTot><vProd>28.40</vProd><vDesc>0.40</vDesc><vNF>28.00</vNF><vTotTrib>4.26</vTotTrib></ICMSTot></total><pag><detPag><tPag>01</tPag><vPag>30.00</vPag></detPag><vTroco>2.00</vTroco></pag></infNFe><infNFeSupl><qrCode><![CDATA[https://www.homologacao.nfce.fazenda.sp.gov.br/qrcode?p=%s|2|2|1|0C0E3B4F1B5E0F1C2D3E4F5A6B7C8D9E0F1A2B3C]]></qrCode><urlChave>https://www.homologacao.nfce.fazenda.sp.gov.br/consulta</urlChave></infNFeSupl></NFe>`,
+		chaveNFCeTeste, tpEmis, dest, chaveNFCeTeste)
+	if !protocolo {
+		return []byte(nfe)
+	}
+	return []byte(`<nfeProc xmlns="http://www.portalfiscal.inf.br/nfe" versao="4.00">` + nfe + prot + `</nfeProc>`)
+}
+
+func TestGeraNFCe(t *testing.T) {
+	tests := []struct {
+		nome          string
+		xml           []byte
+		opts          OpcoesNFCe
+		contem, falta []string
+	}{
+		{"80 mm", nfceProcTeste("12345678909", true), OpcoesNFCe{}, []string{"/MediaBox [0 0 226.77 ", "CONSUMIDOR - CPF 123.456.789-09", "135240000000777", "12.741/2012", "3524 0511 2223 3300 0181 6500 1000 0001 2311 2345 6788", "Troco R$", "HOMOLOGA\xc7\xc3O"}, []string{"CONTING\xcaNCIA"}},
+		{"58 mm", nfceProcTeste("", true), OpcoesNFCe{Largura: Bobina58}, []string{"/MediaBox [0 0 164.41 ", "CONSUMIDOR N\xc3O IDENTIFICADO"}, nil},
+		{"offline", nfceProcTeste("12345678909", false), OpcoesNFCe{}, []string{"EMITIDA EM CONTING\xcaNCIA", "Pendente de autoriza\xe7\xe3o"}, []string{"Protocolo de autoriza"}},
+	}
+
+	for _, tt := range tests {
+		pdf, err := GeraNFCeXML(tt.xml, tt.opts)
+		if err != nil {
+			t.Errorf("%s: %v", tt.nome, err)
+			continue
+		}
+		if n := paginas(pdf); n != 1 {
+			t.Errorf("%s: %d páginas; esperada 1", tt.nome, n)
+		}
+		for _, s := range tt.contem {
+			if !bytes.Contains(pdf, []byte(s)) {
+				t.Errorf("%s: PDF não contém %q", tt.nome, s)
+			}
+		}
+		for _, s := range tt.falta {
+			if bytes.Contains(pdf, []byte(s)) {
+				t.Errorf("%s: PDF não deveria conter %q", tt.nome, s)
+			}
+		}
+	}
+
+	if _, err := GeraNFCeXML(nfeProcTeste(1, 1, 1, true), OpcoesNFCe{}); err == nil {
+		t.Errorf("uma NF-e (modelo 55) deveria ser recusada")
+	}
+}
+
+func TestEscPosNFCe(t *testing.T) {
+	xml := nfceProcTeste("12345678909", true)
+	for _, opts := range []OpcoesNFCe{{}, {Largura: Bobina58}} {
+		cmd, err := EscPosNFCeXML(xml, opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.HasPrefix(cmd, []byte("\x1b@\x1bt\x10")) || !bytes.HasSuffix(cmd, escCorta) {
+			t.Errorf("%d mm: início ou fim inesperado", opts.largura())
+		}
+
+		// Valor a pagar em negrito, alinhado à direita nas colunas da fonte normal.
+		linha := "Valor a Pagar R$" + strings.Repeat(" ", opts.colunas()-21) + "28,00\n"
+		if !bytes.Contains(cmd, []byte("\x1bE\x01\x1ba\x00"+linha+"\x1bE\x00")) {
+			t.Errorf("%d mm: linha do valor a pagar não encontrada", opts.largura())
+		}
+		if !bytes.Contains(cmd, []byte("\x1ba\x002 UN x 10,50"+strings.Repeat(" ", opts.colunas()*4/3-17)+"21,00\n")) ||
+			!bytes.Contains(cmd, []byte("\x1ba\x001,235 KG x 5,99")) {
+			t.Errorf("%d mm: linhas dos itens não encontradas", opts.largura())
+		}
+		for _, l := range strings.Split(string(cmd), "\n") {
+			if strings.HasPrefix(l, "-") && len(l) != opts.colunas() {
+				t.Errorf("%d mm: separador com %d colunas", opts.largura(), len(l))
+			}
+		}
+
+		qr := "https://www.homologacao.nfce.fazenda.sp.gov.br/qrcode?p=" + chaveNFCeTeste
+		n := len("https://www.homologacao.nfce.fazenda.sp.gov.br/qrcode?p="+chaveNFCeTeste+"|2|2|1|0C0E3B4F1B5E0F1C2D3E4F5A6B7C8D9E0F1A2B3C") + 3
+		if !bytes.Contains(cmd, append([]byte{0x1d, '(', 'k', byte(n), byte(n >> 8), '1', 'P', '0'}, qr...)) {
+			t.Errorf("%d mm: comando de armazenamento do QR Code não encontrado", opts.largura())
+		}
+	}
+
+	cmd, err := EscPosNFCeXML(xml, OpcoesNFCe{QRCodeImagem: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Contains(cmd, []byte{0x1d, 'v', '0', 0}) || bytes.Contains(cmd, []byte{0x1d, '(', 'k'}) {
+		t.Errorf("QR Code como imagem deveria usar GS v 0 em vez de GS ( k")
+	}
+}
@@ -0,0 +1,311 @@
+package danfe
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"strings"
+)
+
+// pontosPorMM converte milímetros, a unidade usada nos leiautes, para pontos, a unidade do PDF.
+const pontosPorMM = 72 / 25.4
+
+type fonte int
+
+const (
+	normal fonte = iota
+	negrito
+)
+
+type alinhamento int
+
+const (
+	esquerda alinhamento = iota
+	centro
+	direita
+)
+
+// documento é um gerador mínimo de PDF: páginas com texto nas fontes padrão Helvetica e Helvetica-Bold (codificação WinAnsi, que cobre os caracteres do português), linhas e retângulos. As coordenadas são em milímetros, a partir do canto superior esquerdo.
+type documento struct {
+	largura, altura float64
+	paginas         []*pagina
+}
+
+type pagina struct {
+	doc      *documento
+	conteudo bytes.Buffer
+}
+
+func novoDocumento(largura, altura float64) *documento {
+	return &documento{largura: largura, altura: altura}
+}
+
+func (d *documento) novaPagina() *pagina {
+	p := &pagina{doc: d}
+	d.paginas = append(d.paginas, p)
+	return p
+}
+
+func num(v float64) string {
+	s := fmt.Sprintf("%.2f", v)
+	s = strings.TrimRight(strings.TrimRight(s, "0"), ".")
+	if s == "-0" || s == "" {
+		return "0"
+	}
+	return s
+}
+
+func (p *pagina) x(v float64) string { return num(v * pontosPorMM) }
+func (p *pagina) y(v float64) string { return num((p.doc.altura - v) * pontosPorMM) }
+
+// cor define a cor (RGB, de 0 a 1) do preenchimento e do texto.
+func (p *pagina) cor(r, g, b float64) {
+	fmt.Fprintf(&p.conteudo, "%s %s %s rg\n", num(r), num(g), num(b))
+}
+
+// corTraco define a cor (RGB, de 0 a 1) das linhas.
+func (p *pagina) corTraco(r, g, b float64) {
+	fmt.Fprintf(&p.conteudo, "%s %s %s RG\n", num(r), num(g), num(b))
+}
+
+// espessura define a espessura das linhas, em milímetros.
+func (p *pagina) espessura(mm float64) {
+	fmt.Fprintf(&p.conteudo, "%s w\n", num(mm*pontosPorMM))
+}
+
+// tracejado define o padrão de linha tracejada (em milímetros); sem argumentos, volta à linha contínua.
+func (p *pagina) tracejado(padrao ...float64) {
+	s := make([]string, len(padrao))
+	for i, v := range padrao {
+		s[i] = num(v * pontosPorMM)
+	}
+	fmt.Fprintf(&p.conteudo, "[%s] 0 d\n", strings.Join(s, " "))
+}
+
+// transparencia define a opacidade (de 0 a 1) do que for desenhado em seguida.
+func (p *pagina) transparencia(opaco bool) {
+	if opaco {
+		p.conteudo.WriteString("/GS0 gs\n")
+	} else {
+		p.conteudo.WriteString("/GS1 gs\n")
+	}
+}
+
+func (p *pagina) retangulo(x, y, w, h float64) {
+	fmt.Fprintf(&p.conteudo, "%s %s %s %s re S\n", p.x(x), p.y(y+h), num(w*pontosPorMM), num(h*pontosPorMM))
+}
+
+func (p *pagina) preenche(x, y, w, h float64) {
+	fmt.Fprintf(&p.conteudo, "%s %s %s %s re f\n", p.x(x), p.y(y+h), num(w*pontosPorMM), num(h*pontosPorMM))
+}
+
+func (p *pagina) linha(x1, y1, x2, y2 float64) {
+	fmt.Fprintf(&p.conteudo, "%s %s m %s %s l S\n", p.x(x1), p.y(y1), p.x(x2), p.y(y2))
+}
+
+// texto escreve s com a linha de base em (x, y). O tamanho da fonte é em pontos.
+func (p *pagina) texto(x, y float64, f fonte, tam float64, s string) {
+	p.textoRotacionado(x, y, 0, f, tam, s)
+}
+
+// textoAlinhado escreve s na largura w a partir de x, com o alinhamento informado, cortando o que não couber.
+func (p *pagina) textoAlinhado(x, y, w float64, f fonte, tam float64, s string, alin alinhamento) {
+	s = corta(f, tam, w, s)
+	switch alin {
+	case centro:
+		x += (w - larguraTexto(f, tam, s)) / 2
+	case direita:
+		x += w - larguraTexto(f, tam, s)
+	}
+	p.texto(x, y, f, tam, s)
+}
+
+// textoRotacionado escreve s a partir de (x, y), girado no sentido anti-horário pelo ângulo informado (em graus).
+func (p *pagina) textoRotacionado(x, y, angulo float64, f fonte, tam float64, s string) {
+	if s == "" {
+		return
+	}
+	rad := angulo * math.Pi / 180
+	cos, sin := math.Cos(rad), math.Sin(rad)
+	fmt.Fprintf(&p.conteudo, "BT /F%d %s Tf %s %s %s %s %s %s Tm (%s) Tj ET\n",
+		f+1, num(tam), num(cos), num(sin), num(-sin), num(cos), p.x(x), p.y(y), escapa(winAnsi(s)))
+}
+
+// bytes gera o arquivo PDF.
+func (d *documento) bytes() []byte {
+	var b bytes.Buffer
+	var offsets []int
+	obj := func(conteudo string) {
+		offsets = append(offsets, b.Len())
+		fmt.Fprintf(&b, "%d 0 obj\n%s\nendobj\n", len(offsets), conteudo)
+	}
+
+	b.WriteString("%PDF-1.4\n%\xe2\xe3\xcf\xd3\n")
+
+	// 1: catálogo, 2: páginas, 3 e 4: fontes, 5: estados gráficos; em seguida, página e conteúdo de cada página.
+	kids := make([]string, len(d.paginas))
+	for i := range d.paginas {
+		kids[i] = fmt.Sprintf("%d 0 R", 6+2*i)
+	}
+	obj("<< /Type /Catalog /Pages 2 0 R >>")
+	obj(fmt.Sprintf("<< /Type /Pages /Kids [%s] /Count %d >>", strings.Join(kids, " "), len(d.paginas)))
+	obj("<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica /Encoding /WinAnsiEncoding >>")
+	obj("<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica-Bold /Encoding /WinAnsiEncoding >>")
+	obj("<< /GS0 << /Type /ExtGState /ca 1 /CA 1 >> /GS1 << /Type /ExtGState /ca 0.3 /CA 0.3 >> >>")
+
+	for i, p := range d.paginas {
+		obj(fmt.Sprintf("<< /Type /Page /Parent 2 0 R /MediaBox [0 0 %s %s] /Resources << /Font << /F1 3 0 R /F2 4 0 R >> /ExtGState 5 0 R >> /Contents %d 0 R >>",
+			num(d.largura*pontosPorMM), num(d.altura*pontosPorMM), 7+2*i))
+		obj(fmt.Sprintf("<< /Length %d >>\nstream\n%sendstream", p.conteudo.Len(), p.conteudo.String()))
+	}
+
+	xref := b.Len()
+	fmt.Fprintf(&b, "xref\n0 %d\n0000000000 65535 f \n", len(offsets)+1)
+	for _, o := range offsets {
+		fmt.Fprintf(&b, "%010d 00000 n \n", o)
+	}
+	fmt.Fprintf(&b, "trailer\n<< /Size %d /Root 1 0 R >>\nstartxref\n%d\n%%%%EOF\n", len(offsets)+1, xref)
+
+	return b.Bytes()
+}
+
+func escapa(s string) string {
+	r := strings.NewReplacer(`\`, `\\`, `(`, `\(`, `)`, `\)`, "\r", "", "\n", " ")
+	return r.Replace(s)
+}
+
+// winAnsi converte o texto para a codificação WinAnsi (cp1252) das fontes padrão do PDF. Caracteres sem representação são substituídos por "?".
+func winAnsi(s string) string {
+	b := make([]byte, 0, len(s))
+	for _, r := range s {
+		switch {
+		case r < 0x80 || (r >= 0xa0 && r <= 0xff):
+			b = append(b, byte(r))
+		default:
+			if c, ok := cp1252[r]; ok {
+				b = append(b, c)
+			} else {
+				b = append(b, '?')
+			}
+		}
+	}
+	return string(b)
+}
+
+var cp1252 = map[rune]byte{
+	'€': 0x80, '…': 0x85, '‘': 0x91, '’': 0x92, '“': 0x93, '”': 0x94, '•': 0x95, '–': 0x96, '—': 0x97,
+}
+
+// Larguras (em milésimos do tamanho da fonte) dos caracteres de 32 a 126 nas fontes Helvetica e Helvetica-Bold.
+var larguras = [2][95]int{
+	{
+		278, 278, 355, 556, 556, 889, 667, 191, 333, 333, 389, 584, 278, 333, 278, 278,
+		556, 556, 556, 556, 556, 556, 556, 556, 556, 556, 278, 278, 584, 584, 584, 556,
+		1015, 667, 667, 722, 722, 667, 611, 778, 722, 278, 500, 667, 556, 833, 722, 778,
+		667, 778, 722, 667, 611, 722, 667, 944, 667, 667, 611, 278, 278, 278, 469, 556,
+		333, 556, 556, 500, 556, 556, 278, 556, 556, 222, 222, 500, 222, 833, 556, 556,
+		556, 556, 333, 500, 278, 556, 500, 722, 500, 500, 500, 334, 260, 334, 584,
+	},
+	{
+		278, 333, 474, 556, 556, 889, 722, 238, 333, 333, 389, 584, 278, 333, 278, 278,
+		556, 556, 556, 556, 556, 556, 556, 556, 556, 556, 333, 333, 584, 584, 584, 611,
+		975, 722, 722, 722, 722, 667, 611, 778, 722, 278, 556, 722, 611, 833, 722, 778,
+		667, 778, 722, 667, 611, 722, 667, 944, 667, 667, 611, 333, 278, 333, 584, 556,
+		333, 556, 611, 556, 611, 556, 333, 611, 611, 278, 278, 556, 278, 889, 611, 611,
+		611, 611, 389, 556, 333, 611, 556, 778, 556, 556, 500, 389, 280, 389, 584,
+	},
+}
+
+// baseAcentuada associa os caracteres acentuados à letra base, que tem a mesma largura nas fontes padrão.
+var baseAcentuada = map[rune]rune{}
+
+func init() {
+	grupos := map[rune]string{
+		'a': "àáâãäå", 'A': "ÀÁÂÃÄÅ", 'c': "ç", 'C': "Ç", 'e': "èéêë", 'E': "ÈÉÊË",
+		'i': "ìíîï", 'I': "ÌÍÎÏ", 'n': "ñ", 'N': "Ñ", 'o': "òóôõö", 'O': "ÒÓÔÕÖ",
+		'u': "ùúûü", 'U': "ÙÚÛÜ", 'y': "ýÿ", 'Y': "Ý",
+	}
+	for base, acentuadas := range grupos {
+		for _, r := range acentuadas {
+			baseAcentuada[r] = base
+		}
+	}
+}
+
+func larguraCaractere(f fonte, r rune) int {
+	if b, ok := baseAcentuada[r]; ok {
+		r = b
+	}
+	switch {
+	case r >= 32 && r <= 126:
+		return larguras[f][r-32]
+	case r == 'º':
+		return 365
+	case r == 'ª':
+		return 370
+	case r == '°':
+		return 400
+	}
+	return 556
+}
+
+// larguraTexto retorna a largura do texto, em milímetros.
+func larguraTexto(f fonte, tam float64, s string) float64 {
+	total := 0
+	for _, r := range s {
+		total += larguraCaractere(f, r)
+	}
+	return float64(total) * tam / 1000 / pontosPorMM
+}
+
+// corta reduz o texto até que caiba na largura informada.
+func corta(f fonte, tam, w float64, s string) string {
+	return cortaTexto(s, func(l string) bool { return larguraTexto(f, tam, l) <= w })
+}
+
+func cortaTexto(s string, cabe func(string) bool) string {
+	for !cabe(s) && s != "" {
+		r := []rune(s)
+		s = string(r[:len(r)-1])
+	}
+	return s
+}
+
+// quebraLinhas divide o texto em linhas que caibam na largura informada, quebrando nos espaços (ou no meio das palavras muito longas) e nas quebras de linha do texto.
+func quebraLinhas(f fonte, tam, w float64, s string) []string {
+	return quebraTexto(s, func(l string) bool { return larguraTexto(f, tam, l) <= w })
+}
+
+// quebraTexto é a quebra de linhas de quebraLinhas com uma medida qualquer (por exemplo, a quantidade de colunas da impressora).
+func quebraTexto(s string, cabe func(string) bool) []string {
+	var linhas []string
+	for _, paragrafo := range strings.Split(strings.ReplaceAll(s, "\r", ""), "\n") {
+		linha := ""
+		for _, palavra := range strings.Fields(paragrafo) {
+			candidata := palavra
+			if linha != "" {
+				candidata = linha + " " + palavra
+			}
+			if cabe(candidata) {
+				linha = candidata
+				continue
+			}
+			if linha != "" {
+				linhas = append(linhas, linha)
+			}
+			for !cabe(palavra) {
+				parte := cortaTexto(palavra, cabe)
+				if parte == "" {
+					break
+				}
+				linhas = append(linhas, parte)
+				palavra = palavra[len(parte):]
+			}
+			linha = palavra
+		}
+		if linha != "" {
+			linhas = append(linhas, linha)
+		}
+	}
+	return linhas
+}
@@ -0,0 +1,415 @@
+package danfe
+
+import "fmt"
+
+// qrCode é um codificador de QR Code (ISO/IEC 18004) restrito ao necessário para a NFC-e: modo byte e nível de correção M, com a versão escolhida pelo tamanho dos dados.
+type qrCode struct {
+	versao  int
+	tamanho int
+	modulos [][]bool // escuro = true, indexado por [linha][coluna]
+	funcao  [][]bool // módulos dos padrões de função, que não recebem dados nem máscara
+}
+
+// Códigos de erro e blocos do nível M, por versão (o índice 0 não é usado).
+var (
+	qrECCPorBloco = [41]int{0,
+		10, 16, 26, 18, 24, 16, 18, 22, 22, 26, 30, 22, 22, 24, 24, 28, 28, 26, 26, 26,
+		26, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28, 28}
+	qrBlocos = [41]int{0,
+		1, 1, 1, 2, 2, 4, 4, 4, 5, 5, 5, 8, 9, 9, 10, 10, 11, 13, 14, 16,
+		17, 17, 18, 20, 21, 23, 25, 26, 28, 29, 31, 33, 35, 37, 38, 40, 43, 45, 47, 49}
+)
+
+// qrFormatoM é o indicador do nível de correção M nas informações de formato.
+const qrFormatoM = 0
+
+// novoQRCode codifica os dados na menor versão que os comporta.
+func novoQRCode(dados []byte) (*qrCode, error) {
+	versao := 0
+	for v := 1; v <= 40; v++ {
+		if 4+qrBitsContagem(v)+8*len(dados) <= 8*qrCodewordsDados(v) {
+			versao = v
+			break
+		}
+	}
+	if versao == 0 {
+		return nil, fmt.Errorf("Dados grandes demais para o QR Code: %d bytes", len(dados))
+	}
+
+	// Modo byte (0100), quantidade de bytes, dados, terminador e preenchimento.
+	var bits []bool
+	anexa := func(v, n int) {
+		for i := n - 1; i >= 0; i-- {
+			bits = append(bits, (v>>i)&1 != 0)
+		}
+	}
+	anexa(0x4, 4)
+	anexa(len(dados), qrBitsContagem(versao))
+	for _, b := range dados {
+		anexa(int(b), 8)
+	}
+	capacidade := 8 * qrCodewordsDados(versao)
+	anexa(0, min(4, capacidade-len(bits)))
+	anexa(0, (8-len(bits)%8)%8)
+	for pad := 0xEC; len(bits) < capacidade; pad ^= 0xEC ^ 0x11 {
+		anexa(pad, 8)
+	}
+
+	codewords := make([]byte, len(bits)/8)
+	for i, b := range bits {
+		if b {
+			codewords[i/8] |= 1 << (7 - i%8)
+		}
+	}
+
+	q := &qrCode{versao: versao, tamanho: 4*versao + 17}
+	q.modulos = qrMatriz(q.tamanho)
+	q.funcao = qrMatriz(q.tamanho)
+	q.padroesDeFuncao()
+	q.posicionaCodewords(qrIntercala(versao, codewords))
+
+	// Escolhe a máscara de menor penalidade.
+	melhor, menor := 0, -1
+	for mascara := 0; mascara < 8; mascara++ {
+		q.aplicaMascara(mascara)
+		q.formato(mascara)
+		if p := q.penalidade(); menor < 0 || p < menor {
+			melhor, menor = mascara, p
+		}
+		q.aplicaMascara(mascara) // a máscara é um XOR: aplicá-la de novo desfaz
+	}
+	q.aplicaMascara(melhor)
+	q.formato(melhor)
+	return q, nil
+}
+
+func qrMatriz(n int) [][]bool {
+	m := make([][]bool, n)
+	for i := range m {
+		m[i] = make([]bool, n)
+	}
+	return m
+}
+
+func qrBitsContagem(versao int) int {
+	if versao <= 9 {
+		return 8
+	}
+	return 16
+}
+
+// qrModulosDados é a quantidade de módulos disponíveis para os codewords (dados e correção) na versão.
+func qrModulosDados(versao int) int {
+	n := (16*versao+128)*versao + 64
+	if versao >= 2 {
+		alinhamentos := versao/7 + 2
+		n -= (25*alinhamentos-10)*alinhamentos - 55
+		if versao >= 7 {
+			n -= 36
+		}
+	}
+	return n
+}
+
+func qrCodewordsDados(versao int) int {
+	return qrModulosDados(versao)/8 - qrECCPorBloco[versao]*qrBlocos[versao]
+}
+
+// qrIntercala divide os dados em blocos, calcula a correção de erros Reed-Solomon de cada um e intercala os codewords.
+func qrIntercala(versao int, dados []byte) []byte {
+	nBlocos, nECC := qrBlocos[versao], qrECCPorBloco[versao]
+	total := qrModulosDados(versao) / 8
+	curtos := nBlocos - total%nBlocos
+	tamCurto := total / nBlocos
+	divisor := rsDivisor(nECC)
+
+	// Os blocos curtos recebem um byte fictício no fim dos dados, para que todos tenham o mesmo tamanho na intercalação.
+	blocos := make([][]byte, nBlocos)
+	for i, k := 0, 0; i < nBlocos; i++ {
+		n := tamCurto - nECC
+		if i >= curtos {
+			n++
+		}
+		bloco := append([]byte(nil), dados[k:k+n]...)
+		k += n
+		ecc := rsResto(bloco, divisor)
+		if i < curtos {
+			bloco = append(bloco, 0)
+		}
+		blocos[i] = append(bloco, ecc...)
+	}
+
+	var r []byte
+	for i := range blocos[0] {
+		for j, bloco := range blocos {
+			if i != tamCurto-nECC || j >= curtos {
+				r = append(r, bloco[i])
+			}
+		}
+	}
+	return r
+}
+
+// rsMultiplica multiplica no GF(2^8) com o polinômio 0x11D.
+func rsMultiplica(x, y byte) byte {
+	z := 0
+	for i := 7; i >= 0; i-- {
+		z = (z << 1) ^ ((z >> 7) * 0x11D)
+		z ^= int((y>>i)&1) * int(x)
+	}
+	return byte(z)
+}
+
+// rsDivisor retorna o polinômio gerador de grau n (raízes 2^0 a 2^(n-1)), sem o coeficiente do termo de maior grau, que é 1.
+func rsDivisor(n int) []byte {
+	r := make([]byte, n)
+	r[n-1] = 1
+	raiz := byte(1)
+	for i := 0; i < n; i++ {
+		for j := range r {
+			r[j] = rsMultiplica(r[j], raiz)
+			if j+1 < n {
+				r[j] ^= r[j+1]
+			}
+		}
+		raiz = rsMultiplica(raiz, 2)
+	}
+	return r
+}
+
+func rsResto(dados, divisor []byte) []byte {
+	r := make([]byte, len(divisor))
+	for _, b := range dados {
+		fator := b ^ r[0]
+		copy(r, r[1:])
+		r[len(r)-1] = 0
+		for i := range r {
+			r[i] ^= rsMultiplica(divisor[i], fator)
+		}
+	}
+	return r
+}
+
+func (q *qrCode) define(x, y int, escuro bool) {
+	q.modulos[y][x] = escuro
+	q.funcao[y][x] = true
+}
+
+// padroesDeFuncao desenha os padrões de localização, sincronismo e alinhamento, a informação de versão e reserva a área das informações de formato.
+func (q *qrCode) padroesDeFuncao() {
+	n := q.tamanho
+	for i := 0; i < n; i++ {
+		q.define(6, i, i%2 == 0)
+		q.define(i, 6, i%2 == 0)
+	}
+
+	for _, c := range [][2]int{{3, 3}, {n - 4, 3}, {3, n - 4}} {
+		for dy := -4; dy <= 4; dy++ {
+			for dx := -4; dx <= 4; dx++ {
+				x, y := c[0]+dx, c[1]+dy
+				if x >= 0 && x < n && y >= 0 && y < n {
+					d := max(abs(dx), abs(dy))
+					q.define(x, y, d != 2 && d != 4)
+				}
+			}
+		}
+	}
+
+	pos := qrAlinhamentos(q.versao)
+	for i, y := range pos {
+		for j, x := range pos {
+			if i == 0 && j == 0 || i == 0 && j == len(pos)-1 || i == len(pos)-1 && j == 0 {
+				continue // sobreposto aos padrões de localização
+			}
+			for dy := -2; dy <= 2; dy++ {
+				for dx := -2; dx <= 2; dx++ {
+					q.define(x+dx, y+dy, max(abs(dx), abs(dy)) != 1)
+				}
+			}
+		}
+	}
+
+	q.formato(0)
+
+	if q.versao >= 7 {
+		resto := q.versao
+		for i := 0; i < 12; i++ {
+			resto = (resto << 1) ^ ((resto >> 11) * 0x1F25)
+		}
+		bits := q.versao<<12 | resto
+		for i := 0; i < 18; i++ {
+			escuro := (bits>>i)&1 != 0
+			a, b := n-11+i%3, i/3
+			q.define(a, b, escuro)
+			q.define(b, a, escuro)
+		}
+	}
+}
+
+// qrAlinhamentos retorna as coordenadas dos centros dos padrões de alinhamento.
+func qrAlinhamentos(versao int) []int {
+	if versao == 1 {
+		return nil
+	}
+	n := versao/7 + 2
+	passo := (versao*8 + n*3 + 5) / (n*4 - 4) * 2
+	pos := make([]int, n)
+	pos[0] = 6
+	for i, p := n-1, 4*versao+17-7; i >= 1; i, p = i-1, p-passo {
+		pos[i] = p
+	}
+	return pos
+}
+
+// formato desenha as duas cópias das informações de formato (nível de correção e máscara) e o módulo escuro fixo.
+func (q *qrCode) formato(mascara int) {
+	dados := qrFormatoM<<3 | mascara
+	resto := dados
+	for i := 0; i < 10; i++ {
+		resto = (resto << 1) ^ ((resto >> 9) * 0x537)
+	}
+	bits := (dados<<10 | resto) ^ 0x5412
+	bit := func(i int) bool { return (bits>>i)&1 != 0 }
+
+	n := q.tamanho
+	for i := 0; i <= 5; i++ {
+		q.define(8, i, bit(i))
+	}
+	q.define(8, 7, bit(6))
+	q.define(8, 8, bit(7))
+	q.define(7, 8, bit(8))
+	for i := 9; i < 15; i++ {
+		q.define(14-i, 8, bit(i))
+	}
+	for i := 0; i < 8; i++ {
+		q.define(n-1-i, 8, bit(i))
+	}
+	for i := 8; i < 15; i++ {
+		q.define(8, n-15+i, bit(i))
+	}
+	q.define(8, n-8, true)
+}
+
+// posicionaCodewords distribui os bits em zigue-zague, em colunas duplas da direita para a esquerda, pulando os padrões de função.
+func (q *qrCode) posicionaCodewords(dados []byte) {
+	n, i := q.tamanho, 0
+	for direita := n - 1; direita >= 1; direita -= 2 {
+		if direita == 6 {
+			direita = 5 // a coluna do padrão de sincronismo vertical é pulada
+		}
+		subindo := (direita+1)&2 == 0
+		for v := 0; v < n; v++ {
+			y := v
+			if subindo {
+				y = n - 1 - v
+			}
+			for j := 0; j < 2; j++ {
+				x := direita - j
+				if !q.funcao[y][x] && i < len(dados)*8 {
+					q.modulos[y][x] = (dados[i/8]>>(7-i%8))&1 != 0
+					i++
+				}
+			}
+		}
+	}
+}
+
+func qrMascara(mascara, x, y int) bool {
+	switch mascara {
+	case 0:
+		return (x+y)%2 == 0
+	case 1:
+		return y%2 == 0
+	case 2:
+		return x%3 == 0
+	case 3:
+		return (x+y)%3 == 0
+	case 4:
+		return (x/3+y/2)%2 == 0
+	case 5:
+		return x*y%2+x*y%3 == 0
+	case 6:
+		return (x*y%2+x*y%3)%2 == 0
+	default:
+		return ((x+y)%2+x*y%3)%2 == 0
+	}
+}
+
+func (q *qrCode) aplicaMascara(mascara int) {
+	for y := range q.modulos {
+		for x := range q.modulos[y] {
+			if !q.funcao[y][x] && qrMascara(mascara, x, y) {
+				q.modulos[y][x] = !q.modulos[y][x]
+			}
+		}
+	}
+}
+
+// penalidade avalia o símbolo pelas quatro regras da norma; a máscara de menor penalidade é a que facilita a leitura.
+func (q *qrCode) penalidade() int {
+	n := q.tamanho
+	em := func(x, y int, vertical bool) bool {
+		if vertical {
+			return q.modulos[x][y]
+		}
+		return q.modulos[y][x]
+	}
+
+	p := 0
+	padrao := []bool{true, false, true, true, true, false, true, false, false, false, false}
+	for _, vertical := range []bool{false, true} {
+		for y := 0; y < n; y++ {
+			// Sequências de 5 ou mais módulos da mesma cor.
+			seq := 1
+			for x := 1; x <= n; x++ {
+				if x < n && em(x, y, vertical) == em(x-1, y, vertical) {
+					seq++
+					continue
+				}
+				if seq >= 5 {
+					p += seq - 2
+				}
+				seq = 1
+			}
+
+			// Padrões semelhantes aos de localização (1:1:3:1:1 com 4 módulos claros de um dos lados).
+			for x := 0; x+len(padrao) <= n; x++ {
+				direto, inverso := true, true
+				for k, escuro := range padrao {
+					direto = direto && em(x+k, y, vertical) == escuro
+					inverso = inverso && em(x+len(padrao)-1-k, y, vertical) == escuro
+				}
+				if direto {
+					p += 40
+				}
+				if inverso {
+					p += 40
+				}
+			}
+		}
+	}
+
+	escuros := 0
+	for y := 0; y < n; y++ {
+		for x := 0; x < n; x++ {
+			c := q.modulos[y][x]
+			if c {
+				escuros++
+			}
+			if x+1 < n && y+1 < n && c == q.modulos[y][x+1] && c == q.modulos[y+1][x] && c == q.modulos[y+1][x+1] {
+				p += 3
+			}
+		}
+	}
+
+	total := n * n
+	k := (abs(escuros*20-total*10)+total-1)/total - 1
+	return p + k*10
+}
+
+func abs(v int) int {
+	if v < 0 {
+		return -v
+	}
+	return v
+}
@@ -0,0 +1,206 @@
+package danfe
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestQRCodeCapacidade(t *testing.T) {
+	// Capacidade em bytes do nível M segundo a tabela da norma.
+	for versao, want := range map[int]int{1: 14, 2: 26, 7: 122, 10: 213, 20: 666, 40: 2331} {
+		if got := (8*qrCodewordsDados(versao) - 4 - qrBitsContagem(versao)) / 8; got != want {
+			t.Errorf("versão %d: capacidade %d; esperada %d", versao, got, want)
+		}
+	}
+}
+
+func TestQRCodeFormato(t *testing.T) {
+	// Informações de formato do nível M, por máscara, segundo a tabela da norma.
+	want := []string{"101010000010010", "101000100100101", "101111001111100", "101101101001011",
+		"100010111111001", "100000011001110", "100111110010111", "100101010100000"}
+	q := &qrCode{versao: 1, tamanho: 21, modulos: qrMatriz(21), funcao: qrMatriz(21)}
+	for mascara, w := range want {
+		q.formato(mascara)
+		if got := formatoLido(q); got != w {
+			t.Errorf("máscara %d: formato %s; esperado %s", mascara, got, w)
+		}
+	}
+}
+
+// formatoLido lê a primeira cópia das informações de formato, do bit mais significativo ao menos significativo.
+func formatoLido(q *qrCode) string {
+	var bits [15]bool
+	for i := 0; i <= 5; i++ {
+		bits[i] = q.modulos[i][8]
+	}
+	bits[6], bits[7], bits[8] = q.modulos[7][8], q.modulos[8][8], q.modulos[8][7]
+	for i := 9; i < 15; i++ {
+		bits[i] = q.modulos[8][14-i]
+	}
+	var s strings.Builder
+	for i := 14; i >= 0; i-- {
+		if bits[i] {
+			s.WriteByte('1')
+		} else {
+			s.WriteByte('0')
+		}
+	}
+	return s.String()
+}
+
+func TestQRCode(t *testing.T) {
+	url := "https://www.homologacao.nfce.fazenda.sp.gov.br/qrcode?p=35240511222333000181650010000001231123456788|2|2|1|A1B2C3D4E5F60718293A4B5C6D7E8F9012345678"
+	for _, dados := range []string{"", "1", url, url + "|" + strings.Repeat("QUJD", 86), strings.Repeat("NFC-e ", 300)} {
+		q, err := novoQRCode([]byte(dados))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := decodificaQR(t, q); !bytes.Equal(got, []byte(dados)) {
+			t.Errorf("versão %d: decodificado %q; esperado %q", q.versao, got, dados)
+		}
+	}
+
+	if _, err := novoQRCode(make([]byte, 2332)); err == nil {
+		t.Errorf("dados acima da capacidade da versão 40 deveriam falhar")
+	}
+}
+
+// decodificaQR lê o símbolo de volta: desfaz a máscara indicada no formato, recolhe os codewords, confere a correção de erros de cada bloco (síndromes nulas) e extrai os dados do segmento em modo byte.
+func decodificaQR(t *testing.T, q *qrCode) []byte {
+	t.Helper()
+	formato := formatoLido(q)
+	if formato[:2] != "10" { // nível M (00) após o XOR com 101010000010010
+		t.Fatalf("nível de correção inesperado no formato %s", formato)
+	}
+	mascara := 0
+	for _, c := range formato[2:5] {
+		mascara = mascara<<1 | int(c-'0')
+	}
+	mascara ^= 0x5
+
+	n := q.tamanho
+	var codewords []byte
+	var atual byte
+	bits := 0
+	for direita := n - 1; direita >= 1; direita -= 2 {
+		if direita == 6 {
+			direita = 5
+		}
+		for v := 0; v < n; v++ {
+			y := v
+			if (direita+1)&2 == 0 {
+				y = n - 1 - v
+			}
+			for _, x := range []int{direita, direita - 1} {
+				if q.funcao[y][x] {
+					continue
+				}
+				escuro := q.modulos[y][x] != qrMascara(mascara, x, y)
+				atual <<= 1
+				if escuro {
+					atual |= 1
+				}
+				if bits++; bits%8 == 0 {
+					codewords = append(codewords, atual)
+				}
+			}
+		}
+	}
+
+	nBlocos, nECC := qrBlocos[q.versao], qrECCPorBloco[q.versao]
+	total := qrModulosDados(q.versao) / 8
+	codewords = codewords[:total]
+	curtos := nBlocos - total%nBlocos
+	tamDados := make([]int, nBlocos)
+	for i := range tamDados {
+		tamDados[i] = total/nBlocos - nECC
+		if i >= curtos {
+			tamDados[i]++
+		}
+	}
+	blocos := make([][]byte, nBlocos)
+	k := 0
+	for i := 0; i < tamDados[nBlocos-1]; i++ {
+		for j := range blocos {
+			if i < tamDados[j] {
+				blocos[j] = append(blocos[j], codewords[k])
+				k++
+			}
+		}
+	}
+	for i := 0; i < nECC; i++ {
+		for j := range blocos {
+			blocos[j] = append(blocos[j], codewords[k])
+			k++
+		}
+	}
+
+	var dados []byte
+	for j, bloco := range blocos {
+		raiz := byte(1)
+		for i := 0; i < nECC; i++ {
+			s := byte(0)
+			for _, c := range bloco {
+				s = rsMultiplica(s, raiz) ^ c
+			}
+			if s != 0 {
+				t.Fatalf("versão %d, bloco %d: síndrome %d não nula", q.versao, j, i)
+			}
+			raiz = rsMultiplica(raiz, 2)
+		}
+		dados = append(dados, bloco[:tamDados[j]]...)
+	}
+
+	if dados[0]>>4 != 0x4 {
+		t.Fatalf("modo %x inesperado", dados[0]>>4)
+	}
+	le := func(pos, n int) int {
+		v := 0
+		for i := pos; i < pos+n; i++ {
+			v = v<<1 | int(dados[i/8]>>(7-i%8)&1)
+		}
+		return v
+	}
+	tam := le(4, qrBitsContagem(q.versao))
+	r := make([]byte, tam)
+	for i := range r {
+		r[i] = byte(le(4+qrBitsContagem(q.versao)+8*i, 8))
+	}
+	return r
+}
+
+func TestQRCodeVersao(t *testing.T) {
+	// Informação de versão e centros dos padrões de alinhamento segundo as tabelas da norma.
+	tests := []struct {
+		versao, info int
+		alinhamentos []int
+	}{
+		{2, 0, []int{6, 18}},
+		{7, 0x07C94, []int{6, 22, 38}},
+		{32, 0x209D5, []int{6, 34, 60, 86, 112, 138}},
+		{40, 0x28C69, []int{6, 30, 58, 86, 114, 142, 170}},
+	}
+	for _, tt := range tests {
+		if got := qrAlinhamentos(tt.versao); fmt.Sprint(got) != fmt.Sprint(tt.alinhamentos) {
+			t.Errorf("versão %d: alinhamentos %v; esperados %v", tt.versao, got, tt.alinhamentos)
+		}
+		if tt.info == 0 {
+			continue
+		}
+		n := 4*tt.versao + 17
+		q := &qrCode{versao: tt.versao, tamanho: n, modulos: qrMatriz(n), funcao: qrMatriz(n)}
+		q.padroesDeFuncao()
+		info := 0
+		for i := 17; i >= 0; i-- {
+			info <<= 1
+			if q.modulos[i/3][n-11+i%3] && q.modulos[n-11+i%3][i/3] {
+				info |= 1
+			}
+		}
+		if info != tt.info {
+			t.Errorf("versão %d: informação de versão %05X; esperada %05X", tt.versao, info, tt.info)
+		}
+	}
+}
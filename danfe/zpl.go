@@ -0,0 +1,84 @@
+package danfe
+
+import (
+	"fmt"
+	"math"
+	"strings"
+)
+
+// superficie é onde os leiautes de etiqueta são desenhados: a página do PDF ou a etiqueta ZPL. As coordenadas são em milímetros, a partir do canto superior esquerdo.
+type superficie interface {
+	retangulo(x, y, w, h float64)
+	linha(x1, y1, x2, y2 float64)
+	textoAlinhado(x, y, w float64, f fonte, tam float64, s string, alin alinhamento)
+	codigoDeBarras(x, y, w, h float64, digitos string) error
+}
+
+// etiquetaZPL gera os comandos ZPL II das impressoras Zebra. O texto usa a fonte 0 (escalável), com as medidas da Helvetica para o corte e o alinhamento, e a codificação UTF-8 (^CI28).
+type etiquetaZPL struct {
+	comandos    strings.Builder
+	pontosPorMM float64
+}
+
+func novaEtiquetaZPL(dpi int, largura, altura float64) *etiquetaZPL {
+	// As impressoras Zebra têm resolução nominal de 8, 12 ou 24 pontos por milímetro (203, 300 e 600 dpi).
+	z := &etiquetaZPL{pontosPorMM: math.Round(float64(dpi) / 25.4)}
+	fmt.Fprintf(&z.comandos, "^XA\n^CI28\n^PW%d\n^LL%d\n^LH0,0\n", z.pontos(largura), z.pontos(altura))
+	return z
+}
+
+func (z *etiquetaZPL) pontos(mm float64) int {
+	return int(math.Round(mm * z.pontosPorMM))
+}
+
+// traco é a espessura das linhas, em pontos.
+func (z *etiquetaZPL) traco() int {
+	return max(2, z.pontos(0.25))
+}
+
+func (z *etiquetaZPL) retangulo(x, y, w, h float64) {
+	fmt.Fprintf(&z.comandos, "^FO%d,%d^GB%d,%d,%d^FS\n", z.pontos(x), z.pontos(y), z.pontos(w), z.pontos(h), z.traco())
+}
+
+// linha desenha linhas horizontais ou verticais, as únicas usadas nas etiquetas.
+func (z *etiquetaZPL) linha(x1, y1, x2, y2 float64) {
+	w, h := max(z.pontos(math.Abs(x2-x1)), z.traco()), max(z.pontos(math.Abs(y2-y1)), z.traco())
+	fmt.Fprintf(&z.comandos, "^FO%d,%d^GB%d,%d,%d^FS\n", z.pontos(min(x1, x2)), z.pontos(min(y1, y2)), w, h, z.traco())
+}
+
+// textoAlinhado posiciona o texto pela linha de base (como no PDF) e o alinha com um bloco de uma linha (^FB).
+func (z *etiquetaZPL) textoAlinhado(x, y, w float64, f fonte, tam float64, s string, alin alinhamento) {
+	s = corta(f, tam, w, s)
+	if s == "" {
+		return
+	}
+	altura := tam / pontosPorMM
+	just := [...]string{esquerda: "L", centro: "C", direita: "R"}[alin]
+	fmt.Fprintf(&z.comandos, "^FO%d,%d^A0N,%d^FB%d,1,0,%s^FH_^FD%s^FS\n",
+		z.pontos(x), z.pontos(y-altura*0.75), z.pontos(altura), z.pontos(w), just, escapaZPL(s))
+}
+
+// codigoDeBarras usa o Code 128 da impressora (^BC), no conjunto C (>;), com o maior módulo inteiro que caiba na largura.
+func (z *etiquetaZPL) codigoDeBarras(x, y, w, h float64, digitos string) error {
+	larguras, err := code128C(digitos)
+	if err != nil {
+		return err
+	}
+	modulos := 0
+	for _, l := range larguras {
+		modulos += l
+	}
+	modulo := max(1, z.pontos(w)/modulos)
+	x0 := z.pontos(x) + (z.pontos(w)-modulo*modulos)/2
+	fmt.Fprintf(&z.comandos, "^FO%d,%d^BY%d^BCN,%d,N,N,N,N^FD>;%s^FS\n", x0, z.pontos(y), modulo, z.pontos(h), digitos)
+	return nil
+}
+
+func (z *etiquetaZPL) bytes() []byte {
+	return []byte(z.comandos.String() + "^XZ\n")
+}
+
+// escapaZPL protege os caracteres de controle do ZPL em hexadecimal, com o indicador "_" definido por ^FH.
+func escapaZPL(s string) string {
+	return strings.NewReplacer("_", "_5F", "^", "_5E", "~", "_7E").Replace(s)
+}
@@ -15,7 +15,6 @@ import (
 const (
 	xmlnsDistDFe      = "http://www.portalfiscal.inf.br/nfe/wsdl/NFeDistribuicaoDFe"
 	soapActionDistDFe = "http://www.portalfiscal.inf.br/nfe/wsdl/NFeDistribuicaoDFe/nfeDistDFeInteresse"
-	urlDistDFe        = "https://www1.nfe.fazenda.gov.br/NFeDistribuicaoDFe/NFeDistribuicaoDFe.asmx"
 )
 
 // ============================================================
@@ -105,7 +104,14 @@ type NFeProc struct {
 }
 
 type NFe struct {
-	InfNFe InfNFe `xml:"infNFe"`
+	InfNFe     InfNFe      `xml:"infNFe"`
+	InfNFeSupl *InfNFeSupl `xml:"infNFeSupl"`
+}
+
+// InfNFeSupl contém as informações suplementares da NFC-e (ver AdicionaInfNFeSupl).
+type InfNFeSupl struct {
+	QrCode   string `xml:"qrCode"`
+	UrlChave string `xml:"urlChave"`
 }
 
 type InfNFe struct {
@@ -131,6 +137,7 @@ type Ide struct {
 	Serie       int    `xml:"serie"`
 	NNF         int    `xml:"nNF"`
 	DhEmi       string `xml:"dhEmi"`
+	DhSaiEnt    string `xml:"dhSaiEnt"`
 	TpNF        int    `xml:"tpNF"`
 	IdDest      int    `xml:"idDest"`
 	CMunFG      int    `xml:"cMunFG"`
@@ -144,6 +151,8 @@ type Ide struct {
 	IndIntermed int    `xml:"indIntermed"`
 	ProcEmi     int    `xml:"procEmi"`
 	VerProc     string `xml:"verProc"`
+	DhCont      string `xml:"dhCont,omitempty"`
+	XJust       string `xml:"xJust,omitempty"`
 }
 
 type Emit struct {
@@ -152,17 +161,20 @@ type Emit struct {
 	XFant     string `xml:"xFant"`
 	EnderEmit Ender  `xml:"enderEmit"`
 	IE        string `xml:"IE"`
+	IEST      string `xml:"IEST"`
 	IM        string `xml:"IM"`
 	CNAE      string `xml:"CNAE"`
 	CRT       int    `xml:"CRT"`
 }
 
 type Dest struct {
-	CNPJ      string `xml:"CNPJ"`
-	XNome     string `xml:"xNome"`
-	EnderDest Ender  `xml:"enderDest"`
-	IndIEDest int    `xml:"indIEDest"`
-	IE        string `xml:"IE"`
+	CNPJ          string `xml:"CNPJ"`
+	CPF           string `xml:"CPF"`
+	IdEstrangeiro string `xml:"idEstrangeiro"`
+	XNome         string `xml:"xNome"`
+	EnderDest     Ender  `xml:"enderDest"`
+	IndIEDest     int    `xml:"indIEDest"`
+	IE            string `xml:"IE"`
 }
 
 type Ender struct {
@@ -211,6 +223,7 @@ type Imposto struct {
 
 	ICMS struct {
 		ICMS00 *ICMS00 `xml:"ICMS00"`
+		Outro  *ICMS00 `xml:",any"` // demais grupos (ICMS10, ICMS20, ..., ICMSSN102, ...), com os campos comuns
 	} `xml:"ICMS"`
 
 	IPI struct {
@@ -231,6 +244,7 @@ type Imposto struct {
 type ICMS00 struct {
 	Orig  int     `xml:"orig"`
 	CST   string  `xml:"CST"`
+	CSOSN string  `xml:"CSOSN"`
 	ModBC int     `xml:"modBC"`
 	VBC   float64 `xml:"vBC"`
 	PICMS float64 `xml:"pICMS"`
@@ -313,7 +327,14 @@ type Vol struct {
 }
 
 type Cobr struct {
-	Fat *Fat `xml:"fat"`
+	Fat *Fat  `xml:"fat"`
+	Dup []Dup `xml:"dup"`
+}
+
+type Dup struct {
+	NDup  string  `xml:"nDup"`
+	DVenc string  `xml:"dVenc"`
+	VDup  float64 `xml:"vDup"`
 }
 
 type Fat struct {
@@ -325,6 +346,7 @@ type Fat struct {
 
 type Pag struct {
 	DetPag []DetPag `xml:"detPag"`
+	VTroco float64  `xml:"vTroco"`
 }
 
 type DetPag struct {
@@ -338,15 +360,15 @@ type InfAdic struct {
 }
 
 type InfProt struct {
-	Id       string    `xml:"Id,attr"`
-	TpAmb    int       `xml:"tpAmb"`
-	VerAplic string    `xml:"verAplic"`
-	ChNFe    string    `xml:"chNFe"`
-	DhRecbto time.Time `xml:"dhRecbto"`
-	NProt    string    `xml:"nProt"`
-	DigVal   string    `xml:"digVal"`
-	CStat    int       `xml:"cStat"`
-	XMotivo  string    `xml:"xMotivo"`
+	Id       string   `xml:"Id,attr"`
+	TpAmb    int      `xml:"tpAmb"`
+	VerAplic string   `xml:"verAplic"`
+	ChNFe    string   `xml:"chNFe"`
+	DhRecbto DateTime `xml:"dhRecbto"`
+	NProt    string   `xml:"nProt"`
+	DigVal   string   `xml:"digVal"`
+	CStat    int      `xml:"cStat"`
+	XMotivo  string   `xml:"xMotivo"`
 }
 
 // ============================================================
@@ -375,6 +397,14 @@ type NotaFiscalDistribuida struct {
 	Modelo           string
 	NaturezaOperacao string
 	DataEmissao      time.Time
+	DataSaidaEntrada time.Time
+
+	TipoOperacao              int // tpNF: 0 = entrada, 1 = saída
+	Ambiente                  int // tpAmb
+	TipoEmissao               int // tpEmis
+	TipoImpressao             int // tpImp: 1 = DANFE retrato, 2 = paisagem, 4 = DANFE NFC-e
+	DataContingencia          time.Time
+	JustificativaContingencia string
 
 	Emitente     ParteNFe
 	Destinatario ParteNFe
@@ -385,17 +415,25 @@ type NotaFiscalDistribuida struct {
 	Transporte *TransporteNotaFiscal
 	Cobranca   *CobrancaNotaFiscal
 	Pagamentos []PagamentoNotaFiscal
+	Troco      float64
 	Protocolo  ProtocoloNotaFiscal
 
+	// QRCode e URLConsulta vêm do grupo infNFeSupl da NFC-e.
+	QRCode      string
+	URLConsulta string
+
 	InformacoesComplementares string
 }
 
 type ParteNFe struct {
-	CNPJ         string
-	Nome         string
-	NomeFantasia string
-	IE           string
-	Endereco     EnderecoNFe
+	CNPJ          string
+	CPF           string
+	IdEstrangeiro string
+	Nome          string
+	NomeFantasia  string
+	IE            string
+	IEST          string
+	Endereco      EnderecoNFe
 }
 
 type EnderecoNFe struct {
@@ -438,6 +476,7 @@ type ItemNotaFiscal struct {
 type ICMSItem struct {
 	Origem      int
 	CST         string
+	CSOSN       string
 	BaseCalculo float64
 	Aliquota    float64
 	Valor       float64
@@ -468,7 +507,14 @@ type COFINSItem struct {
 type TotaisNotaFiscal struct {
 	ValorBaseICMS           float64
 	ValorICMS               float64
+	ValorBaseICMSST         float64
+	ValorICMSST             float64
 	ValorProdutos           float64
+	ValorFrete              float64
+	ValorSeguro             float64
+	ValorDesconto           float64
+	ValorOutros             float64
+	ValorIPI                float64
 	ValorNota               float64
 	ValorTributosAproximado float64
 }
@@ -497,6 +543,13 @@ type CobrancaNotaFiscal struct {
 	ValorOriginal float64
 	Desconto      float64
 	ValorLiquido  float64
+	Duplicatas    []DuplicataNotaFiscal
+}
+
+type DuplicataNotaFiscal struct {
+	Numero     string
+	Vencimento time.Time
+	Valor      float64
 }
 
 type PagamentoNotaFiscal struct {
@@ -516,8 +569,8 @@ type ProtocoloNotaFiscal struct {
 // sendRequestDist — SOAP 1.1 simples
 // ============================================================
 
-func sendRequestDist(soap []byte, url string, soapAction string, client *http.Client, optReq ...func(*http.Request)) ([]byte, error) {
-	req, err := http.NewRequest("POST", url, bytes.NewReader(soap))
+func sendRequestDist(soap []byte, ep Endpoint, soapAction string, client *http.Client, optReq ...func(*http.Request)) ([]byte, error) {
+	req, err := http.NewRequest("POST", ep.URL, bytes.NewReader(soap))
 	if err != nil {
 		return nil, fmt.Errorf("erro criando request: %w", err)
 	}
@@ -536,9 +589,10 @@ func sendRequestDist(soap []byte, url string, soapAction string, client *http.Cl
 	defer resp.Body.Close()
 
 	body, _ := io.ReadAll(resp.Body)
+	body = DefaultNormalizador.Normaliza(ep, body)
 
-	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("status %d: %s", resp.StatusCode, string(body))
+	if wsErr := checkResponse(resp, body, ep); wsErr != nil {
+		return nil, wsErr
 	}
 
 	return body, nil
@@ -555,13 +609,18 @@ func ConsultaDistChNFe(
 	client *http.Client,
 	optReq ...func(*http.Request),
 ) (ResultadoDistribuicaoNFe, error) {
+	ep, err := getEndpoint(91, tpAmb, DistribuicaoDFe)
+	if err != nil {
+		return ResultadoDistribuicaoNFe{}, err
+	}
+
 	// monta wrapper
 	w := DistDFeWrapper{
 		Xmlns: xmlnsDistDFe,
 	}
 	w.Dados.Xmlns = xmlnsDistDFe
 	w.Dados.Msg = DistDFeInt{
-		Versao:   "1.01",
+		Versao:   ep.Versao,
 		TpAmb:    int(tpAmb),
 		CUFAutor: mustInt(chave[:2]),
 		CNPJ:     cnpj,
@@ -589,7 +648,7 @@ func ConsultaDistChNFe(
 	// envia
 	respSoap, err := sendRequestDist(
 		soapBody,
-		urlDistDFe,
+		ep,
 		soapActionDistDFe,
 		client,
 		optReq...,
@@ -598,6 +657,11 @@ func ConsultaDistChNFe(
 		return ResultadoDistribuicaoNFe{}, err
 	}
 
+	return lerRetDistDFeInt(respSoap)
+}
+
+// lerRetDistDFeInt extrai o retDistDFeInt do envelope SOAP de retorno e monta o modelo semântico das NF-e (procNFe) recebidas.
+func lerRetDistDFeInt(respSoap []byte) (ResultadoDistribuicaoNFe, error) {
 	// extrai <retDistDFeInt> de dentro do SOAP
 	rawRet, err := extractRetDistDFeInt(respSoap)
 	if err != nil {
@@ -619,7 +683,7 @@ func ConsultaDistChNFe(
 	}
 
 	if ret.DhResp != "" {
-		if t, err := time.Parse(time.RFC3339, ret.DhResp); err == nil {
+		if t, err := ParseDateTime(ret.DhResp); err == nil {
 			result.DataResposta = t
 		}
 	}
@@ -713,23 +777,39 @@ func toNotaFiscalDistribuida(doc DocZip, proc NFeProc) (NotaFiscalDistribuida, e
 		Modelo:           ide.Mod,
 		NaturezaOperacao: strings.TrimSpace(ide.NatOp),
 
+		TipoOperacao:              ide.TpNF,
+		Ambiente:                  ide.TpAmb,
+		TipoEmissao:               ide.TpEmis,
+		TipoImpressao:             ide.TpImp,
+		JustificativaContingencia: strings.TrimSpace(ide.XJust),
+
 		Emitente: ParteNFe{
 			CNPJ:         strings.TrimSpace(emit.CNPJ),
 			Nome:         strings.TrimSpace(emit.XNome),
 			NomeFantasia: strings.TrimSpace(emit.XFant),
 			IE:           strings.TrimSpace(emit.IE),
+			IEST:         strings.TrimSpace(emit.IEST),
 			Endereco:     toEndereco(emit.EnderEmit),
 		},
 		Destinatario: ParteNFe{
-			CNPJ:     strings.TrimSpace(dest.CNPJ),
-			Nome:     strings.TrimSpace(dest.XNome),
-			IE:       strings.TrimSpace(dest.IE),
-			Endereco: toEndereco(dest.EnderDest),
+			CNPJ:          strings.TrimSpace(dest.CNPJ),
+			CPF:           strings.TrimSpace(dest.CPF),
+			IdEstrangeiro: strings.TrimSpace(dest.IdEstrangeiro),
+			Nome:          strings.TrimSpace(dest.XNome),
+			IE:            strings.TrimSpace(dest.IE),
+			Endereco:      toEndereco(dest.EnderDest),
 		},
 		Totais: TotaisNotaFiscal{
 			ValorBaseICMS:           inf.Total.ICMSTot.VBC,
 			ValorICMS:               inf.Total.ICMSTot.VICMS,
+			ValorBaseICMSST:         inf.Total.ICMSTot.VBCST,
+			ValorICMSST:             inf.Total.ICMSTot.VST,
 			ValorProdutos:           inf.Total.ICMSTot.VProd,
+			ValorFrete:              inf.Total.ICMSTot.VFrete,
+			ValorSeguro:             inf.Total.ICMSTot.VSeg,
+			ValorDesconto:           inf.Total.ICMSTot.VDesc,
+			ValorOutros:             inf.Total.ICMSTot.VOutro,
+			ValorIPI:                inf.Total.ICMSTot.VIPI,
 			ValorNota:               inf.Total.ICMSTot.VNF,
 			ValorTributosAproximado: inf.Total.ICMSTot.VTotTrib,
 		},
@@ -741,10 +821,20 @@ func toNotaFiscalDistribuida(doc DocZip, proc NFeProc) (NotaFiscalDistribuida, e
 	}
 
 	if ide.DhEmi != "" {
-		if t, err := time.Parse(time.RFC3339, ide.DhEmi); err == nil {
+		if t, err := ParseDateTime(ide.DhEmi); err == nil {
 			nota.DataEmissao = t
 		}
 	}
+	if ide.DhSaiEnt != "" {
+		if t, err := ParseDateTime(ide.DhSaiEnt); err == nil {
+			nota.DataSaidaEntrada = t
+		}
+	}
+	if ide.DhCont != "" {
+		if t, err := ParseDateTime(ide.DhCont); err == nil {
+			nota.DataContingencia = t
+		}
+	}
 
 	nota.Chave = strings.TrimSpace(proc.ProtNFe.InfProt.ChNFe)
 	if nota.Chave == "" && strings.HasPrefix(inf.Id, "NFe") {
@@ -752,7 +842,7 @@ func toNotaFiscalDistribuida(doc DocZip, proc NFeProc) (NotaFiscalDistribuida, e
 	}
 
 	if !proc.ProtNFe.InfProt.DhRecbto.IsZero() {
-		nota.Protocolo.DataRecebimento = proc.ProtNFe.InfProt.DhRecbto
+		nota.Protocolo.DataRecebimento = proc.ProtNFe.InfProt.DhRecbto.Time
 	}
 
 	if inf.InfAdic != nil {
@@ -763,12 +853,20 @@ func toNotaFiscalDistribuida(doc DocZip, proc NFeProc) (NotaFiscalDistribuida, e
 		nota.Transporte = toTransporte(*inf.Transp)
 	}
 
-	if inf.Cobr != nil && inf.Cobr.Fat != nil {
-		nota.Cobranca = &CobrancaNotaFiscal{
-			NumeroFatura:  strings.TrimSpace(inf.Cobr.Fat.NFat),
-			ValorOriginal: inf.Cobr.Fat.VOrig,
-			Desconto:      inf.Cobr.Fat.VDesc,
-			ValorLiquido:  inf.Cobr.Fat.VLiq,
+	if inf.Cobr != nil && (inf.Cobr.Fat != nil || len(inf.Cobr.Dup) > 0) {
+		nota.Cobranca = &CobrancaNotaFiscal{}
+		if inf.Cobr.Fat != nil {
+			nota.Cobranca.NumeroFatura = strings.TrimSpace(inf.Cobr.Fat.NFat)
+			nota.Cobranca.ValorOriginal = inf.Cobr.Fat.VOrig
+			nota.Cobranca.Desconto = inf.Cobr.Fat.VDesc
+			nota.Cobranca.ValorLiquido = inf.Cobr.Fat.VLiq
+		}
+		for _, d := range inf.Cobr.Dup {
+			dup := DuplicataNotaFiscal{Numero: strings.TrimSpace(d.NDup), Valor: d.VDup}
+			if t, err := time.Parse("2006-01-02", strings.TrimSpace(d.DVenc)); err == nil {
+				dup.Vencimento = t
+			}
+			nota.Cobranca.Duplicatas = append(nota.Cobranca.Duplicatas, dup)
 		}
 	}
 
@@ -780,6 +878,12 @@ func toNotaFiscalDistribuida(doc DocZip, proc NFeProc) (NotaFiscalDistribuida, e
 				Valor:     p.VPag,
 			})
 		}
+		nota.Troco = inf.Pag.VTroco
+	}
+
+	if supl := proc.NFe.InfNFeSupl; supl != nil {
+		nota.QRCode = strings.TrimSpace(supl.QrCode)
+		nota.URLConsulta = strings.TrimSpace(supl.UrlChave)
 	}
 
 	for _, d := range inf.Det {
@@ -799,11 +903,15 @@ func toNotaFiscalDistribuida(doc DocZip, proc NFeProc) (NotaFiscalDistribuida, e
 			Observacao:         strings.TrimSpace(d.InfAdProd),
 		}
 
-		if d.Imposto.ICMS.ICMS00 != nil {
-			ic := d.Imposto.ICMS.ICMS00
+		ic := d.Imposto.ICMS.ICMS00
+		if ic == nil {
+			ic = d.Imposto.ICMS.Outro
+		}
+		if ic != nil {
 			item.ICMS = &ICMSItem{
 				Origem:      ic.Orig,
 				CST:         ic.CST,
+				CSOSN:       ic.CSOSN,
 				BaseCalculo: ic.VBC,
 				Aliquota:    ic.PICMS,
 				Valor:       ic.VICMS,
@@ -895,3 +1003,28 @@ func toTransporte(t Transp) *TransporteNotaFiscal {
 
 	return tr
 }
+
+// ============================================================
+// 8) Modelo semântico a partir do XML
+// ============================================================
+
+// NotaFiscal converte o nfeProc no modelo semântico.
+func (proc NFeProc) NotaFiscal() (NotaFiscalDistribuida, error) {
+	return toNotaFiscalDistribuida(DocZip{}, proc)
+}
+
+// LeNotaFiscal monta o modelo semântico a partir do XML de uma NFe, com (nfeProc) ou sem (NFe) o protocolo de autorização.
+func LeNotaFiscal(xmlNFe []byte) (NotaFiscalDistribuida, error) {
+	var proc NFeProc
+	if err := xml.Unmarshal(xmlNFe, &proc); err != nil {
+		var nfe struct {
+			XMLName xml.Name `xml:"http://www.portalfiscal.inf.br/nfe NFe"`
+			NFe
+		}
+		if errNFe := xml.Unmarshal(xmlNFe, &nfe); errNFe != nil {
+			return NotaFiscalDistribuida{}, fmt.Errorf("Erro na desserialização do arquivo XML: %w", err)
+		}
+		proc = NFeProc{NFe: nfe.NFe}
+	}
+	return proc.NotaFiscal()
+}
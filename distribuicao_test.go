@@ -0,0 +1,67 @@
+package nfe
+
+import (
+	"regexp"
+	"testing"
+)
+
+func TestRetDistDFeInt(t *testing.T) {
+	t.Run("138", func(t *testing.T) {
+		client := respondeFixture(t, DistribuicaoDFe, "AN/DistribuicaoDFe.xml")
+		res, err := ConsultaDistChNFe("99888777000166", "35240511222333000181550010000001231123456785", Producao, client)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res.Status != 138 || res.UltimoNSU != "000000000000201" || res.DataResposta.IsZero() {
+			t.Errorf("retorno inesperado: %+v", res)
+		}
+		// o resNFe (NSU 200) é ignorado; apenas o procNFe é convertido
+		if len(res.Documentos) != 1 {
+			t.Fatalf("%d documentos, esperado 1", len(res.Documentos))
+		}
+		nota := res.Documentos[0]
+		if nota.NSU != "000000000000201" || nota.Chave != "35240511222333000181550010000001231123456785" || nota.Numero != 123 {
+			t.Errorf("nota inesperada: NSU %s, chave %s, nNF %d", nota.NSU, nota.Chave, nota.Numero)
+		}
+		if nota.Emitente.Nome != "EMITENTE DE TESTE LTDA" || nota.Destinatario.CNPJ != "99888777000166" {
+			t.Errorf("partes inesperadas: %+v / %+v", nota.Emitente, nota.Destinatario)
+		}
+		if len(nota.Itens) != 1 || nota.Itens[0].Descricao != "PRODUTO DE TESTE" || nota.Totais.ValorNota != 100 {
+			t.Errorf("itens/totais inesperados: %+v / %+v", nota.Itens, nota.Totais)
+		}
+		if nota.Protocolo.Numero != "135240000000001" || nota.Protocolo.Status != 100 {
+			t.Errorf("protocolo inesperado: %+v", nota.Protocolo)
+		}
+	})
+
+	t.Run("137", func(t *testing.T) {
+		client := respondeFixture(t, DistribuicaoDFe, "AN/DistribuicaoDFe-137.xml")
+		res, err := ConsultaDistChNFe("99888777000166", "35240511222333000181550010000001231123456785", Producao, client)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res.Status != 137 || len(res.Documentos) != 0 {
+			t.Errorf("retorno inesperado: %+v", res)
+		}
+	})
+}
+
+func FuzzLerRetDistDFeInt(f *testing.F) {
+	for _, b := range fixturesSefaz(f, "DistribuicaoDFe") {
+		f.Add(b)
+	}
+	f.Fuzz(func(t *testing.T, data []byte) {
+		lerRetDistDFeInt(data)
+	})
+}
+
+func FuzzDecodeDocZip(f *testing.F) {
+	for _, m := range regexp.MustCompile(`<docZip[^>]*>([^<]*)</docZip>`).FindAllSubmatch(fixtureSefaz(f, "AN/DistribuicaoDFe.xml"), -1) {
+		f.Add(string(m[1]))
+	}
+	f.Fuzz(func(t *testing.T, b64 string) {
+		if xmlDoc, err := decodeDocZip(b64); err == nil {
+			LeNotaFiscal(xmlDoc)
+		}
+	})
+}
@@ -0,0 +1,113 @@
+package nfe
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// cStat da rejeição por duplicidade no envio da NFe: a mesma chave de acesso já foi autorizada (204), ou já existe NFe com o mesmo número e série e outra chave (539).
+const (
+	cStatDuplicidade             = 204
+	cStatDuplicidadeChaveDiversa = 539
+)
+
+// DuplicidadeError indica que a NFe já autorizada encontrada na recuperação da duplicidade (ver RecuperaDuplicidade) não é a NFe enviada: a mesma chave de acesso foi autorizada com outro conteúdo (204), ou outra NFe foi autorizada com o mesmo número e série (539). Satisfaz errors.Is(err, ErrDigValDivergente).
+type DuplicidadeError struct {
+	CStat         int      // 204 ou 539
+	ChNFe         string   // chave de acesso da NFe enviada
+	ChNFeOriginal string   // chave de acesso da NFe já autorizada (igual a ChNFe no 204)
+	DigestValue   string   // DigestValue da NFe enviada, conferido com o conteúdo do XML (no 539, vazio se a NFe não estiver assinada)
+	ProtNFe       *ProtNFe // protocolo da NFe já autorizada, obtido na consulta
+}
+
+func (e *DuplicidadeError) Error() string {
+	return fmt.Sprintf("Duplicidade de NF-e (%d): a NFe %s já foi autorizada (protocolo %s) com conteúdo diferente da NFe %s enviada (digVal %q, DigestValue %q)",
+		e.CStat, e.ChNFeOriginal, e.ProtNFe.InfProt.NProt, e.ChNFe, e.ProtNFe.InfProt.DigVal, e.DigestValue)
+}
+
+func (e *DuplicidadeError) Unwrap() error {
+	return ErrDigValDivergente
+}
+
+// RecuperaDuplicidade trata a rejeição por duplicidade no envio da NFe (prot, o protNFe do retorno da autorização, com cStat 204 ou 539): consulta a NFe já autorizada (ConsSitNFe.Consulta), pela chave da NFe enviada no 204 ou pela chave informada no xMotivo no 539, e compara o digVal do seu protocolo ao DigestValue da NFe enviada, recalculado a partir do XML (ver VerifySignature).
+//
+// Se coincidirem, a NFe autorizada é a enviada (por exemplo, em um envio anterior cuja resposta se perdeu): são retornados o resultado e o XML da consulta, com o protocolo original, que pode ser juntado à NFe por AttachProtocol. Caso contrário, o erro é um *DuplicidadeError, com o protocolo encontrado. No 204, um protocolo sem digVal não permite a comparação e é recusado com ErrDigValAusente, como na AttachProtocol.
+func RecuperaDuplicidade(xmlNFe []byte, prot ProtNFe, tpAmb TAmb, client *http.Client, optReq ...func(req *http.Request)) (RetConsSitNFe, []byte, error) {
+	return recuperaDuplicidade(xmlNFe, prot, tpAmb, ConsultaNFe, client, optReq...)
+}
+
+// recuperaDuplicidade implementa a RecuperaDuplicidade com a função de consulta informada (ver TransmissorOffline.Consulta e Emissor.Consulta).
+func recuperaDuplicidade(xmlNFe []byte, prot ProtNFe, tpAmb TAmb, consulta func(chNFe string, tpAmb TAmb, client *http.Client, optReq ...func(req *http.Request)) (RetConsSitNFe, []byte, error), client *http.Client, optReq ...func(req *http.Request)) (RetConsSitNFe, []byte, error) {
+	nfe, err := lerNFeXML(xmlNFe)
+	if err != nil {
+		return RetConsSitNFe{}, nil, err
+	}
+	infNFe := nfe.SelectElement("infNFe")
+	if infNFe == nil {
+		return RetConsSitNFe{}, nil, fmt.Errorf("NFe sem o grupo infNFe")
+	}
+	chNFe := strings.TrimPrefix(infNFe.SelectAttrValue("Id", ""), "NFe")
+
+	original := chNFe
+	switch prot.InfProt.CStat {
+	case cStatDuplicidade:
+	case cStatDuplicidadeChaveDiversa:
+		m := reChNFeDuplicada.FindStringSubmatch(prot.InfProt.XMotivo)
+		if m == nil {
+			return RetConsSitNFe{}, nil, fmt.Errorf("A rejeição 539 da NFe %s não informa a chave da NFe já autorizada: %s", chNFe, prot.InfProt.XMotivo)
+		}
+		original = m[1]
+	default:
+		return RetConsSitNFe{}, nil, fmt.Errorf("O protocolo da NFe %s não é uma rejeição por duplicidade: %d - %s", chNFe, prot.InfProt.CStat, prot.InfProt.XMotivo)
+	}
+
+	ret, xmlfile, err := consulta(original, tpAmb, client, optReq...)
+	if err != nil {
+		return ret, xmlfile, err
+	}
+	if ret.ProtNFe == nil || !cStatProtNFe[ret.ProtNFe.InfProt.CStat] || (ret.ProtNFe.InfProt.ChNFe != "" && ret.ProtNFe.InfProt.ChNFe != original) {
+		return ret, xmlfile, fmt.Errorf("Duplicidade de NF-e não confirmada pela consulta da NFe %s: %d - %s", original, ret.CStat, ret.XMotivo)
+	}
+
+	digVal := ret.ProtNFe.InfProt.DigVal
+	if original == chNFe && digVal == "" {
+		return ret, xmlfile, fmt.Errorf("Duplicidade de NF-e não confirmada: o protocolo da NFe %s obtido na consulta não informa o digVal: %w", original, ErrDigValAusente)
+	}
+	// No 539 a NFe autorizada é outra, qualquer que seja o DigestValue, que é informado apenas quando puder ser calculado.
+	digest, err := digestNFe(xmlNFe)
+	if original == chNFe {
+		if err != nil {
+			return ret, xmlfile, err
+		}
+		if digVal == digest {
+			return ret, xmlfile, nil
+		}
+	}
+	return ret, xmlfile, &DuplicidadeError{
+		CStat:         prot.InfProt.CStat,
+		ChNFe:         chNFe,
+		ChNFeOriginal: original,
+		DigestValue:   digest,
+		ProtNFe:       ret.ProtNFe,
+	}
+}
+
+// digestNFe retorna o DigestValue da assinatura do infNFe, depois de conferir que ele corresponde ao conteúdo atual do XML: o DigestValue de um XML alterado após a assinatura não representa a NFe.
+func digestNFe(xmlNFe []byte) (string, error) {
+	assinaturas, _ := VerifySignature(xmlNFe)
+	for _, a := range assinaturas {
+		if a.Elemento != "infNFe" {
+			continue
+		}
+		if !a.DigestValido {
+			return "", fmt.Errorf("%w: %s %s: %s", ErrAssinaturaInvalida, a.Elemento, a.Id, a.Motivo)
+		}
+		nfe, err := lerNFeXML(xmlNFe)
+		if err != nil {
+			return "", err
+		}
+		return removeEspacos(textoElemento(nfe, "Signature/SignedInfo/Reference/DigestValue")), nil
+	}
+	return "", fmt.Errorf("O infNFe não está assinado: não é possível calcular o DigestValue da NFe")
+}
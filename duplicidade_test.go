@@ -0,0 +1,86 @@
+package nfe_test
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/eduardotorresdev/nfe"
+	"github.com/eduardotorresdev/nfe/nfetest"
+)
+
+func TestRecuperaDuplicidade(t *testing.T) {
+	sefaz := nfetest.NewSefaz()
+	defer sefaz.Close()
+	client := sefaz.Client()
+	cert, key := certificadoEmitente(t)
+	nfeAssinada := func(nNF int, vNF string) (string, []byte, string) {
+		chave, xmlNFe := nfeEmissao(t, nNF, 12345678, vNF)
+		assinado, err := nfe.AssinaNFe(xmlNFe, cert, key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return chave, assinado, string(reDigestValue.FindSubmatch(assinado)[1])
+	}
+	rejeicao := func(cStat int, xMotivo string) nfe.ProtNFe {
+		var prot nfe.ProtNFe
+		prot.InfProt.CStat = cStat
+		prot.InfProt.XMotivo = xMotivo
+		return prot
+	}
+	duplicidade := rejeicao(204, "Rejeição: Duplicidade de NF-e")
+
+	// 204 com o mesmo conteúdo: o protocolo original é retornado
+	_, autorizada, digest := nfeAssinada(1, "100.00")
+	sefaz.AdicionaNFe(autorizada)
+	ret, xmlRet, err := nfe.RecuperaDuplicidade(autorizada, duplicidade, nfe.Homologacao, client)
+	if err != nil || ret.ProtNFe == nil || ret.ProtNFe.InfProt.DigVal != digest {
+		t.Fatalf("204 com o mesmo conteúdo: %+v, erro %v", ret.ProtNFe, err)
+	}
+	if _, err := nfe.AttachProtocol(autorizada, xmlRet); err != nil {
+		t.Errorf("o protocolo original não pôde ser juntado à NFe: %v", err)
+	}
+
+	// 204 com um protocolo sem digVal: a NFe autorizada não pode ser comparada à enviada
+	sefaz.Responde(nfe.ConsultaProtocolo, nfetest.Resposta{XML: []byte(`<retConsSitNFe xmlns="http://www.portalfiscal.inf.br/nfe" versao="4.00"><tpAmb>2</tpAmb><verAplic>TESTE</verAplic><cStat>100</cStat><xMotivo>Autorizado o uso da NF-e</xMotivo><cUF>35</cUF><dhRecbto>2024-05-17T10:31:02-03:00</dhRecbto><chNFe>` + ret.ProtNFe.InfProt.ChNFe + `</chNFe>` +
+		`<protNFe versao="4.00"><infProt><tpAmb>2</tpAmb><verAplic>TESTE</verAplic><chNFe>` + ret.ProtNFe.InfProt.ChNFe + `</chNFe><dhRecbto>2024-05-17T10:31:02-03:00</dhRecbto><nProt>135240000000001</nProt><cStat>100</cStat><xMotivo>Autorizado o uso da NF-e</xMotivo></infProt></protNFe></retConsSitNFe>`)})
+	if _, _, err := nfe.RecuperaDuplicidade(autorizada, duplicidade, nfe.Homologacao, client); !errors.Is(err, nfe.ErrDigValAusente) || errors.As(err, new(*nfe.DuplicidadeError)) {
+		t.Errorf("204 com um protocolo sem digVal: erro %v; esperado ErrDigValAusente", err)
+	}
+
+	// 204 com outro conteúdo: conflito
+	_, anterior, _ := nfeAssinada(2, "50.00")
+	sefaz.AdicionaNFe(anterior)
+	chave, assinado, digest := nfeAssinada(2, "100.00")
+	_, _, err = nfe.RecuperaDuplicidade(assinado, duplicidade, nfe.Homologacao, client)
+	var dup *nfe.DuplicidadeError
+	if !errors.As(err, &dup) || !errors.Is(err, nfe.ErrDigValDivergente) || dup.ChNFeOriginal != chave || dup.DigestValue != digest || dup.ProtNFe.InfProt.NProt == "" {
+		t.Errorf("204 com outro conteúdo: erro %v", err)
+	}
+
+	// 539: a NFe autorizada é a da chave informada no xMotivo
+	original := chave
+	chave, assinado, _ = nfeAssinada(3, "100.00")
+	_, _, err = nfe.RecuperaDuplicidade(assinado, rejeicao(539, "Rejeição: Duplicidade de NF-e com diferença na Chave de Acesso [chNFe:"+original+"]"), nfe.Homologacao, client)
+	if !errors.As(err, &dup) || dup.CStat != 539 || dup.ChNFe != chave || dup.ChNFeOriginal != original {
+		t.Errorf("539: erro %v", err)
+	}
+
+	tests := []struct {
+		nome       string
+		xml        []byte
+		prot       nfe.ProtNFe
+		assinatura bool
+	}{
+		{"NFe não autorizada", assinado, duplicidade, false},
+		{"539 sem a chave", assinado, rejeicao(539, "Rejeição: Duplicidade de NF-e com diferença na Chave de Acesso"), false},
+		{"outra rejeição", assinado, rejeicao(778, "Rejeição: NCM inexistente"), false},
+		{"NFe alterada após a assinatura", []byte(strings.Replace(string(autorizada), "100.00", "1.00", 1)), duplicidade, true},
+	}
+	for _, tt := range tests {
+		_, _, err := nfe.RecuperaDuplicidade(tt.xml, tt.prot, nfe.Homologacao, client)
+		if err == nil || errors.As(err, &dup) || errors.Is(err, nfe.ErrAssinaturaInvalida) != tt.assinatura {
+			t.Errorf("%s: erro %v", tt.nome, err)
+		}
+	}
+}
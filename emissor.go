@@ -0,0 +1,586 @@
+package nfe
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Situação de uma NFe no Emissor. As três primeiras são intermediárias (a emissão é retomada a partir delas); as demais são finais.
+const (
+	EmissaoAssinada    = "assinada"    // assinada, validada e gravada; ainda não enviada (ou enviada e não recebida pela Sefaz)
+	EmissaoEnviada     = "enviada"     // envio iniciado sem resposta conclusiva: a NFe pode ter sido recebida, e é consultada antes de um novo envio
+	EmissaoProcessando = "processando" // lote recebido para processamento (cStat 103); aguardando o resultado do recibo
+	EmissaoAutorizada  = "autorizada"  // autorizada (inclusive quando já havia sido autorizada antes, cStat 204), com o nfeProc
+	EmissaoDenegada    = "denegada"    // uso denegado, com o nfeProc
+	EmissaoRejeitada   = "rejeitada"   // rejeitada pela Sefaz; pode ser corrigida e emitida novamente com a mesma chave
+	EmissaoDuplicada   = "duplicada"   // já existe NFe autorizada com a mesma chave e outro conteúdo (204), ou com o mesmo número e série (539); ver DuplicidadeError
+)
+
+// ErrEmissaoNaoEncontrada indica que o ArmazenamentoEmissao não tem registro da NFe.
+var ErrEmissaoNaoEncontrada = errors.New("emissão da NFe não encontrada")
+
+// ErrEmissaoEmAndamento indica que a mesma NFe já está sendo emitida por outra chamada do Emissor.
+var ErrEmissaoEmAndamento = errors.New("emissão da NFe já em andamento")
+
+// ErrLoteEmProcessamento indica que o lote continuava em processamento (cStat 105) após todas as consultas do recibo. A emissão permanece na situação EmissaoProcessando e pode ser retomada depois.
+var ErrLoteEmProcessamento = errors.New("lote ainda em processamento na Sefaz")
+
+// cStat do retorno do lote que não se referem à NFe, mas à disponibilidade do autorizador: serviço paralisado (108 e 109) e consumo indevido (656). A NFe pode ser enviada novamente depois.
+var cStatLoteTemporario = map[int]bool{108: true, 109: true, 656: true}
+
+// cStatNFeNaoConsta é o cStat da consulta do protocolo de uma chave de acesso que a Sefaz não recebeu.
+const cStatNFeNaoConsta = 217
+
+// EmissaoNFe é o registro persistido da emissão de uma NFe pelo Emissor.
+type EmissaoNFe struct {
+	ChNFe string `json:"chNFe"`
+	TpAmb TAmb   `json:"tpAmb"`
+	XML   []byte `json:"xml"` // NFe assinada, exatamente como enviada
+
+	Situacao      string    `json:"situacao"`
+	NRec          string    `json:"nRec,omitempty"` // recibo do lote, no processamento assíncrono
+	Tentativas    int       `json:"tentativas"`     // número de envios
+	CStat         int       `json:"cStat,omitempty"`
+	XMotivo       string    `json:"xMotivo,omitempty"`
+	Erro          string    `json:"erro,omitempty"`
+	ProtNFe       *ProtNFe  `json:"protNFe,omitempty"`
+	NFeProc       []byte    `json:"nfeProc,omitempty"`       // NFe com o protocolo (ver AttachProtocol), nas situações autorizada e denegada
+	ChNFeOriginal string    `json:"chNFeOriginal,omitempty"` // chave da NFe já autorizada, na situação duplicada
+	Criada        time.Time `json:"criada"`
+	Atualizada    time.Time `json:"atualizada"`
+}
+
+// Finalizada indica se a emissão chegou a uma situação final (autorizada, denegada, rejeitada ou duplicada).
+func (e EmissaoNFe) Finalizada() bool {
+	return e.Situacao == EmissaoAutorizada || e.Situacao == EmissaoDenegada || e.Situacao == EmissaoRejeitada || e.Situacao == EmissaoDuplicada
+}
+
+// String descreve a situação da emissão, para registro em log.
+func (e EmissaoNFe) String() string {
+	s := []string{e.ChNFe, e.Situacao}
+	if e.CStat != 0 {
+		s = append(s, fmt.Sprintf("%d - %s", e.CStat, e.XMotivo))
+	}
+	if e.Erro != "" {
+		s = append(s, e.Erro)
+	}
+	return strings.Join(s, " ")
+}
+
+// ArmazenamentoEmissao é o armazenamento durável das emissões do Emissor. As implementações devem garantir que um registro salvo não se perca, mesmo com a interrupção do programa: é ele que permite retomar uma emissão sem enviar a NFe duas vezes.
+type ArmazenamentoEmissao interface {
+	// Salva inclui ou atualiza o registro da emissão.
+	Salva(e EmissaoNFe) error
+	// Carrega retorna o registro da emissão da NFe, ou ErrEmissaoNaoEncontrada.
+	Carrega(chNFe string) (EmissaoNFe, error)
+	// Lista retorna todos os registros, em ordem de criação.
+	Lista() ([]EmissaoNFe, error)
+}
+
+// ArmazenamentoEmissaoArquivo é um ArmazenamentoEmissao que grava cada emissão em um arquivo JSON em um diretório, da mesma maneira que a FilaOfflineArquivo.
+type ArmazenamentoEmissaoArquivo struct {
+	dir string
+	mu  sync.Mutex
+}
+
+// NewArmazenamentoEmissaoArquivo cria (se necessário) o diretório e retorna o ArmazenamentoEmissaoArquivo.
+func NewArmazenamentoEmissaoArquivo(dir string) (*ArmazenamentoEmissaoArquivo, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, fmt.Errorf("Erro na criação do diretório das emissões (%s). Detalhes: %w", dir, err)
+	}
+	return &ArmazenamentoEmissaoArquivo{dir: dir}, nil
+}
+
+func (a *ArmazenamentoEmissaoArquivo) Salva(e EmissaoNFe) error {
+	nome, err := arquivoChave(e.ChNFe)
+	if err != nil {
+		return err
+	}
+	b, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if err := gravaArquivo(a.dir, nome, b); err != nil {
+		return fmt.Errorf("Erro na gravação da emissão da NFe %s. Detalhes: %w", e.ChNFe, err)
+	}
+	return nil
+}
+
+func (a *ArmazenamentoEmissaoArquivo) Carrega(chNFe string) (EmissaoNFe, error) {
+	nome, err := arquivoChave(chNFe)
+	if err != nil {
+		return EmissaoNFe{}, err
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	return a.carrega(filepath.Join(a.dir, nome))
+}
+
+func (a *ArmazenamentoEmissaoArquivo) carrega(arq string) (EmissaoNFe, error) {
+	b, err := os.ReadFile(arq)
+	if errors.Is(err, os.ErrNotExist) {
+		return EmissaoNFe{}, ErrEmissaoNaoEncontrada
+	}
+	if err != nil {
+		return EmissaoNFe{}, fmt.Errorf("Erro na leitura da emissão (%s). Detalhes: %w", arq, err)
+	}
+	var e EmissaoNFe
+	if err := json.Unmarshal(b, &e); err != nil {
+		return EmissaoNFe{}, fmt.Errorf("Erro na leitura da emissão (%s). Detalhes: %w", arq, err)
+	}
+	return e, nil
+}
+
+func (a *ArmazenamentoEmissaoArquivo) Lista() ([]EmissaoNFe, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	arquivos, err := filepath.Glob(filepath.Join(a.dir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+	emissoes := make([]EmissaoNFe, 0, len(arquivos))
+	for _, arq := range arquivos {
+		e, err := a.carrega(arq)
+		if err != nil {
+			return nil, err
+		}
+		emissoes = append(emissoes, e)
+	}
+
+	sort.SliceStable(emissoes, func(i, j int) bool { return emissoes[i].Criada.Before(emissoes[j].Criada) })
+	return emissoes, nil
+}
+
+// Emissor conduz a emissão de NFe pelas etapas de assinatura, validação, gravação, envio, consulta do recibo (cStat 103 e 105) e consulta da chave de acesso (duplicidade, cStat 204 e 539, ver RecuperaDuplicidade, ou resposta perdida), até a gravação do nfeProc. Cada mudança de situação é gravada no Armazenamento antes da etapa seguinte, de maneira que, após uma interrupção do programa, Retoma continua cada emissão do ponto em que parou, sem enviar novamente uma NFe que a Sefaz possa ter recebido.
+//
+// A montagem do XML da NFe é feita pelo chamador; a assinatura, pela função Assina (ver AssinaNFe):
+//
+//	emissor := nfe.NewEmissor(armazenamento, client)
+//	emissor.Assina = func(xmlNFe []byte) ([]byte, error) {
+//		cert, key := fonte.PEM()
+//		return nfe.AssinaNFe(xmlNFe, cert, key)
+//	}
+//	emissao, err := emissor.Emite(ctx, xmlNFe)
+//
+// Pode ser usado concorrentemente, inclusive para NFe de UFs e ambientes diferentes.
+type Emissor struct {
+	Armazenamento ArmazenamentoEmissao
+	Client        *http.Client
+	OptReq        []func(req *http.Request)
+
+	// Assina assina o XML da NFe. Só é chamada para as NFe recebidas sem assinatura; sem ela, Emite aceita apenas NFe já assinadas.
+	Assina func(xmlNFe []byte) ([]byte, error)
+
+	// Valida, se informada, é chamada com a NFe assinada, antes da gravação, depois das verificações do Emissor (chave de acesso e assinatura do infNFe). Permite, por exemplo, a validação do XML contra o schema.
+	Valida func(xmlNFe []byte) error
+
+	// IntervaloRecibo é a espera antes de cada consulta do recibo. Zero equivale a 1 segundo.
+	IntervaloRecibo time.Duration
+	// ConsultasRecibo é o número máximo de consultas do recibo em cada chamada, antes de ErrLoteEmProcessamento. Zero equivale a 10.
+	ConsultasRecibo int
+
+	// Autoriza, ConsultaRecibo e Consulta permitem substituir a autorização (AutorizaNFe), a consulta do recibo (ConsultaReciboNFe) e a consulta do protocolo (ConsultaNFe), por exemplo para aplicar um RateLimiter. O XML retornado deve conter o protNFe, que é incluído sem alteração no nfeProc.
+	Autoriza       func(xmlNFe []byte, client *http.Client, optReq ...func(req *http.Request)) (RetEnviNFe, []byte, error)
+	ConsultaRecibo func(nRec string, chNFe string, tpAmb TAmb, client *http.Client, optReq ...func(req *http.Request)) (RetConsReciNFe, []byte, error)
+	Consulta       func(chNFe string, tpAmb TAmb, client *http.Client, optReq ...func(req *http.Request)) (RetConsSitNFe, []byte, error)
+
+	// Contingencia recebe o resultado de cada envio ao autorizador normal da UF: as falhas de comunicação (ver Contingencia.RegistraFalha) e o serviço paralisado (cStat 108/109) ativam a contingência SVC, a ser usada pelo chamador na montagem das próximas NFe (ver Contingencia.Carimba). Se for nil, é usada a DefaultContingencia. O envio de cada NFe segue o tpEmis da sua chave de acesso (ver Contingencia.EndpointChave).
+	Contingencia *Contingencia
+
+	mu          sync.Mutex
+	emAndamento map[string]bool
+	now         func() time.Time
+}
+
+// NewEmissor cria um Emissor que grava as emissões no armazenamento informado.
+func NewEmissor(armazenamento ArmazenamentoEmissao, client *http.Client) *Emissor {
+	return &Emissor{Armazenamento: armazenamento, Client: client}
+}
+
+func (e *Emissor) agora() time.Time {
+	if e.now != nil {
+		return e.now()
+	}
+	return time.Now()
+}
+
+// Emite emite a NFe informada (assinada ou, se a função Assina foi informada, sem assinatura) e retorna a emissão na situação em que parou. O erro é retornado quando a emissão não pôde chegar a uma situação final (falha de comunicação, autorizador indisponível, lote ainda em processamento); nesse caso ela pode ser continuada com Retoma ou com uma nova chamada de Emite. Na situação final EmissaoDuplicada, o erro é o *DuplicidadeError.
+//
+// Emite é idempotente pela chave de acesso: uma NFe já autorizada ou denegada é retornada sem nova comunicação com a Sefaz, e uma emissão interrompida é continuada com o XML gravado, e não com o informado. Apenas uma NFe rejeitada é substituída pelo novo XML (por exemplo, corrigido) e enviada novamente.
+func (e *Emissor) Emite(ctx context.Context, xmlNFe []byte) (EmissaoNFe, error) {
+	nfe, err := lerNFeXML(xmlNFe)
+	if err != nil {
+		return EmissaoNFe{}, err
+	}
+	chNFe, tpAmb, err := lerChaveNFe(nfe)
+	if err != nil {
+		return EmissaoNFe{}, err
+	}
+	if err := e.inicia(chNFe); err != nil {
+		return EmissaoNFe{}, err
+	}
+	defer e.termina(chNFe)
+
+	em, err := e.Armazenamento.Carrega(chNFe)
+	if err == nil && em.Situacao != EmissaoRejeitada {
+		return e.avanca(ctx, em)
+	}
+	if err != nil && !errors.Is(err, ErrEmissaoNaoEncontrada) {
+		return EmissaoNFe{}, err
+	}
+
+	assinado := xmlNFe
+	if nfe.SelectElement("Signature") == nil {
+		if e.Assina == nil {
+			return EmissaoNFe{}, fmt.Errorf("A NFe %s não está assinada e o Emissor não tem a função Assina", chNFe)
+		}
+		if assinado, err = e.Assina(xmlNFe); err != nil {
+			return EmissaoNFe{}, err
+		}
+	}
+	if err := e.valida(chNFe, assinado); err != nil {
+		return EmissaoNFe{}, err
+	}
+
+	agora := e.agora()
+	criada := agora
+	if !em.Criada.IsZero() {
+		criada = em.Criada
+	}
+	em = EmissaoNFe{
+		ChNFe:      chNFe,
+		TpAmb:      tpAmb,
+		XML:        assinado,
+		Situacao:   EmissaoAssinada,
+		Tentativas: em.Tentativas,
+		Criada:     criada,
+	}
+	if err := e.salva(&em); err != nil {
+		return em, err
+	}
+	return e.avanca(ctx, em)
+}
+
+// Retoma continua todas as emissões do Armazenamento que não chegaram a uma situação final, por exemplo após a interrupção do programa. Se o autorizador de uma delas estiver indisponível, as demais continuam; os erros são retornados juntos (ver errors.Join).
+func (e *Emissor) Retoma(ctx context.Context) ([]EmissaoNFe, error) {
+	emissoes, err := e.Armazenamento.Lista()
+	if err != nil {
+		return nil, err
+	}
+
+	var retomadas []EmissaoNFe
+	var erros []error
+	for _, em := range emissoes {
+		if em.Finalizada() {
+			continue
+		}
+		if err := ctx.Err(); err != nil {
+			return retomadas, errors.Join(append(erros, err)...)
+		}
+		if err := e.inicia(em.ChNFe); err != nil {
+			continue
+		}
+		em, err := e.avanca(ctx, em)
+		e.termina(em.ChNFe)
+		if err != nil {
+			erros = append(erros, fmt.Errorf("NFe %s: %w", em.ChNFe, err))
+		}
+		retomadas = append(retomadas, em)
+	}
+	return retomadas, errors.Join(erros...)
+}
+
+// Situacao retorna o registro da emissão da NFe, ou ErrEmissaoNaoEncontrada.
+func (e *Emissor) Situacao(chNFe string) (EmissaoNFe, error) {
+	return e.Armazenamento.Carrega(chNFe)
+}
+
+// inicia registra a emissão da NFe como em andamento neste Emissor, ou retorna ErrEmissaoEmAndamento.
+func (e *Emissor) inicia(chNFe string) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.emAndamento[chNFe] {
+		return fmt.Errorf("%w: %s", ErrEmissaoEmAndamento, chNFe)
+	}
+	if e.emAndamento == nil {
+		e.emAndamento = map[string]bool{}
+	}
+	e.emAndamento[chNFe] = true
+	return nil
+}
+
+func (e *Emissor) termina(chNFe string) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	delete(e.emAndamento, chNFe)
+}
+
+// valida confere a chave de acesso e a assinatura do infNFe da NFe assinada e chama a função Valida.
+func (e *Emissor) valida(chNFe string, assinado []byte) error {
+	nfe, err := lerNFeXML(assinado)
+	if err != nil {
+		return err
+	}
+	if id := nfe.SelectElement("infNFe").SelectAttrValue("Id", ""); id != "NFe"+chNFe {
+		return fmt.Errorf("A NFe assinada não corresponde à chave de acesso %s: %s", chNFe, id)
+	}
+	if !ValidaChaveDeAcesso(chNFe) {
+		return fmt.Errorf("Chave de Acesso inválida: %s", chNFe)
+	}
+
+	assinaturas, err := VerifySignature(assinado)
+	if err != nil {
+		return fmt.Errorf("Erro na validação da assinatura da NFe %s. Detalhes: %w", chNFe, err)
+	}
+	assinada := false
+	for _, a := range assinaturas {
+		assinada = assinada || (a.Elemento == "infNFe" && a.Id == "NFe"+chNFe)
+	}
+	if !assinada {
+		return fmt.Errorf("O infNFe da NFe %s não está assinado", chNFe)
+	}
+
+	if e.Valida != nil {
+		return e.Valida(assinado)
+	}
+	return nil
+}
+
+func (e *Emissor) salva(em *EmissaoNFe) error {
+	em.Atualizada = e.agora()
+	return e.Armazenamento.Salva(*em)
+}
+
+// optReq inclui o contexto nas requisições.
+func (e *Emissor) optReq(ctx context.Context) []func(req *http.Request) {
+	return append([]func(req *http.Request){func(req *http.Request) {
+		*req = *req.WithContext(ctx)
+	}}, e.OptReq...)
+}
+
+// avanca executa as etapas da emissão a partir da situação gravada, até uma situação final ou um erro.
+func (e *Emissor) avanca(ctx context.Context, em EmissaoNFe) (EmissaoNFe, error) {
+	for !em.Finalizada() {
+		if err := ctx.Err(); err != nil {
+			return em, err
+		}
+
+		var err error
+		switch em.Situacao {
+		case EmissaoAssinada:
+			em, err = e.envia(ctx, em)
+		case EmissaoEnviada:
+			em, err = e.consultaChave(ctx, em)
+		case EmissaoProcessando:
+			em, err = e.consultaRecibo(ctx, em)
+		default:
+			return em, fmt.Errorf("Situação desconhecida na emissão da NFe %s: %q", em.ChNFe, em.Situacao)
+		}
+		if err != nil {
+			return em, err
+		}
+	}
+	return em, nil
+}
+
+// envia transmite a NFe. A situação EmissaoEnviada é gravada antes do envio: se a resposta se perder, a NFe é consultada antes de um novo envio.
+func (e *Emissor) envia(ctx context.Context, em EmissaoNFe) (EmissaoNFe, error) {
+	autoriza := e.Autoriza
+	if autoriza == nil {
+		autoriza = AutorizaNFe
+	}
+
+	em.Situacao = EmissaoEnviada
+	em.Tentativas++
+	em.Erro = ""
+	if err := e.salva(&em); err != nil {
+		return em, err
+	}
+
+	ret, xmlRet, err := autoriza(em.XML, e.Client, e.optReq(ctx)...)
+	e.registraEnvio(em, ret, err)
+	if err != nil {
+		return e.falha(em, err)
+	}
+
+	em.CStat, em.XMotivo = ret.CStat, ret.XMotivo
+	switch {
+	case ret.ProtNFe != nil:
+		return e.protocolo(ctx, em, *ret.ProtNFe, xmlRet)
+	case ret.CStat == 103 && ret.InfRec != nil:
+		em.Situacao = EmissaoProcessando
+		em.NRec = ret.InfRec.NRec
+	case cStatLoteTemporario[ret.CStat]:
+		// O lote não foi recebido: a NFe pode ser enviada novamente.
+		em.Situacao = EmissaoAssinada
+		return e.falha(em, fmt.Errorf("Lote da NFe %s não recebido pela Sefaz: %d - %s", em.ChNFe, ret.CStat, ret.XMotivo))
+	default:
+		em.Situacao = EmissaoRejeitada
+	}
+	return em, e.salva(&em)
+}
+
+// registraEnvio informa à Contingencia o resultado do envio de uma NFe ao autorizador normal da UF. Os envios ao SVC (tpEmis 6/7) não dizem respeito ao autorizador da UF e são ignorados.
+func (e *Emissor) registraEnvio(em EmissaoNFe, ret RetEnviNFe, err error) {
+	cUF, _, _, _, _, _, _, tpEmis, _, cerr := GetChaveInfo(em.ChNFe)
+	if cerr != nil || tpEmis == strconv.Itoa(TpEmisSVCAN) || tpEmis == strconv.Itoa(TpEmisSVCRS) {
+		return
+	}
+	c := e.Contingencia
+	if c == nil {
+		c = DefaultContingencia
+	}
+
+	switch {
+	case err != nil:
+		c.RegistraFalha(cUF, em.TpAmb, err)
+	case ret.CStat == 108 || ret.CStat == 109:
+		c.ObservaStatus(RetConsStatServ{TpAmb: em.TpAmb, CStat: ret.CStat, XMotivo: ret.XMotivo, CUF: cUF})
+	default:
+		c.RegistraSucesso(cUF, em.TpAmb)
+	}
+}
+
+// consultaRecibo aguarda o processamento do lote, consultando o recibo a cada IntervaloRecibo.
+func (e *Emissor) consultaRecibo(ctx context.Context, em EmissaoNFe) (EmissaoNFe, error) {
+	consulta := e.ConsultaRecibo
+	if consulta == nil {
+		consulta = ConsultaReciboNFe
+	}
+	intervalo := e.IntervaloRecibo
+	if intervalo <= 0 {
+		intervalo = time.Second
+	}
+	n := e.ConsultasRecibo
+	if n <= 0 {
+		n = 10
+	}
+
+	for i := 0; i < n; i++ {
+		select {
+		case <-ctx.Done():
+			return em, ctx.Err()
+		case <-time.After(intervalo):
+		}
+
+		ret, xmlRet, err := consulta(em.NRec, em.ChNFe, em.TpAmb, e.Client, e.optReq(ctx)...)
+		if err != nil {
+			return e.falha(em, err)
+		}
+		em.CStat, em.XMotivo, em.Erro = ret.CStat, ret.XMotivo, ""
+
+		switch {
+		case ret.CStat == 105:
+			continue
+		case ret.CStat == 104:
+			for _, prot := range ret.ProtNFe {
+				if prot.InfProt.ChNFe == em.ChNFe {
+					return e.protocolo(ctx, em, prot, xmlRet)
+				}
+			}
+			return e.falha(em, fmt.Errorf("O lote do recibo %s não contém o protocolo da NFe %s", em.NRec, em.ChNFe))
+		case ret.CStat == 106:
+			// Lote não localizado: o resultado é obtido pela consulta da chave de acesso.
+			em.Situacao = EmissaoEnviada
+			em.NRec = ""
+			return em, e.salva(&em)
+		case cStatLoteTemporario[ret.CStat]:
+			return e.falha(em, fmt.Errorf("Consulta do recibo %s não atendida pela Sefaz: %d - %s", em.NRec, ret.CStat, ret.XMotivo))
+		default:
+			em.Situacao = EmissaoRejeitada
+			return em, e.salva(&em)
+		}
+	}
+
+	return e.falha(em, fmt.Errorf("%w: NFe %s, recibo %s", ErrLoteEmProcessamento, em.ChNFe, em.NRec))
+}
+
+// protocolo interpreta o protNFe da NFe, obtido do retorno do envio, do recibo ou da consulta da chave (xmlRet), gravando o nfeProc se a NFe foi autorizada ou denegada.
+func (e *Emissor) protocolo(ctx context.Context, em EmissaoNFe, prot ProtNFe, xmlRet []byte) (EmissaoNFe, error) {
+	em.CStat, em.XMotivo, em.Erro = prot.InfProt.CStat, prot.InfProt.XMotivo, ""
+	switch {
+	case cStatProtNFe[prot.InfProt.CStat]:
+		proc, err := AttachProtocol(em.XML, xmlRet)
+		if err != nil {
+			return e.falha(em, err)
+		}
+		em.Situacao = EmissaoDenegada
+		if prot.InfProt.CStat == 100 || prot.InfProt.CStat == 150 {
+			em.Situacao = EmissaoAutorizada
+		}
+		em.ProtNFe = &prot
+		em.NFeProc = proc
+		em.NRec = ""
+	case prot.InfProt.CStat == cStatDuplicidade || prot.InfProt.CStat == cStatDuplicidadeChaveDiversa:
+		return e.duplicidade(ctx, em, prot)
+	default:
+		em.Situacao = EmissaoRejeitada
+	}
+	return em, e.salva(&em)
+}
+
+// consultaChave obtém o resultado da NFe pela consulta do protocolo, quando não se sabe se a Sefaz recebeu o envio. Se a NFe não consta na Sefaz (cStat 217), ela volta à situação EmissaoAssinada para ser enviada novamente.
+func (e *Emissor) consultaChave(ctx context.Context, em EmissaoNFe) (EmissaoNFe, error) {
+	ret, xmlRet, err := e.consulta()(em.ChNFe, em.TpAmb, e.Client, e.optReq(ctx)...)
+	if err != nil {
+		return e.falha(em, err)
+	}
+	if ret.ProtNFe != nil {
+		return e.protocolo(ctx, em, *ret.ProtNFe, xmlRet)
+	}
+	if ret.CStat == cStatNFeNaoConsta {
+		em.Situacao = EmissaoAssinada
+		em.CStat, em.XMotivo, em.Erro = ret.CStat, ret.XMotivo, ""
+		return em, e.salva(&em)
+	}
+	return e.falha(em, fmt.Errorf("A consulta da NFe %s não retornou o protocolo: %d - %s", em.ChNFe, ret.CStat, ret.XMotivo))
+}
+
+// duplicidade trata a rejeição 204 ou 539 (ver RecuperaDuplicidade): se a NFe já autorizada é a enviada, o protocolo original é gravado; se não, a emissão termina na situação EmissaoDuplicada. Sem a confirmação da consulta, a emissão fica na situação EmissaoEnviada.
+func (e *Emissor) duplicidade(ctx context.Context, em EmissaoNFe, prot ProtNFe) (EmissaoNFe, error) {
+	em.Situacao = EmissaoEnviada
+	ret, xmlRet, err := recuperaDuplicidade(em.XML, prot, em.TpAmb, e.consulta(), e.Client, e.optReq(ctx)...)
+	var dup *DuplicidadeError
+	if errors.As(err, &dup) {
+		em.Situacao = EmissaoDuplicada
+		em.ChNFeOriginal = dup.ChNFeOriginal
+		return e.falha(em, err)
+	}
+	if err != nil {
+		return e.falha(em, err)
+	}
+	return e.protocolo(ctx, em, *ret.ProtNFe, xmlRet)
+}
+
+func (e *Emissor) consulta() func(chNFe string, tpAmb TAmb, client *http.Client, optReq ...func(req *http.Request)) (RetConsSitNFe, []byte, error) {
+	if e.Consulta == nil {
+		return ConsultaNFe
+	}
+	return e.Consulta
+}
+
+// falha grava o erro na emissão e o retorna.
+func (e *Emissor) falha(em EmissaoNFe, err error) (EmissaoNFe, error) {
+	em.Erro = err.Error()
+	if errSalva := e.salva(&em); errSalva != nil {
+		return em, errors.Join(err, errSalva)
+	}
+	return em, err
+}
@@ -0,0 +1,331 @@
+package nfe_test
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/beevik/etree"
+	"github.com/eduardotorresdev/nfe"
+	"github.com/eduardotorresdev/nfe/nfetest"
+)
+
+var reDigestValue = regexp.MustCompile(`<DigestValue>([^<]+)</DigestValue>`)
+
+// certificadoEmitente gera um certificado autoassinado do emitente e a sua chave, em PEM.
+func certificadoEmitente(t *testing.T) ([]byte, []byte) {
+	t.Helper()
+	chave, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	modelo := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "EMITENTE LTDA:11222333000181"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, modelo, modelo, &chave.PublicKey, chave)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(chave)})
+}
+
+// nfeEmissao monta uma NFe de SP em homologação, sem assinatura, com o número, o código numérico (cNF) e o valor informados.
+func nfeEmissao(t *testing.T, nNF, cNF int, vNF string) (string, []byte) {
+	t.Helper()
+	chave, err := nfe.MontaChaveDeAcesso(35, 24, 5, "11222333000181", "55", 1, nNF, 1, cNF)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return chave, []byte(`<?xml version="1.0" encoding="UTF-8"?><NFe xmlns="http://www.portalfiscal.inf.br/nfe"><infNFe Id="NFe` + chave + `" versao="4.00"><ide><cUF>35</cUF><mod>55</mod><tpAmb>2</tpAmb><dhEmi>2024-05-17T10:00:00-03:00</dhEmi></ide><total><ICMSTot><vNF>` + vNF + `</vNF></ICMSTot></total></infNFe></NFe>`)
+}
+
+// retEnviNFeTeste monta o retorno da autorização, com o protNFe informado (ou nenhum).
+func retEnviNFeTeste(cStat int, xMotivo, protNFe string) nfetest.Resposta {
+	return nfetest.Resposta{XML: []byte(fmt.Sprintf(`<retEnviNFe xmlns="http://www.portalfiscal.inf.br/nfe" versao="4.00"><tpAmb>2</tpAmb><verAplic>TESTE</verAplic><cStat>%d</cStat><xMotivo>%s</xMotivo><cUF>35</cUF><dhRecbto>2024-05-17T10:31:02-03:00</dhRecbto>%s</retEnviNFe>`, cStat, xMotivo, protNFe))}
+}
+
+// rejeicaoTeste monta o retorno da autorização síncrona de uma NFe rejeitada.
+func rejeicaoTeste(chNFe string, cStat int, xMotivo string) nfetest.Resposta {
+	return retEnviNFeTeste(104, "Lote processado", fmt.Sprintf(`<protNFe versao="4.00"><infProt><tpAmb>2</tpAmb><verAplic>TESTE</verAplic><chNFe>%s</chNFe><dhRecbto>2024-05-17T10:31:02-03:00</dhRecbto><cStat>%d</cStat><xMotivo>%s</xMotivo></infProt></protNFe>`, chNFe, cStat, xMotivo))
+}
+
+// envios conta as requisições de autorização da NFe recebidas pela Sefaz.
+func envios(sefaz *nfetest.Sefaz, chNFe string) int {
+	n := 0
+	for _, req := range sefaz.Requisicoes() {
+		if req.Servico == nfe.Autorizacao && bytes.Contains(req.Mensagem, []byte(`Id="NFe`+chNFe+`"`)) {
+			n++
+		}
+	}
+	return n
+}
+
+// consultas conta as consultas de recibo e de protocolo recebidas pela Sefaz.
+func consultas(sefaz *nfetest.Sefaz) int {
+	n := 0
+	for _, req := range sefaz.Requisicoes() {
+		if req.Servico == nfe.RetAutorizacao || req.Servico == nfe.ConsultaProtocolo {
+			n++
+		}
+	}
+	return n
+}
+
+func novoEmissorTeste(t *testing.T, dir string, client *http.Client) *nfe.Emissor {
+	t.Helper()
+	armazenamento, err := nfe.NewArmazenamentoEmissaoArquivo(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, key := certificadoEmitente(t)
+	e := nfe.NewEmissor(armazenamento, client)
+	e.Assina = func(xmlNFe []byte) ([]byte, error) { return nfe.AssinaNFe(xmlNFe, cert, key) }
+	e.IntervaloRecibo = time.Millisecond
+	e.Contingencia = nfe.NewContingencia(nil)
+	return e
+}
+
+func TestAutorizaNFeNFeProc(t *testing.T) {
+	sefaz := nfetest.NewSefaz()
+	defer sefaz.Close()
+	client := sefaz.Client()
+	cert, key := certificadoEmitente(t)
+	_, xmlNFe := nfeEmissao(t, 1, 12345678, "100.00")
+	assinado, err := nfe.AssinaNFe(xmlNFe, cert, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, xmlRet, err := nfe.AutorizaNFe(assinado, client)
+	if err != nil {
+		t.Fatal(err)
+	}
+	proc, err := nfe.AttachProtocol(assinado, xmlRet)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// O nfeProc é reenviado: apenas a NFe, com os bytes originais, é incluída no enviNFe.
+	if _, _, err := nfe.AutorizaNFe(proc, client); err != nil {
+		t.Fatal(err)
+	}
+	reqs := sefaz.Requisicoes()
+	msg := reqs[len(reqs)-1].Mensagem
+	doc := etree.NewDocument()
+	if err := doc.ReadFromBytes(msg); err != nil {
+		t.Fatal(err)
+	}
+	filhos := doc.Root().ChildElements()
+	nfes := doc.Root().SelectElements("NFe")
+	if len(nfes) != 1 || filhos[len(filhos)-1] != nfes[0] || len(doc.FindElements("//nfeProc")) != 0 || len(doc.FindElements("//NFe")) != 1 || !bytes.Contains(msg, assinado[bytes.Index(assinado, []byte("<NFe")):]) {
+		t.Errorf("enviNFe do nfeProc:\n%s", msg)
+	}
+
+	// O lote de AutorizaNFe tem uma única NFe.
+	semNFe := []byte(`<enviNFe xmlns="http://www.portalfiscal.inf.br/nfe" versao="4.00"><idLote>1</idLote><indSinc>1</indSinc></enviNFe>`)
+	duasNFe := []byte(`<enviNFe xmlns="http://www.portalfiscal.inf.br/nfe" versao="4.00"><idLote>1</idLote><indSinc>1</indSinc>` + string(assinado[bytes.Index(assinado, []byte("<NFe")):]) + string(assinado[bytes.Index(assinado, []byte("<NFe")):]) + `</enviNFe>`)
+	for _, x := range [][]byte{semNFe, duasNFe} {
+		if _, _, err := nfe.AutorizaNFe(x, client); err == nil || !strings.Contains(err.Error(), "exatamente uma NFe") {
+			t.Errorf("AutorizaNFe(%.60s) = %v", x, err)
+		}
+	}
+	if n := len(sefaz.Requisicoes()); n != len(reqs) {
+		t.Errorf("%d requisições enviadas para lotes inválidos", n-len(reqs))
+	}
+}
+
+func TestEmissor(t *testing.T) {
+	sefaz := nfetest.NewSefaz()
+	defer sefaz.Close()
+	sefaz.Processando = 2
+	client := sefaz.Client()
+	emissor := novoEmissorTeste(t, t.TempDir(), client)
+	ctx := context.Background()
+
+	// sinc: autorizada no próprio retorno; assinc: recebida para processamento (103) e em processamento nas duas primeiras consultas do recibo; duplicidade: já autorizada em um envio anterior, cuja resposta se perdeu (204).
+	tests := []struct {
+		nNF       int
+		modo      string
+		situacao  string
+		cStat     int
+		envios    int
+		consultas int
+	}{
+		{1, "sinc", nfe.EmissaoAutorizada, 100, 1, 0},
+		{2, "assinc", nfe.EmissaoAutorizada, 100, 1, 3},
+		{3, "duplicidade", nfe.EmissaoAutorizada, 100, 1, 1},
+		{4, "rejeitada", nfe.EmissaoRejeitada, 778, 1, 0},
+	}
+	for _, tt := range tests {
+		chave, xmlNFe := nfeEmissao(t, tt.nNF, 12345678, "100.00")
+		switch tt.modo {
+		case "assinc":
+			sefaz.Assincrona = true
+		case "duplicidade":
+			assinado, _ := emissor.Assina(xmlNFe)
+			if _, err := sefaz.AdicionaNFe(assinado); err != nil {
+				t.Fatal(err)
+			}
+		case "rejeitada":
+			sefaz.Responde(nfe.Autorizacao, rejeicaoTeste(chave, 778, "Rejeição: NCM inexistente"))
+		}
+
+		antes := consultas(sefaz)
+		em, err := emissor.Emite(ctx, xmlNFe)
+		sefaz.Assincrona = false
+		if n, c := envios(sefaz, chave), consultas(sefaz)-antes; err != nil || em.Situacao != tt.situacao || em.CStat != tt.cStat || n != tt.envios || c != tt.consultas {
+			t.Errorf("%s: %v, erro %v; %d envios e %d consultas", tt.modo, em, err, n, c)
+			continue
+		}
+		if tt.situacao != nfe.EmissaoAutorizada {
+			continue
+		}
+		if _, err := nfe.VerifySignature(em.NFeProc); err != nil || !strings.Contains(string(em.NFeProc), "<nfeProc") || em.ProtNFe.InfProt.NProt == "" {
+			t.Errorf("%s: nfeProc inválido (%v): %s", tt.modo, err, em.NFeProc)
+		}
+
+		// idempotência: a NFe autorizada não é enviada novamente
+		if de, err := emissor.Emite(ctx, xmlNFe); err != nil || de.Situacao != nfe.EmissaoAutorizada || envios(sefaz, chave) != tt.envios {
+			t.Errorf("%s: segunda emissão %v, erro %v; %d envios", tt.modo, de, err, envios(sefaz, chave))
+		}
+	}
+
+	// a NFe rejeitada pode ser corrigida e emitida novamente com a mesma chave
+	chave, corrigida := nfeEmissao(t, 4, 12345678, "90.00")
+	if em, err := emissor.Emite(ctx, corrigida); err != nil || em.Situacao != nfe.EmissaoAutorizada || em.Tentativas != 2 || !strings.Contains(string(em.NFeProc), "90.00") {
+		t.Errorf("NFe corrigida: %v, erro %v", em, err)
+	}
+	if em, err := emissor.Situacao(chave); err != nil || em.Situacao != nfe.EmissaoAutorizada {
+		t.Errorf("Situacao(%s) = %v, %v", chave, em, err)
+	}
+
+	// duplicidade com outro conteúdo (204) e com outra chave (539)
+	for _, modo := range []string{"duplicidade", "chaveDiversa"} {
+		chave, xmlNFe := nfeEmissao(t, 7, 12345678, "100.00")
+		outra := chave
+		if modo == "duplicidade" {
+			_, anterior := nfeEmissao(t, 7, 12345678, "50.00")
+			assinado, _ := emissor.Assina(anterior)
+			sefaz.AdicionaNFe(assinado)
+		} else {
+			chave, xmlNFe = nfeEmissao(t, 8, 12345678, "100.00")
+			_, anterior := nfeEmissao(t, 8, 87654321, "100.00")
+			assinado, _ := emissor.Assina(anterior)
+			outra, _ = sefaz.AdicionaNFe(assinado)
+			sefaz.Responde(nfe.Autorizacao, rejeicaoTeste(chave, 539, "Rejeição: Duplicidade de NF-e com diferença na Chave de Acesso [chNFe:"+outra+"]"))
+		}
+
+		em, err := emissor.Emite(ctx, xmlNFe)
+		var dup *nfe.DuplicidadeError
+		if !errors.As(err, &dup) || em.Situacao != nfe.EmissaoDuplicada || em.ChNFeOriginal != outra || dup.ChNFeOriginal != outra || len(em.NFeProc) != 0 {
+			t.Errorf("%s: %v, erro %v", modo, em, err)
+		}
+		if de, err := emissor.Emite(ctx, xmlNFe); err != nil || de.Situacao != nfe.EmissaoDuplicada || envios(sefaz, chave) != 1 {
+			t.Errorf("%s: segunda emissão %v, erro %v", modo, de, err)
+		}
+	}
+
+	// serviço paralisado: a NFe continua assinada, para novo envio
+	_, xmlNFe := nfeEmissao(t, 5, 12345678, "100.00")
+	sefaz.Responde(nfe.Autorizacao, retEnviNFeTeste(108, "Serviço Paralisado Momentaneamente (curto prazo)", ""))
+	if em, err := emissor.Emite(ctx, xmlNFe); err == nil || em.Situacao != nfe.EmissaoAssinada || em.CStat != 108 {
+		t.Errorf("serviço paralisado: %v, erro %v", em, err)
+	}
+	if ativa, _, xJust := emissor.Contingencia.EmContingencia(35, nfe.Homologacao); !ativa || !strings.Contains(xJust, "108") {
+		t.Errorf("serviço paralisado deveria ativar a contingência: %v, %q", ativa, xJust)
+	}
+
+	// NFe sem assinatura e sem a função Assina, e assinatura que não confere
+	_, xmlNFe = nfeEmissao(t, 6, 12345678, "100.00")
+	semAssina := nfe.NewEmissor(emissor.Armazenamento, client)
+	if _, err := semAssina.Emite(ctx, xmlNFe); err == nil {
+		t.Error("NFe sem assinatura aceita sem a função Assina")
+	}
+	assinado, _ := emissor.Assina(xmlNFe)
+	if _, err := semAssina.Emite(ctx, []byte(strings.Replace(string(assinado), "100.00", "1.00", 1))); !errors.Is(err, nfe.ErrAssinaturaInvalida) {
+		t.Errorf("NFe alterada após a assinatura: erro %v", err)
+	}
+	naoEmitida, _ := nfeEmissao(t, 9, 12345678, "100.00")
+	if _, err := emissor.Situacao(naoEmitida); !errors.Is(err, nfe.ErrEmissaoNaoEncontrada) {
+		t.Errorf("NFe não emitida: erro %v", err)
+	}
+}
+
+func TestEmissorRetoma(t *testing.T) {
+	sefaz := nfetest.NewSefaz()
+	defer sefaz.Close()
+	sefaz.Processando = 3
+	client := sefaz.Client()
+	dir := t.TempDir()
+	emissor := novoEmissorTeste(t, dir, client)
+	emissor.ConsultasRecibo = 1
+	ctx := context.Background()
+
+	// 1: autorizada com a resposta perdida; 2: não recebida pela Sefaz; 3: lote ainda em processamento
+	modos := []string{"perdida", "indisponivel", "assinc"}
+	chaves := make([]string, len(modos))
+	for i, modo := range modos {
+		var xmlNFe []byte
+		chaves[i], xmlNFe = nfeEmissao(t, i+1, 12345678, "100.00")
+		switch modo {
+		case "perdida":
+			assinado, _ := emissor.Assina(xmlNFe)
+			sefaz.AdicionaNFe(assinado)
+			sefaz.Responde(nfe.Autorizacao, nfetest.Resposta{Status: http.StatusServiceUnavailable})
+		case "indisponivel":
+			sefaz.Responde(nfe.Autorizacao, nfetest.Resposta{Status: http.StatusServiceUnavailable})
+		case "assinc":
+			sefaz.Assincrona = true
+		}
+
+		em, err := emissor.Emite(ctx, xmlNFe)
+		sefaz.Assincrona = false
+		want := nfe.EmissaoEnviada
+		if modo == "assinc" {
+			want = nfe.EmissaoProcessando
+			if !errors.Is(err, nfe.ErrLoteEmProcessamento) {
+				t.Errorf("%s: erro %v; esperado ErrLoteEmProcessamento", modo, err)
+			}
+		}
+		if err == nil || em.Situacao != want || em.Erro == "" {
+			t.Errorf("%s: %v, erro %v", modo, em, err)
+		}
+	}
+
+	// reinício do programa: um novo Emissor com o mesmo diretório
+	emissor = novoEmissorTeste(t, dir, client)
+	emissor.ConsultasRecibo = 5
+	retomadas, err := emissor.Retoma(ctx)
+	if err != nil || len(retomadas) != 3 {
+		t.Fatalf("%d emissões retomadas, erro %v", len(retomadas), err)
+	}
+	for _, em := range retomadas {
+		if em.Situacao != nfe.EmissaoAutorizada || len(em.NFeProc) == 0 || em.Erro != "" {
+			t.Errorf("retomada: %v", em)
+		}
+	}
+	for i, want := range []int{1, 2, 1} {
+		if n := envios(sefaz, chaves[i]); n != want {
+			t.Errorf("NFe %d enviada %d vezes; esperado %d", i+1, n, want)
+		}
+	}
+
+	if retomadas, err := emissor.Retoma(ctx); err != nil || len(retomadas) != 0 {
+		t.Errorf("segunda retomada: %v, erro %v", retomadas, err)
+	}
+}
@@ -0,0 +1,277 @@
+package nfe
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// ErrEmpresaNaoCadastrada indica que o CNPJ informado não foi cadastrado em Empresas.
+var ErrEmpresaNaoCadastrada = errors.New("empresa não cadastrada")
+
+// ErrEmpresaDivergente indica que o documento (por exemplo, a NFe) pertence a outro CNPJ, e não à empresa usada na chamada.
+var ErrEmpresaDivergente = errors.New("documento de outra empresa")
+
+// Empresa é a configuração de um contribuinte em um processo que emite para várias empresas (ver Empresas).
+type Empresa struct {
+	CNPJ string
+
+	// CertFile e KeyFile são os arquivos PEM do certificado digital, como em NewHTTPClient. Empresas com os mesmos arquivos (por exemplo, as filiais com o certificado da matriz) compartilham o certificado e as conexões.
+	CertFile string
+	KeyFile  string
+
+	TpAmb TAmb
+	// CUF é a UF do contribuinte, usada na consulta de status e, quando não informada outra, na consulta de cadastro.
+	CUF int
+
+	// NFCe é a configuração do QR Code da NFC-e (CSC e IdCSC). Na versão 3, a ChavePrivada, se não informada, é a do certificado da empresa.
+	NFCe ConfigNFCe
+
+	// Limites, quando informado, substitui para esta empresa os limites de requisições comuns a todas (ver ConfigEmpresas.Limites).
+	Limites *RateLimiterConfig
+}
+
+// ConfigEmpresas define as configurações comuns a todas as empresas.
+type ConfigEmpresas struct {
+	// Limites são os limites de requisições, aplicados separadamente a cada CNPJ (ver DefaultRateLimiterConfig).
+	Limites RateLimiterConfig
+	// Retry, se informada, é a política de novas tentativas usada por todos os clientes (ver WithRetry).
+	Retry *RetryPolicy
+}
+
+// Empresas é o cadastro das empresas (tenants) de um processo que emite para vários contribuintes: para cada chamada, seleciona pelo CNPJ o certificado, o ambiente, a UF, o CSC e os limites de requisições.
+//
+// O http.Client (e o seu pool de conexões) é criado uma única vez por certificado e reaproveitado em todas as chamadas. Como a conexão TLS é autenticada com o certificado, as conexões só podem ser compartilhadas entre as empresas que usam o mesmo certificado. Pode ser usado concorrentemente.
+type Empresas struct {
+	cfg     ConfigEmpresas
+	limiter *RateLimiter
+
+	mu       sync.RWMutex
+	empresas map[string]*ClienteEmpresa
+	fontes   map[[2]string]*fonteCompartilhada
+}
+
+// fonteCompartilhada é o certificado e o http.Client usados por todas as empresas com os mesmos arquivos.
+type fonteCompartilhada struct {
+	fonte  *FonteCertificado
+	client *http.Client
+	uso    int
+}
+
+// NewEmpresas cria um cadastro de empresas vazio.
+func NewEmpresas(cfg ConfigEmpresas) *Empresas {
+	return &Empresas{
+		cfg:      cfg,
+		limiter:  NewRateLimiter(cfg.Limites),
+		empresas: map[string]*ClienteEmpresa{},
+		fontes:   map[[2]string]*fonteCompartilhada{},
+	}
+}
+
+// Adiciona cadastra a empresa, ou substitui a configuração de uma já cadastrada com o mesmo CNPJ. O certificado é carregado e conferido com o CNPJ da empresa.
+func (e *Empresas) Adiciona(emp Empresa) error {
+	if len(emp.CNPJ) != 14 {
+		return fmt.Errorf("CNPJ inválido: %q", emp.CNPJ)
+	}
+	chave, err := chaveFonte(emp.CertFile, emp.KeyFile)
+	if err != nil {
+		return err
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	f, ok := e.fontes[chave]
+	if !ok {
+		fonte, err := NewFonteCertificado(emp.CertFile, emp.KeyFile)
+		if err != nil {
+			return fmt.Errorf("Empresa %s: %w", emp.CNPJ, err)
+		}
+		client, err := fonte.HTTPClient()
+		if err != nil {
+			return err
+		}
+		if e.cfg.Retry != nil {
+			client = WithRetry(client, *e.cfg.Retry)
+		}
+		f = &fonteCompartilhada{fonte: fonte, client: client}
+	}
+	if cert := f.fonte.Certificado(); cert.ICPBrasil() {
+		if err := cert.ConfereDocumento(emp.CNPJ); err != nil {
+			return fmt.Errorf("Empresa %s: %w", emp.CNPJ, err)
+		}
+	}
+
+	c := &ClienteEmpresa{Empresa: emp, Fonte: f.fonte, Client: f.client, limiter: e.limiter}
+	if emp.Limites != nil {
+		c.limiter = NewRateLimiter(*emp.Limites)
+	}
+	if c.NFCe.VersaoQRCode == QRCodeV3 && c.NFCe.ChavePrivada == nil {
+		c.NFCe.ChavePrivada = f.fonte.ChavePrivada()
+	}
+
+	e.fontes[chave] = f
+	f.uso++
+	if antigo, ok := e.empresas[emp.CNPJ]; ok {
+		e.liberaFonte(antigo)
+	}
+	e.empresas[emp.CNPJ] = c
+	return nil
+}
+
+func chaveFonte(certFile, keyFile string) ([2]string, error) {
+	cert, err := filepath.Abs(certFile)
+	if err != nil {
+		return [2]string{}, err
+	}
+	key, err := filepath.Abs(keyFile)
+	if err != nil {
+		return [2]string{}, err
+	}
+	return [2]string{cert, key}, nil
+}
+
+// liberaFonte descarta o certificado e as conexões que não são mais usados por nenhuma empresa.
+func (e *Empresas) liberaFonte(c *ClienteEmpresa) {
+	chave, _ := chaveFonte(c.CertFile, c.KeyFile)
+	f, ok := e.fontes[chave]
+	if !ok {
+		return
+	}
+	if f.uso--; f.uso <= 0 {
+		f.client.CloseIdleConnections()
+		delete(e.fontes, chave)
+	}
+}
+
+// Remove descadastra a empresa.
+func (e *Empresas) Remove(cnpj string) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if c, ok := e.empresas[cnpj]; ok {
+		e.liberaFonte(c)
+		delete(e.empresas, cnpj)
+	}
+}
+
+// Empresa retorna o cliente da empresa com o CNPJ informado, ou ErrEmpresaNaoCadastrada.
+func (e *Empresas) Empresa(cnpj string) (*ClienteEmpresa, error) {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	c, ok := e.empresas[cnpj]
+	if !ok {
+		return nil, fmt.Errorf("%w: CNPJ %s", ErrEmpresaNaoCadastrada, cnpj)
+	}
+	return c, nil
+}
+
+// CNPJs retorna os CNPJs cadastrados, em ordem.
+func (e *Empresas) CNPJs() []string {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	r := make([]string, 0, len(e.empresas))
+	for cnpj := range e.empresas {
+		r = append(r, cnpj)
+	}
+	sort.Strings(r)
+	return r
+}
+
+// Reload recarrega os certificados de todas as empresas (ver FonteCertificado.Reload), por exemplo após a renovação dos arquivos.
+func (e *Empresas) Reload() error {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	var erros []error
+	for _, f := range e.fontes {
+		if err := f.fonte.Reload(); err != nil {
+			erros = append(erros, err)
+		}
+	}
+	return errors.Join(erros...)
+}
+
+// ClienteEmpresa executa as chamadas da biblioteca em nome de uma empresa, com o seu certificado, ambiente, UF, CSC e limites. É obtido com Empresas.Empresa.
+type ClienteEmpresa struct {
+	Empresa
+	Fonte  *FonteCertificado
+	Client *http.Client
+
+	limiter *RateLimiter
+}
+
+// confere retorna ErrEmpresaDivergente se a chave de acesso não for de uma NFe emitida pela empresa (mesma raiz de CNPJ).
+func (c *ClienteEmpresa) confere(chNFe string) error {
+	if len(chNFe) != 44 || chNFe[6:14] != c.CNPJ[:8] {
+		return fmt.Errorf("%w: NFe %s não emitida pela empresa %s", ErrEmpresaDivergente, chNFe, c.CNPJ)
+	}
+	return nil
+}
+
+// ConsultaStatServ consulta o status do serviço na UF e no ambiente da empresa.
+func (c *ClienteEmpresa) ConsultaStatServ(optReq ...func(req *http.Request)) (RetConsStatServ, []byte, error) {
+	if err := c.limiter.Permite(c.CNPJ, ConsultaStatus, c.CUF); err != nil {
+		return RetConsStatServ{}, nil, err
+	}
+	return ConsultaStatServ(c.CUF, c.TpAmb, c.Client, optReq...)
+}
+
+// ConsultaNFe consulta a situação da NFe no ambiente da empresa (ver RateLimiter.ConsultaNFe).
+func (c *ClienteEmpresa) ConsultaNFe(chNFe string, optReq ...func(req *http.Request)) (RetConsSitNFe, []byte, error) {
+	return c.limiter.ConsultaNFe(c.CNPJ, chNFe, c.TpAmb, c.Client, optReq...)
+}
+
+// ConsultaNFeLote consulta muitas chaves de acesso no ambiente e com os limites da empresa (ver nfe.ConsultaNFeLote). Concorrencia é o máximo de consultas simultâneas por autorizador; zero usa o padrão.
+func (c *ClienteEmpresa) ConsultaNFeLote(ctx context.Context, chaves []string, concorrencia int, optReq ...func(req *http.Request)) <-chan ResultadoConsultaLote {
+	return ConsultaNFeLote(ctx, chaves, ConfigConsultaLote{
+		TpAmb:        c.TpAmb,
+		Client:       c.Client,
+		OptReq:       optReq,
+		Concorrencia: concorrencia,
+		Limiter:      c.limiter,
+		CNPJ:         c.CNPJ,
+	})
+}
+
+// ConsultaCad consulta o cadastro de um contribuinte na UF informada ou, se cUF for zero, na UF da empresa.
+func (c *ClienteEmpresa) ConsultaCad(ie string, cnpj string, cpf string, cUF int, optReq ...func(req *http.Request)) (RetConsCad, []byte, error) {
+	if cUF == 0 {
+		cUF = c.CUF
+	}
+	return c.limiter.ConsultaCad(c.CNPJ, ie, cnpj, cpf, cUF, c.TpAmb, c.Client, optReq...)
+}
+
+// ConsultaDistChNFe obtém da Distribuição DF-e uma NFe destinada à empresa.
+func (c *ClienteEmpresa) ConsultaDistChNFe(chave string, optReq ...func(*http.Request)) (ResultadoDistribuicaoNFe, error) {
+	return c.limiter.ConsultaDistChNFe(c.CNPJ, chave, c.TpAmb, c.Client, optReq...)
+}
+
+// AutorizaNFe envia a NFe assinada para autorização, conferindo antes se ela foi emitida pela empresa.
+func (c *ClienteEmpresa) AutorizaNFe(xmlNFe []byte, optReq ...func(req *http.Request)) (RetEnviNFe, []byte, error) {
+	nfe, err := lerNFeXML(xmlNFe)
+	if err != nil {
+		return RetEnviNFe{}, nil, err
+	}
+	infNFe := nfe.FindElement("infNFe")
+	if infNFe == nil {
+		return RetEnviNFe{}, nil, fmt.Errorf("NFe sem o grupo infNFe")
+	}
+	if err := c.confere(strings.TrimPrefix(infNFe.SelectAttrValue("Id", ""), "NFe")); err != nil {
+		return RetEnviNFe{}, nil, err
+	}
+	return AutorizaNFe(xmlNFe, c.Client, optReq...)
+}
+
+// SendManifestacaoEvento envia os eventos assinados com o certificado da empresa (ver SendManifestacaoEventoFonte).
+func (c *ClienteEmpresa) SendManifestacaoEvento(ctx context.Context, idLote string, eventos []ManifestacaoEvento, optReq ...func(*http.Request)) ([]byte, error) {
+	return SendManifestacaoEventoFonte(ctx, c.Client, c.Fonte, idLote, eventos, optReq...)
+}
+
+// AdicionaInfNFeSupl inclui o QR Code na NFC-e assinada, com o CSC da empresa (ver ConfigNFCe.AdicionaInfNFeSupl).
+func (c *ClienteEmpresa) AdicionaInfNFeSupl(xmlNFe []byte) ([]byte, error) {
+	return c.NFCe.AdicionaInfNFeSupl(xmlNFe)
+}
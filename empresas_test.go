@@ -0,0 +1,107 @@
+package nfe
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestEmpresas(t *testing.T) {
+	dir := t.TempDir()
+	grava := func(nome string, cert, chave []byte) (string, string) {
+		t.Helper()
+		certFile, keyFile := filepath.Join(dir, nome+".pem"), filepath.Join(dir, nome+"-key.pem")
+		if err := os.WriteFile(certFile, cert, 0o600); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(keyFile, chave, 0o600); err != nil {
+			t.Fatal(err)
+		}
+		return certFile, keyFile
+	}
+	_, _, titular, chaveTitular := cadeiaTeste(t, time.Now().Add(24*time.Hour))
+	eCNPJ, eCNPJKey := grava("ecnpj", pemTeste(titular), pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(chaveTitular)}))
+	outroPEM, outroChave := certificadoTeste(t, "OUTRA EMPRESA")
+	outro, outroKey := grava("outro", outroPEM, outroChave)
+
+	e := NewEmpresas(ConfigEmpresas{Limites: DefaultRateLimiterConfig()})
+	for _, emp := range []Empresa{
+		{CNPJ: "11222333000181", CertFile: eCNPJ, KeyFile: eCNPJKey, TpAmb: Homologacao, CUF: 35},
+		{CNPJ: "11222333000262", CertFile: eCNPJ, KeyFile: eCNPJKey, TpAmb: Homologacao, CUF: 41},
+		{CNPJ: "99888777000166", CertFile: outro, KeyFile: outroKey, TpAmb: Homologacao, CUF: 35,
+			Limites: &RateLimiterConfig{Padrao: Limite{Requisicoes: 1, Periodo: time.Hour}}},
+	} {
+		if err := e.Adiciona(emp); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := e.Adiciona(Empresa{CNPJ: "55444333000122", CertFile: eCNPJ, KeyFile: eCNPJKey}); !errors.Is(err, ErrDocumentoCertificadoDivergente) {
+		t.Errorf("Adiciona(CNPJ de outro certificado) = %v", err)
+	}
+	if got := strings.Join(e.CNPJs(), ","); got != "11222333000181,11222333000262,99888777000166" {
+		t.Errorf("CNPJs() = %s", got)
+	}
+	if _, err := e.Empresa("55444333000122"); !errors.Is(err, ErrEmpresaNaoCadastrada) {
+		t.Errorf("Empresa(não cadastrada) = %v", err)
+	}
+
+	matriz, _ := e.Empresa("11222333000181")
+	filial, _ := e.Empresa("11222333000262")
+	outra, _ := e.Empresa("99888777000166")
+	if matriz.Client != filial.Client || matriz.Fonte != filial.Fonte || matriz.Client == outra.Client {
+		t.Error("o http.Client deve ser compartilhado só entre as empresas com o mesmo certificado")
+	}
+
+	// Cada chamada usa o certificado, a UF e o ambiente da empresa.
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		cn := r.TLS.PeerCertificates[0].Subject.CommonName
+		w.Write([]byte(`<soap:Envelope xmlns:soap="http://www.w3.org/2003/05/soap-envelope"><soap:Body><nfeResultMsg><retConsStatServ xmlns="http://www.portalfiscal.inf.br/nfe" versao="4.00"><tpAmb>2</tpAmb><cStat>107</cStat><xMotivo>` + cn + `</xMotivo><cUF>35</cUF></retConsStatServ></nfeResultMsg></soap:Body></soap:Envelope>`))
+	}))
+	srv.TLS = &tls.Config{ClientAuth: tls.RequireAnyClientCert}
+	srv.StartTLS()
+	defer srv.Close()
+	DefaultRegistry.Override(35, Homologacao, ConsultaStatus, srv.URL)
+	defer DefaultRegistry.RemoveOverride(35, Homologacao, ConsultaStatus)
+	for _, c := range []*ClienteEmpresa{matriz, outra} {
+		c.Client.Transport.(*http.Transport).TLSClientConfig.RootCAs = srv.Client().Transport.(*http.Transport).TLSClientConfig.RootCAs
+	}
+
+	for _, tt := range []struct {
+		c  *ClienteEmpresa
+		cn string
+	}{{matriz, "EMITENTE LTDA:11222333000181"}, {outra, "OUTRA EMPRESA"}} {
+		ret, _, err := tt.c.ConsultaStatServ()
+		if err != nil || ret.XMotivo != tt.cn {
+			t.Errorf("ConsultaStatServ(%s) = %q, %v", tt.c.CNPJ, ret.XMotivo, err)
+		}
+	}
+	var rlErr *RateLimitError
+	if _, _, err := outra.ConsultaStatServ(); !errors.As(err, &rlErr) || rlErr.CNPJ != "99888777000166" {
+		t.Errorf("ConsultaStatServ() acima do limite da empresa = %v", err)
+	}
+	if _, _, err := matriz.ConsultaStatServ(); err != nil {
+		t.Errorf("ConsultaStatServ() com os limites comuns = %v", err)
+	}
+
+	if _, _, err := outra.AutorizaNFe([]byte(nfeAssinadaTeste)); !errors.Is(err, ErrEmpresaDivergente) {
+		t.Errorf("AutorizaNFe(NFe de outra empresa) = %v", err)
+	}
+
+	e.Remove("11222333000181")
+	e.Remove("99888777000166")
+	if len(e.fontes) != 1 {
+		t.Errorf("%d certificados carregados; esperado 1 (o da filial)", len(e.fontes))
+	}
+	e.Remove("11222333000262")
+	if len(e.fontes) != 0 || len(e.CNPJs()) != 0 {
+		t.Errorf("certificados não liberados: %d", len(e.fontes))
+	}
+}
@@ -0,0 +1,238 @@
+package nfe
+
+import (
+	"context"
+	"encoding/xml"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/beevik/etree"
+)
+
+const (
+	TpEventoEPEC  = "110140"
+	verEventoEPEC = "1.00"
+
+	// cStat do retEvento para um EPEC aceito: 136 (evento registrado, mas não vinculado a NF-e, situação normal, já que a nota ainda não foi transmitida) ou 135 (evento registrado e vinculado).
+	cStatEventoVinculado    = 135
+	cStatEventoNaoVinculado = 136
+)
+
+// EPEC representa os dados do evento prévio de emissão em contingência (tpEvento 110140), registrado no Ambiente Nacional antes da impressão do DANFE de uma NFe (modelo 55) emitida com tpEmis 4.
+type EPEC struct {
+	TpAmb      TAmb
+	CNPJ       string // CNPJ do emitente (autor do evento)
+	CPF        string // CPF do emitente, quando pessoa física
+	ChNFe      string // chave de acesso da NFe, obrigatoriamente com tpEmis 4
+	DhEvento   time.Time
+	NSeqEvento int
+
+	COrgaoAutor int    // cUF do emitente
+	TpAutor     int    // 1 = empresa emitente
+	VerAplic    string // versão do aplicativo do emitente
+	DhEmi       time.Time
+	TpNF        int // 0 = entrada, 1 = saída
+	IE          string
+
+	Dest struct {
+		UF            string
+		CNPJ          string
+		CPF           string
+		IdEstrangeiro string
+		IE            string
+		VNF           float64
+		VICMS         float64
+		VST           float64
+	}
+}
+
+// toManifestacaoEvento converte o EPEC no evento genérico usado na montagem do envEvento.
+func (e EPEC) toManifestacaoEvento() ManifestacaoEvento {
+	nSeq := e.NSeqEvento
+	if nSeq == 0 {
+		nSeq = 1
+	}
+	tpAutor := e.TpAutor
+	if tpAutor == 0 {
+		tpAutor = 1
+	}
+
+	return ManifestacaoEvento{
+		COrgao:     91,
+		TpAmb:      int(e.TpAmb),
+		CNPJ:       e.CNPJ,
+		CPF:        e.CPF,
+		ChNFe:      e.ChNFe,
+		DhEvento:   e.DhEvento,
+		TpEvento:   TpEventoEPEC,
+		NSeqEvento: nSeq,
+		VerEvento:  verEventoEPEC,
+		DescEvento: "EPEC",
+		detEvento: func(det *etree.Element) {
+			det.CreateElement("descEvento").SetText("EPEC")
+			det.CreateElement("cOrgaoAutor").SetText(strconv.Itoa(e.COrgaoAutor))
+			det.CreateElement("tpAutor").SetText(strconv.Itoa(tpAutor))
+			det.CreateElement("verAplic").SetText(e.VerAplic)
+			det.CreateElement("dhEmi").SetText(e.DhEmi.Format(layoutDataHora))
+			det.CreateElement("tpNF").SetText(strconv.Itoa(e.TpNF))
+			det.CreateElement("IE").SetText(e.IE)
+
+			dest := det.CreateElement("dest")
+			dest.CreateElement("UF").SetText(e.Dest.UF)
+			switch {
+			case e.Dest.CNPJ != "":
+				dest.CreateElement("CNPJ").SetText(e.Dest.CNPJ)
+			case e.Dest.CPF != "":
+				dest.CreateElement("CPF").SetText(e.Dest.CPF)
+			default:
+				dest.CreateElement("idEstrangeiro").SetText(e.Dest.IdEstrangeiro)
+			}
+			if e.Dest.IE != "" {
+				dest.CreateElement("IE").SetText(e.Dest.IE)
+			}
+			dest.CreateElement("vNF").SetText(fmt.Sprintf("%.2f", e.Dest.VNF))
+			dest.CreateElement("vICMS").SetText(fmt.Sprintf("%.2f", e.Dest.VICMS))
+			dest.CreateElement("vST").SetText(fmt.Sprintf("%.2f", e.Dest.VST))
+		},
+	}
+}
+
+// valida verifica as regras básicas do EPEC antes do envio.
+func (e EPEC) valida() error {
+	if !ValidaChaveDeAcesso(e.ChNFe) {
+		return fmt.Errorf("Chave de Acesso inválida: %s", e.ChNFe)
+	}
+	_, _, _, _, mod, _, _, tpEmis, _, _ := GetChaveInfo(e.ChNFe)
+	if mod != "55" {
+		return fmt.Errorf("EPEC só pode ser usado para NFe modelo 55: %s", e.ChNFe)
+	}
+	if tpEmis != strconv.Itoa(TpEmisEPEC) {
+		return fmt.Errorf("A chave de acesso de uma NFe em EPEC deve ter tpEmis 4: %s", e.ChNFe)
+	}
+	if e.Dest.UF == "" || (e.Dest.CNPJ == "" && e.Dest.CPF == "" && e.Dest.IdEstrangeiro == "") {
+		return fmt.Errorf("EPEC sem identificação do destinatário")
+	}
+	return nil
+}
+
+// SendEPEC registra o EPEC no Ambiente Nacional, assinando o evento com o certificado informado (ver SendManifestacaoEvento). Retorna o retorno do evento e o XML de resposta. O EPEC é sempre recebido pelo Ambiente Nacional, mesmo com a UF em contingência SVC (ver DefaultContingencia), já que é a alternativa para quando o SVC também está indisponível.
+//
+// O cStat 136 (evento registrado, mas não vinculado a NF-e) indica sucesso: a partir dele o DANFE pode ser impresso, e a NFe deve ser transmitida depois (ver ConciliaEPEC). Outros cStat diferentes de 135 são retornados como erro, junto com o retorno do evento.
+func SendEPEC(ctx context.Context, client *http.Client, certPEMPath, keyPEMPath string, idLote string, epec EPEC, optReq ...func(*http.Request)) (RetEventoNFe, []byte, error) {
+	if err := epec.valida(); err != nil {
+		return RetEventoNFe{}, nil, err
+	}
+
+	soap, err := SendManifestacaoEvento(ctx, client, certPEMPath, keyPEMPath, idLote, []ManifestacaoEvento{epec.toManifestacaoEvento()}, optReq...)
+	if err != nil {
+		return RetEventoNFe{}, soap, err
+	}
+
+	ret, xmlfile, err := readRetEnvEvento(Endpoint{CUF: 91, TpAmb: epec.TpAmb, Servico: Evento}, soap)
+	if err != nil {
+		return RetEventoNFe{}, xmlfile, err
+	}
+	if len(ret.RetEvento) == 0 {
+		return RetEventoNFe{}, xmlfile, fmt.Errorf("Lote de eventos rejeitado: %d - %s", ret.CStat, ret.XMotivo)
+	}
+
+	retEvento := ret.RetEvento[0]
+	switch retEvento.InfEvento.CStat {
+	case cStatEventoVinculado, cStatEventoNaoVinculado:
+		return retEvento, xmlfile, nil
+	}
+	return retEvento, xmlfile, fmt.Errorf("EPEC rejeitado: %d - %s", retEvento.InfEvento.CStat, retEvento.InfEvento.XMotivo)
+}
+
+// readRetEnvEvento extrai e desserializa o retEnvEvento de um envelope SOAP de retorno da recepção de eventos do WebService informado. O XML retornado é o recebido, sem as correções do DefaultNormalizador, que valem apenas para a desserialização (ver desenvelopaNormalizado).
+func readRetEnvEvento(ep Endpoint, soap []byte) (RetEnvEvento, []byte, error) {
+	xmlfile, normalizado, err := desenvelopaNormalizado(ep, soap)
+	if err != nil {
+		return RetEnvEvento{}, nil, err
+	}
+
+	var ret RetEnvEvento
+	if err := xml.Unmarshal(normalizado, &ret); err != nil {
+		return RetEnvEvento{}, xmlfile, fmt.Errorf("Erro na desserialização do arquivo XML: %w. Arquivo: %s", err, xmlfile)
+	}
+	return ret, xmlfile, nil
+}
+
+// Divergencias compara os dados do EPEC com os da NFe que será transmitida, retornando a lista de campos divergentes. A Sefaz rejeita a NFe (cStat 467) se houver divergência, de maneira que a verificação deve ser feita antes da transmissão.
+func (e EPEC) Divergencias(inf InfNFe) []string {
+	var div []string
+	cmp := func(campo string, epec, nfe string) {
+		if strings.TrimSpace(epec) != strings.TrimSpace(nfe) {
+			div = append(div, fmt.Sprintf("%s: EPEC=%q NFe=%q", campo, epec, nfe))
+		}
+	}
+	cmpValor := func(campo string, epec, nfe float64) {
+		if math.Abs(epec-nfe) >= 0.005 {
+			div = append(div, fmt.Sprintf("%s: EPEC=%.2f NFe=%.2f", campo, epec, nfe))
+		}
+	}
+
+	if id := strings.TrimPrefix(inf.Id, "NFe"); id != e.ChNFe {
+		cmp("chNFe", e.ChNFe, id)
+	}
+	if dhEmi, err := time.Parse(time.RFC3339, inf.Ide.DhEmi); err != nil || !dhEmi.Equal(e.DhEmi.Truncate(time.Second)) {
+		cmp("dhEmi", e.DhEmi.Format(layoutDataHora), inf.Ide.DhEmi)
+	}
+	cmp("tpNF", strconv.Itoa(e.TpNF), strconv.Itoa(inf.Ide.TpNF))
+	cmp("IE", e.IE, inf.Emit.IE)
+	cmp("dest/UF", e.Dest.UF, inf.Dest.EnderDest.UF)
+	cmp("dest/CNPJ", e.Dest.CNPJ, inf.Dest.CNPJ)
+	cmp("dest/CPF", e.Dest.CPF, inf.Dest.CPF)
+	cmp("dest/IE", e.Dest.IE, inf.Dest.IE)
+	cmpValor("vNF", e.Dest.VNF, inf.Total.ICMSTot.VNF)
+	cmpValor("vICMS", e.Dest.VICMS, inf.Total.ICMSTot.VICMS)
+	cmpValor("vST", e.Dest.VST, inf.Total.ICMSTot.VST)
+
+	return div
+}
+
+// ConciliacaoEPEC representa o resultado da conciliação de uma NFe emitida em EPEC, após a sua transmissão.
+type ConciliacaoEPEC struct {
+	ChNFe      string
+	Autorizada bool   // a NFe foi autorizada (cStat 100/150)
+	Vinculada  bool   // o EPEC aparece entre os eventos da NFe
+	NProt      string // protocolo de autorização da NFe
+	NProtEPEC  string // protocolo do EPEC
+	CStat      int
+	XMotivo    string
+}
+
+// ConciliaEPEC verifica, a partir da consulta da NFe (ConsultaNFe) transmitida após a contingência EPEC, se a nota foi autorizada e se o EPEC registrado (identificado pelo seu protocolo) foi vinculado a ela.
+func ConciliaEPEC(nProtEPEC string, ret RetConsSitNFe) ConciliacaoEPEC {
+	c := ConciliacaoEPEC{
+		ChNFe:     ret.ChNFe,
+		NProtEPEC: nProtEPEC,
+		CStat:     ret.CStat,
+		XMotivo:   ret.XMotivo,
+	}
+
+	if ret.ProtNFe != nil {
+		c.CStat = ret.ProtNFe.InfProt.CStat
+		c.XMotivo = ret.ProtNFe.InfProt.XMotivo
+		c.NProt = ret.ProtNFe.InfProt.NProt
+		c.Autorizada = c.CStat == 100 || c.CStat == 150
+	}
+
+	if ret.ProcEventoNFe != nil {
+		for _, proc := range *ret.ProcEventoNFe {
+			if proc.RetEvento == nil || proc.RetEvento.InfEvento.TpEvento != TpEventoEPEC {
+				continue
+			}
+			if nProtEPEC == "" || proc.RetEvento.InfEvento.NProt == nProtEPEC {
+				c.Vinculada = true
+				c.NProtEPEC = proc.RetEvento.InfEvento.NProt
+			}
+		}
+	}
+
+	return c
+}
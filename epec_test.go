@@ -0,0 +1,246 @@
+package nfe
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/beevik/etree"
+)
+
+// chaveEPEC é a chave de acesso de uma NFe de SP em EPEC (tpEmis 4), com o dígito verificador calculado fora da biblioteca.
+const chaveEPEC = "35240511222333000181550010000000014123456787"
+
+func epecTeste() EPEC {
+	e := EPEC{
+		TpAmb:       Homologacao,
+		CNPJ:        "11222333000181",
+		ChNFe:       chaveEPEC,
+		DhEvento:    time.Date(2024, 5, 17, 13, 31, 2, 0, time.UTC),
+		COrgaoAutor: 35,
+		VerAplic:    "app-1.0",
+		DhEmi:       time.Date(2024, 5, 17, 10, 30, 0, 0, time.FixedZone("BRT", -3*3600)),
+		TpNF:        1,
+		IE:          "111222333444",
+	}
+	e.Dest.UF = "RJ"
+	e.Dest.CNPJ = "99888777000166"
+	e.Dest.IE = "12345678"
+	e.Dest.VNF = 1500.5
+	e.Dest.VICMS = 180.06
+	return e
+}
+
+func TestMontaChaveDeAcesso(t *testing.T) {
+	casos := []struct {
+		cUF, ano, mes      int
+		cnpj, mod          string
+		serie, nNF, tpEmis int
+		cNF                int
+		esperado           string
+	}{
+		{35, 24, 5, "11222333000181", "55", 1, 1, 4, 12345678, "35240511222333000181550010000000014123456787"},
+		{41, 24, 5, "11222333000181", "55", 999, 999999999, 4, 99999999, "41240511222333000181559999999999994999999995"},
+		{35, 24, 5, "11222333000181", "65", 1, 123, 9, 12345678, "35240511222333000181650010000001239123456783"},
+	}
+	for _, c := range casos {
+		chave, err := MontaChaveDeAcesso(c.cUF, c.ano, c.mes, c.cnpj, c.mod, c.serie, c.nNF, c.tpEmis, c.cNF)
+		if err != nil || chave != c.esperado || !ValidaChaveDeAcesso(chave) {
+			t.Errorf("MontaChaveDeAcesso = %s, %v; esperado %s", chave, err, c.esperado)
+		}
+	}
+
+	// Exemplo do cálculo do dígito verificador do Manual de Orientação do Contribuinte (soma ponderada 644, resto 6, DV 5).
+	if !ValidaChaveDeAcesso("52060433009911002506550120000007800267301615") {
+		t.Errorf("chave de acesso do exemplo do Manual não validada")
+	}
+	if ValidaChaveDeAcesso("52060433009911002506550120000007800267301614") {
+		t.Errorf("chave de acesso com DV errado validada")
+	}
+
+	if _, err := MontaChaveDeAcesso(35, 24, 5, "11222333000181", "55", 1, 1, 0, 12345678); err == nil {
+		t.Errorf("MontaChaveDeAcesso com tpEmis 0 deveria falhar")
+	}
+}
+
+func TestEPECDetEvento(t *testing.T) {
+	ev := epecTeste().toManifestacaoEvento()
+	if ev.COrgao != 91 || ev.TpEvento != TpEventoEPEC || ev.NSeqEvento != 1 || ev.VerEvento != verEventoEPEC {
+		t.Errorf("toManifestacaoEvento = %+v", ev)
+	}
+
+	det := etree.NewDocument().CreateElement("detEvento")
+	ev.detEvento(det)
+	doc := etree.NewDocument()
+	doc.SetRoot(det)
+	got, err := doc.WriteToString()
+	if err != nil {
+		t.Fatal(err)
+	}
+	esperado := `<detEvento><descEvento>EPEC</descEvento><cOrgaoAutor>35</cOrgaoAutor><tpAutor>1</tpAutor><verAplic>app-1.0</verAplic><dhEmi>2024-05-17T10:30:00-03:00</dhEmi><tpNF>1</tpNF><IE>111222333444</IE>` +
+		`<dest><UF>RJ</UF><CNPJ>99888777000166</CNPJ><IE>12345678</IE><vNF>1500.50</vNF><vICMS>180.06</vICMS><vST>0.00</vST></dest></detEvento>`
+	if got != esperado {
+		t.Errorf("detEvento =\n%s\nesperado\n%s", got, esperado)
+	}
+
+	// Em UTC, o fuso é informado como +00:00, e não como Z.
+	e := epecTeste()
+	e.DhEmi = e.DhEmi.UTC()
+	e.Dest.CNPJ = ""
+	e.Dest.IdEstrangeiro = "A1B2C3"
+	e.Dest.IE = ""
+	det = etree.NewDocument().CreateElement("detEvento")
+	e.toManifestacaoEvento().detEvento(det)
+	if dhEmi := det.SelectElement("dhEmi").Text(); dhEmi != "2024-05-17T13:30:00+00:00" {
+		t.Errorf("dhEmi em UTC = %s", dhEmi)
+	}
+	if det.FindElement("dest/idEstrangeiro") == nil || det.FindElement("dest/IE") != nil {
+		t.Errorf("dest de destinatário estrangeiro sem idEstrangeiro ou com IE vazia")
+	}
+}
+
+func TestEPECEnvEvento(t *testing.T) {
+	cert, key := certificadoTeste(t, "EMITENTE LTDA:11222333000181")
+	doc, err := buildEnvEventoDoc("1", []ManifestacaoEvento{epecTeste().toManifestacaoEvento()}, cert, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	inf := doc.FindElement("//infEvento")
+	if id := inf.SelectAttrValue("Id", ""); id != "ID110140"+chaveEPEC+"01" {
+		t.Errorf("Id = %s", id)
+	}
+	if dh := inf.SelectElement("dhEvento").Text(); dh != "2024-05-17T13:31:02+00:00" {
+		t.Errorf("dhEvento = %s", dh)
+	}
+	if inf.FindElement("detEvento/dest/vNF") == nil || doc.FindElement("//evento/Signature") == nil {
+		t.Errorf("envEvento sem o detEvento do EPEC ou sem assinatura")
+	}
+}
+
+func TestEPECValida(t *testing.T) {
+	outra := func(tpEmis int, mod string) string {
+		chave, err := MontaChaveDeAcesso(35, 24, 5, "11222333000181", mod, 1, 1, tpEmis, 12345678)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return chave
+	}
+	casos := []struct {
+		nome   string
+		altera func(e *EPEC)
+		valido bool
+	}{
+		{"válido", func(e *EPEC) {}, true},
+		{"destinatário por CPF", func(e *EPEC) { e.Dest.CNPJ, e.Dest.CPF = "", "12345678909" }, true},
+		{"chave inválida", func(e *EPEC) { e.ChNFe = chaveEPEC[:43] + "0" }, false},
+		{"NFC-e", func(e *EPEC) { e.ChNFe = outra(TpEmisEPEC, "65") }, false},
+		{"tpEmis normal", func(e *EPEC) { e.ChNFe = outra(TpEmisNormal, "55") }, false},
+		{"sem UF do destinatário", func(e *EPEC) { e.Dest.UF = "" }, false},
+		{"sem identificação do destinatário", func(e *EPEC) { e.Dest.CNPJ = "" }, false},
+	}
+	for _, c := range casos {
+		e := epecTeste()
+		c.altera(&e)
+		if err := e.valida(); (err == nil) != c.valido {
+			t.Errorf("%s: valida = %v", c.nome, err)
+		}
+	}
+}
+
+func TestEPECDivergencias(t *testing.T) {
+	nfe := func() InfNFe {
+		var inf InfNFe
+		inf.Id = "NFe" + chaveEPEC
+		inf.Ide.DhEmi = "2024-05-17T10:30:00-03:00"
+		inf.Ide.TpNF = 1
+		inf.Emit.IE = "111222333444"
+		inf.Dest.EnderDest.UF = "RJ"
+		inf.Dest.CNPJ = "99888777000166"
+		inf.Dest.IE = "12345678"
+		inf.Total.ICMSTot.VNF = 1500.5
+		inf.Total.ICMSTot.VICMS = 180.06
+		return inf
+	}
+
+	if div := epecTeste().Divergencias(nfe()); len(div) != 0 {
+		t.Errorf("Divergencias da mesma NFe = %v", div)
+	}
+
+	// O mesmo instante em outro fuso não é divergência.
+	inf := nfe()
+	inf.Ide.DhEmi = "2024-05-17T13:30:00+00:00"
+	if div := epecTeste().Divergencias(inf); len(div) != 0 {
+		t.Errorf("Divergencias com dhEmi em UTC = %v", div)
+	}
+
+	inf = nfe()
+	inf.Id = "NFe" + chaveEPEC[:43] + "0"
+	inf.Ide.DhEmi = "2024-05-17T10:31:00-03:00"
+	inf.Dest.EnderDest.UF = "SP"
+	inf.Total.ICMSTot.VNF = 1500.51
+	inf.Total.ICMSTot.VST = 0.004
+	div := epecTeste().Divergencias(inf)
+	campos := []string{}
+	for _, d := range div {
+		campos = append(campos, d[:strings.Index(d, ":")])
+	}
+	if got := strings.Join(campos, " "); got != "chNFe dhEmi dest/UF vNF" {
+		t.Errorf("Divergencias = %v", div)
+	}
+	if !strings.Contains(div[1], `EPEC="2024-05-17T10:30:00-03:00"`) {
+		t.Errorf("dhEmi do EPEC na divergência = %s", div[1])
+	}
+}
+
+func TestConciliaEPEC(t *testing.T) {
+	retEPEC := func(nProt string) ProcEventoNFe {
+		r := &RetEventoNFe{}
+		r.InfEvento.TpEvento = TpEventoEPEC
+		r.InfEvento.NProt = nProt
+		return ProcEventoNFe{RetEvento: r}
+	}
+	cancelamento := func() ProcEventoNFe {
+		r := &RetEventoNFe{}
+		r.InfEvento.TpEvento = "110111"
+		r.InfEvento.NProt = "135240000000099"
+		return ProcEventoNFe{RetEvento: r}
+	}
+	consulta := func(cStat int, eventos ...ProcEventoNFe) RetConsSitNFe {
+		ret := RetConsSitNFe{ChNFe: chaveEPEC, CStat: cStat, XMotivo: "Motivo da consulta"}
+		if cStat != 217 {
+			ret.ProtNFe = &ProtNFe{}
+			ret.ProtNFe.InfProt.CStat = cStat
+			ret.ProtNFe.InfProt.XMotivo = "Motivo do protocolo"
+			ret.ProtNFe.InfProt.NProt = "135240000000001"
+		}
+		if len(eventos) > 0 {
+			ret.ProcEventoNFe = &eventos
+		}
+		return ret
+	}
+
+	casos := []struct {
+		nome       string
+		nProtEPEC  string
+		ret        RetConsSitNFe
+		autorizada bool
+		vinculada  bool
+		nProt      string
+		cStat      int
+	}{
+		{"autorizada e vinculada", "891240000000011", consulta(100, cancelamento(), retEPEC("891240000000011")), true, true, "135240000000001", 100},
+		{"autorizada sem o EPEC", "891240000000011", consulta(100, retEPEC("891240000000012")), true, false, "135240000000001", 100},
+		{"vinculada a qualquer EPEC", "", consulta(150, retEPEC("891240000000012")), true, true, "135240000000001", 150},
+		{"denegada", "891240000000011", consulta(302, retEPEC("891240000000011")), false, true, "135240000000001", 302},
+		{"não transmitida", "891240000000011", consulta(217), false, false, "", 217},
+	}
+	for _, c := range casos {
+		r := ConciliaEPEC(c.nProtEPEC, c.ret)
+		if r.ChNFe != chaveEPEC || r.Autorizada != c.autorizada || r.Vinculada != c.vinculada || r.NProt != c.nProt || r.CStat != c.cStat {
+			t.Errorf("%s: ConciliaEPEC = %+v", c.nome, r)
+		}
+		if c.vinculada && r.NProtEPEC == "" {
+			t.Errorf("%s: NProtEPEC vazio", c.nome)
+		}
+	}
+}
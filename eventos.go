@@ -2,11 +2,23 @@ package nfe
 
 import (
 	"encoding/xml"
+	"fmt"
 	"time"
 
 	"github.com/amdonov/xmlsig"
 )
 
+// Tipos de evento (tpEvento) mais comuns. O EPEC tem as suas próprias definições (TpEventoEPEC).
+const (
+	TpEventoCCe                      = "110110"
+	TpEventoCancelamento             = "110111"
+	TpEventoCancelamentoSubstituicao = "110112"
+	TpEventoConfirmacaoOperacao      = "210200"
+	TpEventoCienciaOperacao          = "210210"
+	TpEventoDesconhecimentoOperacao  = "210220"
+	TpEventoOperacaoNaoRealizada     = "210240"
+)
+
 // ProcEventoNFe representa o XML que contem tanto a requisição (EventoNFe) quanto o retorno da Sefaz (RetEventoNFe), e poderá vir dentro de consultas de status (ConsSitNFe).
 type ProcEventoNFe struct {
 	XMLName   xml.Name      `json:"-" xml:"procEventoNFe"`
@@ -42,20 +54,52 @@ type EventoNFe struct {
 type RetEventoNFe struct {
 	Versao    string `json:"versao" xml:"versao,attr"`
 	InfEvento struct {
-		ID          string    `json:"Id" xml:"Id,attr,omitempty"`
-		TpAmb       TAmb      `json:"tpAmb" xml:"tpAmb"`
-		VerAplic    string    `json:"verAplic" xml:"verAplic"`
-		COrgao      int       `json:"cOrgao" xml:"cOrgao"`
-		CStat       int       `json:"cStat" xml:"cStat"`
-		XMotivo     string    `json:"xMotivo" xml:"xMotivo"`
-		ChNFe       string    `json:"chNFe" xml:"chNFe"`
-		TpEvento    string    `json:"tpEvento" xml:"tpEvento"`
-		XEvento     string    `json:"xEvento" xml:"xEvento"`
-		NSeqEvento  int       `json:"nSeqEvento" xml:"nSeqEvento"`
-		CNPJDest    string    `json:"CNPJDest,omitempty" xml:"CNPJDest,omitempty"`
-		CPFDest     string    `json:"CPFDest,omitempty" xml:"CPFDest,omitempty"`
-		EmailDest   string    `json:"emailDest,omitempty" xml:"emailDest,omitempty"`
-		DhRegEvento time.Time `json:"dhRegEvento" xml:"dhRegEvento"`
-		NProt       string    `json:"nProt" xml:"nProt"`
+		ID          string   `json:"Id" xml:"Id,attr,omitempty"`
+		TpAmb       TAmb     `json:"tpAmb" xml:"tpAmb"`
+		VerAplic    string   `json:"verAplic" xml:"verAplic"`
+		COrgao      int      `json:"cOrgao" xml:"cOrgao"`
+		CStat       int      `json:"cStat" xml:"cStat"`
+		XMotivo     string   `json:"xMotivo" xml:"xMotivo"`
+		ChNFe       string   `json:"chNFe" xml:"chNFe"`
+		TpEvento    string   `json:"tpEvento" xml:"tpEvento"`
+		XEvento     string   `json:"xEvento" xml:"xEvento"`
+		NSeqEvento  int      `json:"nSeqEvento" xml:"nSeqEvento"`
+		CNPJDest    string   `json:"CNPJDest,omitempty" xml:"CNPJDest,omitempty"`
+		CPFDest     string   `json:"CPFDest,omitempty" xml:"CPFDest,omitempty"`
+		EmailDest   string   `json:"emailDest,omitempty" xml:"emailDest,omitempty"`
+		DhRegEvento DateTime `json:"dhRegEvento" xml:"dhRegEvento"`
+		NProt       string   `json:"nProt" xml:"nProt"`
 	} `json:"infEvento" xml:"infEvento"`
 }
+
+// RetEnvEvento representa o XML de retorno da Sefaz ao envio de um lote de eventos (envEvento), com o retorno individual de cada evento.
+type RetEnvEvento struct {
+	XMLName   xml.Name       `json:"-" xml:"http://www.portalfiscal.inf.br/nfe retEnvEvento"`
+	Versao    string         `json:"versao" xml:"versao,attr"`
+	IdLote    string         `json:"idLote" xml:"idLote"`
+	TpAmb     TAmb           `json:"tpAmb" xml:"tpAmb"`
+	VerAplic  string         `json:"verAplic" xml:"verAplic"`
+	COrgao    int            `json:"cOrgao" xml:"cOrgao"`
+	CStat     int            `json:"cStat" xml:"cStat"`
+	XMotivo   string         `json:"xMotivo" xml:"xMotivo"`
+	RetEvento []RetEventoNFe `json:"retEvento,omitempty" xml:"retEvento,omitempty"`
+}
+
+// DetEvento contém os campos do detEvento dos eventos mais comuns: CC-e (xCorrecao e xCondUso), cancelamento (nProt, xJust e, na substituição, chNFeRef) e manifestação do destinatário (xJust na operação não realizada).
+type DetEvento struct {
+	DescEvento string `json:"descEvento" xml:"descEvento"`
+	XCorrecao  string `json:"xCorrecao,omitempty" xml:"xCorrecao"`
+	XCondUso   string `json:"xCondUso,omitempty" xml:"xCondUso"`
+	NProt      string `json:"nProt,omitempty" xml:"nProt"`
+	XJust      string `json:"xJust,omitempty" xml:"xJust"`
+	ChNFeRef   string `json:"chNFeRef,omitempty" xml:"chNFeRef"`
+}
+
+// Detalhe decodifica o detEvento, que é mantido como XML bruto no EventoNFe.
+func (e EventoNFe) Detalhe() (DetEvento, error) {
+	var det DetEvento
+	if err := xml.Unmarshal(append(append([]byte("<detEvento>"), e.InfEvento.DetEvento.Value...), "</detEvento>"...), &det); err != nil {
+		return det, fmt.Errorf("Erro na desserialização do detEvento: %w", err)
+	}
+	return det, nil
+}
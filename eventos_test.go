@@ -0,0 +1,44 @@
+package nfe
+
+import (
+	"testing"
+)
+
+func TestRetEnvEvento(t *testing.T) {
+	testes := []struct {
+		arquivo  string
+		cOrgao   int
+		cStat    int
+		tpEvento string
+		nProt    string
+	}{
+		{"SP/Evento.xml", 35, 135, "110111", "135240000000002"},
+		{"AN/Evento.xml", 91, 135, "210200", "891240000000010"},
+		// EPEC registrado, mas ainda não vinculado a NF-e
+		{"AN/Evento-EPEC.xml", 91, 136, "110140", "891240000000011"},
+	}
+	for _, tt := range testes {
+		t.Run(tt.arquivo, func(t *testing.T) {
+			ret, _, err := readRetEnvEvento(Endpoint{CUF: tt.cOrgao, Servico: Evento}, fixtureSefaz(t, tt.arquivo))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if ret.CStat != 128 || ret.COrgao != tt.cOrgao || len(ret.RetEvento) != 1 {
+				t.Fatalf("retorno inesperado: %+v", ret)
+			}
+			inf := ret.RetEvento[0].InfEvento
+			if inf.CStat != tt.cStat || inf.TpEvento != tt.tpEvento || inf.NProt != tt.nProt || inf.DhRegEvento.IsZero() {
+				t.Errorf("retEvento inesperado: %+v", inf)
+			}
+		})
+	}
+}
+
+func FuzzRetEnvEvento(f *testing.F) {
+	for _, b := range fixturesSefaz(f, "Evento") {
+		f.Add(b)
+	}
+	f.Fuzz(func(t *testing.T, data []byte) {
+		readRetEnvEvento(Endpoint{CUF: 91, Servico: Evento}, data)
+	})
+}
@@ -1,9 +1,11 @@
-package nfe
+package nfe_test
 
 import (
 	"encoding/json"
 	"fmt"
 	"net/http"
+
+	"github.com/eduardotorresdev/nfe"
 )
 
 // Esse exemplo mostra todos os passos para se fazer uma consulta de protocolo na Sefaz. Desde a criação de um novo http.Client (através da NewHTTPClient) até a personalização do User-Agent por meio do parâmetro optReq.
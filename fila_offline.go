@@ -0,0 +1,379 @@
+package nfe
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// PrazoTransmissaoOffline é o prazo padrão para a transmissão de uma NFC-e emitida em contingência offline, contado a partir da emissão.
+const PrazoTransmissaoOffline = 24 * time.Hour
+
+// Situação de uma NFC-e na fila de contingência offline.
+const (
+	OfflinePendente   = "pendente"   // aguardando transmissão
+	OfflineAutorizada = "autorizada" // autorizada (inclusive quando já havia sido autorizada antes, cStat 204)
+	OfflineDenegada   = "denegada"   // uso denegado (cStat 110, 301, 302 ou 303), com o protocolo
+	OfflineRejeitada  = "rejeitada"  // rejeitada pela Sefaz; deve ser corrigida e emitida novamente
+	OfflineDuplicada  = "duplicada"  // já existe outra NFC-e com o mesmo número e série (cStat 539), ou a mesma chave foi autorizada com outro conteúdo (204); ver DuplicidadeError
+)
+
+// NFCeOffline representa uma NFC-e emitida em contingência offline (tpEmis 9), armazenada até a sua transmissão.
+type NFCeOffline struct {
+	ChNFe string    `json:"chNFe"`
+	TpAmb TAmb      `json:"tpAmb"`
+	DhEmi time.Time `json:"dhEmi"`
+	XML   []byte    `json:"xml"` // NFC-e assinada, com o infNFeSupl
+
+	Situacao      string    `json:"situacao"`
+	Tentativas    int       `json:"tentativas"`
+	UltimoEnvio   time.Time `json:"ultimoEnvio,omitempty"`
+	CStat         int       `json:"cStat,omitempty"`
+	XMotivo       string    `json:"xMotivo,omitempty"`
+	Erro          string    `json:"erro,omitempty"`
+	ProtNFe       *ProtNFe  `json:"protNFe,omitempty"`
+	ChNFeOriginal string    `json:"chNFeOriginal,omitempty"` // chave da NFC-e já existente, no caso de duplicidade
+}
+
+// NovaNFCeOffline prepara uma NFC-e assinada para ser armazenada na fila, verificando se ela é de fato uma NFC-e (modelo 65) emitida em contingência offline (tpEmis 9).
+func NovaNFCeOffline(xmlNFCe []byte) (NFCeOffline, error) {
+	nfe, err := lerNFeXML(xmlNFCe)
+	if err != nil {
+		return NFCeOffline{}, err
+	}
+	info, err := qrCodeFromXML(nfe)
+	if err != nil {
+		return NFCeOffline{}, err
+	}
+
+	_, _, _, _, mod, _, _, tpEmis, _, err := GetChaveInfo(info.ChNFe)
+	if err != nil {
+		return NFCeOffline{}, err
+	}
+	if mod != "65" || tpEmis != strconv.Itoa(TpEmisOfflineNFCe) {
+		return NFCeOffline{}, fmt.Errorf("A fila de contingência offline só aceita NFC-e com tpEmis 9: %s", info.ChNFe)
+	}
+
+	return NFCeOffline{
+		ChNFe:    info.ChNFe,
+		TpAmb:    info.TpAmb,
+		DhEmi:    info.DhEmi,
+		XML:      xmlNFCe,
+		Situacao: OfflinePendente,
+	}, nil
+}
+
+// Vencimento retorna o limite para a transmissão da NFC-e, considerando o prazo informado.
+func (n NFCeOffline) Vencimento(prazo time.Duration) time.Time {
+	return n.DhEmi.Add(prazo)
+}
+
+// FilaOffline é o armazenamento durável das NFC-e emitidas em contingência offline. As implementações devem garantir que uma NFC-e adicionada não se perca, mesmo com a interrupção do programa.
+type FilaOffline interface {
+	// Salva inclui ou atualiza a NFC-e na fila.
+	Salva(n NFCeOffline) error
+	// Lista retorna as NFC-e da fila, em ordem de emissão.
+	Lista() ([]NFCeOffline, error)
+	// Remove exclui a NFC-e da fila.
+	Remove(chNFe string) error
+}
+
+// FilaOfflineArquivo é uma FilaOffline que armazena cada NFC-e em um arquivo JSON em um diretório. A gravação é feita em um arquivo temporário, renomeado em seguida, de maneira que uma interrupção não deixa registros corrompidos.
+type FilaOfflineArquivo struct {
+	dir string
+	mu  sync.Mutex
+}
+
+// NewFilaOfflineArquivo cria (se necessário) o diretório da fila e retorna a FilaOfflineArquivo.
+func NewFilaOfflineArquivo(dir string) (*FilaOfflineArquivo, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, fmt.Errorf("Erro na criação do diretório da fila offline (%s). Detalhes: %w", dir, err)
+	}
+	return &FilaOfflineArquivo{dir: dir}, nil
+}
+
+// arquivoChave retorna o nome do arquivo JSON da chave de acesso. A chave é validada antes (ver ValidaChaveDeAcesso), para que um valor como "../x" não leve a um arquivo fora do diretório.
+func arquivoChave(chNFe string) (string, error) {
+	if !ValidaChaveDeAcesso(chNFe) {
+		return "", fmt.Errorf("Chave de Acesso inválida: %s", chNFe)
+	}
+	return chNFe + ".json", nil
+}
+
+func (f *FilaOfflineArquivo) Salva(n NFCeOffline) error {
+	nome, err := arquivoChave(n.ChNFe)
+	if err != nil {
+		return err
+	}
+	b, err := json.Marshal(n)
+	if err != nil {
+		return err
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if err := gravaArquivo(f.dir, nome, b); err != nil {
+		return fmt.Errorf("Erro na gravação da fila offline. Detalhes: %w", err)
+	}
+	return nil
+}
+
+// gravaArquivo grava o conteúdo em um arquivo temporário no diretório e o renomeia para o nome informado, de maneira que uma interrupção não deixa o arquivo incompleto.
+func gravaArquivo(dir, nome string, b []byte) error {
+	tmp, err := os.CreateTemp(dir, nome+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(b); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), filepath.Join(dir, nome))
+}
+
+func (f *FilaOfflineArquivo) Lista() ([]NFCeOffline, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	arquivos, err := filepath.Glob(filepath.Join(f.dir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+	notas := make([]NFCeOffline, 0, len(arquivos))
+	for _, arq := range arquivos {
+		b, err := os.ReadFile(arq)
+		if err != nil {
+			return nil, fmt.Errorf("Erro na leitura da fila offline (%s). Detalhes: %w", arq, err)
+		}
+		var n NFCeOffline
+		if err := json.Unmarshal(b, &n); err != nil {
+			return nil, fmt.Errorf("Erro na leitura da fila offline (%s). Detalhes: %w", arq, err)
+		}
+		notas = append(notas, n)
+	}
+
+	sort.SliceStable(notas, func(i, j int) bool { return notas[i].DhEmi.Before(notas[j].DhEmi) })
+	return notas, nil
+}
+
+func (f *FilaOfflineArquivo) Remove(chNFe string) error {
+	nome, err := arquivoChave(chNFe)
+	if err != nil {
+		return err
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if err := os.Remove(filepath.Join(f.dir, nome)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("Erro na remoção da NFC-e %s da fila offline. Detalhes: %w", chNFe, err)
+	}
+	return nil
+}
+
+// reChNFeDuplicada extrai a chave da NFC-e já existente do xMotivo da rejeição 539 ("... [chNFe:3524...]").
+var reChNFeDuplicada = regexp.MustCompile(`chNFe:\s*(\d{44})`)
+
+// TransmissorOffline transmite as NFC-e pendentes de uma FilaOffline quando o autorizador volta a responder.
+type TransmissorOffline struct {
+	Fila   FilaOffline
+	Client *http.Client
+	OptReq []func(req *http.Request)
+
+	// Prazo para a transmissão, contado a partir da emissão. Zero equivale a PrazoTransmissaoOffline.
+	Prazo time.Duration
+
+	// Autoriza e Consulta permitem substituir a autorização (AutorizaNFe) e a consulta do protocolo (ConsultaNFe), por exemplo para aplicar um RateLimiter.
+	Autoriza func(xmlNFe []byte, client *http.Client, optReq ...func(req *http.Request)) (RetEnviNFe, []byte, error)
+	Consulta func(chNFe string, tpAmb TAmb, client *http.Client, optReq ...func(req *http.Request)) (RetConsSitNFe, []byte, error)
+
+	// OnResultado, se informada, é chamada após o processamento de cada NFC-e.
+	OnResultado func(n NFCeOffline)
+
+	now func() time.Time
+}
+
+func (t *TransmissorOffline) prazo() time.Duration {
+	if t.Prazo <= 0 {
+		return PrazoTransmissaoOffline
+	}
+	return t.Prazo
+}
+
+func (t *TransmissorOffline) agora() time.Time {
+	if t.now != nil {
+		return t.now()
+	}
+	return time.Now()
+}
+
+// Transmite envia as NFC-e pendentes da fila, em ordem de emissão, atualizando a situação de cada uma: autorizada ou denegada (inclusive na duplicidade 204, confirmada pela consulta do protocolo), rejeitada ou duplicada (539, ou 204 com outro conteúdo; ver RecuperaDuplicidade). Se o autorizador estiver indisponível, a transmissão é interrompida e as notas restantes permanecem pendentes; o erro de comunicação é retornado.
+//
+// As NFC-e processadas permanecem na fila, com a nova situação, até serem removidas (ver FilaOffline.Remove).
+func (t *TransmissorOffline) Transmite(ctx context.Context) ([]NFCeOffline, error) {
+	notas, err := t.Fila.Lista()
+	if err != nil {
+		return nil, err
+	}
+
+	var processadas []NFCeOffline
+	for _, n := range notas {
+		if n.Situacao != OfflinePendente {
+			continue
+		}
+		if err := ctx.Err(); err != nil {
+			return processadas, err
+		}
+
+		n, errEnvio := t.transmite(n)
+		if err := t.Fila.Salva(n); err != nil {
+			return processadas, err
+		}
+		if n.Situacao != OfflinePendente && t.OnResultado != nil {
+			t.OnResultado(n)
+		}
+		processadas = append(processadas, n)
+
+		if errEnvio != nil && isFalhaIndisponibilidade(errEnvio) {
+			return processadas, errEnvio
+		}
+	}
+
+	return processadas, nil
+}
+
+// transmite envia uma NFC-e e interpreta o retorno. O erro é retornado apenas nas falhas de comunicação.
+func (t *TransmissorOffline) transmite(n NFCeOffline) (NFCeOffline, error) {
+	autoriza := t.Autoriza
+	if autoriza == nil {
+		autoriza = AutorizaNFe
+	}
+
+	n.Tentativas++
+	n.UltimoEnvio = t.agora()
+	n.Erro = ""
+
+	ret, _, err := autoriza(n.XML, t.Client, t.OptReq...)
+	if err != nil {
+		n.Erro = err.Error()
+		return n, err
+	}
+
+	n.CStat, n.XMotivo = ret.CStat, ret.XMotivo
+	if ret.ProtNFe == nil {
+		// Lote não processado (ex.: 103, recebido para processamento assíncrono, ou rejeição do lote): a nota continua pendente.
+		return n, nil
+	}
+
+	prot := ret.ProtNFe.InfProt
+	n.CStat, n.XMotivo = prot.CStat, prot.XMotivo
+	switch {
+	case cStatProtNFe[prot.CStat]:
+		n.Situacao = situacaoOffline(prot.CStat)
+		n.ProtNFe = ret.ProtNFe
+	case prot.CStat == cStatDuplicidade || prot.CStat == cStatDuplicidadeChaveDiversa:
+		return t.confirmaDuplicidade(n, *ret.ProtNFe)
+	default:
+		n.Situacao = OfflineRejeitada
+	}
+	return n, nil
+}
+
+// confirmaDuplicidade trata as rejeições 204 (a NFC-e já foi autorizada, por exemplo em uma transmissão cuja resposta se perdeu) e 539 (outra NFC-e foi autorizada com o mesmo número e série) com a RecuperaDuplicidade, que consulta a NFC-e autorizada e compara o digVal do protocolo ao DigestValue da NFC-e transmitida.
+func (t *TransmissorOffline) confirmaDuplicidade(n NFCeOffline, prot ProtNFe) (NFCeOffline, error) {
+	consulta := t.Consulta
+	if consulta == nil {
+		consulta = ConsultaNFe
+	}
+
+	ret, _, err := recuperaDuplicidade(n.XML, prot, n.TpAmb, consulta, t.Client, t.OptReq...)
+	var dup *DuplicidadeError
+	switch {
+	case err == nil:
+		n.Situacao = situacaoOffline(ret.ProtNFe.InfProt.CStat)
+		n.ProtNFe = ret.ProtNFe
+		n.CStat, n.XMotivo = ret.ProtNFe.InfProt.CStat, ret.ProtNFe.InfProt.XMotivo
+		return n, nil
+	case errors.As(err, &dup):
+		n.Situacao = OfflineDuplicada
+		n.ChNFeOriginal = dup.ChNFeOriginal
+	case prot.InfProt.CStat == cStatDuplicidadeChaveDiversa:
+		// A NFC-e já autorizada não pôde ser consultada: a duplicidade é registrada com a chave informada na rejeição.
+		n.Situacao = OfflineDuplicada
+		if m := reChNFeDuplicada.FindStringSubmatch(prot.InfProt.XMotivo); m != nil {
+			n.ChNFeOriginal = m[1]
+		}
+	case ret.CStat == 0, errors.Is(err, ErrDigValAusente):
+		// Falha de comunicação na consulta, ou protocolo sem digVal, que não permite saber se a NFC-e autorizada é a transmitida: a NFC-e continua pendente.
+		n.Erro = err.Error()
+		return n, err
+	default:
+		n.Situacao = OfflineRejeitada
+	}
+	n.Erro = err.Error()
+	return n, nil
+}
+
+// situacaoOffline retorna a situação da NFC-e com o protocolo de autorização ou de denegação (ver cStatProtNFe).
+func situacaoOffline(cStat int) string {
+	if cStat == 100 || cStat == 150 {
+		return OfflineAutorizada
+	}
+	return OfflineDenegada
+}
+
+// ForaDoPrazo retorna as NFC-e pendentes cujo prazo de transmissão já terminou, e que devem ser tratadas de acordo com a legislação da UF.
+func (t *TransmissorOffline) ForaDoPrazo() ([]NFCeOffline, error) {
+	return t.pendentesAte(t.agora())
+}
+
+// VencemAte retorna as NFC-e pendentes cujo prazo de transmissão termina até o instante informado, permitindo alertar antes do vencimento.
+func (t *TransmissorOffline) VencemAte(limite time.Time) ([]NFCeOffline, error) {
+	return t.pendentesAte(limite)
+}
+
+func (t *TransmissorOffline) pendentesAte(limite time.Time) ([]NFCeOffline, error) {
+	notas, err := t.Fila.Lista()
+	if err != nil {
+		return nil, err
+	}
+
+	var vencidas []NFCeOffline
+	for _, n := range notas {
+		if n.Situacao == OfflinePendente && !n.Vencimento(t.prazo()).After(limite) {
+			vencidas = append(vencidas, n)
+		}
+	}
+	return vencidas, nil
+}
+
+// String descreve a situação da NFC-e, para registro em log.
+func (n NFCeOffline) String() string {
+	s := []string{n.ChNFe, n.Situacao}
+	if n.CStat != 0 {
+		s = append(s, fmt.Sprintf("%d - %s", n.CStat, n.XMotivo))
+	}
+	if n.Erro != "" {
+		s = append(s, n.Erro)
+	}
+	return strings.Join(s, " ")
+}
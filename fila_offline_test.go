@@ -0,0 +1,168 @@
+package nfe
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func xmlNFCeOffline(t *testing.T, nNF int, dhEmi time.Time) []byte {
+	t.Helper()
+	chave, err := MontaChaveDeAcesso(35, 24, 5, "11222333000181", "65", 1, nNF, TpEmisOfflineNFCe, 12345678)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return []byte(fmt.Sprintf(`<?xml version="1.0" encoding="UTF-8"?><NFe xmlns="http://www.portalfiscal.inf.br/nfe"><infNFe Id="NFe%s" versao="4.00"><ide><tpAmb>2</tpAmb><dhEmi>%s</dhEmi></ide></infNFe></NFe>`, chave, dhEmi.Format(time.RFC3339)))
+}
+
+func TestFilaOffline(t *testing.T) {
+	fila, err := NewFilaOfflineArquivo(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// As NFC-e são assinadas, e a Sefaz falsa informa nos protocolos o digVal de cada uma.
+	cert, key := certificadoTeste(t, "EMITENTE LTDA:11222333000181")
+	digVals := map[string]string{}
+	emissao := time.Date(2024, 5, 17, 10, 0, 0, 0, time.UTC)
+	for nNF := 1; nNF <= 6; nNF++ {
+		assinado, err := AssinaNFe(xmlNFCeOffline(t, nNF, emissao.Add(time.Duration(nNF)*time.Hour)), cert, key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		n, err := NovaNFCeOffline(assinado)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if digVals[n.ChNFe], err = digestNFe(assinado); err != nil {
+			t.Fatal(err)
+		}
+		if err := fila.Salva(n); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := NovaNFCeOffline([]byte(`<NFe><infNFe Id="NFe` + chaveNFCeOnline + `"><ide><tpAmb>2</tpAmb></ide></infNFe></NFe>`)); err == nil {
+		t.Errorf("NFC-e com tpEmis 1 não deveria ser aceita na fila offline")
+	}
+
+	// nNF 1: autorizada; 2: duplicidade (204) confirmada pela consulta; 3: duplicidade com diferença na chave (539); 4: duplicidade (204) de uma NFC-e denegada (110); 5: duplicidade (204) com um protocolo sem digVal; 6: autorizador indisponível.
+	cStats := map[int]int{1: 100, 2: 204, 3: 539, 4: 204, 5: 204}
+	tr := &TransmissorOffline{
+		Fila: fila,
+		Autoriza: func(xmlNFe []byte, client *http.Client, optReq ...func(req *http.Request)) (RetEnviNFe, []byte, error) {
+			n, _ := NovaNFCeOffline(xmlNFe)
+			_, _, _, _, _, _, nNF, _, _, _ := GetChaveInfo(n.ChNFe)
+			cStat, ok := cStats[nNF]
+			if !ok {
+				return RetEnviNFe{}, nil, &WSError{StatusCode: http.StatusServiceUnavailable}
+			}
+			ret := RetEnviNFe{CStat: 104, ProtNFe: &ProtNFe{}}
+			inf := &ret.ProtNFe.InfProt
+			inf.ChNFe, inf.CStat, inf.DigVal = n.ChNFe, cStat, digVals[n.ChNFe]
+			switch cStat {
+			case 100:
+				inf.NProt, inf.XMotivo = "135240000000001", "Autorizado o uso da NF-e"
+			case 204:
+				inf.XMotivo = "Rejeição: Duplicidade de NF-e"
+			case 539:
+				inf.XMotivo = "Rejeição: Duplicidade de NF-e com diferença na Chave de Acesso [chNFe:" + chaveNFCeOnline + "]"
+			}
+			return ret, nil, nil
+		},
+		Consulta: func(chNFe string, tpAmb TAmb, client *http.Client, optReq ...func(req *http.Request)) (RetConsSitNFe, []byte, error) {
+			_, _, _, _, _, _, nNF, _, _, _ := GetChaveInfo(chNFe)
+			ret := RetConsSitNFe{CStat: 100, ProtNFe: &ProtNFe{}}
+			inf := &ret.ProtNFe.InfProt
+			inf.ChNFe, inf.CStat, inf.NProt, inf.DigVal = chNFe, 100, "135240000000002", digVals[chNFe]
+			switch nNF {
+			case 4:
+				ret.CStat, inf.CStat, inf.XMotivo = 110, 110, "Uso Denegado"
+			case 5:
+				inf.DigVal = ""
+			}
+			return ret, nil, nil
+		},
+		now: func() time.Time { return emissao.Add(31 * time.Hour) },
+	}
+
+	processadas, err := tr.Transmite(context.Background())
+	if err == nil {
+		t.Errorf("a indisponibilidade do autorizador deveria ser retornada")
+	}
+	want := []string{OfflineAutorizada, OfflineAutorizada, OfflineDuplicada, OfflineDenegada, OfflinePendente, OfflinePendente}
+	if len(processadas) != len(want) {
+		t.Fatalf("processadas = %v", processadas)
+	}
+	for i, n := range processadas {
+		if n.Situacao != want[i] {
+			t.Errorf("nNF %d: situação %q, esperada %q (%v)", i+1, n.Situacao, want[i], n)
+		}
+	}
+	if processadas[1].ProtNFe == nil || processadas[1].ProtNFe.InfProt.NProt != "135240000000002" || processadas[1].ProtNFe.InfProt.DigVal != digVals[processadas[1].ChNFe] {
+		t.Errorf("protocolo da duplicidade não obtido pela consulta: %v", processadas[1])
+	}
+	if processadas[2].ChNFeOriginal != chaveNFCeOnline {
+		t.Errorf("chave da NFC-e duplicada = %q", processadas[2].ChNFeOriginal)
+	}
+	if processadas[3].ProtNFe == nil || processadas[3].CStat != 110 {
+		t.Errorf("denegação da duplicidade: %v", processadas[3])
+	}
+	if !strings.Contains(processadas[4].Erro, "digVal") {
+		t.Errorf("duplicidade sem digVal: %v", processadas[4])
+	}
+
+	// A situação é persistida: apenas as nNF 5 e 6 continuam pendentes, e o prazo (24h da emissão às 15h e às 16h) terminou.
+	vencidas, err := tr.ForaDoPrazo()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(vencidas) != 2 || vencidas[0].ChNFe != processadas[4].ChNFe || vencidas[1].ChNFe != processadas[5].ChNFe || vencidas[1].Tentativas != 1 {
+		t.Errorf("ForaDoPrazo() = %v", vencidas)
+	}
+	if proximas, _ := tr.VencemAte(emissao.Add(24 * time.Hour)); len(proximas) != 0 {
+		t.Errorf("VencemAte() = %v", proximas)
+	}
+}
+
+func TestArquivoChaveInvalida(t *testing.T) {
+	base := t.TempDir()
+	fora := filepath.Join(base, "x.json")
+	if err := os.WriteFile(fora, []byte("{}"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	fila, err := NewFilaOfflineArquivo(filepath.Join(base, "fila"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	armazenamento, err := NewArmazenamentoEmissaoArquivo(filepath.Join(base, "emissoes"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Chaves que levariam a arquivos fora do diretório, ou com o dígito verificador errado.
+	for _, chNFe := range []string{"../x", "..", "", chaveNFCeOnline[:43] + "0"} {
+		if err := fila.Remove(chNFe); err == nil {
+			t.Errorf("FilaOfflineArquivo.Remove(%q) aceita", chNFe)
+		}
+		if err := fila.Salva(NFCeOffline{ChNFe: chNFe}); err == nil {
+			t.Errorf("FilaOfflineArquivo.Salva(%q) aceita", chNFe)
+		}
+		if _, err := armazenamento.Carrega(chNFe); err == nil {
+			t.Errorf("ArmazenamentoEmissaoArquivo.Carrega(%q) aceita", chNFe)
+		}
+		if err := armazenamento.Salva(EmissaoNFe{ChNFe: chNFe}); err == nil {
+			t.Errorf("ArmazenamentoEmissaoArquivo.Salva(%q) aceita", chNFe)
+		}
+	}
+	if _, err := os.Stat(fora); err != nil {
+		t.Errorf("arquivo fora da fila removido: %v", err)
+	}
+	if err := fila.Remove(chaveNFCeOnline); err != nil {
+		t.Errorf("Remove de uma chave válida fora da fila: %v", err)
+	}
+}
@@ -0,0 +1,193 @@
+package nfe
+
+import (
+	"context"
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+// AntecedenciaAvisoPadrao é a antecedência com que a FonteCertificado avisa sobre o vencimento do certificado, quando outra não é informada.
+const AntecedenciaAvisoPadrao = 30 * 24 * time.Hour
+
+// FonteCertificado mantém o certificado digital (par de arquivos PEM, como em NewHTTPClient) carregado em memória e permite substituí-lo sem reiniciar o processo: explicitamente, com Reload, ou automaticamente, com Monitora, quando os arquivos são alterados. O mesmo certificado é usado na conexão TLS (ver HTTPClient) e na assinatura dos XMLs (ver PEM e ChavePrivada).
+//
+// Pode ser usada concorrentemente.
+type FonteCertificado struct {
+	certFile, keyFile string
+
+	// Antecedencia é quanto tempo antes do vencimento Aviso começa a ser chamada. Zero equivale a AntecedenciaAvisoPadrao.
+	Antecedencia time.Duration
+	// Aviso, se definida, é chamada por Monitora quando o certificado está próximo do vencimento (ou já venceu), no máximo uma vez por dia restante.
+	Aviso func(cert *Certificado, restante time.Duration)
+
+	mu         sync.RWMutex
+	atual      *certificadoCarregado
+	modificado [2]time.Time
+	ultimoDia  int
+	agora      func() time.Time
+}
+
+type certificadoCarregado struct {
+	par             tls.Certificate
+	cert            *Certificado
+	chave           *rsa.PrivateKey
+	certPEM, keyPEM []byte
+}
+
+// NewFonteCertificado carrega o certificado e a chave dos arquivos PEM informados.
+func NewFonteCertificado(certFile, keyFile string) (*FonteCertificado, error) {
+	f := &FonteCertificado{certFile: certFile, keyFile: keyFile, ultimoDia: -1, agora: time.Now}
+	if err := f.Reload(); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// Reload relê os arquivos do certificado e da chave. Em caso de erro (por exemplo, um arquivo ainda sendo copiado ou um par que não corresponde), o certificado anterior continua em uso.
+func (f *FonteCertificado) Reload() error {
+	modificado := [2]time.Time{modificacao(f.certFile), modificacao(f.keyFile)}
+	certPEM, keyPEM, err := loadSigningCert(f.certFile, f.keyFile)
+	if err != nil {
+		return fmt.Errorf("Erro no carregamento do certificado digital. Detalhes: %w", err)
+	}
+	par, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		return fmt.Errorf("Erro no carregamento do certificado digital. Detalhes: %w", err)
+	}
+	chave, _, err := parsePrivateKeyAndCert(certPEM, keyPEM)
+	if err != nil {
+		return fmt.Errorf("Erro no carregamento do certificado digital. Detalhes: %w", err)
+	}
+	cert, err := NewCertificado(certPEM)
+	if err != nil {
+		return err
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.atual = &certificadoCarregado{par: par, cert: cert, chave: chave, certPEM: certPEM, keyPEM: keyPEM}
+	f.modificado = modificado
+	f.ultimoDia = -1
+	return nil
+}
+
+func modificacao(path string) time.Time {
+	if fi, err := os.Stat(path); err == nil {
+		return fi.ModTime()
+	}
+	return time.Time{}
+}
+
+func (f *FonteCertificado) carregado() *certificadoCarregado {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	return f.atual
+}
+
+// Certificado retorna o certificado em uso.
+func (f *FonteCertificado) Certificado() *Certificado {
+	return f.carregado().cert
+}
+
+// PEM retorna o certificado e a chave em uso, em PEM, para a assinatura dos XMLs (ver SendManifestacaoEventoFonte).
+func (f *FonteCertificado) PEM() (certPEM, keyPEM []byte) {
+	c := f.carregado()
+	return c.certPEM, c.keyPEM
+}
+
+// ChavePrivada retorna a chave do certificado em uso, por exemplo para ConfigNFCe.ChavePrivada.
+func (f *FonteCertificado) ChavePrivada() *rsa.PrivateKey {
+	return f.carregado().chave
+}
+
+// DiasParaExpirar retorna quantos dias completos faltam para o certificado em uso expirar (negativo se já expirou).
+func (f *FonteCertificado) DiasParaExpirar() int {
+	return diasCompletos(f.Certificado().ExpiraEm(f.agora()))
+}
+
+func diasCompletos(d time.Duration) int {
+	dias := int(d / (24 * time.Hour))
+	if d < 0 {
+		dias--
+	}
+	return dias
+}
+
+// GetClientCertificate fornece o certificado em uso a cada handshake TLS (ver tls.Config.GetClientCertificate).
+//
+// Diferente de tls.Config.Certificates, o certificado é enviado mesmo quando a lista de ACs aceitas pelo servidor não contém a AC do certificado, o que evita o problema intermitente da Sefaz-RS descrito no README.
+func (f *FonteCertificado) GetClientCertificate(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+	return &f.carregado().par, nil
+}
+
+// HTTPClient cria um http.Client com as mesmas configurações de NewHTTPClient, mas que usa sempre o certificado em uso na FonteCertificado, inclusive depois de um Reload. As conexões já abertas continuam com o certificado anterior até serem encerradas.
+func (f *FonteCertificado) HTTPClient() (*http.Client, error) {
+	caCertPool, err := x509.SystemCertPool()
+	if err != nil {
+		return nil, fmt.Errorf("Erro no carregamento da cadeia de certificados do sistema. Detalhes: %w", err)
+	}
+	tlsConfig := tls.Config{
+		GetClientCertificate: f.GetClientCertificate,
+		RootCAs:              caCertPool,
+		Renegotiation:        tls.RenegotiateOnceAsClient,
+	}
+	return &http.Client{
+		Timeout: defaultTimeout,
+		Transport: &http.Transport{
+			TLSClientConfig: &tlsConfig,
+		},
+	}, nil
+}
+
+// Monitora verifica os arquivos do certificado a cada intervalo até o contexto ser cancelado: recarrega o certificado quando algum deles é alterado e chama Aviso quando ele está próximo do vencimento. Os erros de recarga são passados para erro (se não for nil), e o certificado anterior continua em uso.
+func (f *FonteCertificado) Monitora(ctx context.Context, intervalo time.Duration, erro func(error)) {
+	ticker := time.NewTicker(intervalo)
+	defer ticker.Stop()
+	for {
+		f.verifica(erro)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// verifica faz uma rodada do monitoramento: recarga, se os arquivos mudaram, e aviso de vencimento.
+func (f *FonteCertificado) verifica(erro func(error)) {
+	f.mu.RLock()
+	alterado := f.modificado != [2]time.Time{modificacao(f.certFile), modificacao(f.keyFile)}
+	f.mu.RUnlock()
+	if alterado {
+		if err := f.Reload(); err != nil && erro != nil {
+			erro(err)
+		}
+	}
+
+	if f.Aviso == nil {
+		return
+	}
+	antecedencia := f.Antecedencia
+	if antecedencia == 0 {
+		antecedencia = AntecedenciaAvisoPadrao
+	}
+	cert := f.Certificado()
+	restante := cert.ExpiraEm(f.agora())
+	if restante > antecedencia {
+		return
+	}
+	dia := diasCompletos(restante)
+	f.mu.Lock()
+	avisar := f.ultimoDia != dia
+	f.ultimoDia = dia
+	f.mu.Unlock()
+	if avisar {
+		f.Aviso(cert, restante)
+	}
+}
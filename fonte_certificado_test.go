@@ -0,0 +1,114 @@
+package nfe
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestFonteCertificado(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "client.pem"), filepath.Join(dir, "key.pem")
+	grava := func(nome string, mod time.Time) {
+		t.Helper()
+		cert, chave := certificadoTeste(t, nome)
+		for arq, data := range map[string][]byte{certFile: cert, keyFile: chave} {
+			if err := os.WriteFile(arq, data, 0o600); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.Chtimes(arq, mod, mod); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	inicio := time.Now().Add(-time.Minute)
+	grava("CERTIFICADO 1", inicio)
+
+	f, err := NewFonteCertificado(certFile, keyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cn := f.Certificado().X509.Subject.CommonName; cn != "CERTIFICADO 1" {
+		t.Errorf("Certificado() = %s", cn)
+	}
+	if f.ChavePrivada() == nil {
+		t.Error("ChavePrivada() = nil")
+	}
+
+	// Servidor que exige o certificado do cliente e responde com o CN recebido.
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, r.TLS.PeerCertificates[0].Subject.CommonName)
+	}))
+	srv.TLS = &tls.Config{ClientAuth: tls.RequireAnyClientCert}
+	srv.StartTLS()
+	defer srv.Close()
+	client, err := f.HTTPClient()
+	if err != nil {
+		t.Fatal(err)
+	}
+	client.Transport.(*http.Transport).TLSClientConfig.RootCAs = srv.Client().Transport.(*http.Transport).TLSClientConfig.RootCAs
+	cnServidor := func() string {
+		t.Helper()
+		client.CloseIdleConnections()
+		resp, err := client.Get(srv.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		cn, _ := io.ReadAll(resp.Body)
+		return string(cn)
+	}
+	if cn := cnServidor(); cn != "CERTIFICADO 1" {
+		t.Errorf("certificado recebido pelo servidor: %q", cn)
+	}
+
+	// Aviso de vencimento, uma vez por dia restante.
+	venc := f.Certificado().X509.NotAfter
+	var avisos []time.Duration
+	f.Aviso = func(_ *Certificado, restante time.Duration) { avisos = append(avisos, restante) }
+	f.agora = func() time.Time { return venc.Add(-40 * 24 * time.Hour) }
+	f.verifica(nil)
+	f.agora = func() time.Time { return venc.Add(-5*24*time.Hour - time.Hour) }
+	f.verifica(nil)
+	f.verifica(nil)
+	if len(avisos) != 1 || diasCompletos(avisos[0]) != 5 || f.DiasParaExpirar() != 5 {
+		t.Errorf("avisos = %v, DiasParaExpirar() = %d", avisos, f.DiasParaExpirar())
+	}
+	f.agora = func() time.Time { return venc.Add(time.Hour) }
+	if f.DiasParaExpirar() != -1 {
+		t.Errorf("DiasParaExpirar() após o vencimento = %d", f.DiasParaExpirar())
+	}
+	f.Aviso, f.agora = nil, time.Now
+
+	// Arquivos alterados: recarga automática.
+	grava("CERTIFICADO 2", inicio.Add(time.Second))
+	f.verifica(func(err error) { t.Error(err) })
+	if cn := f.Certificado().X509.Subject.CommonName; cn != "CERTIFICADO 2" {
+		t.Errorf("Certificado() após a alteração = %s", cn)
+	}
+	if cn := cnServidor(); cn != "CERTIFICADO 2" {
+		t.Errorf("certificado recebido pelo servidor após a alteração: %q", cn)
+	}
+
+	// Um arquivo inválido não substitui o certificado em uso.
+	if err := os.WriteFile(keyFile, []byte("inválido"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	os.Chtimes(keyFile, inicio.Add(2*time.Second), inicio.Add(2*time.Second))
+	var erro error
+	f.verifica(func(err error) { erro = err })
+	if erro == nil || f.Certificado().X509.Subject.CommonName != "CERTIFICADO 2" {
+		t.Errorf("recarga de arquivo inválido: erro %v, certificado %s", erro, f.Certificado().X509.Subject.CommonName)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	f.Monitora(ctx, time.Hour, nil)
+}
@@ -7,12 +7,12 @@ toolchain go1.24.0
 require (
 	cloud.google.com/go v0.110.3
 	github.com/amdonov/xmlsig v0.1.0
+	github.com/beevik/etree v1.6.0
 	github.com/frones/brdocs v0.0.0-20191124002639-fcc6fb60dff8
+	github.com/russellhaering/goxmldsig v1.5.0
 )
 
 require (
-	github.com/beevik/etree v1.6.0 // indirect
 	github.com/frones/strmask v0.0.0-20191124001919-f8a35ebadd11 // indirect
 	github.com/jonboulle/clockwork v0.5.0 // indirect
-	github.com/russellhaering/goxmldsig v1.5.0 // indirect
 )
@@ -62,25 +62,30 @@ func newRequest(url string, soapAction string, body []byte) (*http.Request, erro
 }
 
 // sendRequest é uma função que se encarrega de fazer o envelopamento da requisição, enviar pra Sefaz com certificado digital e desenvelopar o retorno.
-func sendRequest(obj interface{}, url string, xmlns string, soapAction string, client *http.Client, optReq ...func(req *http.Request)) ([]byte, error) {
+//
+// O WebService (ver Registry) determina a URL, a versão do SOAP e o envelope usados. Retorna o XML de resposta duas vezes (ver desenvelopaNormalizado): como recebido da Sefaz e depois das correções do DefaultNormalizador, que deve ser o usado na desserialização.
+func sendRequest(obj interface{}, ep Endpoint, xmlns string, soapAction string, client *http.Client, optReq ...func(req *http.Request)) (original []byte, normalizado []byte, err error) {
 	xmlfile, err := xml.Marshal(obj)
 	if err != nil {
-		return nil, fmt.Errorf("Erro na geração do XML de requisição. Detalhes: %w", err)
+		return nil, nil, fmt.Errorf("Erro na geração do XML de requisição. Detalhes: %w", err)
 	}
 
-	if url == urlConsCadMT {
+	if ep.Envelope == envelopeConsCadMT {
 		xmlfile, err = getSoapEnvelopeConsCadMT(xmlfile, xmlns)
 	} else {
-		xmlfile, err = getSoapEnvelope(xmlfile, xmlns)
+		xmlfile, err = getSoapEnvelope(xmlfile, xmlns, ep.SOAP)
 	}
 	if err != nil {
-		return nil, fmt.Errorf("Erro na geração do envelope SOAP. Detalhes: %w", err)
+		return nil, nil, fmt.Errorf("Erro na geração do envelope SOAP. Detalhes: %w", err)
 	}
 	xmlfile = []byte(append([]byte(xml.Header), xmlfile...))
 
-	req, err := newRequest(url, soapAction, xmlfile)
+	req, err := newRequest(ep.URL, soapAction, xmlfile)
 	if err != nil {
-		return nil, fmt.Errorf("Erro na criação da requisição (http.Request) para a URL %s. Detalhes: %w", url, err)
+		return nil, nil, fmt.Errorf("Erro na criação da requisição (http.Request) para a URL %s. Detalhes: %w", ep.URL, err)
+	}
+	if ep.SOAP == SOAP11 {
+		req.Header.Set("Content-Type", "text/xml; charset=utf-8")
 	}
 	for _, opt := range optReq {
 		opt(req)
@@ -88,30 +93,63 @@ func sendRequest(obj interface{}, url string, xmlns string, soapAction string, c
 
 	resp, err := client.Do(req)
 	if err != nil {
-		return nil, fmt.Errorf("Erro na requisição ao WebService %s. Detalhes: %w", url, err)
+		return nil, nil, fmt.Errorf("Erro na requisição ao WebService %s. Detalhes: %w", ep.URL, err)
 	}
 	defer resp.Body.Close()
 
-	if resp.StatusCode != http.StatusOK {
-		body, _ := ioutil.ReadAll(resp.Body)
-		return nil, &WSError{url, resp.StatusCode, resp.Status, string(body)}
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil && resp.StatusCode == http.StatusOK {
+		return nil, nil, fmt.Errorf("Erro na leitura do corpo da resposta: %w", err)
 	}
 
-	body, err := ioutil.ReadAll(resp.Body)
-	if err != nil {
-		return nil, fmt.Errorf("Erro na leitura do corpo da resposta: %w", err)
+	if wsErr := checkResponse(resp, DefaultNormalizador.Normaliza(ep, body), ep); wsErr != nil {
+		return nil, nil, wsErr
 	}
 
-	if url == urlConsCadMT {
-		xmlfile, err = readSoapEnvelopeConsCadMT(body)
-	} else if url == urlConsCadMG {
-		xmlfile, err = readSoapEnvelopeConsCadMG(body)
-	} else {
-		xmlfile, err = readSoapEnvelope(body)
+	return desenvelopaNormalizado(ep, body)
+}
+
+// desenvelopaNormalizado extrai o XML de resposta do envelope como recebido, para ser retornado ao chamador sem alteração (o protNFe e o retEvento são assinados pela Sefaz), e depois das correções do DefaultNormalizador, para a desserialização. Se o envelope não puder ser lido sem as correções (BOM ou encoding diferente de UTF-8), o XML original é o normalizado.
+func desenvelopaNormalizado(ep Endpoint, body []byte) (original []byte, normalizado []byte, err error) {
+	normalizado, err = desenvelopa(ep, DefaultNormalizador.Normaliza(ep, body))
+	if err != nil {
+		return nil, nil, err
 	}
+	original, err = desenvelopa(ep, body)
 	if err != nil {
-		return nil, err
+		return normalizado, normalizado, nil
+	}
+	return original, normalizado, nil
+}
+
+// desenvelopa extrai o XML de resposta do envelope SOAP de acordo com o envelope do WebService (os da consulta de cadastro de MT e MG são diferentes dos demais).
+func desenvelopa(ep Endpoint, body []byte) ([]byte, error) {
+	switch ep.Envelope {
+	case envelopeConsCadMT:
+		return readSoapEnvelopeConsCadMT(body)
+	case envelopeConsCadMG:
+		return readSoapEnvelopeConsCadMG(body)
+	default:
+		return readSoapEnvelope(body)
+	}
+}
+
+// checkResponse retorna um *WSError caso a resposta tenha status HTTP diferente de 200 ou contenha um SOAP Fault (em qualquer status).
+func checkResponse(resp *http.Response, body []byte, ep Endpoint) *WSError {
+	code, reason, detail, fault := readSoapFault(body)
+	if !fault && resp.StatusCode == http.StatusOK {
+		return nil
 	}
 
-	return xmlfile, err
+	return &WSError{
+		Url:           ep.URL,
+		StatusCode:    resp.StatusCode,
+		StatusMessage: resp.Status,
+		Body:          string(body),
+		Code:          code,
+		Reason:        reason,
+		Detail:        detail,
+		CUF:           ep.CUF,
+		Servico:       ep.Servico,
+	}
 }
@@ -0,0 +1,125 @@
+//go:build ignore
+
+// Gera o icpbrasil.pem com as ACs publicadas pelo ITI no repositório da AC Raiz (ACcompactado.zip). Uso: go generate (ver certificado.go).
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+)
+
+const urlACs = "https://acraiz.icpbrasil.gov.br/credenciadas/CertificadosAC-ICP-Brasil/ACcompactado.zip"
+
+// raizesObrigatorias são as ACs Raiz que o arquivo gerado deve conter (ver TestDefaultCadeiaICPBrasil).
+var raizesObrigatorias = []string{
+	"Autoridade Certificadora Raiz Brasileira v5",
+	"Autoridade Certificadora Raiz Brasileira v10",
+	"Autoridade Certificadora Raiz Brasileira v11",
+}
+
+const cabecalho = `Cadeia de certificados ICP-Brasil embutida na biblioteca (ver DefaultCadeiaICPBrasil).
+
+Gerado por icpbrasil_gen.go (go generate) a partir do ACcompactado.zip publicado pelo ITI no
+repositório da AC Raiz (acraiz.icpbrasil.gov.br). Os certificados autoassinados são usados como
+raízes e os demais como intermediários. Todo texto fora dos blocos BEGIN/END CERTIFICATE é ignorado.
+
+Para usar outra cadeia sem uma nova versão da biblioteca, ver LoadCadeiaICPBrasil.
+
+`
+
+func main() {
+	resp, err := http.Get(urlACs)
+	if err != nil {
+		log.Fatalf("Erro no download das ACs ICP-Brasil. Detalhes: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		log.Fatalf("Erro no download das ACs ICP-Brasil: %s", resp.Status)
+	}
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		log.Fatalf("Erro no download das ACs ICP-Brasil. Detalhes: %v", err)
+	}
+	zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		log.Fatalf("Erro na leitura do ACcompactado.zip. Detalhes: %v", err)
+	}
+
+	certs := map[string]*x509.Certificate{}
+	for _, f := range zr.File {
+		if f.FileInfo().IsDir() {
+			continue
+		}
+		rc, err := f.Open()
+		if err != nil {
+			log.Fatalf("Erro na leitura de %s. Detalhes: %v", f.Name, err)
+		}
+		b, err := io.ReadAll(rc)
+		rc.Close()
+		if err != nil {
+			log.Fatalf("Erro na leitura de %s. Detalhes: %v", f.Name, err)
+		}
+		for _, der := range blocos(b) {
+			cert, err := x509.ParseCertificate(der)
+			if err != nil {
+				log.Fatalf("Erro na leitura de %s. Detalhes: %v", f.Name, err)
+			}
+			certs[string(cert.Raw)] = cert
+		}
+	}
+
+	raizes := map[string]bool{}
+	var lista []*x509.Certificate
+	for _, cert := range certs {
+		if bytes.Equal(cert.RawIssuer, cert.RawSubject) && cert.CheckSignatureFrom(cert) == nil {
+			raizes[cert.Subject.CommonName] = true
+		}
+		lista = append(lista, cert)
+	}
+	for _, cn := range raizesObrigatorias {
+		if !raizes[cn] {
+			log.Fatalf("O ACcompactado.zip não contém a AC Raiz %q", cn)
+		}
+	}
+	sort.Slice(lista, func(i, j int) bool {
+		return lista[i].Subject.CommonName < lista[j].Subject.CommonName || lista[i].Subject.CommonName == lista[j].Subject.CommonName && lista[i].SerialNumber.Cmp(lista[j].SerialNumber) < 0
+	})
+
+	var out bytes.Buffer
+	out.WriteString(cabecalho)
+	for _, cert := range lista {
+		fmt.Fprintf(&out, "# %s (válido até %s)\n", cert.Subject.CommonName, cert.NotAfter.Format("2006-01-02"))
+		pem.Encode(&out, &pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw})
+	}
+	if err := os.WriteFile("icpbrasil.pem", out.Bytes(), 0o644); err != nil {
+		log.Fatal(err)
+	}
+	log.Printf("icpbrasil.pem: %d certificados, %d raízes", len(lista), len(raizes))
+}
+
+// blocos retorna os certificados do arquivo, em PEM (um ou mais blocos) ou DER.
+func blocos(b []byte) [][]byte {
+	if !strings.Contains(string(b), "-----BEGIN") {
+		return [][]byte{b}
+	}
+	var r [][]byte
+	for rest := b; ; {
+		var block *pem.Block
+		block, rest = pem.Decode(rest)
+		if block == nil {
+			return r
+		}
+		if block.Type == "CERTIFICATE" {
+			r = append(r, block.Bytes)
+		}
+	}
+}
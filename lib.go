@@ -77,14 +77,7 @@ func ValidaChaveDeAcesso(DFeChave string) bool {
 		return false
 	}
 
-	sum := 0
-	for i, c := range DFeChave[:len(DFeChave)-1] {
-		n := int(c - '0')
-		sum += n * (((len(DFeChave) - 2 - i) % 8) + 2)
-	}
-	dv := ((sum * 10) % 11) % 10
-
-	if strconv.Itoa(dv) != DFeChave[len(DFeChave)-1:] {
+	if strconv.Itoa(calculaDV(DFeChave[:len(DFeChave)-1])) != DFeChave[len(DFeChave)-1:] {
 		return false
 	}
 
@@ -124,6 +117,27 @@ func ValidaChaveDeAcesso(DFeChave string) bool {
 	return true
 }
 
+// calculaDV calcula o dígito verificador (módulo 11) das 43 primeiras posições da chave de acesso.
+func calculaDV(chave43 string) int {
+	sum := 0
+	for i, c := range chave43 {
+		n := int(c - '0')
+		sum += n * (((len(chave43) - 1 - i) % 8) + 2)
+	}
+	return ((sum * 10) % 11) % 10
+}
+
+// MontaChaveDeAcesso monta a chave de acesso da NFe a partir das suas informações, calculando o dígito verificador. O ano deve ser informado com dois dígitos e o tpEmis determina o tipo de emissão (1 normal, 4 EPEC, 6/7 SVC, 9 NFC-e offline).
+func MontaChaveDeAcesso(cUF int, ano int, mes int, cnpj string, mod string, serie int, numNF int, tpEmis int, cNF int) (string, error) {
+	if GetUF(cUF) == "" || ano < 0 || ano > 99 || mes < 1 || mes > 12 || len(cnpj) != 14 || !isNumber(cnpj) || len(mod) != 2 || !isNumber(mod) ||
+		serie < 0 || serie > 999 || numNF < 1 || numNF > 999999999 || tpEmis < 1 || tpEmis > 9 || cNF < 0 || cNF > 99999999 {
+		return "", fmt.Errorf("Dados inválidos para a chave de acesso: cUF=%d ano=%d mes=%d CNPJ=%s mod=%s serie=%d nNF=%d tpEmis=%d cNF=%d", cUF, ano, mes, cnpj, mod, serie, numNF, tpEmis, cNF)
+	}
+
+	chave := fmt.Sprintf("%02d%02d%02d%s%s%03d%09d%d%08d", cUF, ano, mes, cnpj, mod, serie, numNF, tpEmis, cNF)
+	return chave + strconv.Itoa(calculaDV(chave)), nil
+}
+
 // GetcUF retorna o código IBGE da UF a partir da sigla
 func GetcUF(uf string) int {
 	switch uf {
@@ -0,0 +1,228 @@
+package nfe
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// IntervaloMonitorPadrao é o intervalo entre as consultas do MonitorStatus quando nenhum for informado. Consultas de status mais frequentes que isso tendem a ser consideradas consumo indevido pelas Sefazes.
+const IntervaloMonitorPadrao = 5 * time.Minute
+
+// cStatServicoEmOperacao é o cStat da consulta de status que indica o serviço em operação.
+const cStatServicoEmOperacao = 107
+
+// StatusAutorizador é a situação de um autorizador obtida pelo MonitorStatus.
+type StatusAutorizador struct {
+	Autorizador string
+	TpAmb       TAmb
+
+	// CUF é a UF usada na consulta (a menor UF atendida pelo autorizador).
+	CUF int
+
+	// Saudavel indica que a última consulta retornou cStat 107 (serviço em operação), ou que as falhas de comunicação desde então não atingiram o LimiteFalhas.
+	Saudavel bool
+
+	// Ret é o último retorno da consulta de status, inclusive o tempo médio de resposta (tMed). Em falhas de comunicação, é mantido o retorno anterior.
+	Ret RetConsStatServ
+	// Err é o erro de comunicação da última consulta, se houver, e Falhas o número de falhas consecutivas.
+	Err    error
+	Falhas int
+
+	// Atualizado é o momento da última consulta e Desde o momento em que o autorizador passou à situação atual.
+	Atualizado time.Time
+	Desde      time.Time
+}
+
+// MonitorStatus consulta periodicamente o status do serviço (ver ConsultaStatServ) de todos os autorizadores e mantém o último retorno em memória, de maneira que a emissão possa verificar a situação do autorizador (Healthy) sem uma nova consulta à Sefaz.
+//
+// As mudanças de situação são publicadas para as funções registradas com Observa; a Contingencia, por exemplo, pode ser ativada e desativada automaticamente:
+//
+//	monitor := nfe.NewMonitorStatus(client, nfe.Producao, nil)
+//	monitor.Observa(contingencia.ObservaAutorizador)
+//	go monitor.Monitora(ctx, 0)
+//
+// Pode ser usado concorrentemente.
+type MonitorStatus struct {
+	TpAmb  TAmb
+	Client *http.Client
+	OptReq []func(req *http.Request)
+
+	// Autorizadores monitorados (ex.: "SP", "SVRS", "SVC-AN"). Vazio monitora todos os autorizadores do Registry que oferecem a consulta de status: as UFs com autorizador próprio, SVRS, SVAN, SVC-AN e SVC-RS.
+	Autorizadores []string
+
+	// LimiteFalhas é o número de falhas de comunicação consecutivas que tornam um autorizador indisponível. Zero equivale a 2. Os cStat diferentes de 107 (ex.: 108 e 109, serviço paralisado) tornam o autorizador indisponível imediatamente.
+	LimiteFalhas int
+
+	registry     *Registry
+	mu           sync.RWMutex
+	status       map[string]StatusAutorizador
+	observadores []func(StatusAutorizador)
+	notificacao  sync.Mutex
+	now          func() time.Time
+}
+
+// NewMonitorStatus cria um MonitorStatus para o ambiente informado, usando o Registry informado (ou o DefaultRegistry, se nil). As consultas só começam com Monitora ou Atualiza.
+func NewMonitorStatus(client *http.Client, tpAmb TAmb, registry *Registry) *MonitorStatus {
+	if registry == nil {
+		registry = DefaultRegistry
+	}
+	return &MonitorStatus{
+		TpAmb:    tpAmb,
+		Client:   client,
+		registry: registry,
+		status:   map[string]StatusAutorizador{},
+		now:      time.Now,
+	}
+}
+
+// Observa registra uma função que será chamada a cada mudança de situação (saudável ou não) de um autorizador, inclusive na primeira consulta. As funções nunca são chamadas simultaneamente, mas sim na goroutine de cada consulta, e não devem bloquear.
+func (m *MonitorStatus) Observa(fn func(StatusAutorizador)) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.observadores = append(m.observadores, fn)
+}
+
+// Monitora consulta todos os autorizadores a cada intervalo (ou IntervaloMonitorPadrao, se zero), até o contexto ser cancelado. A primeira rodada é feita imediatamente.
+func (m *MonitorStatus) Monitora(ctx context.Context, intervalo time.Duration) {
+	if intervalo <= 0 {
+		intervalo = IntervaloMonitorPadrao
+	}
+	ticker := time.NewTicker(intervalo)
+	defer ticker.Stop()
+	for {
+		m.Atualiza(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Atualiza consulta, em paralelo, o status de todos os autorizadores monitorados e retorna quando todas as consultas terminarem.
+func (m *MonitorStatus) Atualiza(ctx context.Context) {
+	var wg sync.WaitGroup
+	for _, autorizador := range m.autorizadores() {
+		wg.Add(1)
+		go func(autorizador string) {
+			defer wg.Done()
+			cUF, ret, err := m.consulta(ctx, autorizador)
+			if ctx.Err() != nil {
+				return
+			}
+			m.registra(autorizador, cUF, ret, err)
+		}(autorizador)
+	}
+	wg.Wait()
+}
+
+// autorizadores retorna os autorizadores monitorados.
+func (m *MonitorStatus) autorizadores() []string {
+	if len(m.Autorizadores) > 0 {
+		return m.Autorizadores
+	}
+	var lista []string
+	for _, a := range m.registry.Autorizadores() {
+		if _, err := m.registry.LookupAutorizador(a, m.TpAmb, ConsultaStatus); err == nil {
+			lista = append(lista, a)
+		}
+	}
+	return lista
+}
+
+// consulta executa a consulta de status no autorizador, com a menor UF atendida por ele. Os autorizadores normais são consultados pela Lookup (considerando as substituições de URL) e os de contingência diretamente.
+func (m *MonitorStatus) consulta(ctx context.Context, autorizador string) (int, RetConsStatServ, error) {
+	ufs := m.registry.UFs(autorizador)
+	if len(ufs) == 0 {
+		return 0, RetConsStatServ{}, fmt.Errorf("Autorizador desconhecido: %s", autorizador)
+	}
+	cUF := ufs[0]
+
+	var ep Endpoint
+	var err error
+	if m.registry.Autorizador(cUF) == autorizador {
+		ep, err = m.registry.Lookup(cUF, m.TpAmb, ConsultaStatus)
+	} else {
+		ep, err = m.registry.LookupAutorizador(autorizador, m.TpAmb, ConsultaStatus)
+		ep.CUF = cUF
+	}
+	if err != nil {
+		return cUF, RetConsStatServ{}, err
+	}
+
+	cons := ConsStatServ{
+		Versao: VerConsStatServ,
+		TpAmb:  m.TpAmb,
+		XServ:  "STATUS",
+		CUF:    cUF,
+	}
+	optReq := append([]func(req *http.Request){func(req *http.Request) {
+		*req = *req.WithContext(ctx)
+	}}, m.OptReq...)
+	ret, _, err := cons.consulta(ep, m.Client, optReq...)
+	return cUF, ret, err
+}
+
+// registra atualiza a situação do autorizador com o resultado de uma consulta e notifica os observadores se ela mudou.
+func (m *MonitorStatus) registra(autorizador string, cUF int, ret RetConsStatServ, err error) {
+	limite := m.LimiteFalhas
+	if limite <= 0 {
+		limite = 2
+	}
+
+	m.mu.Lock()
+	anterior, existia := m.status[autorizador]
+	s := StatusAutorizador{
+		Autorizador: autorizador,
+		TpAmb:       m.TpAmb,
+		CUF:         cUF,
+		Ret:         ret,
+		Err:         err,
+		Atualizado:  m.now(),
+	}
+	if err != nil {
+		s.Ret = anterior.Ret
+		s.Falhas = anterior.Falhas + 1
+		s.Saudavel = (!existia || anterior.Saudavel) && s.Falhas < limite
+	} else {
+		s.Saudavel = ret.CStat == cStatServicoEmOperacao
+	}
+	mudou := !existia || anterior.Saudavel != s.Saudavel
+	s.Desde = anterior.Desde
+	if mudou {
+		s.Desde = s.Atualizado
+	}
+	m.status[autorizador] = s
+	observadores := m.observadores
+	m.mu.Unlock()
+
+	if mudou {
+		m.notificacao.Lock()
+		defer m.notificacao.Unlock()
+		for _, fn := range observadores {
+			fn(s)
+		}
+	}
+}
+
+// StatusAutorizador retorna a última situação conhecida do autorizador informado (ex.: "SVRS", "SVC-AN"), ou false se ele ainda não foi consultado.
+func (m *MonitorStatus) StatusAutorizador(autorizador string) (StatusAutorizador, bool) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	s, ok := m.status[autorizador]
+	return s, ok
+}
+
+// Status retorna a última situação conhecida do autorizador da UF, ou false se ele ainda não foi consultado.
+func (m *MonitorStatus) Status(cUF int) (StatusAutorizador, bool) {
+	return m.StatusAutorizador(m.registry.Autorizador(cUF))
+}
+
+// Healthy indica se o autorizador da UF está em operação, de acordo com a última consulta. Um autorizador ainda não consultado é considerado em operação: as falhas na emissão continuam sendo tratadas normalmente (ver Contingencia.RegistraFalha).
+func (m *MonitorStatus) Healthy(cUF int) bool {
+	s, ok := m.Status(cUF)
+	return !ok || s.Saudavel
+}
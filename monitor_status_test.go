@@ -0,0 +1,178 @@
+package nfe
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+var reCUFConsulta = regexp.MustCompile(`<cUF>(\d+)</cUF>`)
+
+// sefazStatus responde à consulta de status de cada autorizador (pelo caminho da URL) com o cStat programado, ou com o status HTTP 503 se o cStat for zero.
+type sefazStatus struct {
+	mu        sync.Mutex
+	cStat     map[string]int
+	consultas map[string][]string
+}
+
+func (s *sefazStatus) define(autorizador string, cStat int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.cStat[autorizador] = cStat
+}
+
+func (s *sefazStatus) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	autorizador := strings.TrimPrefix(r.URL.Path, "/")
+	body, _ := io.ReadAll(r.Body)
+	cUF := reCUFConsulta.FindSubmatch(body)[1]
+
+	s.mu.Lock()
+	s.consultas[autorizador] = append(s.consultas[autorizador], string(cUF))
+	cStat := s.cStat[autorizador]
+	s.mu.Unlock()
+
+	if cStat == 0 {
+		http.Error(w, "Service Unavailable", http.StatusServiceUnavailable)
+		return
+	}
+	fmt.Fprintf(w, `<soap:Envelope xmlns:soap="http://www.w3.org/2003/05/soap-envelope"><soap:Body><nfeResultMsg><retConsStatServ xmlns="http://www.portalfiscal.inf.br/nfe" versao="4.00"><tpAmb>1</tpAmb><verAplic>TESTE</verAplic><cStat>%d</cStat><xMotivo>Status %d</xMotivo><cUF>%s</cUF><dhRecbto>2024-05-17T10:31:02-03:00</dhRecbto><tMed>%d</tMed></retConsStatServ></nfeResultMsg></soap:Body></soap:Envelope>`, cStat, cStat, cUF, cStat-100)
+}
+
+// registryStatus cria um Registry com três autorizadores normais (SP, SVRS para RJ e SC, RS) e o SVC-AN, todos apontando para a sefazStatus.
+func registryStatus(t *testing.T) (*Registry, *sefazStatus) {
+	s := &sefazStatus{cStat: map[string]int{"SP": 107, "SVRS": 107, "RS": 107, "SVC-AN": 107}, consultas: map[string][]string{}}
+	srv := httptest.NewServer(s)
+	t.Cleanup(srv.Close)
+
+	var ws []string
+	for _, a := range []string{"SP", "SVRS", "RS", "SVC-AN"} {
+		ws = append(ws, fmt.Sprintf(`{"autorizador": %q, "ambiente": 1, "servico": "ConsultaStatus", "versao": "4.00", "url": "%s/%s"}`, a, srv.URL, a))
+	}
+	ws = append(ws, fmt.Sprintf(`{"autorizador": "AN", "ambiente": 1, "servico": "Evento", "versao": "1.00", "url": "%s/AN"}`, srv.URL))
+	r, err := NewRegistry([]byte(`{
+		"autorizadores": {"35": "SP", "33": "SVRS", "42": "SVRS", "43": "RS", "91": "AN"},
+		"contingencia": {"35": "SVC-AN", "33": "SVC-AN", "42": "SVC-AN", "43": "SVC-AN"},
+		"webservices": [` + strings.Join(ws, ",") + `]
+	}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return r, s
+}
+
+func TestMonitorStatus(t *testing.T) {
+	registry, sefaz := registryStatus(t)
+	monitor := NewMonitorStatus(http.DefaultClient, Producao, registry)
+	contingencia := NewContingencia(registry)
+	monitor.Observa(contingencia.ObservaAutorizador)
+
+	var mudancas []string
+	monitor.Observa(func(s StatusAutorizador) {
+		mudancas = append(mudancas, fmt.Sprintf("%s:%v", s.Autorizador, s.Saudavel))
+	})
+
+	if !monitor.Healthy(35) {
+		t.Error("autorizador ainda não consultado deveria ser considerado em operação")
+	}
+
+	ctx := context.Background()
+	monitor.Atualiza(ctx)
+	if len(mudancas) != 4 {
+		t.Fatalf("mudanças na primeira consulta: %v", mudancas)
+	}
+	if got := sefaz.consultas["SVRS"]; len(got) != 1 || got[0] != "33" {
+		t.Errorf("SVRS consultado com cUF %v, esperado a menor UF (33)", got)
+	}
+	if _, ok := sefaz.consultas["AN"]; ok {
+		t.Error("AN não oferece a consulta de status e não deveria ser consultado")
+	}
+	if s, ok := monitor.Status(42); !ok || !s.Saudavel || s.Autorizador != "SVRS" || s.Ret.TMed != 7 {
+		t.Errorf("Status(42) = %+v, %v", s, ok)
+	}
+
+	// SP paralisado: indisponível na primeira consulta, com contingência ativada
+	mudancas = nil
+	sefaz.define("SP", 108)
+	monitor.Atualiza(ctx)
+	if monitor.Healthy(35) || len(mudancas) != 1 || mudancas[0] != "SP:false" {
+		t.Errorf("SP paralisado: Healthy = %v, mudanças %v", monitor.Healthy(35), mudancas)
+	}
+	if ativa, _, xJust := contingencia.EmContingencia(35, Producao); !ativa || !strings.Contains(xJust, "108") {
+		t.Errorf("contingência de SP: %v, %q", ativa, xJust)
+	}
+
+	// SVRS fora do ar: indisponível após duas falhas, mantendo o último retorno
+	mudancas = nil
+	sefaz.define("SVRS", 0)
+	monitor.Atualiza(ctx)
+	if !monitor.Healthy(33) || len(mudancas) != 0 {
+		t.Errorf("SVRS após uma falha: Healthy = %v, mudanças %v", monitor.Healthy(33), mudancas)
+	}
+	monitor.Atualiza(ctx)
+	s, _ := monitor.Status(33)
+	if s.Saudavel || s.Err == nil || s.Falhas != 2 || s.Ret.CStat != 107 || len(mudancas) != 1 {
+		t.Errorf("SVRS após duas falhas: %+v, mudanças %v", s, mudancas)
+	}
+	for _, cUF := range []int{33, 42} {
+		if ativa, _, _ := contingencia.EmContingencia(cUF, Producao); !ativa {
+			t.Errorf("contingência da UF %d não ativada", cUF)
+		}
+	}
+	if ativa, _, _ := contingencia.EmContingencia(43, Producao); ativa {
+		t.Error("contingência do RS ativada")
+	}
+
+	// recuperação
+	sefaz.define("SP", 107)
+	sefaz.define("SVRS", 107)
+	monitor.Atualiza(ctx)
+	for _, cUF := range []int{35, 33, 42} {
+		if ativa, _, _ := contingencia.EmContingencia(cUF, Producao); ativa || !monitor.Healthy(cUF) {
+			t.Errorf("UF %d ainda em contingência", cUF)
+		}
+	}
+	if s, _ := monitor.StatusAutorizador("SVC-AN"); !s.Saudavel || s.CUF != 33 {
+		t.Errorf("SVC-AN: %+v", s)
+	}
+}
+
+func TestMonitorStatusMonitora(t *testing.T) {
+	registry, sefaz := registryStatus(t)
+	monitor := NewMonitorStatus(http.DefaultClient, Producao, registry)
+	monitor.Autorizadores = []string{"RS"}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	fim := make(chan struct{})
+	go func() {
+		monitor.Monitora(ctx, 10*time.Millisecond)
+		close(fim)
+	}()
+
+	for i := 0; ; i++ {
+		sefaz.mu.Lock()
+		n := len(sefaz.consultas["RS"])
+		sefaz.mu.Unlock()
+		if n >= 3 {
+			break
+		}
+		if i > 100 {
+			t.Fatalf("%d consultas após 1s", n)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	cancel()
+	<-fim
+
+	sefaz.mu.Lock()
+	defer sefaz.mu.Unlock()
+	if len(sefaz.consultas) != 1 {
+		t.Errorf("autorizadores consultados: %v", sefaz.consultas)
+	}
+}
@@ -0,0 +1,264 @@
+package nfe
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/rsa"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/beevik/etree"
+)
+
+// Versões do QR Code da NFC-e.
+const (
+	QRCodeV2 = 2 // NT 2015.002: hash SHA-1 com o CSC
+	QRCodeV3 = 3 // NT 2025.001: sem CSC, com assinatura digital na emissão offline
+)
+
+// ConfigNFCe reúne as informações do contribuinte necessárias para montar o QR Code da NFC-e.
+type ConfigNFCe struct {
+	// VersaoQRCode é a versão do QR Code (QRCodeV2 ou QRCodeV3). Zero equivale a QRCodeV2.
+	VersaoQRCode int
+
+	// IdCSC e CSC são o identificador e o Código de Segurança do Contribuinte, obtidos junto à Sefaz da UF. Obrigatórios na versão 2.
+	IdCSC string
+	CSC   string
+
+	// ChavePrivada é a chave do certificado do emitente, usada para assinar o QR Code da emissão offline (tpEmis 9) na versão 3.
+	ChavePrivada *rsa.PrivateKey
+
+	// Registry é a tabela com as URLs de consulta de cada UF. Se nil, o DefaultRegistry é usado.
+	Registry *Registry
+}
+
+// QRCodeNFCe contém os dados da NFC-e que compõem o QR Code. DhEmi, VNF e DigVal só são usados na emissão offline (tpEmis 9, obtido da chave de acesso), assim como os dados do destinatário na versão 3.
+type QRCodeNFCe struct {
+	ChNFe  string
+	TpAmb  TAmb
+	DhEmi  time.Time
+	VNF    float64
+	DigVal string // DigestValue da assinatura da NFC-e, em base64, como consta no XML
+
+	DestCNPJ          string
+	DestCPF           string
+	DestIdEstrangeiro string
+}
+
+func (cfg ConfigNFCe) registry() *Registry {
+	if cfg.Registry == nil {
+		return DefaultRegistry
+	}
+	return cfg.Registry
+}
+
+// Parametro monta o conteúdo do parâmetro p do QR Code, de acordo com a versão e com o tipo de emissão (online ou offline).
+func (cfg ConfigNFCe) Parametro(q QRCodeNFCe) (string, error) {
+	if !ValidaChaveDeAcesso(q.ChNFe) {
+		return "", fmt.Errorf("Chave de Acesso inválida: %s", q.ChNFe)
+	}
+	_, _, _, _, mod, _, _, tpEmis, _, _ := GetChaveInfo(q.ChNFe)
+	if mod != "65" {
+		return "", fmt.Errorf("QR Code só pode ser gerado para NFC-e (modelo 65): %s", q.ChNFe)
+	}
+	offline := tpEmis == strconv.Itoa(TpEmisOfflineNFCe)
+
+	switch cfg.VersaoQRCode {
+	case 0, QRCodeV2:
+		return cfg.parametroV2(q, offline)
+	case QRCodeV3:
+		return cfg.parametroV3(q, offline)
+	}
+	return "", fmt.Errorf("Versão do QR Code não suportada: %d", cfg.VersaoQRCode)
+}
+
+// parametroV2 monta o parâmetro da versão 2: chave|2|tpAmb|idCSC|hash na emissão online e chave|2|tpAmb|dia|vNF|digVal|idCSC|hash na offline, sendo hash o SHA-1 (em hexadecimal) dos campos anteriores concatenados ao CSC.
+func (cfg ConfigNFCe) parametroV2(q QRCodeNFCe, offline bool) (string, error) {
+	idCSC, err := strconv.Atoi(cfg.IdCSC)
+	if err != nil || idCSC <= 0 || cfg.CSC == "" {
+		return "", fmt.Errorf("IdCSC e CSC são obrigatórios no QR Code versão 2: IdCSC=%q", cfg.IdCSC)
+	}
+
+	campos := []string{q.ChNFe, "2", strconv.Itoa(int(q.TpAmb))}
+	if offline {
+		if q.DigVal == "" || q.DhEmi.IsZero() {
+			return "", fmt.Errorf("dhEmi e digVal são obrigatórios no QR Code da emissão offline: %s", q.ChNFe)
+		}
+		campos = append(campos, q.DhEmi.Format("02"), fmt.Sprintf("%.2f", q.VNF), hex.EncodeToString([]byte(q.DigVal)))
+	}
+	campos = append(campos, strconv.Itoa(idCSC))
+
+	p := strings.Join(campos, "|")
+	hash := sha1.Sum([]byte(p + cfg.CSC))
+	return p + "|" + strings.ToUpper(hex.EncodeToString(hash[:])), nil
+}
+
+// parametroV3 monta o parâmetro da versão 3: chave|3|tpAmb na emissão online e chave|3|tpAmb|dia|vNF|tpIdDest|idDest|assinatura na offline, sendo assinatura a assinatura RSA-SHA1 (em base64) dos campos anteriores, com a chave privada do emitente.
+func (cfg ConfigNFCe) parametroV3(q QRCodeNFCe, offline bool) (string, error) {
+	campos := []string{q.ChNFe, "3", strconv.Itoa(int(q.TpAmb))}
+	if !offline {
+		return strings.Join(campos, "|"), nil
+	}
+
+	if cfg.ChavePrivada == nil {
+		return "", fmt.Errorf("A chave privada do emitente é obrigatória no QR Code versão 3 da emissão offline: %s", q.ChNFe)
+	}
+	if q.DhEmi.IsZero() {
+		return "", fmt.Errorf("dhEmi é obrigatório no QR Code da emissão offline: %s", q.ChNFe)
+	}
+
+	tpIdDest, idDest := "", ""
+	switch {
+	case q.DestCNPJ != "":
+		tpIdDest, idDest = "1", q.DestCNPJ
+	case q.DestCPF != "":
+		tpIdDest, idDest = "2", q.DestCPF
+	case q.DestIdEstrangeiro != "":
+		tpIdDest, idDest = "3", q.DestIdEstrangeiro
+	}
+	campos = append(campos, q.DhEmi.Format("02"), fmt.Sprintf("%.2f", q.VNF), tpIdDest, idDest)
+
+	p := strings.Join(campos, "|")
+	hashed := sha1.Sum([]byte(p))
+	sig, err := rsa.SignPKCS1v15(nil, cfg.ChavePrivada, crypto.SHA1, hashed[:])
+	if err != nil {
+		return "", fmt.Errorf("Erro na assinatura do QR Code: %w", err)
+	}
+	return p + "|" + base64.StdEncoding.EncodeToString(sig), nil
+}
+
+// MontaInfNFeSupl monta as informações suplementares da NFC-e: a URL completa do QR Code (URL da UF seguida do parâmetro p) e a URL de consulta pela chave de acesso.
+func (cfg ConfigNFCe) MontaInfNFeSupl(q QRCodeNFCe) (InfNFeSupl, error) {
+	cUF, _, _, _, _, _, _, _, _, err := GetChaveInfo(q.ChNFe)
+	if err != nil {
+		return InfNFeSupl{}, err
+	}
+	urls, err := cfg.registry().URLsNFCe(cUF, q.TpAmb)
+	if err != nil {
+		return InfNFeSupl{}, err
+	}
+	p, err := cfg.Parametro(q)
+	if err != nil {
+		return InfNFeSupl{}, err
+	}
+
+	return InfNFeSupl{QrCode: urls.QRCode + "?p=" + p, UrlChave: urls.URLChave}, nil
+}
+
+// AdicionaInfNFeSupl inclui o grupo infNFeSupl em cada NFC-e do XML informado (NFe isolada, enviNFe ou nfeProc), a partir dos dados da própria nota. O XML deve estar assinado, pois a emissão offline usa o DigestValue da assinatura no QR Code; o infNFeSupl não faz parte da assinatura e é inserido entre o infNFe e a Signature, substituindo um eventual grupo já existente. Os demais bytes do XML são mantidos sem alteração, como na AttachProtocol.
+func (cfg ConfigNFCe) AdicionaInfNFeSupl(xmlNFe []byte) ([]byte, error) {
+	posicoes, err := posicoesElementos(xmlNFe, "NFe")
+	if err != nil {
+		return nil, fmt.Errorf("Erro na leitura do XML da NFC-e: %w", err)
+	}
+	if len(posicoes) == 0 {
+		return nil, fmt.Errorf("Nenhuma NFC-e encontrada no XML")
+	}
+
+	var out []byte
+	fim := int64(0)
+	for _, p := range posicoes {
+		bruto := xmlNFe[p[0]:p[1]]
+		nfe, err := lerElemento(bruto)
+		if err != nil {
+			return nil, err
+		}
+		q, err := qrCodeFromXML(nfe)
+		if err != nil {
+			return nil, err
+		}
+		supl, err := cfg.MontaInfNFeSupl(q)
+		if err != nil {
+			return nil, err
+		}
+		comSupl, err := insereInfNFeSupl(bruto, supl)
+		if err != nil {
+			return nil, err
+		}
+		out = append(append(out, xmlNFe[fim:p[0]]...), comSupl...)
+		fim = p[1]
+	}
+	return append(out, xmlNFe[fim:]...), nil
+}
+
+// insereInfNFeSupl insere o infNFeSupl logo após o infNFe, nos bytes do elemento NFe, removendo um eventual grupo já existente. Os demais bytes não são alterados.
+func insereInfNFeSupl(nfe []byte, supl InfNFeSupl) ([]byte, error) {
+	infNFe, err := posicoesElementos(nfe, "infNFe")
+	if err != nil {
+		return nil, err
+	}
+	if len(infNFe) != 1 {
+		return nil, fmt.Errorf("NFC-e sem infNFe")
+	}
+	antigos, err := posicoesElementos(nfe, "infNFeSupl")
+	if err != nil {
+		return nil, err
+	}
+
+	var grupo bytes.Buffer
+	grupo.WriteString("<infNFeSupl><qrCode>")
+	xml.EscapeText(&grupo, []byte(supl.QrCode))
+	grupo.WriteString("</qrCode><urlChave>")
+	xml.EscapeText(&grupo, []byte(supl.UrlChave))
+	grupo.WriteString("</urlChave></infNFeSupl>")
+
+	fim := infNFe[0][1]
+	out := append(append([]byte(nil), nfe[:fim]...), grupo.Bytes()...)
+	inicio := fim
+	for _, p := range antigos {
+		if p[0] < fim {
+			return nil, fmt.Errorf("NFC-e com infNFeSupl antes do infNFe")
+		}
+		out = append(out, nfe[inicio:p[0]]...)
+		inicio = p[1]
+	}
+	return append(out, nfe[inicio:]...), nil
+}
+
+// qrCodeFromXML extrai do elemento NFe os dados usados no QR Code.
+func qrCodeFromXML(nfe *etree.Element) (QRCodeNFCe, error) {
+	inf := nfe.SelectElement("infNFe")
+	if inf == nil {
+		return QRCodeNFCe{}, fmt.Errorf("NFC-e sem infNFe")
+	}
+	q := QRCodeNFCe{ChNFe: strings.TrimPrefix(inf.SelectAttrValue("Id", ""), "NFe")}
+
+	texto := func(path string) string {
+		if el := inf.FindElement(path); el != nil {
+			return strings.TrimSpace(el.Text())
+		}
+		return ""
+	}
+
+	tpAmb, err := strconv.Atoi(texto("ide/tpAmb"))
+	if err != nil {
+		return QRCodeNFCe{}, fmt.Errorf("tpAmb inválido na NFC-e %s", q.ChNFe)
+	}
+	q.TpAmb = TAmb(tpAmb)
+
+	if dhEmi := texto("ide/dhEmi"); dhEmi != "" {
+		if q.DhEmi, err = time.Parse(time.RFC3339, dhEmi); err != nil {
+			return QRCodeNFCe{}, fmt.Errorf("dhEmi inválido na NFC-e %s: %w", q.ChNFe, err)
+		}
+	}
+	if vNF := texto("total/ICMSTot/vNF"); vNF != "" {
+		if q.VNF, err = strconv.ParseFloat(vNF, 64); err != nil {
+			return QRCodeNFCe{}, fmt.Errorf("vNF inválido na NFC-e %s: %w", q.ChNFe, err)
+		}
+	}
+	q.DestCNPJ = texto("dest/CNPJ")
+	q.DestCPF = texto("dest/CPF")
+	q.DestIdEstrangeiro = texto("dest/idEstrangeiro")
+
+	if dv := nfe.FindElement("Signature/SignedInfo/Reference/DigestValue"); dv != nil {
+		q.DigVal = strings.TrimSpace(dv.Text())
+	}
+
+	return q, nil
+}
@@ -0,0 +1,174 @@
+package nfe
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha1"
+	"encoding/base64"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/beevik/etree"
+)
+
+const (
+	chaveNFCeOnline  = "35240511222333000181650010000001231123456788"
+	chaveNFCeOffline = "35240511222333000181650010000001249123456780"
+)
+
+func TestQRCodeV2(t *testing.T) {
+	cfg := ConfigNFCe{IdCSC: "000001", CSC: "0123456789"}
+	dhEmi := time.Date(2024, 5, 17, 10, 30, 0, 0, time.FixedZone("", -3*3600))
+
+	tests := []struct {
+		nome string
+		q    QRCodeNFCe
+		want string
+	}{
+		{
+			"online",
+			QRCodeNFCe{ChNFe: chaveNFCeOnline, TpAmb: Homologacao},
+			chaveNFCeOnline + "|2|2|1|3FD396EC2D0047C1EBC2072F745FFE7388C25F5B",
+		},
+		{
+			"offline",
+			QRCodeNFCe{ChNFe: chaveNFCeOffline, TpAmb: Homologacao, DhEmi: dhEmi, VNF: 60.5, DigVal: "Vh9k1wqBVyT06jXdpf8OP6tpD/U="},
+			chaveNFCeOffline + "|2|2|17|60.50|5668396b3177714256795430366a58647066384f50367470442f553d|1|2D82131430E9B27BCFFA89D1CFF55CC138A76831",
+		},
+	}
+	for _, tt := range tests {
+		got, err := cfg.Parametro(tt.q)
+		if err != nil || got != tt.want {
+			t.Errorf("%s: Parametro() = %q, %v; esperado %q", tt.nome, got, err, tt.want)
+		}
+	}
+
+	if _, err := (ConfigNFCe{}).Parametro(tests[0].q); err == nil {
+		t.Errorf("QR Code versão 2 sem CSC deveria falhar")
+	}
+}
+
+// TestQRCodeV2Referencia confere o parâmetro da versão 2 com valores calculados fora da biblioteca (hashlib do Python), seguindo o algoritmo do Manual de Especificações Técnicas do DANFE NFC-e e QR Code: CSC de 36 caracteres, idCSC sem os zeros não significativos ("000002" é informado como 2) e digVal convertido para hexadecimal.
+func TestQRCodeV2Referencia(t *testing.T) {
+	cfg := ConfigNFCe{IdCSC: "000002", CSC: "G8063VRTNDMO886SFNK5LDUDEI24XJ22YIPO"}
+	tests := []struct {
+		q    QRCodeNFCe
+		want string
+	}{
+		{
+			QRCodeNFCe{ChNFe: "43240511222333000181650010000004561123456787", TpAmb: Producao},
+			"43240511222333000181650010000004561123456787|2|1|2|BA6F572E9B72F1110AE62728BCBCF4E581994A34",
+		},
+		{
+			QRCodeNFCe{ChNFe: "43240511222333000181650010000004579123456780", TpAmb: Producao, DhEmi: time.Date(2024, 5, 5, 23, 59, 0, 0, time.FixedZone("", -3*3600)), VNF: 1234.56, DigVal: "a5mvUFQMaqVx0s1yl0mUCUKexXo="},
+			"43240511222333000181650010000004579123456780|2|1|05|1234.56|61356d765546514d61715678307331796c306d5543554b6578586f3d|2|0B4713A4C825592AEDCD58A6008645A7D31ADA35",
+		},
+	}
+	for _, tt := range tests {
+		if got, err := cfg.Parametro(tt.q); err != nil || got != tt.want {
+			t.Errorf("Parametro() = %q, %v; esperado %q", got, err, tt.want)
+		}
+	}
+}
+
+func TestQRCodeV3(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := ConfigNFCe{VersaoQRCode: QRCodeV3, ChavePrivada: key}
+
+	got, err := cfg.Parametro(QRCodeNFCe{ChNFe: chaveNFCeOnline, TpAmb: Producao})
+	if want := chaveNFCeOnline + "|3|1"; err != nil || got != want {
+		t.Errorf("online: Parametro() = %q, %v; esperado %q", got, err, want)
+	}
+
+	q := QRCodeNFCe{ChNFe: chaveNFCeOffline, TpAmb: Producao, DhEmi: time.Date(2024, 5, 3, 9, 0, 0, 0, time.UTC), VNF: 10, DestCPF: "12345678909"}
+	got, err = cfg.Parametro(q)
+	if err != nil {
+		t.Fatal(err)
+	}
+	i := strings.LastIndex(got, "|")
+	if want := chaveNFCeOffline + "|3|1|03|10.00|2|12345678909"; got[:i] != want {
+		t.Fatalf("offline: Parametro() = %q; esperado %q|<assinatura>", got, want)
+	}
+	sig, err := base64.StdEncoding.DecodeString(got[i+1:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	hashed := sha1.Sum([]byte(got[:i]))
+	if err := rsa.VerifyPKCS1v15(&key.PublicKey, crypto.SHA1, hashed[:], sig); err != nil {
+		t.Errorf("assinatura do QR Code inválida: %v", err)
+	}
+}
+
+func TestAdicionaInfNFeSupl(t *testing.T) {
+	xmlNFe := `<NFe xmlns="http://www.portalfiscal.inf.br/nfe"><infNFe Id="NFe` + chaveNFCeOnline + `" versao="4.00"><ide><tpAmb>2</tpAmb><dhEmi>2024-05-17T10:30:00-03:00</dhEmi></ide><total><ICMSTot><vNF>60.50</vNF></ICMSTot></total></infNFe><infNFeSupl><qrCode>antigo</qrCode><urlChave>antiga</urlChave></infNFeSupl><Signature xmlns="http://www.w3.org/2000/09/xmldsig#"><SignedInfo><Reference><DigestValue>Vh9k1wqBVyT06jXdpf8OP6tpD/U=</DigestValue></Reference></SignedInfo></Signature></NFe>`
+
+	out, err := ConfigNFCe{IdCSC: "1", CSC: "0123456789"}.AdicionaInfNFeSupl([]byte(xmlNFe))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	doc := etree.NewDocument()
+	if err := doc.ReadFromBytes(out); err != nil {
+		t.Fatal(err)
+	}
+	filhos := doc.Root().ChildElements()
+	if len(filhos) != 3 || filhos[0].Tag != "infNFe" || filhos[1].Tag != "infNFeSupl" || filhos[2].Tag != "Signature" {
+		t.Fatalf("infNFeSupl fora de posição: %s", out)
+	}
+
+	want := "https://www.homologacao.nfce.fazenda.sp.gov.br/qrcode?p=" + chaveNFCeOnline + "|2|2|1|3FD396EC2D0047C1EBC2072F745FFE7388C25F5B"
+	if got := filhos[1].SelectElement("qrCode").Text(); got != want {
+		t.Errorf("qrCode = %q; esperado %q", got, want)
+	}
+	if got := filhos[1].SelectElement("urlChave").Text(); got != "https://www.homologacao.nfce.fazenda.sp.gov.br/consulta" {
+		t.Errorf("urlChave = %q", got)
+	}
+}
+
+func TestAdicionaInfNFeSuplBytes(t *testing.T) {
+	// Declaração, espaços, entidades e prefixos que uma nova serialização alteraria.
+	antes := `<?xml version="1.0" encoding="UTF-8"?>` + "\n" + `<nfeProc xmlns="http://www.portalfiscal.inf.br/nfe" versao="4.00"><NFe xmlns="http://www.portalfiscal.inf.br/nfe">
+  <infNFe Id="NFe` + chaveNFCeOnline + `" versao="4.00"><ide><tpAmb>2</tpAmb><dhEmi>2024-05-17T10:30:00-03:00</dhEmi></ide><emit><xNome>A &amp; B &#8211; LTDA</xNome></emit><total><ICMSTot><vNF>60.50</vNF></ICMSTot></total></infNFe>
+  `
+	depois := `
+  <Signature xmlns="http://www.w3.org/2000/09/xmldsig#"><SignedInfo ><Reference URI='#NFe` + chaveNFCeOnline + `'><DigestValue>Vh9k1wqBVyT06jXdpf8OP6tpD/U=</DigestValue></Reference></SignedInfo></Signature></NFe><protNFe versao="4.00"><infProt><cStat>100</cStat></infProt></protNFe></nfeProc>
+`
+	cfg := ConfigNFCe{IdCSC: "1", CSC: "0123456789"}
+	for nome, xmlNFe := range map[string]string{
+		"sem infNFeSupl": strings.TrimRight(antes, " \n") + depois,
+		"com infNFeSupl": antes + `<infNFeSupl><qrCode>antigo</qrCode><urlChave>antiga</urlChave></infNFeSupl>` + depois,
+	} {
+		out, err := cfg.AdicionaInfNFeSupl([]byte(xmlNFe))
+		if err != nil {
+			t.Fatal(err)
+		}
+		i, j := strings.Index(string(out), "<infNFeSupl>"), strings.Index(string(out), "</infNFeSupl>")+len("</infNFeSupl>")
+		if i < 0 || j < i {
+			t.Fatalf("%s: infNFeSupl não incluído: %s", nome, out)
+		}
+		sem := strings.Replace(xmlNFe, `<infNFeSupl><qrCode>antigo</qrCode><urlChave>antiga</urlChave></infNFeSupl>`, "", 1)
+		if string(out[:i])+string(out[j:]) != sem || !strings.HasSuffix(string(out[:i]), "</infNFe>") || strings.Contains(string(out), "antigo") {
+			t.Errorf("%s: bytes fora do infNFeSupl alterados:\n%s", nome, out)
+		}
+	}
+
+	if _, err := cfg.AdicionaInfNFeSupl([]byte(`<nfeProc/>`)); err == nil {
+		t.Error("XML sem NFC-e deveria falhar")
+	}
+}
+
+func TestRegistryURLsNFCe(t *testing.T) {
+	for _, tpAmb := range []TAmb{Producao, Homologacao} {
+		for _, cUF := range todasUFs {
+			u, err := DefaultRegistry.URLsNFCe(cUF, tpAmb)
+			if err != nil || u.QRCode == "" || u.URLChave == "" {
+				t.Errorf("%s/%d: %v (%+v)", GetUF(cUF), tpAmb, err, u)
+			}
+		}
+	}
+}
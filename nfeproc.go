@@ -0,0 +1,227 @@
+package nfe
+
+import (
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/beevik/etree"
+)
+
+const (
+	VerNFeProc       = "4.00"
+	VerProcEventoNFe = "1.00"
+)
+
+// ErrDigValDivergente indica que o protocolo (digVal) não corresponde à assinatura (DigestValue) do XML informado: o protocolo é de outra versão assinada da mesma chave de acesso (ou, na duplicidade 539, de outra NFe com o mesmo número e série; ver DuplicidadeError).
+var ErrDigValDivergente = errors.New("digVal do protocolo diferente do DigestValue da assinatura")
+
+// ErrDigValAusente indica que o protocolo não tem digVal, ou que o XML informado não tem assinatura (DigestValue): não é possível conferir se o protocolo é da NFe informada.
+var ErrDigValAusente = errors.New("protocolo sem digVal ou NFe sem DigestValue na assinatura")
+
+// cStat do protNFe com o qual a NFe passa a ter um nfeProc: autorizada (100 e 150) ou denegada (110, 301, 302 e 303).
+var cStatProtNFe = map[int]bool{100: true, 150: true, 110: true, 301: true, 302: true, 303: true}
+
+// cStat do retEvento de um evento registrado (135 e 136) ou, no cancelamento, homologado fora de prazo (155).
+var cStatRetEvento = map[int]bool{135: true, 136: true, 155: true}
+
+// AttachProtocol junta a NFe assinada ao seu protocolo no nfeProc, preservando os bytes originais dos dois (uma nova serialização com encoding/xml invalidaria a assinatura).
+//
+// xmlNFe pode ser a NFe isolada, o enviNFe ou um nfeProc; xmlProt pode ser o protNFe isolado ou qualquer retorno que o contenha (retEnviNFe, retConsReciNFe com várias notas ou retConsSitNFe), do qual é usado o protNFe da chave de acesso da NFe. O protocolo precisa autorizar (ou denegar) o uso e o seu digVal deve coincidir com o DigestValue da assinatura (ver ErrDigValDivergente); um protocolo sem digVal ou uma NFe sem assinatura são recusados com ErrDigValAusente.
+func AttachProtocol(xmlNFe, xmlProt []byte) ([]byte, error) {
+	brutoNFe, err := elementosBrutos(xmlNFe, "NFe")
+	if err != nil {
+		return nil, err
+	}
+	if len(brutoNFe) != 1 {
+		return nil, fmt.Errorf("O XML deve conter exatamente uma NFe; encontradas %d", len(brutoNFe))
+	}
+	nfe, err := lerElemento(brutoNFe[0])
+	if err != nil {
+		return nil, err
+	}
+	infNFe := nfe.FindElement("infNFe")
+	if infNFe == nil {
+		return nil, fmt.Errorf("NFe sem o grupo infNFe")
+	}
+	chNFe := strings.TrimPrefix(infNFe.SelectAttrValue("Id", ""), "NFe")
+	digestValue := textoElemento(nfe, "Signature/SignedInfo/Reference/DigestValue")
+
+	protocolos, err := elementosBrutos(xmlProt, "protNFe")
+	if err != nil {
+		return nil, err
+	}
+	for _, bruto := range protocolos {
+		prot, err := lerElemento(bruto)
+		if err != nil {
+			return nil, err
+		}
+		if textoElemento(prot, "infProt/chNFe") != chNFe {
+			continue
+		}
+
+		cStat := textoElemento(prot, "infProt/cStat")
+		if !cStatProtNFe[atoi(cStat)] {
+			return nil, fmt.Errorf("O protocolo da NFe %s não autoriza o uso: %s - %s", chNFe, cStat, textoElemento(prot, "infProt/xMotivo"))
+		}
+		digVal := textoElemento(prot, "infProt/digVal")
+		if digVal == "" || digestValue == "" {
+			return nil, fmt.Errorf("NFe %s: %w (digVal %q, DigestValue %q)", chNFe, ErrDigValAusente, digVal, digestValue)
+		}
+		if digVal != digestValue {
+			return nil, fmt.Errorf("NFe %s: %w (digVal %q, DigestValue %q)", chNFe, ErrDigValDivergente, digVal, digestValue)
+		}
+
+		var b bytes.Buffer
+		b.WriteString(xml.Header[:len(xml.Header)-1])
+		fmt.Fprintf(&b, `<nfeProc xmlns="%s" versao="%s">`, xmlnsNFe, VerNFeProc)
+		b.Write(brutoNFe[0])
+		b.Write(bruto)
+		b.WriteString(`</nfeProc>`)
+		return b.Bytes(), nil
+	}
+	return nil, fmt.Errorf("Nenhum protNFe da NFe %s encontrado no XML do protocolo", chNFe)
+}
+
+// AttachEventProtocol junta o evento assinado ao seu retorno no procEventoNFe, preservando os bytes originais dos dois.
+//
+// xmlEvento pode ser o evento isolado ou o envEvento (com um único evento); xmlRetEvento pode ser o retEvento isolado ou o retEnvEvento, do qual é usado o retEvento do mesmo chNFe, tpEvento e nSeqEvento. O evento precisa ter sido registrado (cStat 135, 136 ou 155).
+func AttachEventProtocol(xmlEvento, xmlRetEvento []byte) ([]byte, error) {
+	brutoEvento, err := elementosBrutos(xmlEvento, "evento")
+	if err != nil {
+		return nil, err
+	}
+	if len(brutoEvento) != 1 {
+		return nil, fmt.Errorf("O XML deve conter exatamente um evento; encontrados %d", len(brutoEvento))
+	}
+	evento, err := lerElemento(brutoEvento[0])
+	if err != nil {
+		return nil, err
+	}
+	chave := func(e *etree.Element) string {
+		return textoElemento(e, "infEvento/chNFe") + "|" + textoElemento(e, "infEvento/tpEvento") + "|" + fmt.Sprint(atoi(textoElemento(e, "infEvento/nSeqEvento")))
+	}
+	id, idEvento := chave(evento), ""
+	if inf := evento.FindElement("infEvento"); inf != nil {
+		idEvento = inf.SelectAttrValue("Id", "")
+	}
+
+	retornos, err := elementosBrutos(xmlRetEvento, "retEvento")
+	if err != nil {
+		return nil, err
+	}
+	for _, bruto := range retornos {
+		ret, err := lerElemento(bruto)
+		if err != nil {
+			return nil, err
+		}
+		if chave(ret) != id {
+			continue
+		}
+
+		cStat := textoElemento(ret, "infEvento/cStat")
+		if !cStatRetEvento[atoi(cStat)] {
+			return nil, fmt.Errorf("O evento %s não foi registrado: %s - %s", idEvento, cStat, textoElemento(ret, "infEvento/xMotivo"))
+		}
+
+		var b bytes.Buffer
+		b.WriteString(xml.Header[:len(xml.Header)-1])
+		fmt.Fprintf(&b, `<procEventoNFe xmlns="%s" versao="%s">`, xmlnsNFe, evento.SelectAttrValue("versao", VerProcEventoNFe))
+		b.Write(brutoEvento[0])
+		b.Write(bruto)
+		b.WriteString(`</procEventoNFe>`)
+		return b.Bytes(), nil
+	}
+	return nil, fmt.Errorf("Nenhum retEvento do evento %s encontrado no XML do retorno", idEvento)
+}
+
+// RecuperaNFeProc reconstrói o nfeProc de uma NFe cujo protocolo se perdeu (por exemplo, por uma falha de comunicação após o envio), juntando o XML assinado guardado pelo emitente ao protNFe obtido na ConsultaNFe. Também retorna o resultado da consulta, que informa, por exemplo, se a NFe não existe na Sefaz.
+func RecuperaNFeProc(xmlNFe []byte, tpAmb TAmb, client *http.Client, optReq ...func(req *http.Request)) ([]byte, RetConsSitNFe, error) {
+	nfe, err := lerNFeXML(xmlNFe)
+	if err != nil {
+		return nil, RetConsSitNFe{}, err
+	}
+	infNFe := nfe.FindElement("infNFe")
+	if infNFe == nil {
+		return nil, RetConsSitNFe{}, fmt.Errorf("NFe sem o grupo infNFe")
+	}
+	chNFe := strings.TrimPrefix(infNFe.SelectAttrValue("Id", ""), "NFe")
+
+	ret, xmlfile, err := ConsultaNFe(chNFe, tpAmb, client, optReq...)
+	if err != nil {
+		return nil, ret, err
+	}
+	if ret.ProtNFe == nil {
+		return nil, ret, fmt.Errorf("A consulta da NFe %s não retornou o protocolo: %d - %s", chNFe, ret.CStat, ret.XMotivo)
+	}
+
+	// O protNFe é tomado dos bytes da resposta, e não do ProtNFe desserializado, para preservar uma eventual assinatura da Sefaz.
+	proc, err := AttachProtocol(xmlNFe, xmlfile)
+	return proc, ret, err
+}
+
+// elementosBrutos retorna os bytes originais de todos os elementos com o nome local informado, sem nenhuma alteração. Os elementos com prefixo de namespace (ex.: <ns2:NFe>) não são aceitos, porque os seus bytes não podem ser usados fora do documento original.
+func elementosBrutos(data []byte, local string) ([][]byte, error) {
+	posicoes, err := posicoesElementos(data, local)
+	if err != nil {
+		return nil, err
+	}
+	r := make([][]byte, len(posicoes))
+	for i, p := range posicoes {
+		r[i] = data[p[0]:p[1]]
+	}
+	return r, nil
+}
+
+// posicoesElementos retorna o início e o fim, nos bytes originais, de todos os elementos com o nome local informado (ver elementosBrutos).
+func posicoesElementos(data []byte, local string) ([][2]int64, error) {
+	d := xml.NewDecoder(bytes.NewReader(data))
+	var r [][2]int64
+	for {
+		inicio := d.InputOffset()
+		tok, err := d.Token()
+		if err == io.EOF {
+			return r, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("Erro na leitura do XML: %w", err)
+		}
+		se, ok := tok.(xml.StartElement)
+		if !ok || se.Name.Local != local {
+			continue
+		}
+		if err := d.Skip(); err != nil {
+			return nil, fmt.Errorf("Erro na leitura do XML: %w", err)
+		}
+		bruto := data[inicio:d.InputOffset()]
+		if !bytes.HasPrefix(bruto, []byte("<"+local)) {
+			return nil, fmt.Errorf("Elemento %s com prefixo de namespace não suportado: %.40s", local, bruto)
+		}
+		r = append(r, [2]int64{inicio, d.InputOffset()})
+	}
+}
+
+func lerElemento(bruto []byte) (*etree.Element, error) {
+	doc := etree.NewDocument()
+	if err := doc.ReadFromBytes(bruto); err != nil {
+		return nil, fmt.Errorf("Erro na leitura do XML: %w", err)
+	}
+	return doc.Root(), nil
+}
+
+func textoElemento(e *etree.Element, path string) string {
+	if el := e.FindElement(path); el != nil {
+		return strings.TrimSpace(el.Text())
+	}
+	return ""
+}
+
+func atoi(s string) int {
+	n, _ := strconv.Atoi(s)
+	return n
+}
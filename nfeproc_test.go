@@ -0,0 +1,124 @@
+package nfe
+
+import (
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+const chaveProcTeste = "35240511222333000181550010000001231123456781"
+
+// nfeAssinadaTeste imita uma NFe assinada com formatação própria (aspas simples, espaços e quebras de linha), que uma nova serialização alteraria.
+const nfeAssinadaTeste = "<NFe xmlns='http://www.portalfiscal.inf.br/nfe'>\n  <infNFe versao='4.00' Id='NFe" + chaveProcTeste + "'><ide><cUF>35</cUF><mod>55</mod></ide><emit><xNome>A &amp; B LTDA</xNome></emit></infNFe>\n  <Signature xmlns=\"http://www.w3.org/2000/09/xmldsig#\"><SignedInfo><Reference URI=\"#NFe" + chaveProcTeste + "\"><DigestValue>Vh9k1wqBVyT06jXdpf8OP6tpD/U=</DigestValue></Reference></SignedInfo><SignatureValue>AAAA</SignatureValue></Signature></NFe>"
+
+func protNFeTeste(chNFe string, cStat int, digVal string) string {
+	return `<protNFe versao="4.00"><infProt Id="ID135240000000001"><tpAmb>2</tpAmb><verAplic>SP_NFE_PL009_V4</verAplic><chNFe>` + chNFe + `</chNFe><dhRecbto>2024-05-17T10:31:02-03:00</dhRecbto><nProt>135240000000001</nProt><digVal>` + digVal + `</digVal><cStat>` + strconv.Itoa(cStat) + `</cStat><xMotivo>Motivo</xMotivo></infProt></protNFe>`
+}
+
+func TestAttachProtocol(t *testing.T) {
+	digVal := "Vh9k1wqBVyT06jXdpf8OP6tpD/U="
+	enviNFe := []byte(`<?xml version="1.0" encoding="UTF-8"?><enviNFe xmlns="http://www.portalfiscal.inf.br/nfe" versao="4.00"><idLote>1</idLote><indSinc>0</indSinc>` + nfeAssinadaTeste + `</enviNFe>`)
+	retConsReci := []byte(`<retConsReciNFe xmlns="http://www.portalfiscal.inf.br/nfe" versao="4.00"><cStat>104</cStat>` +
+		protNFeTeste("35240511222333000181550010000001241123456786", 100, "outro") + protNFeTeste(chaveProcTeste, 100, digVal) + `</retConsReciNFe>`)
+
+	proc, err := AttachProtocol(enviNFe, retConsReci)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `<?xml version="1.0" encoding="UTF-8"?><nfeProc xmlns="http://www.portalfiscal.inf.br/nfe" versao="4.00">` + nfeAssinadaTeste + protNFeTeste(chaveProcTeste, 100, digVal) + `</nfeProc>`
+	if string(proc) != want {
+		t.Errorf("AttachProtocol() =\n%s\nesperado\n%s", proc, want)
+	}
+
+	var p NFeProc
+	if err := xml.Unmarshal(proc, &p); err != nil || p.ProtNFe.InfProt.ChNFe != chaveProcTeste || p.NFe.InfNFe.Emit.XNome != "A & B LTDA" {
+		t.Errorf("nfeProc inválido: %v %+v", err, p.ProtNFe.InfProt)
+	}
+
+	// O próprio nfeProc pode ser usado como entrada.
+	if again, err := AttachProtocol(proc, proc); err != nil || !bytes.Equal(again, proc) {
+		t.Errorf("AttachProtocol(nfeProc) = %s, %v", again, err)
+	}
+
+	tests := []struct {
+		nome string
+		prot string
+		erro string
+	}{
+		{"digVal divergente", protNFeTeste(chaveProcTeste, 100, "OUTRO="), "digVal"},
+		{"rejeitada", protNFeTeste(chaveProcTeste, 539, digVal), "não autoriza"},
+		{"outra chave", protNFeTeste("35240511222333000181550010000001241123456786", 100, digVal), "Nenhum protNFe"},
+	}
+	for _, tt := range tests {
+		_, err := AttachProtocol([]byte(nfeAssinadaTeste), []byte(tt.prot))
+		if err == nil || !strings.Contains(err.Error(), tt.erro) {
+			t.Errorf("%s: erro %v; esperado %q", tt.nome, err, tt.erro)
+		}
+	}
+	if _, err := AttachProtocol([]byte(nfeAssinadaTeste), []byte(protNFeTeste(chaveProcTeste, 100, "OUTRO="))); !errors.Is(err, ErrDigValDivergente) {
+		t.Errorf("erro %v; esperado ErrDigValDivergente", err)
+	}
+
+	// Sem o digVal do protocolo ou sem a assinatura da NFe, não há como conferir o protocolo.
+	if _, err := AttachProtocol([]byte(nfeAssinadaTeste), []byte(protNFeTeste(chaveProcTeste, 100, ""))); !errors.Is(err, ErrDigValAusente) {
+		t.Errorf("protocolo sem digVal: erro %v; esperado ErrDigValAusente", err)
+	}
+	semAssinatura := []byte(`<NFe xmlns="http://www.portalfiscal.inf.br/nfe"><infNFe versao="4.00" Id="NFe` + chaveProcTeste + `"/></NFe>`)
+	if _, err := AttachProtocol(semAssinatura, []byte(protNFeTeste(chaveProcTeste, 100, digVal))); !errors.Is(err, ErrDigValAusente) {
+		t.Errorf("NFe sem assinatura: erro %v; esperado ErrDigValAusente", err)
+	}
+
+	// Denegada (110) também tem nfeProc.
+	if _, err := AttachProtocol([]byte(nfeAssinadaTeste), []byte(protNFeTeste(chaveProcTeste, 110, digVal))); err != nil {
+		t.Errorf("denegada: %v", err)
+	}
+}
+
+func TestAttachEventProtocol(t *testing.T) {
+	evento := "<evento xmlns='http://www.portalfiscal.inf.br/nfe' versao='1.00'><infEvento Id='ID110111" + chaveProcTeste + "01'><chNFe>" + chaveProcTeste + "</chNFe><tpEvento>110111</tpEvento><nSeqEvento>1</nSeqEvento></infEvento>\n<Signature xmlns=\"http://www.w3.org/2000/09/xmldsig#\"/></evento>"
+	retEvento := func(tpEvento string, cStat int) string {
+		return `<retEvento versao="1.00"><infEvento><cStat>` + strconv.Itoa(cStat) + `</cStat><xMotivo>Evento registrado</xMotivo><chNFe>` + chaveProcTeste + `</chNFe><tpEvento>` + tpEvento + `</tpEvento><nSeqEvento>1</nSeqEvento><nProt>135240000000999</nProt></infEvento></retEvento>`
+	}
+	envEvento := []byte(`<envEvento xmlns="http://www.portalfiscal.inf.br/nfe" versao="1.00"><idLote>1</idLote>` + evento + `</envEvento>`)
+	retEnvEvento := []byte(`<retEnvEvento xmlns="http://www.portalfiscal.inf.br/nfe" versao="1.00"><cStat>128</cStat>` + retEvento("110110", 135) + retEvento("110111", 135) + `</retEnvEvento>`)
+
+	proc, err := AttachEventProtocol(envEvento, retEnvEvento)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `<?xml version="1.0" encoding="UTF-8"?><procEventoNFe xmlns="http://www.portalfiscal.inf.br/nfe" versao="1.00">` + evento + retEvento("110111", 135) + `</procEventoNFe>`
+	if string(proc) != want {
+		t.Errorf("AttachEventProtocol() =\n%s\nesperado\n%s", proc, want)
+	}
+	var p ProcEventoNFe
+	if err := xml.Unmarshal(proc, &p); err != nil || p.RetEvento == nil || p.RetEvento.InfEvento.NProt != "135240000000999" {
+		t.Errorf("procEventoNFe inválido: %v", err)
+	}
+
+	if _, err := AttachEventProtocol(envEvento, []byte(retEvento("110111", 573))); err == nil || !strings.Contains(err.Error(), "não foi registrado") {
+		t.Errorf("evento rejeitado: erro %v", err)
+	}
+}
+
+func TestRecuperaNFeProc(t *testing.T) {
+	prot := protNFeTeste(chaveProcTeste, 100, "Vh9k1wqBVyT06jXdpf8OP6tpD/U=")
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`<soap:Envelope xmlns:soap="http://www.w3.org/2003/05/soap-envelope"><soap:Body><nfeResultMsg xmlns="http://www.portalfiscal.inf.br/nfe/wsdl/NFeConsultaProtocolo4"><retConsSitNFe xmlns="http://www.portalfiscal.inf.br/nfe" versao="4.00"><tpAmb>2</tpAmb><cStat>100</cStat><xMotivo>Autorizado o uso da NF-e</xMotivo><cUF>35</cUF><chNFe>` + chaveProcTeste + `</chNFe>` + prot + `</retConsSitNFe></nfeResultMsg></soap:Body></soap:Envelope>`))
+	}))
+	defer srv.Close()
+	DefaultRegistry.Override(35, Homologacao, ConsultaProtocolo, srv.URL)
+	defer DefaultRegistry.RemoveOverride(35, Homologacao, ConsultaProtocolo)
+
+	proc, ret, err := RecuperaNFeProc([]byte(nfeAssinadaTeste), Homologacao, srv.Client())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ret.CStat != 100 || !bytes.Contains(proc, []byte(nfeAssinadaTeste+prot)) {
+		t.Errorf("RecuperaNFeProc() = %s, cStat %d", proc, ret.CStat)
+	}
+}
@@ -0,0 +1,131 @@
+package nfetest
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"sync"
+)
+
+// reFixture reconhece o nome dos arquivos gravados pelo Gravador: sequência, mensagem de requisição e, se diferente de 200, o status HTTP (ex.: "0001-consStatServ.xml", "0002-enviNFe.503.xml").
+var reFixture = regexp.MustCompile(`^\d+-(\w+)(?:\.(\d{3}))?\.xml$`)
+
+// Gravador é um http.RoundTripper que grava em um diretório as respostas recebidas das Sefazes, para que depois sejam reproduzidas nos testes (ver Sefaz.Reproduz). Cada resposta é gravada sem alteração em um arquivo cujo nome identifica a ordem, o serviço (pela mensagem de requisição) e o status HTTP.
+//
+// Uso típico, com um certificado de homologação:
+//
+//	client, _ := nfe.NewHTTPClient("client.pem", "key.pem")
+//	client.Transport, _ = nfetest.NewGravador("testdata/sp", client.Transport)
+type Gravador struct {
+	dir       string
+	transport http.RoundTripper
+
+	mu sync.Mutex
+	n  int
+}
+
+// NewGravador cria o diretório, se necessário, e retorna um Gravador que envia as requisições pelo transport informado (ou pelo http.DefaultTransport, se nil). A numeração continua a partir das fixtures já existentes no diretório.
+func NewGravador(dir string, transport http.RoundTripper) (*Gravador, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("Erro na criação do diretório das fixtures. Detalhes: %w", err)
+	}
+	if transport == nil {
+		transport = http.DefaultTransport
+	}
+	arquivos, err := fixtures(dir)
+	if err != nil {
+		return nil, err
+	}
+	return &Gravador{dir: dir, transport: transport, n: len(arquivos)}, nil
+}
+
+// RoundTrip envia a requisição e grava a resposta.
+func (g *Gravador) RoundTrip(req *http.Request) (*http.Response, error) {
+	var corpo []byte
+	if req.Body != nil {
+		b, err := io.ReadAll(req.Body)
+		req.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		corpo = b
+		req = req.Clone(req.Context())
+		req.Body = io.NopCloser(bytes.NewReader(corpo))
+	}
+	nome := "desconhecida"
+	if r, err := lerRequisicao(corpo); err == nil {
+		nome = r.raiz
+	}
+
+	resp, err := g.transport.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	body, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	resp.Body = io.NopCloser(bytes.NewReader(body))
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.n++
+	arquivo := fmt.Sprintf("%04d-%s.xml", g.n, nome)
+	if resp.StatusCode != http.StatusOK {
+		arquivo = fmt.Sprintf("%04d-%s.%d.xml", g.n, nome, resp.StatusCode)
+	}
+	if err := os.WriteFile(filepath.Join(g.dir, arquivo), body, 0o644); err != nil {
+		return nil, fmt.Errorf("Erro na gravação da fixture %s. Detalhes: %w", arquivo, err)
+	}
+	return resp, nil
+}
+
+func fixtures(dir string) ([]string, error) {
+	entradas, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("Erro na leitura do diretório das fixtures. Detalhes: %w", err)
+	}
+	var r []string
+	for _, e := range entradas {
+		if !e.IsDir() && reFixture.MatchString(e.Name()) {
+			r = append(r, e.Name())
+		}
+	}
+	sort.Strings(r)
+	return r, nil
+}
+
+// Reproduz programa (ver Responde) as respostas gravadas pelo Gravador no diretório, na ordem da gravação. Cada resposta é enviada sem alteração, inclusive o envelope SOAP, na próxima requisição do mesmo serviço.
+func (s *Sefaz) Reproduz(dir string) error {
+	arquivos, err := fixtures(dir)
+	if err != nil {
+		return err
+	}
+	if len(arquivos) == 0 {
+		return fmt.Errorf("Nenhuma fixture encontrada em %s", dir)
+	}
+	for _, arquivo := range arquivos {
+		m := reFixture.FindStringSubmatch(arquivo)
+		ws, ok := mensagens[m[1]]
+		if !ok {
+			return fmt.Errorf("Fixture %s: mensagem desconhecida", arquivo)
+		}
+		corpo, err := os.ReadFile(filepath.Join(dir, arquivo))
+		if err != nil {
+			return fmt.Errorf("Erro na leitura da fixture %s. Detalhes: %w", arquivo, err)
+		}
+		resp := Resposta{Corpo: corpo}
+		if m[2] != "" {
+			resp.Status, _ = strconv.Atoi(m[2])
+		}
+		s.Responde(ws, resp)
+	}
+	return nil
+}
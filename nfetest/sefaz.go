@@ -0,0 +1,368 @@
+// Package nfetest implementa uma Sefaz falsa, baseada no httptest, para testar sem certificado nem rede os programas que usam a biblioteca nfe.
+//
+// A Sefaz atende todos os WebServices usados pela biblioteca (status, consulta, cadastro, eventos, distribuição e autorização) e, por padrão, mantém um estado simples: as NFe autorizadas podem ser consultadas, canceladas e obtidas na distribuição. Cada serviço pode ter respostas programadas (ver Sefaz.Responde e Sefaz.Handle) ou reproduzir as respostas gravadas de uma Sefaz real (ver Gravador e Sefaz.Reproduz).
+package nfetest
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/beevik/etree"
+	"github.com/eduardotorresdev/nfe"
+)
+
+const xmlnsNFe = "http://www.portalfiscal.inf.br/nfe"
+
+// mensagens relaciona o elemento raiz de cada mensagem de requisição ao WebService correspondente.
+var mensagens = map[string]nfe.TWebService{
+	"consStatServ": nfe.ConsultaStatus,
+	"consSitNFe":   nfe.ConsultaProtocolo,
+	"ConsCad":      nfe.ConsultaCadastro,
+	"enviNFe":      nfe.Autorizacao,
+	"consReciNFe":  nfe.RetAutorizacao,
+	"envEvento":    nfe.Evento,
+	"inutNFe":      nfe.Inutilizacao,
+	"distDFeInt":   nfe.DistribuicaoDFe,
+}
+
+// Requisicao é uma requisição recebida pela Sefaz falsa.
+type Requisicao struct {
+	Servico    nfe.TWebService
+	SOAPAction string
+	// Mensagem é o XML enviado dentro do envelope SOAP (consStatServ, enviNFe, envEvento etc.), com os bytes originais.
+	Mensagem []byte
+
+	soap      string // namespace do envelope SOAP da requisição
+	xmlns     string // namespace do nfeDadosMsg
+	envelope  string // formato específico do retorno ("MT", "MG" ou "Dist")
+	raiz      string // elemento raiz da Mensagem
+	urlPath   string
+	documento *etree.Element
+}
+
+// Resposta é o retorno programado para uma requisição.
+type Resposta struct {
+	// Status é o status HTTP. Zero equivale a 200.
+	Status int
+	// XML é a mensagem de retorno (retConsStatServ, retEnviNFe etc.), que é envelopada no formato esperado pelo serviço.
+	XML []byte
+	// Corpo, se informado, é enviado como está, sem envelope. Usado para as fixtures gravadas, os SOAP Fault e as páginas de erro.
+	Corpo []byte
+	// Atraso é o tempo de espera antes da resposta, por exemplo para simular timeouts.
+	Atraso time.Duration
+}
+
+// Handler gera a resposta de um WebService. A Requisicao pode ser inspecionada, por exemplo, com Requisicao.Valor.
+type Handler func(req Requisicao) Resposta
+
+// Fault retorna uma resposta com status HTTP 500 e um SOAP Fault (SOAP 1.2) com o código e o motivo informados, como as Sefazes retornam em falhas internas ou em requisições mal formadas.
+func Fault(code, reason string) Resposta {
+	doc := etree.NewDocument()
+	env := doc.CreateElement("soap:Envelope")
+	env.CreateAttr("xmlns:soap", "http://www.w3.org/2003/05/soap-envelope")
+	fault := env.CreateElement("soap:Body").CreateElement("soap:Fault")
+	fault.CreateElement("soap:Code").CreateElement("soap:Value").SetText(code)
+	reasonEl := fault.CreateElement("soap:Reason").CreateElement("soap:Text")
+	reasonEl.CreateAttr("xml:lang", "pt-BR")
+	reasonEl.SetText(reason)
+	corpo, _ := doc.WriteToBytes()
+	return Resposta{Status: http.StatusInternalServerError, Corpo: corpo}
+}
+
+// Sefaz é um servidor httptest que responde como os WebServices das Sefazes. NewSefaz substitui (ver nfe.Registry.Override) as URLs de todos os serviços, em todas as UFs e nos dois ambientes, pelas do servidor, de maneira que as funções da biblioteca passam a se comunicar com ele.
+//
+// Como as substituições são feitas no nfe.DefaultRegistry, apenas uma Sefaz deve estar ativa de cada vez: os testes que a usam não devem ser executados em paralelo. Substituições feitas para uma UF específica têm precedência sobre as da Sefaz, e os envios às SVC (tpEmis 6 e 7) não são redirecionados.
+type Sefaz struct {
+	*httptest.Server
+
+	// Agora retorna o horário usado nos retornos. O padrão é time.Now.
+	Agora func() time.Time
+	// VerAplic é a versão do aplicativo informada nos retornos.
+	VerAplic string
+	// Assincrona faz a autorização processar todos os lotes de forma assíncrona (cStat 103, com o recibo), mesmo com indSinc 1, como as UFs que não oferecem o processamento síncrono.
+	Assincrona bool
+	// Processando é o número de consultas de cada recibo respondidas com o lote em processamento (cStat 105) antes do resultado.
+	Processando int
+
+	registry *nfe.Registry
+
+	mu          sync.Mutex
+	fila        map[nfe.TWebService][]Resposta
+	handlers    map[nfe.TWebService]Handler
+	requisicoes []Requisicao
+	notas       map[string]*notaSefaz
+	eventos     map[string][][]byte
+	recibos     map[string][][]byte
+	consultas   map[string]int
+	seq         int
+}
+
+// notaSefaz é uma NFe recebida (ou adicionada) pela Sefaz falsa.
+type notaSefaz struct {
+	nfe       []byte
+	prot      []byte
+	cUF       int
+	nProt     string
+	dhAut     string
+	cancelada bool
+}
+
+// NewSefaz inicia a Sefaz falsa e redireciona para ela os WebServices do nfe.DefaultRegistry. Close encerra o servidor e desfaz o redirecionamento.
+func NewSefaz() *Sefaz {
+	s := &Sefaz{
+		Agora:     time.Now,
+		VerAplic:  "NFETEST_4.00",
+		registry:  nfe.DefaultRegistry,
+		fila:      map[nfe.TWebService][]Resposta{},
+		handlers:  map[nfe.TWebService]Handler{},
+		notas:     map[string]*notaSefaz{},
+		eventos:   map[string][][]byte{},
+		recibos:   map[string][][]byte{},
+		consultas: map[string]int{},
+	}
+	s.Server = httptest.NewServer(s)
+	for ws := range servicos() {
+		for _, tpAmb := range []nfe.TAmb{nfe.Producao, nfe.Homologacao} {
+			s.registry.Override(0, tpAmb, ws, s.URL+"/"+ws.String())
+		}
+	}
+	return s
+}
+
+func servicos() map[nfe.TWebService]bool {
+	r := map[nfe.TWebService]bool{}
+	for _, ws := range mensagens {
+		r[ws] = true
+	}
+	return r
+}
+
+// Close desfaz o redirecionamento dos WebServices e encerra o servidor.
+func (s *Sefaz) Close() {
+	for ws := range servicos() {
+		for _, tpAmb := range []nfe.TAmb{nfe.Producao, nfe.Homologacao} {
+			s.registry.RemoveOverride(0, tpAmb, ws)
+		}
+	}
+	s.Server.Close()
+}
+
+// Responde programa as próximas respostas do serviço, que são usadas na ordem, uma por requisição, antes do Handler e do comportamento padrão.
+func (s *Sefaz) Responde(ws nfe.TWebService, respostas ...Resposta) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.fila[ws] = append(s.fila[ws], respostas...)
+}
+
+// Handle define a função que responde às requisições do serviço no lugar do comportamento padrão. Com h nil, o comportamento padrão é restaurado.
+func (s *Sefaz) Handle(ws nfe.TWebService, h Handler) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if h == nil {
+		delete(s.handlers, ws)
+		return
+	}
+	s.handlers[ws] = h
+}
+
+// Requisicoes retorna as requisições recebidas até o momento, em ordem.
+func (s *Sefaz) Requisicoes() []Requisicao {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Requisicao(nil), s.requisicoes...)
+}
+
+// Valor retorna o texto do elemento da mensagem indicado pelo caminho (ex.: "infCons/CNPJ"), ou "" se ele não existir.
+func (req Requisicao) Valor(path string) string {
+	if req.documento == nil {
+		return ""
+	}
+	if el := req.documento.FindElement(path); el != nil {
+		return strings.TrimSpace(el.Text())
+	}
+	return ""
+}
+
+// ServeHTTP atende as requisições SOAP.
+func (s *Sefaz) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		escreve(w, Fault("soap:Receiver", err.Error()), nil)
+		return
+	}
+	req, err := lerRequisicao(body)
+	if err != nil {
+		escreve(w, Fault("soap:Sender", err.Error()), nil)
+		return
+	}
+	req.SOAPAction = r.Header.Get("SOAPAction")
+	req.urlPath = r.URL.Path
+	if path := "/" + req.Servico.String(); req.urlPath != path {
+		escreve(w, Fault("soap:Sender", fmt.Sprintf("Mensagem %s enviada ao WebService %s", req.raiz, strings.TrimPrefix(req.urlPath, "/"))), nil)
+		return
+	}
+
+	resp := s.resposta(req)
+	if resp.Atraso > 0 {
+		select {
+		case <-time.After(resp.Atraso):
+		case <-r.Context().Done():
+			return
+		}
+	}
+	escreve(w, resp, req.envelopa(resp.XML))
+}
+
+// resposta obtém a resposta da requisição: a próxima programada, a do Handler ou a padrão.
+func (s *Sefaz) resposta(req Requisicao) Resposta {
+	s.mu.Lock()
+	s.requisicoes = append(s.requisicoes, req)
+	if fila := s.fila[req.Servico]; len(fila) > 0 {
+		s.fila[req.Servico] = fila[1:]
+		s.mu.Unlock()
+		return fila[0]
+	}
+	h, ok := s.handlers[req.Servico]
+	s.mu.Unlock()
+	if ok {
+		return h(req)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	switch req.Servico {
+	case nfe.ConsultaStatus:
+		return s.status(req)
+	case nfe.ConsultaProtocolo:
+		return s.consulta(req)
+	case nfe.ConsultaCadastro:
+		return s.cadastro(req)
+	case nfe.Autorizacao:
+		return s.autorizacao(req)
+	case nfe.RetAutorizacao:
+		return s.retAutorizacao(req)
+	case nfe.Evento:
+		return s.evento(req)
+	case nfe.DistribuicaoDFe:
+		return s.distribuicao(req)
+	}
+	return Fault("soap:Receiver", fmt.Sprintf("Serviço %v não implementado na Sefaz de teste", req.Servico))
+}
+
+func escreve(w http.ResponseWriter, resp Resposta, envelope []byte) {
+	corpo := resp.Corpo
+	if corpo == nil {
+		corpo = envelope
+	}
+	if bytes.Contains(corpo, []byte("http://schemas.xmlsoap.org/soap/envelope/")) {
+		w.Header().Set("Content-Type", "text/xml; charset=utf-8")
+	} else {
+		w.Header().Set("Content-Type", "application/soap+xml; charset=utf-8")
+	}
+	if resp.Status != 0 {
+		w.WriteHeader(resp.Status)
+	}
+	w.Write(corpo)
+}
+
+// lerRequisicao identifica o serviço e extrai a mensagem do envelope SOAP.
+func lerRequisicao(body []byte) (Requisicao, error) {
+	doc := etree.NewDocument()
+	if err := doc.ReadFromBytes(body); err != nil {
+		return Requisicao{}, fmt.Errorf("Erro na leitura do envelope SOAP: %w", err)
+	}
+	env := doc.Root()
+	if env == nil || env.Tag != "Envelope" {
+		return Requisicao{}, fmt.Errorf("Envelope SOAP não encontrado")
+	}
+	dados := doc.FindElement("//nfeDadosMsg")
+	if dados == nil || len(dados.ChildElements()) != 1 {
+		return Requisicao{}, fmt.Errorf("nfeDadosMsg não encontrado ou sem mensagem")
+	}
+	raiz := dados.ChildElements()[0].Tag
+	ws, ok := mensagens[raiz]
+	if !ok {
+		return Requisicao{}, fmt.Errorf("Mensagem desconhecida: %s", raiz)
+	}
+	brutos := elementosBrutos(body, raiz)
+	if len(brutos) == 0 {
+		return Requisicao{}, fmt.Errorf("Mensagem %s não encontrada", raiz)
+	}
+
+	req := Requisicao{
+		Servico:   ws,
+		Mensagem:  brutos[0],
+		soap:      env.NamespaceURI(),
+		xmlns:     dados.SelectAttrValue("xmlns", ""),
+		raiz:      raiz,
+		documento: dados.ChildElements()[0],
+	}
+	switch parent := dados.Parent(); {
+	case parent.Tag == "consultaCadastro":
+		req.envelope, req.xmlns = "MT", parent.SelectAttrValue("xmlns", req.xmlns)
+	case parent.Tag == "nfeDistDFeInteresse":
+		req.envelope, req.xmlns = "Dist", parent.SelectAttrValue("xmlns", req.xmlns)
+	case ws == nfe.ConsultaCadastro && envelopeCadastro(req.Valor("infCons/UF")) == "ConsCadMG":
+		req.envelope = "MG"
+	}
+	return req, nil
+}
+
+// envelopeCadastro retorna o envelope específico da consulta de cadastro da UF (ver nfe.Endpoint.Envelope).
+func envelopeCadastro(uf string) string {
+	for _, tpAmb := range []nfe.TAmb{nfe.Producao, nfe.Homologacao} {
+		if ep, err := nfe.DefaultRegistry.Lookup(nfe.GetcUF(uf), tpAmb, nfe.ConsultaCadastro); err == nil && ep.Envelope != "" {
+			return ep.Envelope
+		}
+	}
+	return ""
+}
+
+// envelopa inclui a mensagem de retorno no envelope SOAP esperado pela biblioteca para o serviço da requisição.
+func (req Requisicao) envelopa(msg []byte) []byte {
+	soap := req.soap
+	if soap == "" {
+		soap = "http://www.w3.org/2003/05/soap-envelope"
+	}
+	var b bytes.Buffer
+	b.WriteString(xml.Header)
+	fmt.Fprintf(&b, `<soap:Envelope xmlns:soap="%s" xmlns:xsi="http://www.w3.org/2001/XMLSchema-instance" xmlns:xsd="http://www.w3.org/2001/XMLSchema"><soap:Body>`, soap)
+	switch req.envelope {
+	case "MT":
+		fmt.Fprintf(&b, `<nfeResultMsg xmlns="%s"><consultaCadastroResult>%s</consultaCadastroResult></nfeResultMsg>`, req.xmlns, msg)
+	case "MG":
+		fmt.Fprintf(&b, `<consultaCadastro4Result xmlns="%s">%s</consultaCadastro4Result>`, req.xmlns, msg)
+	case "Dist":
+		fmt.Fprintf(&b, `<nfeDistDFeInteresseResponse xmlns="%s"><nfeDistDFeInteresseResult>%s</nfeDistDFeInteresseResult></nfeDistDFeInteresseResponse>`, req.xmlns, msg)
+	default:
+		fmt.Fprintf(&b, `<nfeResultMsg xmlns="%s">%s</nfeResultMsg>`, req.xmlns, msg)
+	}
+	b.WriteString(`</soap:Body></soap:Envelope>`)
+	return b.Bytes()
+}
+
+// elementosBrutos retorna os bytes originais de cada elemento com o nome local informado.
+func elementosBrutos(data []byte, local string) [][]byte {
+	dec := xml.NewDecoder(bytes.NewReader(data))
+	var r [][]byte
+	for {
+		ini := dec.InputOffset()
+		tok, err := dec.Token()
+		if err != nil {
+			return r
+		}
+		if se, ok := tok.(xml.StartElement); ok && se.Name.Local == local {
+			if err := dec.Skip(); err != nil {
+				return r
+			}
+			r = append(r, data[ini:dec.InputOffset()])
+		}
+	}
+}
@@ -0,0 +1,250 @@
+package nfetest
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/eduardotorresdev/nfe"
+)
+
+// nfeTeste monta uma NFe de homologação com uma assinatura fictícia (a Sefaz falsa só usa o DigestValue).
+func nfeTeste(t *testing.T, cUF int, numero int, digestValue string) (string, []byte) {
+	t.Helper()
+	chave, err := nfe.MontaChaveDeAcesso(cUF, 24, 5, "11222333000181", "55", 1, numero, 1, 12345678)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return chave, []byte(fmt.Sprintf(`<NFe xmlns="http://www.portalfiscal.inf.br/nfe"><infNFe versao="4.00" Id="NFe%s"><ide><cUF>%d</cUF><mod>55</mod><serie>1</serie><nNF>%d</nNF><tpAmb>2</tpAmb></ide><emit><CNPJ>11222333000181</CNPJ><xNome>EMITENTE LTDA</xNome></emit></infNFe>`+
+		`<Signature xmlns="http://www.w3.org/2000/09/xmldsig#"><SignedInfo><Reference URI="#NFe%s"><DigestValue>%s</DigestValue></Reference></SignedInfo><SignatureValue>AAAA</SignatureValue></Signature></NFe>`, chave, cUF, numero, chave, digestValue))
+}
+
+// certificadoTeste grava um certificado autoassinado e a sua chave, como os arquivos usados em nfe.NewHTTPClient.
+func certificadoTeste(t *testing.T) (certFile, keyFile string) {
+	t.Helper()
+	chave, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	modelo := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "EMITENTE LTDA"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, modelo, modelo, &chave.PublicKey, chave)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	certFile, keyFile = filepath.Join(dir, "client.pem"), filepath.Join(dir, "key.pem")
+	os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600)
+	os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(chave)}), 0o600)
+	return certFile, keyFile
+}
+
+func TestSefaz(t *testing.T) {
+	s := NewSefaz()
+	defer s.Close()
+	client := s.Client()
+
+	if ret, _, err := nfe.ConsultaStatServ(35, nfe.Homologacao, client); err != nil || ret.CStat != 107 || ret.CUF != 35 {
+		t.Errorf("ConsultaStatServ() = %+v, %v", ret, err)
+	}
+
+	chave, xmlNFe := nfeTeste(t, 35, 1, "Vh9k1wqBVyT06jXdpf8OP6tpD/U=")
+	if ret, _, err := nfe.ConsultaNFe(chave, nfe.Homologacao, client); err != nil || ret.CStat != 217 {
+		t.Errorf("ConsultaNFe(não enviada) = %+v, %v", ret, err)
+	}
+
+	// Autorização síncrona; o protocolo forma o nfeProc.
+	ret, xmlRet, err := nfe.AutorizaNFe(xmlNFe, client)
+	if err != nil || ret.CStat != 104 || ret.ProtNFe == nil || ret.ProtNFe.InfProt.CStat != 100 || ret.ProtNFe.InfProt.DigVal != "Vh9k1wqBVyT06jXdpf8OP6tpD/U=" {
+		t.Fatalf("AutorizaNFe() = %+v, %v", ret, err)
+	}
+	nProt := ret.ProtNFe.InfProt.NProt
+	if _, err := nfe.AttachProtocol(xmlNFe, xmlRet); err != nil {
+		t.Errorf("AttachProtocol() = %v", err)
+	}
+	if ret, _, err := nfe.AutorizaNFe(xmlNFe, client); err != nil || ret.ProtNFe.InfProt.CStat != 204 {
+		t.Errorf("AutorizaNFe(duplicada) = %+v, %v", ret.ProtNFe, err)
+	}
+	if ret, _, err := nfe.ConsultaNFe(chave, nfe.Homologacao, client); err != nil || ret.CStat != 100 || ret.ProtNFe.InfProt.NProt != nProt {
+		t.Errorf("ConsultaNFe(autorizada) = %+v, %v", ret, err)
+	}
+
+	// Autorização assíncrona.
+	chave2, xmlNFe2 := nfeTeste(t, 35, 2, "outro")
+	env := nfe.EnviNFe{Versao: nfe.VerEnviNFe, IdLote: "1", IndSinc: 0, NFe: xmlNFe2}
+	retEnv, _, err := env.Envia(chave2, nfe.Homologacao, client)
+	if err != nil || retEnv.CStat != 103 || retEnv.InfRec == nil {
+		t.Fatalf("EnviNFe.Envia() = %+v, %v", retEnv, err)
+	}
+	if ret, _, err := nfe.ConsultaReciboNFe(retEnv.InfRec.NRec, chave2, nfe.Homologacao, client); err != nil || ret.CStat != 104 || len(ret.ProtNFe) != 1 || ret.ProtNFe[0].InfProt.ChNFe != chave2 {
+		t.Errorf("ConsultaReciboNFe() = %+v, %v", ret, err)
+	}
+
+	// Distribuição da NFe autorizada.
+	dist, err := nfe.ConsultaDistChNFe("11222333000181", chave, nfe.Homologacao, client)
+	if err != nil || dist.Status != 138 || len(dist.Documentos) != 1 || dist.Documentos[0].Emitente.Nome != "EMITENTE LTDA" {
+		t.Errorf("ConsultaDistChNFe() = %+v, %v", dist, err)
+	}
+
+	// Cancelamento.
+	certFile, keyFile := certificadoTeste(t)
+	cancelamento := nfe.ManifestacaoEvento{COrgao: 35, TpAmb: 2, CNPJ: "11222333000181", ChNFe: chave, DhEvento: time.Now(), TpEvento: "110111", NSeqEvento: 1, VerEvento: "1.00", DescEvento: "Cancelamento"}
+	soap, err := nfe.SendManifestacaoEvento(context.Background(), client, certFile, keyFile, "1", []nfe.ManifestacaoEvento{cancelamento})
+	if err != nil || !strings.Contains(string(soap), "<cStat>135</cStat>") {
+		t.Fatalf("SendManifestacaoEvento() = %s, %v", soap, err)
+	}
+	if soap, _ := nfe.SendManifestacaoEvento(context.Background(), client, certFile, keyFile, "2", []nfe.ManifestacaoEvento{cancelamento}); !strings.Contains(string(soap), "<cStat>573</cStat>") {
+		t.Errorf("SendManifestacaoEvento(duplicado) = %s", soap)
+	}
+	if ret, _, err := nfe.ConsultaNFe(chave, nfe.Homologacao, client); err != nil || ret.CStat != 101 || ret.ProcEventoNFe == nil || len(*ret.ProcEventoNFe) != 1 {
+		t.Errorf("ConsultaNFe(cancelada) = %+v, %v", ret, err)
+	}
+
+	// Cadastro, inclusive nos envelopes próprios de MT e MG.
+	for _, cUF := range []int{35, 51, 31} {
+		ret, _, err := nfe.ConsultaCad("", "11222333000181", "", cUF, nfe.Homologacao, client)
+		if err != nil || ret.InfCons.CStat != 111 || ret.InfCons.InfCad == nil || (*ret.InfCons.InfCad)[0].UF != nfe.GetUF(cUF) {
+			t.Errorf("ConsultaCad(%d) = %+v, %v", cUF, ret, err)
+		}
+	}
+
+	if n := len(s.Requisicoes()); n != 14 {
+		t.Errorf("%d requisições registradas", n)
+	}
+}
+
+func TestSefazAssincrona(t *testing.T) {
+	s := NewSefaz()
+	defer s.Close()
+	s.Assincrona = true
+	s.Processando = 2
+	client := s.Client()
+
+	// Mesmo com indSinc 1, o lote é processado de forma assíncrona.
+	chave, xmlNFe := nfeTeste(t, 35, 1, "Vh9k1wqBVyT06jXdpf8OP6tpD/U=")
+	retEnv, _, err := nfe.AutorizaNFe(xmlNFe, client)
+	if err != nil || retEnv.CStat != 103 || retEnv.InfRec == nil || retEnv.ProtNFe != nil {
+		t.Fatalf("AutorizaNFe() = %+v, %v", retEnv, err)
+	}
+	for i, cStat := range []int{105, 105, 104, 104} {
+		ret, _, err := nfe.ConsultaReciboNFe(retEnv.InfRec.NRec, chave, nfe.Homologacao, client)
+		if err != nil || ret.CStat != cStat || (cStat == 104) != (len(ret.ProtNFe) == 1) {
+			t.Errorf("ConsultaReciboNFe() %d = %+v, %v", i+1, ret, err)
+		}
+	}
+}
+
+func TestSefazRespostas(t *testing.T) {
+	s := NewSefaz()
+	defer s.Close()
+	client := s.Client()
+
+	s.Responde(nfe.ConsultaStatus,
+		Resposta{XML: []byte(`<retConsStatServ xmlns="http://www.portalfiscal.inf.br/nfe" versao="4.00"><tpAmb>2</tpAmb><cStat>108</cStat><xMotivo>Serviço Paralisado Momentaneamente (curto prazo)</xMotivo><cUF>35</cUF></retConsStatServ>`)},
+		Fault("soap:Receiver", "Erro interno"),
+		Resposta{Status: http.StatusServiceUnavailable, Corpo: []byte("<html>Service Unavailable</html>")},
+	)
+	if ret, _, err := nfe.ConsultaStatServ(35, nfe.Homologacao, client); err != nil || ret.CStat != 108 {
+		t.Errorf("ConsultaStatServ() programado = %+v, %v", ret, err)
+	}
+	var wsErr *nfe.WSError
+	if _, _, err := nfe.ConsultaStatServ(35, nfe.Homologacao, client); !errors.As(err, &wsErr) || !wsErr.IsFault() || wsErr.Reason != "Erro interno" {
+		t.Errorf("ConsultaStatServ() com Fault = %v", err)
+	}
+	if _, _, err := nfe.ConsultaStatServ(35, nfe.Homologacao, client); !errors.As(err, &wsErr) || wsErr.StatusCode != http.StatusServiceUnavailable {
+		t.Errorf("ConsultaStatServ() com 503 = %v", err)
+	}
+	if ret, _, err := nfe.ConsultaStatServ(35, nfe.Homologacao, client); err != nil || ret.CStat != 107 {
+		t.Errorf("ConsultaStatServ() após as respostas programadas = %+v, %v", ret, err)
+	}
+
+	s.Handle(nfe.ConsultaCadastro, func(req Requisicao) Resposta {
+		return Resposta{XML: []byte(`<retConsCad xmlns="http://www.portalfiscal.inf.br/nfe" versao="2.00"><infCons><verAplic>1</verAplic><cStat>259</cStat><xMotivo>Rejeição: CNPJ da consulta não cadastrado como contribuinte na UF</xMotivo><UF>` + req.Valor("infCons/UF") + `</UF><CNPJ>` + req.Valor("infCons/CNPJ") + `</CNPJ><dhCons>2024-05-17T10:00:00</dhCons><cUF>35</cUF></infCons></retConsCad>`)}
+	})
+	if ret, _, err := nfe.ConsultaCad("", "11222333000181", "", 35, nfe.Homologacao, client); err != nil || ret.InfCons.CStat != 259 || ret.InfCons.CNPJ != "11222333000181" {
+		t.Errorf("ConsultaCad() com Handler = %+v, %v", ret, err)
+	}
+
+	s.Responde(nfe.ConsultaStatus, Resposta{Atraso: time.Second})
+	lento := *client
+	lento.Timeout = 50 * time.Millisecond
+	if _, _, err := nfe.ConsultaStatServ(35, nfe.Homologacao, &lento); err == nil {
+		t.Error("ConsultaStatServ() com atraso maior que o timeout não retornou erro")
+	}
+
+	reqs := s.Requisicoes()
+	if reqs[0].Servico != nfe.ConsultaStatus || !strings.HasPrefix(string(reqs[0].Mensagem), "<consStatServ") || !strings.HasSuffix(reqs[0].SOAPAction, "nfeStatusServicoNF") {
+		t.Errorf("Requisicoes()[0] = %+v", reqs[0])
+	}
+}
+
+func TestGravador(t *testing.T) {
+	dir := t.TempDir()
+	gravada := NewSefaz()
+	gravada.Responde(nfe.ConsultaStatus, Fault("soap:Receiver", "Erro gravado"))
+	g, err := NewGravador(dir, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := &http.Client{Transport: g}
+	nfe.ConsultaStatServ(35, nfe.Homologacao, client)
+	status, xmlStatus, _ := nfe.ConsultaStatServ(35, nfe.Homologacao, client)
+	cad, _, _ := nfe.ConsultaCad("", "11222333000181", "", 51, nfe.Homologacao, client)
+	gravada.Close()
+
+	arquivos, _ := fixtures(dir)
+	if strings.Join(arquivos, ",") != "0001-consStatServ.500.xml,0002-consStatServ.xml,0003-ConsCad.xml" {
+		t.Fatalf("fixtures gravadas: %v", arquivos)
+	}
+
+	s := NewSefaz()
+	defer s.Close()
+	if err := s.Reproduz(dir); err != nil {
+		t.Fatal(err)
+	}
+	s.Agora = func() time.Time { return time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC) }
+	if _, _, err := nfe.ConsultaStatServ(35, nfe.Homologacao, s.Client()); err == nil || !strings.Contains(err.Error(), "Erro gravado") {
+		t.Errorf("ConsultaStatServ() reproduzindo o Fault = %v", err)
+	}
+	ret, xmlRet, err := nfe.ConsultaStatServ(35, nfe.Homologacao, s.Client())
+	if err != nil || !ret.DhRecbto.Equal(status.DhRecbto.Time) || string(xmlRet) != string(xmlStatus) {
+		t.Errorf("ConsultaStatServ() reproduzido = %+v, %v", ret, err)
+	}
+	if ret, _, err := nfe.ConsultaCad("", "11222333000181", "", 51, nfe.Homologacao, s.Client()); err != nil || !ret.InfCons.DhCons.Equal(cad.InfCons.DhCons.Time) {
+		t.Errorf("ConsultaCad() reproduzido = %+v, %v", ret, err)
+	}
+
+	if err := s.Reproduz(t.TempDir()); err == nil {
+		t.Error("Reproduz(diretório vazio) não retornou erro")
+	}
+}
+
+// A Sefaz falsa substitui os WebServices de todas as UFs enquanto estiver ativa.
+func ExampleSefaz() {
+	s := NewSefaz()
+	defer s.Close()
+
+	ret, _, err := nfe.ConsultaStatServ(35, nfe.Homologacao, s.Client())
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	fmt.Println(ret.CStat, ret.XMotivo)
+	// Output: 107 Serviço em Operação
+}
@@ -0,0 +1,434 @@
+package nfetest
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/base64"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/beevik/etree"
+	"github.com/eduardotorresdev/nfe"
+)
+
+// Comportamento padrão dos WebServices da Sefaz falsa. As funções são chamadas com s.mu travado.
+
+// retorno cria o elemento raiz de uma mensagem de retorno, com os campos informados em pares (nome, valor). Campos com valor vazio são omitidos.
+func retorno(nome, versao string, campos ...string) *etree.Element {
+	e := etree.NewElement(nome)
+	e.CreateAttr("xmlns", xmlnsNFe)
+	e.CreateAttr("versao", versao)
+	return adiciona(e, campos...)
+}
+
+func adiciona(e *etree.Element, campos ...string) *etree.Element {
+	for i := 0; i+1 < len(campos); i += 2 {
+		if campos[i+1] != "" {
+			e.CreateElement(campos[i]).SetText(campos[i+1])
+		}
+	}
+	return e
+}
+
+// serializa gera o XML do elemento, incluindo antes do seu fechamento os elementos brutos informados (protNFe, procEventoNFe), que são mantidos sem alteração.
+func serializa(e *etree.Element, brutos ...[]byte) []byte {
+	doc := etree.NewDocument()
+	doc.SetRoot(e)
+	b, _ := doc.WriteToBytes()
+	if len(brutos) == 0 {
+		return b
+	}
+	fim := bytes.LastIndex(b, []byte("</"))
+	r := append([]byte(nil), b[:fim]...)
+	for _, bruto := range brutos {
+		r = append(r, bruto...)
+	}
+	return append(r, b[fim:]...)
+}
+
+func (s *Sefaz) dh() string {
+	return s.Agora().In(fusoBrasilia).Format("2006-01-02T15:04:05-07:00")
+}
+
+var fusoBrasilia = time.FixedZone("-03:00", -3*60*60)
+
+func (s *Sefaz) proximo() int {
+	s.seq++
+	return s.seq
+}
+
+func (s *Sefaz) status(req Requisicao) Resposta {
+	return Resposta{XML: serializa(retorno("retConsStatServ", nfe.VerConsStatServ,
+		"tpAmb", req.Valor("tpAmb"),
+		"verAplic", s.VerAplic,
+		"cStat", "107",
+		"xMotivo", "Serviço em Operação",
+		"cUF", req.Valor("cUF"),
+		"dhRecbto", s.dh(),
+		"tMed", "1",
+	))}
+}
+
+func (s *Sefaz) consulta(req Requisicao) Resposta {
+	chNFe := req.Valor("chNFe")
+	n := s.notas[chNFe]
+	if n == nil || n.prot == nil {
+		return Resposta{XML: serializa(retorno("retConsSitNFe", nfe.VerConsSitNFe,
+			"tpAmb", req.Valor("tpAmb"),
+			"verAplic", s.VerAplic,
+			"cStat", "217",
+			"xMotivo", "Rejeição: NF-e não consta na base de dados da SEFAZ",
+			"cUF", cUFChave(chNFe),
+			"dhRecbto", s.dh(),
+			"chNFe", chNFe,
+		))}
+	}
+
+	cStat, xMotivo := "100", "Autorizado o uso da NF-e"
+	if n.cancelada {
+		cStat, xMotivo = "101", "Cancelamento de NF-e homologado"
+	}
+	brutos := append([][]byte{n.prot}, s.eventos[chNFe]...)
+	return Resposta{XML: serializa(retorno("retConsSitNFe", nfe.VerConsSitNFe,
+		"tpAmb", req.Valor("tpAmb"),
+		"verAplic", s.VerAplic,
+		"cStat", cStat,
+		"xMotivo", xMotivo,
+		"cUF", strconv.Itoa(n.cUF),
+		"dhRecbto", s.dh(),
+		"chNFe", chNFe,
+	), brutos...)}
+}
+
+func (s *Sefaz) cadastro(req Requisicao) Resposta {
+	uf := req.Valor("infCons/UF")
+	ie, cnpj, cpf := req.Valor("infCons/IE"), req.Valor("infCons/CNPJ"), req.Valor("infCons/CPF")
+	ret := retorno("retConsCad", "2.00")
+	inf := adiciona(ret.CreateElement("infCons"),
+		"verAplic", s.VerAplic,
+		"cStat", "111",
+		"xMotivo", "Consulta cadastro com uma ocorrência",
+		"UF", uf,
+		"IE", ie,
+		"CNPJ", cnpj,
+		"CPF", cpf,
+		"dhCons", s.dh(),
+		"cUF", strconv.Itoa(nfe.GetcUF(uf)),
+	)
+	if ie == "" {
+		ie = "ISENTO"
+	}
+	adiciona(inf.CreateElement("infCad"),
+		"IE", ie,
+		"CNPJ", cnpj,
+		"CPF", cpf,
+		"UF", uf,
+		"cSit", "1",
+		"indCredNFe", "1",
+		"indCredCTe", "4",
+		"xNome", "CONTRIBUINTE DE TESTE",
+	)
+	return Resposta{XML: serializa(ret)}
+}
+
+// protocolo registra a NFe e gera o seu protNFe: autorizado ou, se a chave de acesso já foi usada, rejeitado por duplicidade (cStat 204).
+func (s *Sefaz) protocolo(bruto []byte) ([]byte, error) {
+	el, err := lerElemento(bruto)
+	if err != nil {
+		return nil, err
+	}
+	chNFe := strings.TrimPrefix(valor(el, "infNFe/@Id"), "NFe")
+	digVal := valor(el, "Signature/SignedInfo/Reference/DigestValue")
+	tpAmb, _ := strconv.Atoi(valor(el, "infNFe/ide/tpAmb"))
+	cUF, _ := strconv.Atoi(valor(el, "infNFe/ide/cUF"))
+	if len(chNFe) != 44 {
+		return nil, fmt.Errorf("NFe sem chave de acesso")
+	}
+
+	infProt := etree.NewElement("infProt")
+	if n := s.notas[chNFe]; n != nil {
+		adiciona(infProt,
+			"tpAmb", strconv.Itoa(tpAmb),
+			"verAplic", s.VerAplic,
+			"chNFe", chNFe,
+			"dhRecbto", s.dh(),
+			"digVal", digVal,
+			"cStat", "204",
+			"xMotivo", fmt.Sprintf("Rejeição: Duplicidade de NF-e [nProt:%s][dhAut:%s]", n.nProt, n.dhAut),
+		)
+		return serializa(protNFe(infProt)), nil
+	}
+
+	n := &notaSefaz{nfe: bruto, cUF: cUF, dhAut: s.dh()}
+	n.nProt = fmt.Sprintf("1%02d%s%010d", cUF, n.dhAut[2:4], s.proximo())
+	infProt.CreateAttr("Id", "ID"+n.nProt)
+	adiciona(infProt,
+		"tpAmb", strconv.Itoa(tpAmb),
+		"verAplic", s.VerAplic,
+		"chNFe", chNFe,
+		"dhRecbto", n.dhAut,
+		"nProt", n.nProt,
+		"digVal", digVal,
+		"cStat", "100",
+		"xMotivo", "Autorizado o uso da NF-e",
+	)
+	n.prot = serializa(protNFe(infProt))
+	s.notas[chNFe] = n
+	return n.prot, nil
+}
+
+func protNFe(infProt *etree.Element) *etree.Element {
+	prot := etree.NewElement("protNFe")
+	prot.CreateAttr("versao", nfe.VerEnviNFe)
+	prot.AddChild(infProt)
+	return prot
+}
+
+func (s *Sefaz) autorizacao(req Requisicao) Resposta {
+	var protocolos [][]byte
+	cUF := ""
+	for _, bruto := range elementosBrutos(req.Mensagem, "NFe") {
+		prot, err := s.protocolo(bruto)
+		if err != nil {
+			return Fault("soap:Sender", err.Error())
+		}
+		protocolos = append(protocolos, prot)
+		if cUF == "" {
+			cUF = cUFChave(valor(mustLer(prot), "infProt/chNFe"))
+		}
+	}
+	tpAmb := ""
+	if len(protocolos) > 0 {
+		tpAmb = valor(mustLer(protocolos[0]), "infProt/tpAmb")
+	}
+
+	ret := retorno("retEnviNFe", nfe.VerEnviNFe, "tpAmb", tpAmb, "verAplic", s.VerAplic)
+	switch {
+	case len(protocolos) == 0:
+		adiciona(ret, "cStat", "225", "xMotivo", "Rejeição: Falha no Schema XML do lote de NFe", "cUF", cUF, "dhRecbto", s.dh())
+		return Resposta{XML: serializa(ret)}
+	case req.Valor("indSinc") == "1" && len(protocolos) == 1 && !s.Assincrona:
+		adiciona(ret, "cStat", "104", "xMotivo", "Lote processado", "cUF", cUF, "dhRecbto", s.dh())
+		return Resposta{XML: serializa(ret, protocolos[0])}
+	}
+	nRec := fmt.Sprintf("%s%013d", cUF, s.proximo())
+	s.recibos[nRec] = protocolos
+	adiciona(ret, "cStat", "103", "xMotivo", "Lote recebido com sucesso", "cUF", cUF, "dhRecbto", s.dh())
+	adiciona(ret.CreateElement("infRec"), "nRec", nRec, "tMed", "1")
+	return Resposta{XML: serializa(ret)}
+}
+
+func (s *Sefaz) retAutorizacao(req Requisicao) Resposta {
+	nRec := req.Valor("nRec")
+	protocolos, ok := s.recibos[nRec]
+	cStat, xMotivo := "104", "Lote processado"
+	switch {
+	case !ok:
+		cStat, xMotivo = "106", "Lote não localizado"
+	case s.consultas[nRec] < s.Processando:
+		s.consultas[nRec]++
+		cStat, xMotivo, protocolos = "105", "Lote em processamento", nil
+	}
+	cUF := ""
+	if len(nRec) > 2 {
+		cUF = nRec[:2]
+	}
+	return Resposta{XML: serializa(retorno("retConsReciNFe", nfe.VerEnviNFe,
+		"tpAmb", req.Valor("tpAmb"),
+		"verAplic", s.VerAplic,
+		"nRec", nRec,
+		"cStat", cStat,
+		"xMotivo", xMotivo,
+		"cUF", cUF,
+		"dhRecbto", s.dh(),
+	), protocolos...)}
+}
+
+// evento registra cada evento do lote: o cancelamento exige uma NFe autorizada e a marca como cancelada; os demais eventos de uma NFe desconhecida (como o EPEC) são registrados sem vínculo (cStat 136).
+func (s *Sefaz) evento(req Requisicao) Resposta {
+	tpAmb, cOrgao := "", ""
+	var retornos [][]byte
+	for _, bruto := range elementosBrutos(req.Mensagem, "evento") {
+		ev, err := lerElemento(bruto)
+		if err != nil {
+			return Fault("soap:Sender", err.Error())
+		}
+		tpAmb, cOrgao = valor(ev, "infEvento/tpAmb"), valor(ev, "infEvento/cOrgao")
+		chNFe, tpEvento, nSeq := valor(ev, "infEvento/chNFe"), valor(ev, "infEvento/tpEvento"), valor(ev, "infEvento/nSeqEvento")
+		n := s.notas[chNFe]
+
+		cStat, xMotivo := "135", "Evento registrado e vinculado a NF-e"
+		switch {
+		case s.eventoRegistrado(chNFe, tpEvento, nSeq):
+			cStat, xMotivo = "573", "Rejeição: Duplicidade de Evento"
+		case (tpEvento == "110111" || tpEvento == "110112") && (n == nil || n.prot == nil):
+			cStat, xMotivo = "217", "Rejeição: NF-e não consta na base de dados da SEFAZ"
+		case tpEvento == "110111" || tpEvento == "110112":
+			if n.cancelada {
+				cStat, xMotivo = "218", "Rejeição: NF-e já está cancelada na base de dados da SEFAZ"
+			}
+		case n == nil:
+			cStat, xMotivo = "136", "Evento registrado, mas não vinculado a NF-e"
+		}
+
+		inf := etree.NewElement("infEvento")
+		nProt := ""
+		if cStat == "135" || cStat == "136" {
+			nProt = fmt.Sprintf("1%s%s%010d", padUF(cOrgao), s.dh()[2:4], s.proximo())
+			inf.CreateAttr("Id", "ID"+nProt)
+		}
+		adiciona(inf,
+			"tpAmb", tpAmb,
+			"verAplic", s.VerAplic,
+			"cOrgao", cOrgao,
+			"cStat", cStat,
+			"xMotivo", xMotivo,
+			"chNFe", chNFe,
+			"tpEvento", tpEvento,
+			"xEvento", valor(ev, "infEvento/detEvento/descEvento"),
+			"nSeqEvento", nSeq,
+			"dhRegEvento", s.dh(),
+			"nProt", nProt,
+		)
+		ret := etree.NewElement("retEvento")
+		ret.CreateAttr("versao", ev.SelectAttrValue("versao", "1.00"))
+		ret.AddChild(inf)
+		retEvento := serializa(ret)
+		retornos = append(retornos, retEvento)
+
+		if nProt != "" {
+			proc, err := nfe.AttachEventProtocol(bruto, retEvento)
+			if err != nil {
+				return Fault("soap:Receiver", err.Error())
+			}
+			s.eventos[chNFe] = append(s.eventos[chNFe], proc)
+			if n != nil && (tpEvento == "110111" || tpEvento == "110112") {
+				n.cancelada = true
+			}
+		}
+	}
+
+	return Resposta{XML: serializa(retorno("retEnvEvento", "1.00",
+		"idLote", req.Valor("idLote"),
+		"tpAmb", tpAmb,
+		"verAplic", s.VerAplic,
+		"cOrgao", cOrgao,
+		"cStat", "128",
+		"xMotivo", "Lote de Evento Processado",
+	), retornos...)}
+}
+
+func (s *Sefaz) eventoRegistrado(chNFe, tpEvento, nSeq string) bool {
+	for _, proc := range s.eventos[chNFe] {
+		el := mustLer(proc)
+		if valor(el, "evento/infEvento/tpEvento") == tpEvento && valor(el, "evento/infEvento/nSeqEvento") == nSeq {
+			return true
+		}
+	}
+	return false
+}
+
+// distribuicao retorna o nfeProc da NFe consultada pela chave de acesso (consChNFe), se ela tiver sido autorizada.
+func (s *Sefaz) distribuicao(req Requisicao) Resposta {
+	ret := retorno("retDistDFeInt", req.documento.SelectAttrValue("versao", "1.01"),
+		"tpAmb", req.Valor("tpAmb"),
+		"verAplic", s.VerAplic,
+	)
+
+	n := s.notas[req.Valor("consChNFe/chNFe")]
+	if n == nil || n.prot == nil {
+		adiciona(ret, "cStat", "137", "xMotivo", "Nenhum documento localizado", "dhResp", s.dh(), "ultNSU", fmt.Sprintf("%015d", s.seq), "maxNSU", fmt.Sprintf("%015d", s.seq))
+		return Resposta{XML: serializa(ret)}
+	}
+	proc, err := nfe.AttachProtocol(n.nfe, n.prot)
+	if err != nil {
+		return Fault("soap:Receiver", err.Error())
+	}
+	var gz bytes.Buffer
+	w := gzip.NewWriter(&gz)
+	w.Write(proc)
+	w.Close()
+
+	nsu := fmt.Sprintf("%015d", s.proximo())
+	adiciona(ret, "cStat", "138", "xMotivo", "Documento localizado", "dhResp", s.dh(), "ultNSU", nsu, "maxNSU", nsu)
+	doc := ret.CreateElement("loteDistDFeInt").CreateElement("docZip")
+	doc.CreateAttr("NSU", nsu)
+	doc.CreateAttr("schema", "procNFe_v4.00.xsd")
+	doc.SetText(base64.StdEncoding.EncodeToString(gz.Bytes()))
+	return Resposta{XML: serializa(ret)}
+}
+
+// AdicionaNFe registra na Sefaz uma NFe autorizada, que passa a ser retornada na consulta e na distribuição, por exemplo uma NFe emitida por um fornecedor para a empresa. xmlNFe pode ser o nfeProc, cujo protocolo é mantido, ou a NFe assinada, que recebe um protocolo novo. Retorna a chave de acesso.
+func (s *Sefaz) AdicionaNFe(xmlNFe []byte) (string, error) {
+	brutos := elementosBrutos(xmlNFe, "NFe")
+	if len(brutos) != 1 {
+		return "", fmt.Errorf("O XML deve conter exatamente uma NFe; encontradas %d", len(brutos))
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	el, err := lerElemento(brutos[0])
+	if err != nil {
+		return "", err
+	}
+	chNFe := strings.TrimPrefix(valor(el, "infNFe/@Id"), "NFe")
+	if prot := elementosBrutos(xmlNFe, "protNFe"); len(prot) == 1 {
+		p := mustLer(prot[0])
+		cUF, _ := strconv.Atoi(cUFChave(chNFe))
+		s.notas[chNFe] = &notaSefaz{nfe: brutos[0], prot: prot[0], cUF: cUF, nProt: valor(p, "infProt/nProt"), dhAut: valor(p, "infProt/dhRecbto")}
+		return chNFe, nil
+	}
+	delete(s.notas, chNFe)
+	if _, err := s.protocolo(brutos[0]); err != nil {
+		return "", err
+	}
+	return chNFe, nil
+}
+
+func lerElemento(bruto []byte) (*etree.Element, error) {
+	doc := etree.NewDocument()
+	if err := doc.ReadFromBytes(bruto); err != nil {
+		return nil, fmt.Errorf("Erro na leitura do XML: %w", err)
+	}
+	if doc.Root() == nil {
+		return nil, fmt.Errorf("XML vazio")
+	}
+	return doc.Root(), nil
+}
+
+// mustLer lê um XML gerado pela própria Sefaz falsa.
+func mustLer(bruto []byte) *etree.Element {
+	el, err := lerElemento(bruto)
+	if err != nil {
+		panic(err)
+	}
+	return el
+}
+
+// valor retorna o texto do elemento (ou o atributo, com "@") indicado pelo caminho.
+func valor(el *etree.Element, path string) string {
+	if i := strings.Index(path, "/@"); i >= 0 {
+		if e := el.FindElement(path[:i]); e != nil {
+			return e.SelectAttrValue(path[i+2:], "")
+		}
+		return ""
+	}
+	if e := el.FindElement(path); e != nil {
+		return strings.TrimSpace(e.Text())
+	}
+	return ""
+}
+
+func cUFChave(chNFe string) string {
+	if len(chNFe) < 2 {
+		return ""
+	}
+	return chNFe[:2]
+}
+
+func padUF(cOrgao string) string {
+	n, _ := strconv.Atoi(cOrgao)
+	return fmt.Sprintf("%02d", n)
+}
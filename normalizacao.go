@@ -0,0 +1,261 @@
+package nfe
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// Normalizacao é uma correção aplicada às respostas das Sefazes antes da desserialização, para contornar desvios do leiaute de algumas UFs (datas sem fuso horário, namespace ausente, BOM, encoding diferente de UTF-8 etc.).
+//
+// A correção é uma substituição de expressão regular (Padrao e Substituicao, como em regexp.Regexp.ReplaceAll) ou, quando não puder ser expressa assim, uma função (Func). UFs e Servicos restringem a aplicação às respostas daquelas UFs (pelo código IBGE, ou 91 para o Ambiente Nacional) e daqueles serviços; vazios, a correção vale para todas.
+type Normalizacao struct {
+	Nome         string
+	UFs          []int
+	Servicos     []TWebService
+	Padrao       *regexp.Regexp
+	Substituicao string
+	Func         func(body []byte) []byte
+}
+
+// aplicavel indica se a normalização vale para as respostas do WebService informado.
+func (n Normalizacao) aplicavel(ep Endpoint) bool {
+	if len(n.UFs) > 0 && !contemInt(n.UFs, ep.CUF) {
+		return false
+	}
+	if len(n.Servicos) > 0 {
+		for _, ws := range n.Servicos {
+			if ws == ep.Servico {
+				return true
+			}
+		}
+		return false
+	}
+	return true
+}
+
+func contemInt(lista []int, v int) bool {
+	for _, i := range lista {
+		if i == v {
+			return true
+		}
+	}
+	return false
+}
+
+// Normalizador aplica, em ordem, as normalizações cadastradas às respostas dos WebServices. É seguro para uso concorrente. Ver DefaultNormalizador.
+type Normalizador struct {
+	mu     sync.RWMutex
+	regras []Normalizacao
+}
+
+// NewNormalizador cria um Normalizador com as normalizações informadas.
+func NewNormalizador(regras ...Normalizacao) *Normalizador {
+	return &Normalizador{regras: append([]Normalizacao(nil), regras...)}
+}
+
+// Adiciona inclui uma normalização, que será aplicada depois das já cadastradas. Uma normalização com o mesmo Nome de outra já cadastrada a substitui.
+func (n *Normalizador) Adiciona(regra Normalizacao) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	for i, r := range n.regras {
+		if regra.Nome != "" && r.Nome == regra.Nome {
+			n.regras[i] = regra
+			return
+		}
+	}
+	n.regras = append(n.regras, regra)
+}
+
+// Remove exclui a normalização com o nome informado.
+func (n *Normalizador) Remove(nome string) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	for i, r := range n.regras {
+		if r.Nome == nome {
+			n.regras = append(n.regras[:i:i], n.regras[i+1:]...)
+			return
+		}
+	}
+}
+
+// Normaliza aplica à resposta (o envelope SOAP completo) as normalizações válidas para o WebService informado.
+func (n *Normalizador) Normaliza(ep Endpoint, body []byte) []byte {
+	n.mu.RLock()
+	defer n.mu.RUnlock()
+	for _, r := range n.regras {
+		if !r.aplicavel(ep) {
+			continue
+		}
+		if r.Padrao != nil {
+			body = r.Padrao.ReplaceAll(body, []byte(r.Substituicao))
+		}
+		if r.Func != nil {
+			body = r.Func(body)
+		}
+	}
+	return body
+}
+
+// DefaultNormalizador é o Normalizador usado por todos os serviços da biblioteca, iniciado com as NormalizacoesPadrao. Novas correções podem ser incluídas com DefaultNormalizador.Adiciona, sem esperar uma nova versão da biblioteca.
+var DefaultNormalizador = NewNormalizador(NormalizacoesPadrao()...)
+
+var (
+	reDeclaracaoXML = regexp.MustCompile(`^(<\?xml[^>]*encoding=["'])([\w.:-]+)(["'])`)
+	reRaizSemNs     = regexp.MustCompile(`<(retConsStatServ|retConsSitNFe|retConsCad|retEnviNFe|retConsReciNFe|retEnvEvento|retInutNFe|retDistDFeInt)(\s[^>]*)?>`)
+	reDataHora      = regexp.MustCompile(`<(dh\w+)>([^<]+)</dh\w+>`)
+)
+
+// NormalizacoesPadrao retorna as normalizações cadastradas no DefaultNormalizador:
+//
+//   - "bom": remove o BOM e os espaços antes da declaração XML;
+//   - "encoding": converte para UTF-8 as respostas em ISO-8859-1 (ou declaradas assim), que o encoding/xml não lê;
+//   - "namespace": inclui o namespace da NF-e na raiz dos retornos que não o informam;
+//   - "dataHora": reescreve os campos dh* (dhRecbto, dhCons, dhRegEvento etc.) em qualquer formato aceito pela ParseDateTime no formato yyyy-mm-ddThh:mm:ssTZD;
+//   - "dataCadastro": trunca para yyyy-mm-dd as datas da consulta de cadastro (dIniAtiv, dUltSit e dBaixa) que algumas UFs informam com hora.
+func NormalizacoesPadrao() []Normalizacao {
+	return []Normalizacao{
+		{Nome: "bom", Func: func(body []byte) []byte {
+			return bytes.TrimLeft(body, "\ufeff \t\r\n")
+		}},
+		{Nome: "encoding", Func: normalizaEncoding},
+		{Nome: "namespace", Func: func(body []byte) []byte {
+			return reRaizSemNs.ReplaceAllFunc(body, func(tag []byte) []byte {
+				if bytes.Contains(tag, []byte("xmlns=")) {
+					return tag
+				}
+				return append(tag[:len(tag)-1:len(tag)-1], ` xmlns="`+xmlnsNFe+`">`...)
+			})
+		}},
+		{Nome: "dataHora", Func: func(body []byte) []byte {
+			return reDataHora.ReplaceAllFunc(body, func(campo []byte) []byte {
+				m := reDataHora.FindSubmatch(campo)
+				valor := strings.TrimSpace(string(m[2]))
+				if _, err := time.Parse(time.RFC3339, valor); err == nil {
+					return campo
+				}
+				t, err := ParseDateTime(valor)
+				if err != nil {
+					return campo
+				}
+				return []byte(fmt.Sprintf("<%s>%s</%s>", m[1], t.Format(time.RFC3339), m[1]))
+			})
+		}},
+		{
+			Nome:         "dataCadastro",
+			Servicos:     []TWebService{ConsultaCadastro},
+			Padrao:       regexp.MustCompile(`(<(dIniAtiv|dUltSit|dBaixa)>\d{4}-\d{2}-\d{2})[^<]+(</(?:dIniAtiv|dUltSit|dBaixa)>)`),
+			Substituicao: "$1$3",
+		},
+	}
+}
+
+// normalizaEncoding converte de ISO-8859-1 para UTF-8 as respostas que não são UTF-8 válido e ajusta a declaração XML de qualquer encoding para UTF-8.
+func normalizaEncoding(body []byte) []byte {
+	m := reDeclaracaoXML.FindSubmatchIndex(body)
+	if m == nil && utf8.Valid(body) {
+		return body
+	}
+	if !utf8.Valid(body) {
+		var b bytes.Buffer
+		b.Grow(len(body) + len(body)/8)
+		for _, c := range body {
+			b.WriteRune(rune(c))
+		}
+		body = b.Bytes()
+		m = reDeclaracaoXML.FindSubmatchIndex(body)
+	}
+	if m != nil && !strings.EqualFold(string(body[m[4]:m[5]]), "utf-8") {
+		body = append(append(append([]byte(nil), body[:m[4]]...), "UTF-8"...), body[m[5]:]...)
+	}
+	return body
+}
+
+// DateTime é um time.Time que aceita, na desserialização (XML, inclusive atributos, ou JSON), todos os formatos de data e hora encontrados nas respostas das Sefazes (ver ParseDateTime). Na serialização usa o formato do leiaute, yyyy-mm-ddThh:mm:ssTZD.
+type DateTime struct {
+	time.Time
+}
+
+// Formatos aceitos pela ParseDateTime, na ordem em que são tentados. Os formatos sem fuso horário são interpretados no horário de Brasília.
+var (
+	formatosComFuso = []string{
+		time.RFC3339Nano,
+		"2006-01-02T15:04Z07:00",
+		"2006-01-02T15:04:05Z0700",
+		"2006-01-02T15:04:05.999999999Z0700",
+		"2006-01-02 15:04:05Z07:00",
+		"2006-01-02Z07:00",
+	}
+	formatosSemFuso = []string{
+		"2006-01-02T15:04:05.999999999",
+		"2006-01-02T15:04",
+		"2006-01-02 15:04:05.999999999",
+		"2006-01-02",
+		"02/01/2006 15:04:05",
+		"02/01/2006",
+	}
+)
+
+// fusoBrasilia é o fuso assumido para as datas informadas sem fuso horário (UTC-3, sem horário de verão desde 2019).
+var fusoBrasilia = time.FixedZone("", -3*60*60)
+
+// ParseDateTime interpreta uma data e hora em qualquer dos formatos encontrados nas respostas das Sefazes: com ou sem fuso horário ("Z", "-03:00" ou "-0300"), com ou sem segundos e frações de segundo, com espaço no lugar do "T", apenas a data, ou dd/mm/yyyy. Datas sem fuso horário são interpretadas no horário de Brasília (-03:00).
+func ParseDateTime(s string) (time.Time, error) {
+	s = strings.TrimSpace(s)
+	for _, f := range formatosComFuso {
+		if t, err := time.Parse(f, s); err == nil {
+			return t, nil
+		}
+	}
+	for _, f := range formatosSemFuso {
+		if t, err := time.ParseInLocation(f, s, fusoBrasilia); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("Data e hora em formato desconhecido: %q", s)
+}
+
+// UnmarshalText implementa encoding.TextUnmarshaler. Um valor vazio resulta na data zero.
+func (d *DateTime) UnmarshalText(b []byte) error {
+	if len(bytes.TrimSpace(b)) == 0 {
+		d.Time = time.Time{}
+		return nil
+	}
+	t, err := ParseDateTime(string(b))
+	if err != nil {
+		return err
+	}
+	d.Time = t
+	return nil
+}
+
+// MarshalText implementa encoding.TextMarshaler, no formato das Sefazes (ver layoutDataHora), com o fuso no formato -hh:mm, inclusive em UTC. A data zero resulta em um valor vazio.
+func (d DateTime) MarshalText() ([]byte, error) {
+	if d.IsZero() {
+		return []byte{}, nil
+	}
+	return []byte(d.Format(layoutDataHora)), nil
+}
+
+// UnmarshalJSON implementa json.Unmarshaler, com os mesmos formatos da UnmarshalText (os métodos do time.Time embutido aceitariam apenas RFC 3339).
+func (d *DateTime) UnmarshalJSON(b []byte) error {
+	if string(b) == "null" {
+		return nil
+	}
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return err
+	}
+	return d.UnmarshalText([]byte(s))
+}
+
+// MarshalJSON implementa json.Marshaler. A data zero resulta em uma string vazia.
+func (d DateTime) MarshalJSON() ([]byte, error) {
+	b, _ := d.MarshalText()
+	return json.Marshal(string(b))
+}
@@ -0,0 +1,196 @@
+package nfe
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseDateTime(t *testing.T) {
+	brt := time.FixedZone("", -3*3600)
+	esperado := time.Date(2024, 5, 17, 10, 31, 2, 0, brt)
+	testes := []struct {
+		valor string
+		t     time.Time
+	}{
+		{"2024-05-17T10:31:02-03:00", esperado},
+		{"2024-05-17T13:31:02Z", esperado},
+		{"2024-05-17T10:31:02-0300", esperado},
+		{"2024-05-17T10:31:02", esperado},
+		{" 2024-05-17T10:31:02 ", esperado},
+		{"2024-05-17 10:31:02", esperado},
+		{"2024-05-17T10:31:02.000-03:00", esperado},
+		{"2024-05-17T10:31:02.000", esperado},
+		{"2024-05-17T09:31:02-04:00", esperado},
+		{"17/05/2024 10:31:02", esperado},
+		{"2024-05-17T10:31", time.Date(2024, 5, 17, 10, 31, 0, 0, brt)},
+		{"2024-05-17T10:31-03:00", time.Date(2024, 5, 17, 10, 31, 0, 0, brt)},
+		{"2024-05-17", time.Date(2024, 5, 17, 0, 0, 0, 0, brt)},
+		{"2024-05-17Z", time.Date(2024, 5, 17, 0, 0, 0, 0, time.UTC)},
+		{"17/05/2024", time.Date(2024, 5, 17, 0, 0, 0, 0, brt)},
+	}
+	for _, tt := range testes {
+		got, err := ParseDateTime(tt.valor)
+		if err != nil || !got.Equal(tt.t) {
+			t.Errorf("ParseDateTime(%q) = %v, %v; esperado %v", tt.valor, got, err, tt.t)
+		}
+	}
+	for _, valor := range []string{"", "17-05-2024", "2024-05-17T25:00:00", "ontem"} {
+		if _, err := ParseDateTime(valor); err == nil {
+			t.Errorf("ParseDateTime(%q) deveria falhar", valor)
+		}
+	}
+}
+
+func TestDateTime(t *testing.T) {
+	var v struct {
+		Dh   DateTime `xml:"dh"`
+		Attr DateTime `xml:"dh,attr"`
+		Nil  DateTime `xml:"vazio"`
+	}
+	if err := xml.Unmarshal([]byte(`<x dh="2024-05-17"><dh>2024-05-17T10:31:02</dh><vazio></vazio></x>`), &v); err != nil {
+		t.Fatal(err)
+	}
+	if v.Dh.Format(time.RFC3339) != "2024-05-17T10:31:02-03:00" || v.Attr.Format(time.RFC3339) != "2024-05-17T00:00:00-03:00" || !v.Nil.IsZero() {
+		t.Errorf("DateTime = %v, %v, %v", v.Dh, v.Attr, v.Nil)
+	}
+	b, err := xml.Marshal(struct {
+		XMLName xml.Name `xml:"x"`
+		Dh      DateTime `xml:"dh"`
+	}{Dh: v.Dh})
+	if err != nil || string(b) != "<x><dh>2024-05-17T10:31:02-03:00</dh></x>" {
+		t.Errorf("xml.Marshal = %s, %v", b, err)
+	}
+	if err := xml.Unmarshal([]byte(`<x><dh>ontem</dh></x>`), &v); err == nil {
+		t.Error("xml.Unmarshal de data inválida deveria falhar")
+	}
+
+	var j struct{ Dh DateTime }
+	if err := json.Unmarshal([]byte(`{"Dh":"2024-05-17 10:31:02"}`), &j); err != nil || !j.Dh.Equal(v.Dh.Time) {
+		t.Errorf("json.Unmarshal = %v, %v", j.Dh, err)
+	}
+	if b, err := json.Marshal(j); err != nil || string(b) != `{"Dh":"2024-05-17T10:31:02-03:00"}` {
+		t.Errorf("json.Marshal = %s, %v", b, err)
+	}
+
+	// Em UTC, o fuso é escrito como +00:00 (o time.RFC3339 usaria "Z").
+	if b, err := (DateTime{v.Dh.UTC()}).MarshalText(); err != nil || string(b) != "2024-05-17T13:31:02+00:00" {
+		t.Errorf("MarshalText em UTC = %s, %v", b, err)
+	}
+}
+
+func TestNormalizador(t *testing.T) {
+	ep := Endpoint{CUF: 31, Servico: ConsultaCadastro}
+	testes := []struct {
+		nome     string
+		entrada  string
+		esperado string
+	}{
+		{"bom", "\xef\xbb\xbf\r\n<?xml version=\"1.0\"?><a/>", `<?xml version="1.0"?><a/>`},
+		{"encoding", "<?xml version=\"1.0\" encoding=\"ISO-8859-1\"?><a>Opera\xe7\xe3o</a>", `<?xml version="1.0" encoding="UTF-8"?><a>Operação</a>`},
+		{"encoding utf-8 declarado como latin1", `<?xml version="1.0" encoding='iso-8859-1'?><a>Operação</a>`, `<?xml version="1.0" encoding='UTF-8'?><a>Operação</a>`},
+		{"encoding sem declaração", "<a>Opera\xe7\xe3o</a>", `<a>Operação</a>`},
+		{"namespace", `<retConsCad versao="2.00"><x/></retConsCad>`, `<retConsCad versao="2.00" xmlns="http://www.portalfiscal.inf.br/nfe"><x/></retConsCad>`},
+		{"namespace sem atributos", `<retConsStatServ>`, `<retConsStatServ xmlns="http://www.portalfiscal.inf.br/nfe">`},
+		{"namespace existente", `<retConsCad xmlns="http://www.portalfiscal.inf.br/nfe" versao="2.00">`, `<retConsCad xmlns="http://www.portalfiscal.inf.br/nfe" versao="2.00">`},
+		{"dataHora", `<dhCons>2024-05-17T10:40:00</dhCons><dhRecbto>2024-05-17 10:40:00.5</dhRecbto>`, `<dhCons>2024-05-17T10:40:00-03:00</dhCons><dhRecbto>2024-05-17T10:40:00-03:00</dhRecbto>`},
+		{"dataHora válida", `<dhCons>2024-05-17T10:40:00.123Z</dhCons>`, `<dhCons>2024-05-17T10:40:00.123Z</dhCons>`},
+		{"dataHora inválida", `<dhCons>ontem</dhCons>`, `<dhCons>ontem</dhCons>`},
+		{"dataCadastro", `<dIniAtiv>2001-05-10T00:00:00-03:00</dIniAtiv><dUltSit>2020-01-02Z</dUltSit><dBaixa>2020-01-02</dBaixa>`, `<dIniAtiv>2001-05-10</dIniAtiv><dUltSit>2020-01-02</dUltSit><dBaixa>2020-01-02</dBaixa>`},
+	}
+	for _, tt := range testes {
+		if got := string(DefaultNormalizador.Normaliza(ep, []byte(tt.entrada))); got != tt.esperado {
+			t.Errorf("%s: Normaliza = %q, esperado %q", tt.nome, got, tt.esperado)
+		}
+	}
+
+	// dataCadastro vale apenas para a consulta de cadastro
+	entrada := `<dIniAtiv>2001-05-10T00:00:00</dIniAtiv>`
+	if got := string(DefaultNormalizador.Normaliza(Endpoint{CUF: 31, Servico: ConsultaStatus}, []byte(entrada))); got != entrada {
+		t.Errorf("Normaliza fora da consulta de cadastro = %q", got)
+	}
+}
+
+func TestNormalizadorAdiciona(t *testing.T) {
+	n := NewNormalizador()
+	n.Adiciona(Normalizacao{Nome: "xMotivo", UFs: []int{29}, Padrao: regexp.MustCompile(`<xMotivo>\s+`), Substituicao: "<xMotivo>"})
+	entrada := []byte("<xMotivo>  Autorizado</xMotivo>")
+	if got := string(n.Normaliza(Endpoint{CUF: 29}, entrada)); got != "<xMotivo>Autorizado</xMotivo>" {
+		t.Errorf("Normaliza(BA) = %q", got)
+	}
+	if got := string(n.Normaliza(Endpoint{CUF: 35}, entrada)); got != string(entrada) {
+		t.Errorf("Normaliza(SP) = %q", got)
+	}
+
+	n.Adiciona(Normalizacao{Nome: "xMotivo", Padrao: regexp.MustCompile(`Autorizado`), Substituicao: "Autorizado o uso"})
+	if got := string(n.Normaliza(Endpoint{CUF: 35}, entrada)); got != "<xMotivo>  Autorizado o uso</xMotivo>" {
+		t.Errorf("Normaliza após substituição = %q", got)
+	}
+	n.Remove("xMotivo")
+	if got := string(n.Normaliza(Endpoint{CUF: 35}, entrada)); got != string(entrada) {
+		t.Errorf("Normaliza após Remove = %q", got)
+	}
+}
+
+func FuzzNormalizador(f *testing.F) {
+	for _, b := range fixturesSefaz(f, "") {
+		f.Add(b)
+	}
+	f.Fuzz(func(t *testing.T, data []byte) {
+		for _, ws := range []TWebService{ConsultaStatus, ConsultaCadastro} {
+			body := DefaultNormalizador.Normaliza(Endpoint{CUF: 31, Servico: ws}, data)
+			// a normalização deve ser idempotente
+			if again := DefaultNormalizador.Normaliza(Endpoint{CUF: 31, Servico: ws}, body); string(again) != string(body) {
+				t.Errorf("Normaliza não idempotente: %q -> %q", body, again)
+			}
+		}
+	})
+}
+
+func TestSendRequestResposta(t *testing.T) {
+	const chave = "35240511222333000181550010000001231123456785"
+	fixture := fixtureSefaz(t, "SP/ConsultaProtocolo.xml")
+	var resposta []byte
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(resposta)
+	}))
+	defer srv.Close()
+	DefaultRegistry.Override(0, Homologacao, ConsultaProtocolo, srv.URL)
+	defer DefaultRegistry.RemoveOverride(0, Homologacao, ConsultaProtocolo)
+
+	// O protNFe, assinado pela Sefaz, é retornado como recebido; as correções valem apenas para a desserialização.
+	resposta = bytes.ReplaceAll(fixture, []byte("2024-05-17T10:31:02-03:00"), []byte("2024-05-17 10:31:02"))
+	ret, xmlfile, err := ConsultaNFe(chave, Homologacao, srv.Client())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Contains(xmlfile, []byte("<dhRecbto>2024-05-17 10:31:02</dhRecbto>")) {
+		t.Errorf("XML retornado com as correções do DefaultNormalizador: %s", xmlfile)
+	}
+	if dh := ret.ProtNFe.InfProt.DhRecbto; !dh.Equal(time.Date(2024, 5, 17, 13, 31, 2, 0, time.UTC)) {
+		t.Errorf("dhRecbto do protNFe = %v", dh)
+	}
+
+	// Sem as correções, o envelope em ISO-8859-1 não é lido: o XML retornado é o normalizado.
+	resposta = bytes.Replace(bytes.Replace(fixture, []byte(`encoding="utf-8"`), []byte(`encoding="ISO-8859-1"`), 1), []byte("Autorizado o uso da NF-e</xMotivo></infProt>"), []byte("Autorizado o uso da NF-e \xe9</xMotivo></infProt>"), 1)
+	if ret, xmlfile, err = ConsultaNFe(chave, Homologacao, srv.Client()); err != nil || !strings.HasPrefix(string(xmlfile), "<retConsSitNFe") || ret.ProtNFe == nil {
+		t.Errorf("ConsultaNFe em ISO-8859-1 = %+v, %.40s, %v", ret, xmlfile, err)
+	}
+
+	// Os campos DateTime aceitam os formatos das Sefazes mesmo sem a normalização dataHora.
+	padrao := DefaultNormalizador
+	DefaultNormalizador = NewNormalizador(NormalizacoesPadrao()...)
+	DefaultNormalizador.Remove("dataHora")
+	defer func() { DefaultNormalizador = padrao }()
+	resposta = bytes.ReplaceAll(bytes.ReplaceAll(fixture, []byte("2024-05-17T10:35:40-03:00"), []byte("2024-05-17T10:35:40")), []byte("2024-05-17T10:31:02-03:00"), []byte("2024-05-17 10:31:02"))
+	ret, _, err = ConsultaNFe(chave, Homologacao, srv.Client())
+	if err != nil || !ret.DhRecbto.Equal(time.Date(2024, 5, 17, 13, 35, 40, 0, time.UTC)) || ret.ProtNFe == nil || !ret.ProtNFe.InfProt.DhRecbto.Equal(time.Date(2024, 5, 17, 13, 31, 2, 0, time.UTC)) {
+		t.Errorf("ConsultaNFe sem a normalização dataHora = %v, %+v, %v", ret.DhRecbto, ret.ProtNFe, err)
+	}
+}
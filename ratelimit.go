@@ -0,0 +1,336 @@
+package nfe
+
+import (
+	"container/list"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// cStatConsumoIndevido é o cStat retornado quando a Sefaz bloqueia o CNPJ por consumo indevido do serviço.
+const cStatConsumoIndevido = 656
+
+// bloqueioConsumoIndevido é o tempo durante o qual a Sefaz mantém o CNPJ bloqueado após um cStat 656.
+const bloqueioConsumoIndevido = time.Hour
+
+// Limite define a quantidade máxima de requisições aceitas em um período, para um mesmo CNPJ, serviço e UF.
+type Limite struct {
+	Requisicoes int
+	Periodo     time.Duration
+}
+
+// RateLimiterConfig define os limites usados pelo RateLimiter.
+type RateLimiterConfig struct {
+	// Padrao é o limite usado para os serviços não informados em PorServico. Um limite com Requisicoes igual a zero não restringe as chamadas.
+	Padrao     Limite
+	PorServico map[TWebService]Limite
+
+	// JanelaDuplicidade é o período durante o qual uma requisição idêntica a uma anterior (mesmo CNPJ, serviço, UF e parâmetros) é respondida com o resultado já obtido, sem nova consulta à Sefaz. Zero desabilita a supressão.
+	JanelaDuplicidade time.Duration
+}
+
+// DefaultRateLimiterConfig retorna limites conservadores: 20 consultas por hora na Distribuição DF-e, 60 por minuto nos demais serviços e supressão de consultas idênticas por 2 minutos.
+func DefaultRateLimiterConfig() RateLimiterConfig {
+	return RateLimiterConfig{
+		Padrao: Limite{Requisicoes: 60, Periodo: time.Minute},
+		PorServico: map[TWebService]Limite{
+			DistribuicaoDFe: {Requisicoes: 20, Periodo: time.Hour},
+		},
+		JanelaDuplicidade: 2 * time.Minute,
+	}
+}
+
+// RateLimitError é retornado quando uma chamada seria bloqueada pelo RateLimiter, evitando que a Sefaz bloqueie o CNPJ por consumo indevido (cStat 656).
+type RateLimitError struct {
+	CNPJ    string
+	Servico TWebService
+	CUF     int
+
+	// RetryAfter indica quanto tempo falta para que uma nova chamada seja permitida.
+	RetryAfter time.Duration
+	// ConsumoIndevido indica que o bloqueio decorre de um cStat 656 já retornado pela Sefaz.
+	ConsumoIndevido bool
+}
+
+func (e *RateLimitError) Error() string {
+	if e.ConsumoIndevido {
+		return fmt.Sprintf("Consulta bloqueada por consumo indevido (CNPJ %s, serviço %v, UF %s). Aguardar %v", e.CNPJ, e.Servico, siglaOrgao(e.CUF), e.RetryAfter.Round(time.Second))
+	}
+	return fmt.Sprintf("Limite de requisições excedido (CNPJ %s, serviço %v, UF %s). Aguardar %v", e.CNPJ, e.Servico, siglaOrgao(e.CUF), e.RetryAfter.Round(time.Second))
+}
+
+// RateLimiter controla a frequência de consultas por (CNPJ, serviço, UF) e suprime consultas duplicadas, envolvendo as chamadas ConsultaNFe, ConsultaCad e ConsultaDistChNFe. Pode ser usado concorrentemente.
+type RateLimiter struct {
+	cfg RateLimiterConfig
+
+	mu        sync.Mutex
+	buckets   map[limiterKey]*bucket
+	bloqueios map[limiterKey]time.Time
+	cache     map[requestKey]*cachedCall
+	expiracao *list.List // entradas do cache (entradaCache) em ordem de conclusão
+
+	now func() time.Time
+}
+
+type limiterKey struct {
+	cnpj string
+	ws   TWebService
+	cUF  int
+}
+
+type requestKey struct {
+	limiterKey
+	params string
+}
+
+type entradaCache struct {
+	rk requestKey
+	c  *cachedCall
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// cachedCall guarda o resultado de uma consulta para a supressão de duplicidades. done é fechado quando a chamada termina, permitindo que chamadas idênticas simultâneas aguardem a primeira.
+type cachedCall struct {
+	done  chan struct{}
+	at    time.Time
+	value interface{}
+	err   error
+}
+
+// NewRateLimiter cria um RateLimiter com a configuração informada (ver DefaultRateLimiterConfig).
+func NewRateLimiter(cfg RateLimiterConfig) *RateLimiter {
+	return &RateLimiter{
+		cfg:       cfg,
+		buckets:   map[limiterKey]*bucket{},
+		bloqueios: map[limiterKey]time.Time{},
+		cache:     map[requestKey]*cachedCall{},
+		expiracao: list.New(),
+		now:       time.Now,
+	}
+}
+
+func (l *RateLimiter) limite(ws TWebService) Limite {
+	if lim, ok := l.cfg.PorServico[ws]; ok {
+		return lim
+	}
+	return l.cfg.Padrao
+}
+
+// reserve consome uma requisição do limite da chave, ou retorna o tempo que falta para que ela esteja disponível.
+func (l *RateLimiter) reserve(k limiterKey) (time.Duration, bool) {
+	now := l.now()
+
+	if fim, ok := l.bloqueios[k]; ok {
+		if now.Before(fim) {
+			return fim.Sub(now), true
+		}
+		delete(l.bloqueios, k)
+	}
+
+	lim := l.limite(k.ws)
+	if lim.Requisicoes <= 0 || lim.Periodo <= 0 {
+		return 0, false
+	}
+	rate := float64(lim.Requisicoes) / float64(lim.Periodo)
+
+	b, ok := l.buckets[k]
+	if !ok {
+		b = &bucket{tokens: float64(lim.Requisicoes), last: now}
+		l.buckets[k] = b
+	}
+	b.tokens += float64(now.Sub(b.last)) * rate
+	if b.tokens > float64(lim.Requisicoes) {
+		b.tokens = float64(lim.Requisicoes)
+	}
+	b.last = now
+
+	if b.tokens < 1 {
+		return time.Duration((1 - b.tokens) / rate), false
+	}
+	b.tokens--
+	return 0, false
+}
+
+// Permite consome uma requisição do limite de (CNPJ, serviço, UF), retornando um *RateLimitError caso a chamada deva ser bloqueada. Útil para aplicar os limites a chamadas que não são envolvidas pelo RateLimiter.
+func (l *RateLimiter) Permite(cnpj string, ws TWebService, cUF int) error {
+	k := limiterKey{cnpj, ws, cUF}
+
+	l.mu.Lock()
+	wait, indevido := l.reserve(k)
+	l.mu.Unlock()
+
+	if wait > 0 {
+		return &RateLimitError{CNPJ: cnpj, Servico: ws, CUF: cUF, RetryAfter: wait, ConsumoIndevido: indevido}
+	}
+	return nil
+}
+
+// Aguarda bloqueia até que uma requisição para (CNPJ, serviço, UF) seja permitida, ou até o cancelamento do contexto. Bloqueios por consumo indevido não são aguardados e retornam *RateLimitError imediatamente.
+func (l *RateLimiter) Aguarda(ctx context.Context, cnpj string, ws TWebService, cUF int) error {
+	for {
+		err := l.Permite(cnpj, ws, cUF)
+		if err == nil {
+			return nil
+		}
+		rlErr := err.(*RateLimitError)
+		if rlErr.ConsumoIndevido {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(rlErr.RetryAfter):
+		}
+	}
+}
+
+// RegistraConsumoIndevido bloqueia (CNPJ, serviço, UF) pelo mesmo período aplicado pela Sefaz após um cStat 656.
+func (l *RateLimiter) RegistraConsumoIndevido(cnpj string, ws TWebService, cUF int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.bloqueios[limiterKey{cnpj, ws, cUF}] = l.now().Add(bloqueioConsumoIndevido)
+}
+
+// do executa fn respeitando o limite de (CNPJ, serviço, UF) e a supressão de duplicidades. cStat extrai o cStat do resultado para detectar o consumo indevido.
+func (l *RateLimiter) do(k limiterKey, params string, fn func() (interface{}, error), cStat func(interface{}) int) (interface{}, error) {
+	rk := requestKey{k, params}
+
+	l.mu.Lock()
+	if l.cfg.JanelaDuplicidade > 0 {
+		if c, ok := l.cache[rk]; ok {
+			select {
+			case <-c.done:
+				if c.err == nil && l.now().Sub(c.at) < l.cfg.JanelaDuplicidade {
+					l.mu.Unlock()
+					return c.value, nil
+				}
+			default:
+				l.mu.Unlock()
+				<-c.done
+				return c.value, c.err
+			}
+		}
+	}
+
+	wait, indevido := l.reserve(k)
+	if wait > 0 {
+		l.mu.Unlock()
+		return nil, &RateLimitError{CNPJ: k.cnpj, Servico: k.ws, CUF: k.cUF, RetryAfter: wait, ConsumoIndevido: indevido}
+	}
+
+	c := &cachedCall{done: make(chan struct{})}
+	if l.cfg.JanelaDuplicidade > 0 {
+		l.cache[rk] = c
+	}
+	l.mu.Unlock()
+
+	concluida := false
+	defer func() {
+		if !concluida {
+			c.err = errConsultaInterrompida
+		}
+		l.conclui(k, rk, c, cStat)
+		close(c.done)
+	}()
+	c.value, c.err = fn()
+	concluida = true
+
+	return c.value, c.err
+}
+
+// errConsultaInterrompida é o erro recebido pelas chamadas idênticas que aguardavam uma consulta interrompida por um panic.
+var errConsultaInterrompida = errors.New("A consulta idêntica em andamento foi interrompida antes de retornar")
+
+// conclui registra o término da chamada: bloqueia a chave em caso de cStat 656, descarta o resultado com erro e remove do cache as entradas cuja janela de duplicidade já terminou, a partir das mais antigas, sem percorrer todo o cache.
+func (l *RateLimiter) conclui(k limiterKey, rk requestKey, c *cachedCall, cStat func(interface{}) int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	c.at = l.now()
+	if c.err == nil && cStat(c.value) == cStatConsumoIndevido {
+		l.bloqueios[k] = c.at.Add(bloqueioConsumoIndevido)
+	}
+	if l.cfg.JanelaDuplicidade <= 0 {
+		return
+	}
+	if c.err != nil && l.cache[rk] == c {
+		delete(l.cache, rk)
+	} else {
+		l.expiracao.PushBack(entradaCache{rk, c})
+	}
+	for e := l.expiracao.Front(); e != nil; e = l.expiracao.Front() {
+		old := e.Value.(entradaCache)
+		if c.at.Sub(old.c.at) < l.cfg.JanelaDuplicidade {
+			break
+		}
+		if l.cache[old.rk] == old.c {
+			delete(l.cache, old.rk)
+		}
+		l.expiracao.Remove(e)
+	}
+}
+
+type consultaNFeResult struct {
+	ret     RetConsSitNFe
+	xmlfile []byte
+}
+
+// ConsultaNFe executa a ConsultaNFe() respeitando os limites configurados. cnpj é o CNPJ do certificado usado na consulta.
+func (l *RateLimiter) ConsultaNFe(cnpj string, dfechave string, tpAmb TAmb, client *http.Client, optReq ...func(req *http.Request)) (RetConsSitNFe, []byte, error) {
+	cUF, _, _, _, _, _, _, _, _, err := GetChaveInfo(dfechave)
+	if err != nil {
+		return RetConsSitNFe{}, nil, err
+	}
+
+	v, err := l.do(limiterKey{cnpj, ConsultaProtocolo, cUF}, fmt.Sprint(tpAmb, dfechave),
+		func() (interface{}, error) {
+			ret, xmlfile, err := ConsultaNFe(dfechave, tpAmb, client, optReq...)
+			return consultaNFeResult{ret, xmlfile}, err
+		},
+		func(v interface{}) int { return v.(consultaNFeResult).ret.CStat })
+	if v == nil {
+		return RetConsSitNFe{}, nil, err
+	}
+	r := v.(consultaNFeResult)
+	return r.ret, r.xmlfile, err
+}
+
+type consultaCadResult struct {
+	ret     RetConsCad
+	xmlfile []byte
+}
+
+// ConsultaCad executa a ConsultaCad() respeitando os limites configurados. cnpjCertificado é o CNPJ do certificado usado na consulta, e não o do contribuinte consultado.
+func (l *RateLimiter) ConsultaCad(cnpjCertificado string, ie string, cnpj string, cpf string, cUF int, tpAmb TAmb, client *http.Client, optReq ...func(req *http.Request)) (RetConsCad, []byte, error) {
+	v, err := l.do(limiterKey{cnpjCertificado, ConsultaCadastro, cUF}, fmt.Sprint(tpAmb, ie, "|", cnpj, "|", cpf),
+		func() (interface{}, error) {
+			ret, xmlfile, err := ConsultaCad(ie, cnpj, cpf, cUF, tpAmb, client, optReq...)
+			return consultaCadResult{ret, xmlfile}, err
+		},
+		func(v interface{}) int { return v.(consultaCadResult).ret.InfCons.CStat })
+	if v == nil {
+		return RetConsCad{}, nil, err
+	}
+	r := v.(consultaCadResult)
+	return r.ret, r.xmlfile, err
+}
+
+// ConsultaDistChNFe executa a ConsultaDistChNFe() respeitando os limites configurados.
+func (l *RateLimiter) ConsultaDistChNFe(cnpj string, chave string, tpAmb TAmb, client *http.Client, optReq ...func(*http.Request)) (ResultadoDistribuicaoNFe, error) {
+	v, err := l.do(limiterKey{cnpj, DistribuicaoDFe, 91}, fmt.Sprint(tpAmb, chave),
+		func() (interface{}, error) {
+			return ConsultaDistChNFe(cnpj, chave, tpAmb, client, optReq...)
+		},
+		func(v interface{}) int { return v.(ResultadoDistribuicaoNFe).Status })
+	if v == nil {
+		return ResultadoDistribuicaoNFe{}, err
+	}
+	return v.(ResultadoDistribuicaoNFe), err
+}
@@ -0,0 +1,236 @@
+package nfe
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+// rateLimiterTeste cria um RateLimiter com relógio controlado pelo teste.
+func rateLimiterTeste(cfg RateLimiterConfig) (*RateLimiter, *time.Time) {
+	l := NewRateLimiter(cfg)
+	agora := time.Date(2024, 5, 17, 10, 0, 0, 0, time.UTC)
+	l.now = func() time.Time { return agora }
+	return l, &agora
+}
+
+func cStatTeste(v interface{}) int {
+	if v == nil {
+		return 0
+	}
+	return v.(int)
+}
+
+func TestRateLimiterLimites(t *testing.T) {
+	l, agora := rateLimiterTeste(RateLimiterConfig{
+		Padrao:     Limite{Requisicoes: 2, Periodo: time.Minute},
+		PorServico: map[TWebService]Limite{DistribuicaoDFe: {Requisicoes: 1, Periodo: time.Hour}, ConsultaCadastro: {}},
+	})
+
+	for i := 0; i < 2; i++ {
+		if err := l.Permite("111", ConsultaProtocolo, 35); err != nil {
+			t.Fatalf("Permite %d: %v", i+1, err)
+		}
+	}
+	err := l.Permite("111", ConsultaProtocolo, 35)
+	var rlErr *RateLimitError
+	if !errors.As(err, &rlErr) || rlErr.ConsumoIndevido || rlErr.RetryAfter != 30*time.Second {
+		t.Fatalf("Permite acima do limite = %v, esperado RateLimitError com RetryAfter de 30s", err)
+	}
+
+	// Os limites são independentes por CNPJ, serviço e UF.
+	for _, k := range []limiterKey{{"222", ConsultaProtocolo, 35}, {"111", ConsultaProtocolo, 31}, {"111", ConsultaStatus, 35}} {
+		if err := l.Permite(k.cnpj, k.ws, k.cUF); err != nil {
+			t.Errorf("Permite(%v) = %v", k, err)
+		}
+	}
+
+	*agora = agora.Add(30 * time.Second)
+	if err := l.Permite("111", ConsultaProtocolo, 35); err != nil {
+		t.Errorf("Permite após a reposição = %v", err)
+	}
+
+	if err := l.Permite("111", DistribuicaoDFe, 91); err != nil {
+		t.Fatal(err)
+	}
+	if err := l.Permite("111", DistribuicaoDFe, 91); !errors.As(err, &rlErr) || rlErr.RetryAfter != time.Hour {
+		t.Errorf("Permite acima do limite do serviço = %v, esperado RetryAfter de 1h", err)
+	}
+
+	// Um limite sem requisições não restringe as chamadas.
+	for i := 0; i < 10; i++ {
+		if err := l.Permite("111", ConsultaCadastro, 35); err != nil {
+			t.Fatalf("Permite sem limite = %v", err)
+		}
+	}
+}
+
+func TestRateLimiterConsumoIndevido(t *testing.T) {
+	l, agora := rateLimiterTeste(RateLimiterConfig{Padrao: Limite{Requisicoes: 10, Periodo: time.Minute}})
+	k := limiterKey{"111", ConsultaProtocolo, 35}
+
+	v, err := l.do(k, "a", func() (interface{}, error) { return cStatConsumoIndevido, nil }, cStatTeste)
+	if err != nil || v != cStatConsumoIndevido {
+		t.Fatalf("do = %v, %v", v, err)
+	}
+
+	chamou := false
+	_, err = l.do(k, "b", func() (interface{}, error) { chamou = true; return 100, nil }, cStatTeste)
+	var rlErr *RateLimitError
+	if chamou || !errors.As(err, &rlErr) || !rlErr.ConsumoIndevido || rlErr.RetryAfter != bloqueioConsumoIndevido {
+		t.Fatalf("do após o 656 = %v (chamou %v), esperado bloqueio por consumo indevido", err, chamou)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := l.Aguarda(ctx, k.cnpj, k.ws, k.cUF); !errors.As(err, &rlErr) || !rlErr.ConsumoIndevido {
+		t.Errorf("Aguarda = %v, esperado o bloqueio sem espera", err)
+	}
+
+	// O bloqueio vale apenas para a chave que recebeu o 656.
+	if err := l.Permite(k.cnpj, ConsultaStatus, k.cUF); err != nil {
+		t.Errorf("Permite em outro serviço = %v", err)
+	}
+
+	*agora = agora.Add(bloqueioConsumoIndevido)
+	if err := l.Permite(k.cnpj, k.ws, k.cUF); err != nil {
+		t.Errorf("Permite após o bloqueio = %v", err)
+	}
+
+	l.RegistraConsumoIndevido("222", ConsultaCadastro, 31)
+	if err := l.Permite("222", ConsultaCadastro, 31); !errors.As(err, &rlErr) || !rlErr.ConsumoIndevido {
+		t.Errorf("Permite após RegistraConsumoIndevido = %v", err)
+	}
+}
+
+func TestRateLimiterDuplicidade(t *testing.T) {
+	l, agora := rateLimiterTeste(RateLimiterConfig{JanelaDuplicidade: 2 * time.Minute})
+	k := limiterKey{"111", ConsultaProtocolo, 35}
+	chamadas := 0
+	fn := func() (interface{}, error) { chamadas++; return 100, nil }
+
+	for i := 0; i < 3; i++ {
+		if v, err := l.do(k, "a", fn, cStatTeste); err != nil || v != 100 {
+			t.Fatalf("do = %v, %v", v, err)
+		}
+	}
+	if chamadas != 1 {
+		t.Errorf("chamadas idênticas = %d, esperado 1", chamadas)
+	}
+
+	l.do(k, "b", fn, cStatTeste)
+	if chamadas != 2 {
+		t.Errorf("chamadas com parâmetros diferentes = %d, esperado 2", chamadas)
+	}
+
+	*agora = agora.Add(2 * time.Minute)
+	l.do(k, "a", fn, cStatTeste)
+	if chamadas != 3 {
+		t.Errorf("chamadas após a janela = %d, esperado 3", chamadas)
+	}
+
+	// Resultados com erro não são reaproveitados.
+	falhas := 0
+	falha := func() (interface{}, error) { falhas++; return nil, errors.New("falha") }
+	l.do(k, "c", falha, cStatTeste)
+	l.do(k, "c", falha, cStatTeste)
+	if falhas != 2 {
+		t.Errorf("chamadas com erro = %d, esperado 2", falhas)
+	}
+}
+
+func TestRateLimiterDuplicidadeSimultanea(t *testing.T) {
+	l, _ := rateLimiterTeste(RateLimiterConfig{JanelaDuplicidade: time.Minute})
+	k := limiterKey{"111", ConsultaProtocolo, 35}
+
+	libera := make(chan struct{})
+	iniciada := make(chan struct{})
+	chamadas := 0
+	fn := func() (interface{}, error) {
+		chamadas++
+		close(iniciada)
+		<-libera
+		return 100, nil
+	}
+
+	var wg sync.WaitGroup
+	resultados := make([]interface{}, 5)
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		resultados[0], _ = l.do(k, "a", fn, cStatTeste)
+	}()
+	<-iniciada
+	for i := 1; i < len(resultados); i++ {
+		i := i
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			resultados[i], _ = l.do(k, "a", fn, cStatTeste)
+		}()
+	}
+	close(libera)
+	wg.Wait()
+
+	if chamadas != 1 {
+		t.Errorf("chamadas = %d, esperado 1", chamadas)
+	}
+	for i, v := range resultados {
+		if v != 100 {
+			t.Errorf("resultado %d = %v, esperado o resultado da primeira chamada", i, v)
+		}
+	}
+}
+
+func TestRateLimiterPanic(t *testing.T) {
+	l, _ := rateLimiterTeste(RateLimiterConfig{JanelaDuplicidade: time.Minute})
+	k := limiterKey{"111", ConsultaProtocolo, 35}
+
+	libera := make(chan struct{})
+	iniciada := make(chan struct{})
+	go func() {
+		defer func() { recover() }()
+		l.do(k, "a", func() (interface{}, error) {
+			close(iniciada)
+			<-libera
+			panic("falha na consulta")
+		}, cStatTeste)
+	}()
+	<-iniciada
+
+	// As chamadas idênticas simultâneas aguardam o fechamento de done e recebem o erro da chamada em andamento.
+	l.mu.Lock()
+	c := l.cache[requestKey{k, "a"}]
+	l.mu.Unlock()
+	close(libera)
+
+	select {
+	case <-c.done:
+		if !errors.Is(c.err, errConsultaInterrompida) {
+			t.Errorf("erro recebido pelas chamadas idênticas = %v, esperado errConsultaInterrompida", c.err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("done não foi fechado após o panic da chamada")
+	}
+
+	if v, err := l.do(k, "a", func() (interface{}, error) { return 100, nil }, cStatTeste); err != nil || v != 100 {
+		t.Errorf("do após o panic = %v, %v", v, err)
+	}
+}
+
+func TestRateLimiterExpiracaoCache(t *testing.T) {
+	l, agora := rateLimiterTeste(RateLimiterConfig{JanelaDuplicidade: time.Minute})
+	k := limiterKey{"111", ConsultaProtocolo, 35}
+	fn := func() (interface{}, error) { return 100, nil }
+
+	for i := 0; i < 1000; i++ {
+		l.do(k, fmt.Sprint(i), fn, cStatTeste)
+		*agora = agora.Add(time.Second)
+	}
+	if n := len(l.cache); n > 61 || l.expiracao.Len() != n {
+		t.Errorf("cache com %d entradas e %d na fila de expiração, esperado no máximo as 61 da última janela", n, l.expiracao.Len())
+	}
+}
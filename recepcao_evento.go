@@ -28,7 +28,6 @@ import (
 const (
 	xmlnsRecepcaoEvento      = "http://www.portalfiscal.inf.br/nfe/wsdl/NFeRecepcaoEvento4"
 	xmlnsNFe                 = "http://www.portalfiscal.inf.br/nfe"
-	urlRecepcaoEvento        = "https://www.nfe.fazenda.gov.br/NFeRecepcaoEvento4/NFeRecepcaoEvento4.asmx"
 	soapActionRecepcaoEvento = "http://www.portalfiscal.inf.br/nfe/wsdl/NFeRecepcaoEvento4/nfeRecepcaoEventoNF"
 )
 
@@ -47,6 +46,9 @@ type ManifestacaoEvento struct {
 	NSeqEvento int
 	VerEvento  string
 	DescEvento string
+
+	// detEvento, quando informado, preenche o detEvento no lugar da descEvento simples (usado por eventos com campos específicos, como o EPEC).
+	detEvento func(det *etree.Element)
 }
 
 // ============================================================================
@@ -60,15 +62,79 @@ func SendManifestacaoEvento(
 	idLote string,
 	eventos []ManifestacaoEvento,
 	optReq ...func(*http.Request),
+) ([]byte, error) {
+	// Carrega cert/key (PEM) para assinatura
+	certPEM, keyPEM, err := loadSigningCert(certPEMPath, keyPEMPath)
+	if err != nil {
+		return nil, fmt.Errorf("erro carregando cert/key: %w", err)
+	}
+	return sendManifestacaoEvento(ctx, client, certPEM, keyPEM, idLote, eventos, optReq...)
+}
+
+// SendManifestacaoEventoFonte é o SendManifestacaoEvento com o certificado em uso na FonteCertificado, que pode ser renovado sem reiniciar o processo.
+func SendManifestacaoEventoFonte(
+	ctx context.Context,
+	client *http.Client,
+	fonte *FonteCertificado,
+	idLote string,
+	eventos []ManifestacaoEvento,
+	optReq ...func(*http.Request),
+) ([]byte, error) {
+	certPEM, keyPEM := fonte.PEM()
+	return sendManifestacaoEvento(ctx, client, certPEM, keyPEM, idLote, eventos, optReq...)
+}
+
+// endpointEvento obtem o WebService de recepção do evento: o do Ambiente Nacional (cOrgao 91) ou, para os eventos da UF, o definido pelo tpEmis da chave de acesso, que direciona ao SVC os eventos das notas emitidas em contingência enquanto ela estiver ativa (ver DefaultContingencia).
+func endpointEvento(ev ManifestacaoEvento) (Endpoint, error) {
+	if ev.COrgao != 91 {
+		if cUF, _, _, _, _, _, _, _, _, err := GetChaveInfo(ev.ChNFe); err == nil && cUF == ev.COrgao {
+			return DefaultContingencia.EndpointChave(ev.ChNFe, TAmb(ev.TpAmb), Evento)
+		}
+	}
+	return getEndpoint(ev.COrgao, TAmb(ev.TpAmb), Evento)
+}
+
+func sendManifestacaoEvento(
+	ctx context.Context,
+	client *http.Client,
+	certPEM, keyPEM []byte,
+	idLote string,
+	eventos []ManifestacaoEvento,
+	optReq ...func(*http.Request),
 ) ([]byte, error) {
 	if len(eventos) == 0 {
 		return nil, fmt.Errorf("nenhum evento informado")
 	}
 
-	// Carrega cert/key (PEM) para assinatura
-	certPEM, keyPEM, err := loadSigningCert(certPEMPath, keyPEMPath)
+	// WebService do órgão de recepção (cOrgao 91 = Ambiente Nacional), o mesmo para todos os eventos do lote
+	ep, err := endpointEvento(eventos[0])
 	if err != nil {
-		return nil, fmt.Errorf("erro carregando cert/key: %w", err)
+		return nil, err
+	}
+	for _, ev := range eventos[1:] {
+		outro, err := endpointEvento(ev)
+		if err != nil {
+			return nil, err
+		}
+		if outro.URL != ep.URL {
+			return nil, fmt.Errorf("os eventos de um lote devem ser recebidos pelo mesmo autorizador: NFe %s (%s) e NFe %s (%s)", eventos[0].ChNFe, ep.Autorizador, ev.ChNFe, outro.Autorizador)
+		}
+	}
+
+	// O autor do evento precisa ser o titular do certificado (senão a Sefaz rejeita com o cStat 213); só é possível conferir nos certificados com os campos da ICP-Brasil.
+	cert, err := NewCertificado(certPEM)
+	if err != nil {
+		return nil, err
+	}
+	if err := cert.Vigente(time.Now()); err != nil {
+		return nil, err
+	}
+	if cert.ICPBrasil() {
+		for _, ev := range eventos {
+			if err := cert.ConfereDocumento(ev.CNPJ + ev.CPF); err != nil {
+				return nil, fmt.Errorf("evento %s da NFe %s: %w", ev.TpEvento, ev.ChNFe, err)
+			}
+		}
 	}
 
 	// 1) Monta envEvento (Document) já assinado
@@ -107,7 +173,7 @@ func SendManifestacaoEvento(
 	fmt.Println("=====================================")
 
 	// 3) Envia com o SEU http.Client
-	req, err := http.NewRequestWithContext(ctx, "POST", urlRecepcaoEvento, bytes.NewReader(soapBytes))
+	req, err := http.NewRequestWithContext(ctx, "POST", ep.URL, bytes.NewReader(soapBytes))
 	if err != nil {
 		return nil, fmt.Errorf("erro criando request HTTP: %w", err)
 	}
@@ -130,8 +196,9 @@ func SendManifestacaoEvento(
 	fmt.Println(string(respBody))
 	fmt.Println("======================================")
 
-	if resp.StatusCode != http.StatusOK {
-		return respBody, fmt.Errorf("HTTP %d na RecepcaoEvento", resp.StatusCode)
+	// A resposta é retornada como recebida, já que o retEvento é assinado pela Sefaz; as correções do DefaultNormalizador valem apenas para a leitura (ver readRetEnvEvento).
+	if wsErr := checkResponse(resp, DefaultNormalizador.Normaliza(ep, respBody), ep); wsErr != nil {
+		return respBody, wsErr
 	}
 
 	return respBody, nil
@@ -177,14 +244,18 @@ func buildEnvEventoDoc(
 		}
 
 		infEventoEl.CreateElement("chNFe").SetText(ev.ChNFe)
-		infEventoEl.CreateElement("dhEvento").SetText(ev.DhEvento.Format(time.RFC3339))
+		infEventoEl.CreateElement("dhEvento").SetText(ev.DhEvento.Format(layoutDataHora))
 		infEventoEl.CreateElement("tpEvento").SetText(ev.TpEvento)
 		infEventoEl.CreateElement("nSeqEvento").SetText(strconv.Itoa(ev.NSeqEvento))
 		infEventoEl.CreateElement("verEvento").SetText(ev.VerEvento)
 
 		detEventoEl := infEventoEl.CreateElement("detEvento")
 		detEventoEl.CreateAttr("versao", "1.00")
-		detEventoEl.CreateElement("descEvento").SetText(ev.DescEvento)
+		if ev.detEvento != nil {
+			ev.detEvento(detEventoEl)
+		} else {
+			detEventoEl.CreateElement("descEvento").SetText(ev.DescEvento)
+		}
 
 		// Assina ESTE infEvento e adiciona <Signature> como irmão (filho de <evento>)
 		sigEl, err := buildSignatureForInfEvento(doc, infEventoEl, certPEM, keyPEM)
@@ -0,0 +1,239 @@
+package nfe
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"math"
+	"math/rand"
+	"net"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// RetryPolicy define a política de novas tentativas para falhas transitórias das Sefazes: timeouts e erros de rede, status HTTP 408/429/502/503/504 e cStat 108/109 (serviço paralisado).
+//
+// A política é opcional e aplicada ao http.Client através da WithRetry, de maneira que vale para todos os serviços da biblioteca.
+type RetryPolicy struct {
+	MaxAttempts    int           // Número máximo de tentativas, incluindo a primeira. Valores menores que 2 desabilitam as novas tentativas.
+	InitialBackoff time.Duration // Espera antes da segunda tentativa.
+	MaxBackoff     time.Duration // Espera máxima entre tentativas.
+	Multiplier     float64       // Fator de crescimento exponencial da espera.
+	Jitter         float64       // Variação aleatória da espera, como fração (0 a 1) do valor calculado.
+	RetryStatus    []int         // Status HTTP considerados transitórios.
+	RetryCStat     []int         // cStat considerados transitórios.
+
+	// ConfirmaNaoRegistrado é chamada antes de repetir uma operação não idempotente (como a autorização) que falhou depois de a requisição ter sido enviada. A nova tentativa só é feita se a função confirmar, normalmente através de uma consulta da chave (ConsultaNFe), que nada foi registrado na Sefaz. Se for nil, operações não idempotentes nunca são repetidas nesses casos.
+	ConfirmaNaoRegistrado func(req *http.Request) (bool, error)
+}
+
+// DefaultRetryPolicy retorna uma política com 4 tentativas, espera inicial de 1s dobrando a cada tentativa (até 30s) e jitter de 20%.
+func DefaultRetryPolicy() RetryPolicy {
+	return RetryPolicy{
+		MaxAttempts:    4,
+		InitialBackoff: time.Second,
+		MaxBackoff:     30 * time.Second,
+		Multiplier:     2,
+		Jitter:         0.2,
+		RetryStatus:    []int{http.StatusRequestTimeout, http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout},
+		RetryCStat:     []int{108, 109},
+	}
+}
+
+// soapActionsNaoIdempotentes lista os serviços que não podem ser simplesmente repetidos, pois a Sefaz pode ter registrado a operação mesmo sem que a resposta tenha chegado.
+var soapActionsNaoIdempotentes = []string{
+	"NFeAutorizacao4",
+}
+
+var reCStat = regexp.MustCompile(`<cStat>\s*(\d+)\s*</cStat>`)
+
+// WithRetry retorna uma cópia do http.Client fornecido que aplica a política de novas tentativas. O Timeout do client original passa a valer para cada tentativa, e não para o conjunto delas.
+func WithRetry(client *http.Client, policy RetryPolicy) *http.Client {
+	c := *client
+	base := client.Transport
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	c.Transport = &retryTransport{base: base, policy: policy, attemptTimeout: client.Timeout}
+	c.Timeout = 0
+	return &c
+}
+
+// retryTransport é o http.RoundTripper que implementa a RetryPolicy.
+type retryTransport struct {
+	base           http.RoundTripper
+	policy         RetryPolicy
+	attemptTimeout time.Duration
+}
+
+func (t *retryTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	attempts := t.policy.MaxAttempts
+	if attempts < 1 || (req.Body != nil && req.GetBody == nil) {
+		attempts = 1
+	}
+	naoIdempotente := isNaoIdempotente(req)
+
+	var resp *http.Response
+	var err error
+	for attempt := 1; ; attempt++ {
+		// O http.RoundTripper não pode alterar a requisição recebida: as novas tentativas usam uma cópia, com um novo corpo.
+		r := req
+		if attempt > 1 {
+			r = req.Clone(req.Context())
+			if req.GetBody != nil {
+				body, gerr := req.GetBody()
+				if gerr != nil {
+					return nil, gerr
+				}
+				r.Body = body
+			}
+		}
+
+		resp, err = t.roundTrip(r)
+
+		if attempt >= attempts {
+			return resp, err
+		}
+		retry, enviada := t.policy.retryable(resp, err)
+		if !retry {
+			return resp, err
+		}
+		if naoIdempotente && enviada {
+			if t.policy.ConfirmaNaoRegistrado == nil {
+				return resp, err
+			}
+			ok, cerr := t.policy.ConfirmaNaoRegistrado(req)
+			if cerr != nil || !ok {
+				return resp, err
+			}
+		}
+
+		wait := t.policy.backoff(attempt, resp)
+		if resp != nil {
+			resp.Body.Close()
+		}
+		select {
+		case <-req.Context().Done():
+			return nil, req.Context().Err()
+		case <-time.After(wait):
+		}
+	}
+}
+
+// roundTrip executa uma única tentativa, lendo todo o corpo da resposta para que o cStat possa ser verificado e para que o timeout por tentativa não interrompa a leitura posterior.
+func (t *retryTransport) roundTrip(req *http.Request) (*http.Response, error) {
+	r := req
+	if t.attemptTimeout > 0 {
+		ctx, cancel := context.WithTimeout(req.Context(), t.attemptTimeout)
+		defer cancel()
+		r = req.WithContext(ctx)
+	}
+
+	resp, err := t.base.RoundTrip(r)
+	if err != nil {
+		return nil, err
+	}
+	body, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	resp.Body = io.NopCloser(bytes.NewReader(body))
+	resp.ContentLength = int64(len(body))
+
+	return resp, nil
+}
+
+// retryable classifica o resultado de uma tentativa. enviada indica se a requisição pode ter chegado à Sefaz (ou seja, a falha não aconteceu antes do envio e a resposta não garante que nada foi processado).
+func (p RetryPolicy) retryable(resp *http.Response, err error) (retry bool, enviada bool) {
+	if err != nil {
+		var opErr *net.OpError
+		if errors.As(err, &opErr) && opErr.Op == "dial" {
+			return true, false
+		}
+		var netErr net.Error
+		if errors.As(err, &netErr) || errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, context.DeadlineExceeded) {
+			return true, true
+		}
+		return false, true
+	}
+
+	for _, s := range p.RetryStatus {
+		if resp.StatusCode == s {
+			return true, true
+		}
+	}
+
+	if resp.StatusCode == http.StatusOK {
+		if cStat, ok := readCStat(resp); ok {
+			for _, c := range p.RetryCStat {
+				if cStat == c {
+					// serviço paralisado: a Sefaz garante que nada foi processado.
+					return true, false
+				}
+			}
+		}
+	}
+
+	return false, true
+}
+
+// backoff calcula a espera antes da próxima tentativa, respeitando o cabeçalho Retry-After quando presente.
+func (p RetryPolicy) backoff(attempt int, resp *http.Response) time.Duration {
+	mult := p.Multiplier
+	if mult < 1 {
+		mult = 1
+	}
+	d := float64(p.InitialBackoff) * math.Pow(mult, float64(attempt-1))
+	if p.Jitter > 0 {
+		d += d * p.Jitter * (2*rand.Float64() - 1)
+	}
+	wait := time.Duration(d)
+
+	if resp != nil {
+		if s, err := strconv.Atoi(strings.TrimSpace(resp.Header.Get("Retry-After"))); err == nil && time.Duration(s)*time.Second > wait {
+			wait = time.Duration(s) * time.Second
+		}
+	}
+
+	if p.MaxBackoff > 0 && wait > p.MaxBackoff {
+		wait = p.MaxBackoff
+	}
+	if wait < 0 {
+		wait = 0
+	}
+	return wait
+}
+
+// readCStat extrai o primeiro cStat do corpo da resposta, sem consumi-lo.
+func readCStat(resp *http.Response) (int, bool) {
+	body, err := io.ReadAll(resp.Body)
+	resp.Body = io.NopCloser(bytes.NewReader(body))
+	if err != nil {
+		return 0, false
+	}
+	m := reCStat.FindSubmatch(body)
+	if m == nil {
+		return 0, false
+	}
+	cStat, err := strconv.Atoi(string(m[1]))
+	if err != nil {
+		return 0, false
+	}
+	return cStat, true
+}
+
+// isNaoIdempotente verifica, pela SOAPAction (cabeçalho ou parâmetro action do Content-Type no SOAP 1.2), se a requisição é de um serviço não idempotente.
+func isNaoIdempotente(req *http.Request) bool {
+	action := req.Header.Get("SOAPAction") + " " + req.Header.Get("Content-Type")
+	for _, s := range soapActionsNaoIdempotentes {
+		if strings.Contains(strings.ToLower(action), strings.ToLower(s)) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,208 @@
+package nfe
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+// transporteTeste é um http.RoundTripper que responde com as respostas (ou erros) informadas, na ordem, repetindo a última, e conta as tentativas e guarda o corpo recebido em cada uma.
+type transporteTeste struct {
+	respostas []func() (*http.Response, error)
+	chamadas  int
+	corpos    []string
+}
+
+func (t *transporteTeste) RoundTrip(req *http.Request) (*http.Response, error) {
+	i := t.chamadas
+	if i >= len(t.respostas) {
+		i = len(t.respostas) - 1
+	}
+	t.chamadas++
+	if req.Body != nil {
+		b, _ := io.ReadAll(req.Body)
+		t.corpos = append(t.corpos, string(b))
+	}
+	return t.respostas[i]()
+}
+
+func respostaTeste(status int, body string) func() (*http.Response, error) {
+	return func() (*http.Response, error) {
+		return &http.Response{StatusCode: status, Status: http.StatusText(status), Header: http.Header{}, Body: io.NopCloser(strings.NewReader(body))}, nil
+	}
+}
+
+func erroTeste(err error) func() (*http.Response, error) {
+	return func() (*http.Response, error) { return nil, err }
+}
+
+// timeoutTeste é um net.Error de timeout, como o retornado pelo http.Transport quando a resposta não chega.
+type timeoutTeste struct{}
+
+func (timeoutTeste) Error() string   { return "i/o timeout" }
+func (timeoutTeste) Timeout() bool   { return true }
+func (timeoutTeste) Temporary() bool { return true }
+
+func TestRetryable(t *testing.T) {
+	p := DefaultRetryPolicy()
+	casos := []struct {
+		nome           string
+		resp           func() (*http.Response, error)
+		retry, enviada bool
+	}{
+		{"falha na conexão", erroTeste(&net.OpError{Op: "dial", Err: errors.New("connection refused")}), true, false},
+		{"timeout", erroTeste(timeoutTeste{}), true, true},
+		{"conexão encerrada", erroTeste(io.ErrUnexpectedEOF), true, true},
+		{"prazo do contexto", erroTeste(fmt.Errorf("envio: %w", context.DeadlineExceeded)), true, true},
+		{"erro permanente", erroTeste(errors.New("x509: certificate signed by unknown authority")), false, true},
+		{"408", respostaTeste(http.StatusRequestTimeout, ""), true, true},
+		{"429", respostaTeste(http.StatusTooManyRequests, ""), true, true},
+		{"502", respostaTeste(http.StatusBadGateway, ""), true, true},
+		{"503", respostaTeste(http.StatusServiceUnavailable, ""), true, true},
+		{"504", respostaTeste(http.StatusGatewayTimeout, ""), true, true},
+		{"500", respostaTeste(http.StatusInternalServerError, ""), false, true},
+		{"403", respostaTeste(http.StatusForbidden, ""), false, true},
+		{"cStat 108", respostaTeste(http.StatusOK, "<retConsStatServ><cStat>108</cStat></retConsStatServ>"), true, false},
+		{"cStat 109", respostaTeste(http.StatusOK, "<retConsStatServ><cStat> 109 </cStat></retConsStatServ>"), true, false},
+		{"cStat 107", respostaTeste(http.StatusOK, "<retConsStatServ><cStat>107</cStat></retConsStatServ>"), false, true},
+		{"200 sem cStat", respostaTeste(http.StatusOK, "<html/>"), false, true},
+	}
+	for _, c := range casos {
+		t.Run(c.nome, func(t *testing.T) {
+			resp, err := c.resp()
+			retry, enviada := p.retryable(resp, err)
+			if retry != c.retry || (retry && enviada != c.enviada) {
+				t.Errorf("retryable = %v, %v; esperado %v, %v", retry, enviada, c.retry, c.enviada)
+			}
+		})
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	p := RetryPolicy{InitialBackoff: time.Second, MaxBackoff: 10 * time.Second, Multiplier: 2, Jitter: 0.2}
+	casos := []struct {
+		nome       string
+		attempt    int
+		retryAfter string
+		min, max   time.Duration
+	}{
+		{"primeira espera", 1, "", 800 * time.Millisecond, 1200 * time.Millisecond},
+		{"crescimento exponencial", 3, "", 3200 * time.Millisecond, 4800 * time.Millisecond},
+		{"limitada ao MaxBackoff", 10, "", 10 * time.Second, 10 * time.Second},
+		{"Retry-After maior que o backoff", 1, "5", 5 * time.Second, 5 * time.Second},
+		{"Retry-After menor que o backoff", 3, "1", 3200 * time.Millisecond, 4800 * time.Millisecond},
+		{"Retry-After limitado ao MaxBackoff", 1, "120", 10 * time.Second, 10 * time.Second},
+		{"Retry-After inválido", 1, "Wed, 21 Oct 2015 07:28:00 GMT", 800 * time.Millisecond, 1200 * time.Millisecond},
+	}
+	for _, c := range casos {
+		t.Run(c.nome, func(t *testing.T) {
+			resp, _ := respostaTeste(http.StatusServiceUnavailable, "")()
+			if c.retryAfter != "" {
+				resp.Header.Set("Retry-After", c.retryAfter)
+			}
+			for i := 0; i < 100; i++ {
+				if d := p.backoff(c.attempt, resp); d < c.min || d > c.max {
+					t.Fatalf("backoff(%d) = %v, esperado entre %v e %v", c.attempt, d, c.min, c.max)
+				}
+			}
+		})
+	}
+
+	if d := (RetryPolicy{InitialBackoff: time.Second, Multiplier: 0.5}).backoff(3, nil); d != time.Second {
+		t.Errorf("backoff com Multiplier < 1 = %v, esperado a espera inicial", d)
+	}
+}
+
+func TestIsNaoIdempotente(t *testing.T) {
+	casos := []struct {
+		header, valor string
+		esperado      bool
+	}{
+		{"SOAPAction", "http://www.portalfiscal.inf.br/nfe/wsdl/NFeAutorizacao4/nfeAutorizacaoLote", true},
+		{"Content-Type", `application/soap+xml; charset=utf-8; action="http://www.portalfiscal.inf.br/nfe/wsdl/NFeAutorizacao4/nfeAutorizacaoLote"`, true},
+		{"SOAPAction", "http://www.portalfiscal.inf.br/nfe/wsdl/NFeRetAutorizacao4/nfeRetAutorizacaoLote", false},
+		{"SOAPAction", "http://www.portalfiscal.inf.br/nfe/wsdl/NFeConsultaProtocolo4/nfeConsultaNF", false},
+	}
+	for _, c := range casos {
+		req, _ := http.NewRequest("POST", "https://sefaz.exemplo/ws", nil)
+		req.Header.Set(c.header, c.valor)
+		if r := isNaoIdempotente(req); r != c.esperado {
+			t.Errorf("isNaoIdempotente(%s: %s) = %v, esperado %v", c.header, c.valor, r, c.esperado)
+		}
+	}
+}
+
+func TestWithRetry(t *testing.T) {
+	const (
+		autorizacao = "http://www.portalfiscal.inf.br/nfe/wsdl/NFeAutorizacao4/nfeAutorizacaoLote"
+		consulta    = "http://www.portalfiscal.inf.br/nfe/wsdl/NFeConsultaProtocolo4/nfeConsultaNF"
+	)
+	indisponivel := respostaTeste(http.StatusServiceUnavailable, "")
+	paralisado := respostaTeste(http.StatusOK, "<retEnviNFe><cStat>108</cStat></retEnviNFe>")
+	ok := respostaTeste(http.StatusOK, "<retEnviNFe><cStat>103</cStat></retEnviNFe>")
+	recusada := erroTeste(&net.OpError{Op: "dial", Err: errors.New("connection refused")})
+
+	casos := []struct {
+		nome       string
+		soapAction string
+		respostas  []func() (*http.Response, error)
+		confirma   func(req *http.Request) (bool, error)
+		tentativas int
+		status     int
+	}{
+		{"consulta repetida após 503", consulta, []func() (*http.Response, error){indisponivel, indisponivel, ok}, nil, 3, http.StatusOK},
+		{"consulta limitada ao MaxAttempts", consulta, []func() (*http.Response, error){indisponivel}, nil, 4, http.StatusServiceUnavailable},
+		{"autorização não repetida após o envio", autorizacao, []func() (*http.Response, error){indisponivel, ok}, nil, 1, http.StatusServiceUnavailable},
+		{"autorização não repetida após timeout", autorizacao, []func() (*http.Response, error){erroTeste(timeoutTeste{}), ok}, nil, 1, 0},
+		{"autorização não repetida sem confirmação", autorizacao, []func() (*http.Response, error){indisponivel, ok}, func(*http.Request) (bool, error) { return false, nil }, 1, http.StatusServiceUnavailable},
+		{"autorização não repetida se a confirmação falhar", autorizacao, []func() (*http.Response, error){indisponivel, ok}, func(*http.Request) (bool, error) { return true, errors.New("falha") }, 1, http.StatusServiceUnavailable},
+		{"autorização repetida com confirmação", autorizacao, []func() (*http.Response, error){indisponivel, ok}, func(*http.Request) (bool, error) { return true, nil }, 2, http.StatusOK},
+		{"autorização repetida se a conexão falhar", autorizacao, []func() (*http.Response, error){recusada, ok}, nil, 2, http.StatusOK},
+		{"autorização repetida com serviço paralisado", autorizacao, []func() (*http.Response, error){paralisado, ok}, nil, 2, http.StatusOK},
+	}
+	for _, c := range casos {
+		t.Run(c.nome, func(t *testing.T) {
+			tr := &transporteTeste{respostas: c.respostas}
+			p := DefaultRetryPolicy()
+			p.InitialBackoff = time.Millisecond
+			p.MaxBackoff = time.Millisecond
+			p.ConfirmaNaoRegistrado = c.confirma
+			client := WithRetry(&http.Client{Transport: tr, Timeout: time.Second}, p)
+			if client.Timeout != 0 {
+				t.Errorf("Timeout = %v, esperado 0 (o timeout passa a valer por tentativa)", client.Timeout)
+			}
+
+			req, err := newRequest("https://sefaz.exemplo/ws", c.soapAction, []byte("<nfeDadosMsg/>"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			corpo := req.Body
+			resp, err := client.Do(req)
+			if req.Body != corpo {
+				t.Error("o corpo da requisição recebida foi substituído")
+			}
+			for i, b := range tr.corpos {
+				if b != "<nfeDadosMsg/>" {
+					t.Errorf("corpo da tentativa %d = %q", i+1, b)
+				}
+			}
+			if tr.chamadas != c.tentativas {
+				t.Errorf("tentativas = %d, esperado %d", tr.chamadas, c.tentativas)
+			}
+			status := 0
+			if err == nil {
+				status = resp.StatusCode
+				resp.Body.Close()
+			}
+			if status != c.status {
+				t.Errorf("status = %d (%v), esperado %d", status, err, c.status)
+			}
+		})
+	}
+}
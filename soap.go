@@ -1,8 +1,10 @@
 package nfe
 
 import (
+	"bytes"
 	"encoding/xml"
 	"fmt"
+	"strings"
 )
 
 // Envelope representa o XML do envelope SOAP que será usado na comunicação.
@@ -29,8 +31,32 @@ type EnvelopeResult struct {
 	} `xml:"Body"`
 }
 
-// getSoapEnvelope envelopa um XML fornecido de acordo com o padrão SOAP.
-func getSoapEnvelope(msg []byte, xmlns string) ([]byte, error) {
+// envelopeSOAP11 representa o XML do envelope SOAP 1.1, com o prefixo soap no namespace do SOAP 1.1.
+type envelopeSOAP11 struct {
+	XMLName xml.Name `xml:"soap:Envelope"`
+	Xsi     string   `xml:"xmlns:xsi,attr"`
+	Xsd     string   `xml:"xmlns:xsd,attr"`
+	Soap    string   `xml:"xmlns:soap,attr"`
+	Body    struct {
+		NfeDadosMsg struct {
+			Xmlns string `xml:"xmlns,attr"`
+			Value []byte `xml:",innerxml"`
+		} `xml:"nfeDadosMsg"`
+	} `xml:"soap:Body"`
+}
+
+// getSoapEnvelope envelopa um XML fornecido de acordo com o padrão SOAP, na versão informada (SOAP11 ou SOAP12).
+func getSoapEnvelope(msg []byte, xmlns string, soapVersion string) ([]byte, error) {
+	if soapVersion == SOAP11 {
+		var env envelopeSOAP11
+		env.Xsi = "http://www.w3.org/2001/XMLSchema-instance"
+		env.Xsd = "http://www.w3.org/2001/XMLSchema"
+		env.Soap = "http://schemas.xmlsoap.org/soap/envelope/"
+		env.Body.NfeDadosMsg.Xmlns = xmlns
+		env.Body.NfeDadosMsg.Value = msg
+		return xml.Marshal(env)
+	}
+
 	var env Envelope
 
 	env.Xsi = "http://www.w3.org/2001/XMLSchema-instance"
@@ -51,3 +77,57 @@ func readSoapEnvelope(msg []byte) ([]byte, error) {
 	}
 	return env.Body.NfeResultMsg.Value, nil
 }
+
+// EnvelopeFault representa o XML de um envelope SOAP de retorno contendo um Fault. Os campos em minúsculas correspondem ao SOAP 1.1 e os demais ao SOAP 1.2.
+type EnvelopeFault struct {
+	XMLName xml.Name `xml:"Envelope"`
+	Body    struct {
+		Fault *struct {
+			FaultCode   string `xml:"faultcode"`
+			FaultString string `xml:"faultstring"`
+			FaultDetail struct {
+				Value string `xml:",innerxml"`
+			} `xml:"detail"`
+			Code struct {
+				Value   string `xml:"Value"`
+				Subcode struct {
+					Value string `xml:"Value"`
+				} `xml:"Subcode"`
+			} `xml:"Code"`
+			Reason struct {
+				Text []string `xml:"Text"`
+			} `xml:"Reason"`
+			Detail struct {
+				Value string `xml:",innerxml"`
+			} `xml:"Detail"`
+		} `xml:"Fault"`
+	} `xml:"Body"`
+}
+
+// readSoapFault verifica se o envelope SOAP de retorno contém um Fault e, em caso positivo, retorna o código, o motivo e o detalhe informados pelo WebService.
+func readSoapFault(msg []byte) (code string, reason string, detail string, ok bool) {
+	if !bytes.Contains(msg, []byte("Fault")) {
+		return "", "", "", false
+	}
+
+	var env EnvelopeFault
+	if err := xml.Unmarshal(msg, &env); err != nil || env.Body.Fault == nil {
+		return "", "", "", false
+	}
+	f := env.Body.Fault
+
+	if f.Code.Value != "" || len(f.Reason.Text) > 0 {
+		code = strings.TrimSpace(f.Code.Value)
+		if sub := strings.TrimSpace(f.Code.Subcode.Value); sub != "" {
+			code += "/" + sub
+		}
+		reason = strings.TrimSpace(strings.Join(f.Reason.Text, " "))
+		detail = strings.TrimSpace(f.Detail.Value)
+	} else {
+		code = strings.TrimSpace(f.FaultCode)
+		reason = strings.TrimSpace(f.FaultString)
+		detail = strings.TrimSpace(f.FaultDetail.Value)
+	}
+
+	return code, reason, detail, true
+}
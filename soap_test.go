@@ -0,0 +1,192 @@
+package nfe
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// fixtureSefaz lê uma resposta do corpus de testdata/sefaz, organizado por UF (ou AN, para o Ambiente Nacional) e serviço. As respostas são sintéticas: foram escritas a partir dos leiautes do MOC e das variações de envelope conhecidas de cada UF, e não capturadas das Sefazes.
+func fixtureSefaz(t testing.TB, arquivo string) []byte {
+	t.Helper()
+	b, err := os.ReadFile(filepath.Join("testdata", "sefaz", arquivo))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+// fixturesSefaz retorna todas as respostas do corpus cujo nome começa com o prefixo informado (ex.: "ConsultaStatus").
+func fixturesSefaz(t testing.TB, prefixo string) map[string][]byte {
+	t.Helper()
+	arquivos, err := filepath.Glob(filepath.Join("testdata", "sefaz", "*", prefixo+"*.xml"))
+	if err != nil || len(arquivos) == 0 {
+		t.Fatalf("nenhuma fixture %s: %v", prefixo, err)
+	}
+	r := map[string][]byte{}
+	for _, a := range arquivos {
+		rel, _ := filepath.Rel(filepath.Join("testdata", "sefaz"), a)
+		r[filepath.ToSlash(rel)] = fixtureSefaz(t, rel)
+	}
+	return r
+}
+
+// respondeFixture faz o serviço responder, em todas as UFs, com a fixture informada, como em nfetest.Sefaz.Reproduz.
+func respondeFixture(t *testing.T, ws TWebService, arquivo string) *http.Client {
+	t.Helper()
+	corpo := fixtureSefaz(t, arquivo)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(corpo)
+	}))
+	t.Cleanup(srv.Close)
+	for _, tpAmb := range []TAmb{Producao, Homologacao} {
+		tpAmb := tpAmb
+		DefaultRegistry.Override(0, tpAmb, ws, srv.URL)
+		t.Cleanup(func() { DefaultRegistry.RemoveOverride(0, tpAmb, ws) })
+	}
+	return srv.Client()
+}
+
+func TestReadSoapEnvelope(t *testing.T) {
+	// Os prefixos do envelope variam entre as UFs (soap, env, soapenv).
+	for _, arquivo := range []string{"SP/ConsultaStatus.xml", "MG/ConsultaStatus.xml", "MT/ConsultaStatus.xml"} {
+		xmlfile, err := readSoapEnvelope(fixtureSefaz(t, arquivo))
+		if err != nil || !strings.HasPrefix(string(xmlfile), "<retConsStatServ") {
+			t.Errorf("readSoapEnvelope(%s) = %.40s, %v", arquivo, xmlfile, err)
+		}
+	}
+}
+
+func FuzzReadSoapEnvelope(f *testing.F) {
+	for _, b := range fixturesSefaz(f, "") {
+		f.Add(b)
+	}
+	f.Add([]byte(`<soap:Envelope xmlns:soap="http://www.w3.org/2003/05/soap-envelope"><soap:Body><soap:Fault><soap:Code><soap:Value>soap:Receiver</soap:Value></soap:Code><soap:Reason><soap:Text>Erro</soap:Text></soap:Reason></soap:Fault></soap:Body></soap:Envelope>`))
+	f.Fuzz(func(t *testing.T, data []byte) {
+		readSoapEnvelope(data)
+		code, reason, detail, ok := readSoapFault(data)
+		if !ok && (code != "" || reason != "" || detail != "") {
+			t.Errorf("readSoapFault sem Fault retornou %q, %q, %q", code, reason, detail)
+		}
+	})
+}
+
+func TestReadSoapFault(t *testing.T) {
+	casos := []struct {
+		nome                 string
+		msg                  string
+		code, reason, detail string
+		ok                   bool
+	}{
+		{
+			nome:   "SOAP 1.1",
+			msg:    `<soap:Envelope xmlns:soap="http://schemas.xmlsoap.org/soap/envelope/"><soap:Body><soap:Fault><faultcode>soap:Server</faultcode><faultstring> Servidor indisponível </faultstring><detail><erro>timeout</erro></detail></soap:Fault></soap:Body></soap:Envelope>`,
+			code:   "soap:Server",
+			reason: "Servidor indisponível",
+			detail: "<erro>timeout</erro>",
+			ok:     true,
+		},
+		{
+			nome:   "SOAP 1.2 com Subcode",
+			msg:    `<env:Envelope xmlns:env="http://www.w3.org/2003/05/soap-envelope"><env:Body><env:Fault><env:Code><env:Value>env:Sender</env:Value><env:Subcode><env:Value>ns:Schema</env:Value></env:Subcode></env:Code><env:Reason><env:Text xml:lang="pt">Erro de</env:Text><env:Text xml:lang="en">schema</env:Text></env:Reason><env:Detail>linha 1</env:Detail></env:Fault></env:Body></env:Envelope>`,
+			code:   "env:Sender/ns:Schema",
+			reason: "Erro de schema",
+			detail: "linha 1",
+			ok:     true,
+		},
+		{
+			nome:   "SOAP 1.2 sem Detail",
+			msg:    `<soap:Envelope xmlns:soap="http://www.w3.org/2003/05/soap-envelope"><soap:Body><soap:Fault><soap:Code><soap:Value>soap:Receiver</soap:Value></soap:Code><soap:Reason><soap:Text>Erro</soap:Text></soap:Reason></soap:Fault></soap:Body></soap:Envelope>`,
+			code:   "soap:Receiver",
+			reason: "Erro",
+			ok:     true,
+		},
+		{
+			nome: "resposta sem Fault",
+			msg:  string(fixtureSefaz(t, "SP/ConsultaStatus.xml")),
+		},
+		{
+			nome: "Fault fora do Body",
+			msg:  `<soap:Envelope xmlns:soap="http://www.w3.org/2003/05/soap-envelope"><soap:Body><nfeResultMsg>Fault</nfeResultMsg></soap:Body></soap:Envelope>`,
+		},
+		{
+			nome: "XML inválido",
+			msg:  `<soap:Envelope><soap:Body><soap:Fault>`,
+		},
+	}
+	for _, c := range casos {
+		t.Run(c.nome, func(t *testing.T) {
+			code, reason, detail, ok := readSoapFault([]byte(c.msg))
+			if code != c.code || reason != c.reason || detail != c.detail || ok != c.ok {
+				t.Errorf("readSoapFault = %q, %q, %q, %v; esperado %q, %q, %q, %v", code, reason, detail, ok, c.code, c.reason, c.detail, c.ok)
+			}
+		})
+	}
+}
+
+func TestCheckResponse(t *testing.T) {
+	ep := Endpoint{URL: "https://sefaz.exemplo/ws", CUF: 35, Servico: ConsultaStatus}
+	fault11 := []byte(`<soap:Envelope xmlns:soap="http://schemas.xmlsoap.org/soap/envelope/"><soap:Body><soap:Fault><faultcode>soap:Client</faultcode><faultstring>Requisição inválida</faultstring></soap:Fault></soap:Body></soap:Envelope>`)
+	fault12 := []byte(`<soap:Envelope xmlns:soap="http://www.w3.org/2003/05/soap-envelope"><soap:Body><soap:Fault><soap:Code><soap:Value>soap:Receiver</soap:Value></soap:Code><soap:Reason><soap:Text>Erro interno</soap:Text></soap:Reason></soap:Fault></soap:Body></soap:Envelope>`)
+
+	casos := []struct {
+		nome   string
+		status int
+		body   []byte
+		erro   bool
+		fault  bool
+		code   string
+		reason string
+	}{
+		{nome: "200 sem Fault", status: http.StatusOK, body: fixtureSefaz(t, "SP/ConsultaStatus.xml")},
+		{nome: "200 com Fault SOAP 1.1", status: http.StatusOK, body: fault11, erro: true, fault: true, code: "soap:Client", reason: "Requisição inválida"},
+		{nome: "500 com Fault SOAP 1.2", status: http.StatusInternalServerError, body: fault12, erro: true, fault: true, code: "soap:Receiver", reason: "Erro interno"},
+		{nome: "503 sem Fault", status: http.StatusServiceUnavailable, body: []byte("Service Unavailable"), erro: true},
+	}
+	for _, c := range casos {
+		t.Run(c.nome, func(t *testing.T) {
+			resp := &http.Response{StatusCode: c.status, Status: http.StatusText(c.status)}
+			e := checkResponse(resp, c.body, ep)
+			if (e != nil) != c.erro {
+				t.Fatalf("checkResponse = %v, esperado erro %v", e, c.erro)
+			}
+			if e == nil {
+				return
+			}
+			if e.IsFault() != c.fault || e.Code != c.code || e.Reason != c.reason {
+				t.Errorf("checkResponse = fault %v, %q, %q; esperado fault %v, %q, %q", e.IsFault(), e.Code, e.Reason, c.fault, c.code, c.reason)
+			}
+			if e.StatusCode != c.status || e.Url != ep.URL || e.CUF != ep.CUF || e.Servico != ep.Servico || e.Body != string(c.body) {
+				t.Errorf("checkResponse = %+v", e)
+			}
+			if c.fault && !strings.Contains(e.Error(), c.reason) {
+				t.Errorf("Error() = %q, esperado o motivo do Fault", e.Error())
+			}
+		})
+	}
+}
+
+func TestGetSoapEnvelope(t *testing.T) {
+	const xmlns = "http://www.portalfiscal.inf.br/nfe/wsdl/NFeStatusServico4"
+	casos := []struct {
+		versao    string
+		prefixo   string
+		namespace string
+	}{
+		{SOAP11, "soap", "http://schemas.xmlsoap.org/soap/envelope/"},
+		{SOAP12, "soap12", "http://www.w3.org/2003/05/soap-envelope"},
+	}
+	for _, c := range casos {
+		env, err := getSoapEnvelope([]byte("<consStatServ/>"), xmlns, c.versao)
+		if err != nil {
+			t.Fatal(err)
+		}
+		esperado := `<` + c.prefixo + `:Envelope xmlns:xsi="http://www.w3.org/2001/XMLSchema-instance" xmlns:xsd="http://www.w3.org/2001/XMLSchema" xmlns:` + c.prefixo + `="` + c.namespace + `"><` + c.prefixo + `:Body><nfeDadosMsg xmlns="` + xmlns + `"><consStatServ/></nfeDadosMsg></` + c.prefixo + `:Body></` + c.prefixo + `:Envelope>`
+		if string(env) != esperado {
+			t.Errorf("getSoapEnvelope(SOAP %s) = %s\nesperado %s", c.versao, env, esperado)
+		}
+	}
+}
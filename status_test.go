@@ -0,0 +1,58 @@
+package nfe
+
+import (
+	"encoding/xml"
+	"testing"
+	"time"
+)
+
+func TestRetConsStatServ(t *testing.T) {
+	brt := time.FixedZone("", -3*3600)
+	testes := []struct {
+		arquivo   string
+		cUF       int
+		cStat     int
+		xMotivo   string
+		tMed      int
+		dhRecbto  time.Time
+		dhRetorno time.Time
+		xObs      string
+	}{
+		{"SP/ConsultaStatus.xml", 35, 107, "Serviço em Operação", 1, time.Date(2024, 5, 17, 10, 31, 2, 0, brt), time.Time{}, ""},
+		{"MG/ConsultaStatus.xml", 31, 107, "Servico em Operacao", 1, time.Date(2024, 5, 17, 10, 31, 2, 0, brt), time.Time{}, ""},
+		{"MT/ConsultaStatus.xml", 51, 107, "Servico em Operacao", 1, time.Date(2024, 5, 17, 10, 31, 2, 0, brt), time.Time{}, ""},
+		{"PR/ConsultaStatus.xml", 41, 107, "Servico em Operacao", 1, time.Date(2024, 5, 17, 10, 31, 2, 0, brt), time.Time{}, ""},
+		// GO responde com BOM, em ISO-8859-1, sem o namespace da NF-e e com dhRecbto sem fuso
+		{"GO/ConsultaStatus.xml", 52, 107, "Serviço em Operação", 1, time.Date(2024, 5, 17, 10, 31, 2, 0, brt), time.Time{}, ""},
+		{"RS/ConsultaStatus-108.xml", 43, 108, "Serviço Paralisado Momentaneamente (curto prazo)", 3, time.Date(2024, 5, 17, 10, 31, 2, 0, brt), time.Date(2024, 5, 17, 11, 0, 0, 0, brt), "Manutencao programada"},
+	}
+	for _, tt := range testes {
+		t.Run(tt.arquivo, func(t *testing.T) {
+			client := respondeFixture(t, ConsultaStatus, tt.arquivo)
+			ret, _, err := ConsultaStatServ(tt.cUF, Homologacao, client)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if ret.CUF != tt.cUF || ret.CStat != tt.cStat || ret.XMotivo != tt.xMotivo || ret.TMed != tt.tMed || ret.XObs != tt.xObs {
+				t.Errorf("retorno inesperado: %+v", ret)
+			}
+			if !ret.DhRecbto.Equal(tt.dhRecbto) || !ret.DhRetorno.Equal(tt.dhRetorno) {
+				t.Errorf("dhRecbto = %v, dhRetorno = %v", ret.DhRecbto, ret.DhRetorno)
+			}
+		})
+	}
+}
+
+func FuzzRetConsStatServ(f *testing.F) {
+	for _, b := range fixturesSefaz(f, "ConsultaStatus") {
+		f.Add(b)
+	}
+	f.Fuzz(func(t *testing.T, data []byte) {
+		xmlfile, err := readSoapEnvelope(data)
+		if err != nil {
+			return
+		}
+		var ret RetConsStatServ
+		xml.Unmarshal(xmlfile, &ret)
+	})
+}
@@ -1,7 +1,7 @@
 package nfe
 
 import (
-	"time"
+	"fmt"
 )
 
 // TAmb representa o ambiente (tpAmb) que será usado na comunicação.
@@ -12,7 +12,7 @@ const (
 	Homologacao TAmb = 2
 )
 
-// TWebService representa o serviço que será consultado. Usado pela função getURLWS para obter a URL da requisição.
+// TWebService representa o serviço que será consultado. Usado pelo Registry para obter a URL da requisição.
 type TWebService int
 
 const (
@@ -23,19 +23,58 @@ const (
 	RetAutorizacao
 	Evento
 	Inutilizacao
+	DistribuicaoDFe
 )
 
+func (ws TWebService) String() string {
+	switch ws {
+	case ConsultaStatus:
+		return "ConsultaStatus"
+	case ConsultaProtocolo:
+		return "ConsultaProtocolo"
+	case ConsultaCadastro:
+		return "ConsultaCadastro"
+	case Autorizacao:
+		return "Autorizacao"
+	case RetAutorizacao:
+		return "RetAutorizacao"
+	case Evento:
+		return "Evento"
+	case Inutilizacao:
+		return "Inutilizacao"
+	case DistribuicaoDFe:
+		return "DistribuicaoDFe"
+	}
+	return fmt.Sprintf("TWebService(%d)", int(ws))
+}
+
+// MarshalText permite que o TWebService seja serializado pelo nome (ex.: "ConsultaStatus"), como na tabela de URLs (ver Registry).
+func (ws TWebService) MarshalText() ([]byte, error) {
+	return []byte(ws.String()), nil
+}
+
+// UnmarshalText interpreta o nome do serviço (ex.: "ConsultaStatus").
+func (ws *TWebService) UnmarshalText(text []byte) error {
+	for w := ConsultaStatus; w <= DistribuicaoDFe; w++ {
+		if w.String() == string(text) {
+			*ws = w
+			return nil
+		}
+	}
+	return fmt.Errorf("Serviço desconhecido: %s", text)
+}
+
 // ProtNFe representa o XML do protocolo de autorização da NFe, encontrado em RetConsSitNFe.
 type ProtNFe struct {
 	Versao  string `json:"-" xml:"versao,attr"`
 	InfProt struct {
-		TpAmb    TAmb      `json:"tpAmb" xml:"tpAmb"`
-		VerAplic string    `json:"verAplic" xml:"verAplic"`
-		ChNFe    string    `json:"chNFe" xml:"chNFe"`
-		DhRecbto time.Time `json:"dhRecbto" xml:"dhRecbto"`
-		NProt    string    `json:"nProt" xml:"nProt"`
-		DigVal   string    `json:"digVal" xml:"digVal"`
-		CStat    int       `json:"cStat" xml:"cStat"`
-		XMotivo  string    `json:"xMotivo" xml:"xMotivo"`
+		TpAmb    TAmb     `json:"tpAmb" xml:"tpAmb"`
+		VerAplic string   `json:"verAplic" xml:"verAplic"`
+		ChNFe    string   `json:"chNFe" xml:"chNFe"`
+		DhRecbto DateTime `json:"dhRecbto" xml:"dhRecbto"`
+		NProt    string   `json:"nProt" xml:"nProt"`
+		DigVal   string   `json:"digVal" xml:"digVal"`
+		CStat    int      `json:"cStat" xml:"cStat"`
+		XMotivo  string   `json:"xMotivo" xml:"xMotivo"`
 	} `json:"infProt" xml:"infProt"`
 }
@@ -1,267 +1,357 @@
 package nfe
 
 import (
+	_ "embed"
+	"encoding/json"
 	"fmt"
+	"os"
+	"sort"
+	"sync"
 )
 
-const (
-	urlConsSitNFeAM    = "https://nfe.sefaz.am.gov.br/services2/services/NfeConsulta4"
-	urlConsSitNFeBA    = "https://nfe.sefaz.ba.gov.br/webservices/NFeConsultaProtocolo4/NFeConsultaProtocolo4.asmx"
-	urlConsSitNFeGO    = "https://nfe.sefaz.go.gov.br/nfe/services/NFeConsultaProtocolo4"
-	urlConsSitNFeMG    = "https://nfe.fazenda.mg.gov.br/nfe2/services/NFeConsultaProtocolo4"
-	urlConsSitNFeMS    = "https://nfe.sefaz.ms.gov.br/ws/NFeConsultaProtocolo4"
-	urlConsSitNFeMT    = "https://nfe.sefaz.mt.gov.br/nfews/v2/services/NfeConsulta4"
-	urlConsSitNFePE    = "https://nfe.sefaz.pe.gov.br/nfe-service/services/NFeConsultaProtocolo4"
-	urlConsSitNFePR    = "https://nfe.sefa.pr.gov.br/nfe/NFeConsultaProtocolo4"
-	urlConsSitNFeRS    = "https://nfe.sefazrs.rs.gov.br/ws/NfeConsulta/NfeConsulta4.asmx"
-	urlConsSitNFeSP    = "https://nfe.fazenda.sp.gov.br/ws/nfeconsultaprotocolo4.asmx"
-	urlConsSitNFeSVAN  = "https://www.sefazvirtual.fazenda.gov.br/NFeConsultaProtocolo4/NFeConsultaProtocolo4.asmx"
-	urlConsSitNFeSVRS  = "https://nfe.svrs.rs.gov.br/ws/NfeConsulta/NfeConsulta4.asmx"
-	urlConsSitNFeSVCAN = "https://www.svc.fazenda.gov.br/NFeConsultaProtocolo4/NFeConsultaProtocolo4.asmx"
-	urlConsSitNFeSVCRS = "https://nfe.svrs.rs.gov.br/ws/NfeConsulta/NfeConsulta4.asmx"
-
-	urlHomConsSitNFeAM    = "https://homnfe.sefaz.am.gov.br/services2/services/NfeConsulta4"
-	urlHomConsSitNFeBA    = "https://hnfe.sefaz.ba.gov.br/webservices/NFeConsultaProtocolo4/NFeConsultaProtocolo4.asmx"
-	urlHomConsSitNFeGO    = "https://homolog.sefaz.go.gov.br/nfe/services/NFeConsultaProtocolo4"
-	urlHomConsSitNFeMG    = "https://hnfe.fazenda.mg.gov.br/nfe2/services/NFeConsultaProtocolo4"
-	urlHomConsSitNFeMS    = "https://hom.nfe.sefaz.ms.gov.br/ws/NFeConsultaProtocolo4"
-	urlHomConsSitNFeMT    = "https://homologacao.sefaz.mt.gov.br/nfews/v2/services/NfeConsulta4"
-	urlHomConsSitNFePE    = "https://nfehomolog.sefaz.pe.gov.br/nfe-service/services/NFeConsultaProtocolo4"
-	urlHomConsSitNFePR    = "https://homologacao.nfe.sefa.pr.gov.br/nfe/NFeConsultaProtocolo4"
-	urlHomConsSitNFeRS    = "https://nfe-homologacao.sefazrs.rs.gov.br/ws/NfeConsulta/NfeConsulta4.asmx"
-	urlHomConsSitNFeSP    = "https://homologacao.nfe.fazenda.sp.gov.br/ws/nfeconsultaprotocolo4.asmx"
-	urlHomConsSitNFeSVAN  = "https://hom.sefazvirtual.fazenda.gov.br/NFeConsultaProtocolo4/NFeConsultaProtocolo4.asmx"
-	urlHomConsSitNFeSVRS  = "https://nfe-homologacao.svrs.rs.gov.br/ws/NfeConsulta/NfeConsulta4.asmx"
-	urlHomConsSitNFeSVCAN = "https://hom.svc.fazenda.gov.br/NFeConsultaProtocolo4/NFeConsultaProtocolo4.asmx"
-	urlHomConsSitNFeSVCRS = "https://nfe-homologacao.svrs.rs.gov.br/ws/NfeConsulta/NfeConsulta4.asmx"
-)
+// urlsJSON é a tabela de WebServices embutida na biblioteca. Ver Registry.
+//
+//go:embed urls.json
+var urlsJSON []byte
 
+// Versões do protocolo SOAP usadas pelos WebServices.
 const (
-	urlConsStatServAM    = "https://nfe.sefaz.am.gov.br/services2/services/NfeStatusServico4"
-	urlConsStatServBA    = "https://nfe.sefaz.ba.gov.br/webservices/NFeStatusServico4/NFeStatusServico4.asmx"
-	urlConsStatServGO    = "https://nfe.sefaz.go.gov.br/nfe/services/NFeStatusServico4"
-	urlConsStatServMG    = "https://nfe.fazenda.mg.gov.br/nfe2/services/NFeStatusServico4"
-	urlConsStatServMS    = "https://nfe.sefaz.ms.gov.br/ws/NFeStatusServico4"
-	urlConsStatServMT    = "https://nfe.sefaz.mt.gov.br/nfews/v2/services/NfeStatusServico4"
-	urlConsStatServPE    = "https://nfe.sefaz.pe.gov.br/nfe-service/services/NFeStatusServico4"
-	urlConsStatServPR    = "https://nfe.sefa.pr.gov.br/nfe/NFeStatusServico4"
-	urlConsStatServRS    = "https://nfe.sefazrs.rs.gov.br/ws/NfeStatusServico/NfeStatusServico4.asmx"
-	urlConsStatServSP    = "https://nfe.fazenda.sp.gov.br/ws/nfestatusservico4.asmx"
-	urlConsStatServSVAN  = "https://www.sefazvirtual.fazenda.gov.br/NFeStatusServico4/NFeStatusServico4.asmx"
-	urlConsStatServSVRS  = "https://nfe.svrs.rs.gov.br/ws/NfeStatusServico/NfeStatusServico4.asmx"
-	urlConsStatServSVCAN = "https://www.svc.fazenda.gov.br/NFeStatusServico4/NFeStatusServico4.asmx"
-	urlConsStatServSVCRS = "https://nfe.svrs.rs.gov.br/ws/NfeStatusServico/NfeStatusServico4.asmx"
-
-	urlHomConsStatServAM    = "https://homnfe.sefaz.am.gov.br/services2/services/NfeStatusServico4"
-	urlHomConsStatServBA    = "https://hnfe.sefaz.ba.gov.br/webservices/NFeStatusServico4/NFeStatusServico4.asmx"
-	urlHomConsStatServGO    = "https://homolog.sefaz.go.gov.br/nfe/services/NFeStatusServico4"
-	urlHomConsStatServMG    = "https://hnfe.fazenda.mg.gov.br/nfe2/services/NFeStatusServico4"
-	urlHomConsStatServMS    = "https://hom.nfe.sefaz.ms.gov.br/ws/NFeStatusServico4"
-	urlHomConsStatServMT    = "https://homologacao.sefaz.mt.gov.br/nfews/v2/services/NfeStatusServico4"
-	urlHomConsStatServPE    = "https://nfehomolog.sefaz.pe.gov.br/nfe-service/services/NFeStatusServico4"
-	urlHomConsStatServPR    = "https://homologacao.nfe.sefa.pr.gov.br/nfe/NFeStatusServico4"
-	urlHomConsStatServRS    = "https://nfe-homologacao.sefazrs.rs.gov.br/ws/NfeStatusServico/NfeStatusServico4.asmx"
-	urlHomConsStatServSP    = "https://homologacao.nfe.fazenda.sp.gov.br/ws/nfestatusservico4.asmx"
-	urlHomConsStatServSVAN  = "https://hom.sefazvirtual.fazenda.gov.br/NFeStatusServico4/NFeStatusServico4.asmx"
-	urlHomConsStatServSVRS  = "https://nfe-homologacao.svrs.rs.gov.br/ws/NfeStatusServico/NfeStatusServico4.asmx"
-	urlHomConsStatServSVCAN = "https://hom.svc.fazenda.gov.br/NFeStatusServico4/NFeStatusServico4.asmx"
-	urlHomConsStatServSVCRS = "https://nfe-homologacao.svrs.rs.gov.br/ws/NfeStatusServico/NfeStatusServico4.asmx"
+	SOAP11 = "1.1"
+	SOAP12 = "1.2"
 )
 
+// Envelopes SOAP específicos de algumas UFs (ver soapConsCadMTMG.go).
 const (
-	//urlConsCadAM = "https://nfe.sefaz.am.gov.br/services2/services/CadConsultaCadastro2"
-	urlConsCadBA = "https://nfe.sefaz.ba.gov.br/webservices/CadConsultaCadastro4/CadConsultaCadastro4.asmx"
-	urlConsCadGO = "https://nfe.sefaz.go.gov.br/nfe/services/CadConsultaCadastro4"
-	urlConsCadMG = "https://nfe.fazenda.mg.gov.br/nfe2/services/CadConsultaCadastro4"
-	urlConsCadMS = "https://nfe.sefaz.ms.gov.br/ws/CadConsultaCadastro4"
-	urlConsCadMT = "https://nfe.sefaz.mt.gov.br/nfews/v2/services/CadConsultaCadastro4"
-	urlConsCadPE = "https://nfe.sefaz.pe.gov.br/nfe-service/services/CadConsultaCadastro4"
-	urlConsCadPR = "https://nfe.sefa.pr.gov.br/nfe/CadConsultaCadastro4"
-	urlConsCadRS = "https://cad.sefazrs.rs.gov.br/ws/cadconsultacadastro/cadconsultacadastro4.asmx"
-	urlConsCadSP = "https://nfe.fazenda.sp.gov.br/ws/cadconsultacadastro4.asmx"
-	//urlConsCadSVAN  = "https://www.sefazvirtual.fazenda.gov.br/CadConsultaCadastro4/CadConsultaCadastro4.asmx"
-	urlConsCadSVRS = "https://cad.svrs.rs.gov.br/ws/cadconsultacadastro/cadconsultacadastro4.asmx"
-	//urlConsCadSVCAN = "https://www.svc.fazenda.gov.br/CadConsultaCadastro4/CadConsultaCadastro4.asmx"
-	//urlConsCadSVCRS = "https://nfe.svrs.rs.gov.br/ws/CadConsultaCadastro/CadConsultaCadastro4.asmx"
-
-	//urlHomConsCadAM = "https://homnfe.sefaz.am.gov.br/services2/services/cadconsultacadastro2"
-	urlHomConsCadBA = "https://hnfe.sefaz.ba.gov.br/webservices/CadConsultaCadastro4/CadConsultaCadastro4.asmx"
-	urlHomConsCadGO = "https://homolog.sefaz.go.gov.br/nfe/services/CadConsultaCadastro4"
-	urlHomConsCadMG = "https://hnfe.fazenda.mg.gov.br/nfe2/services/CadConsultaCadastro4"
-	urlHomConsCadMS = "https://hom.nfe.sefaz.ms.gov.br/ws/CadConsultaCadastro4"
-	urlHomConsCadMT = "https://homologacao.sefaz.mt.gov.br/nfews/v2/services/CadConsultaCadastro4"
-	urlHomConsCadPE = "https://nfehomolog.sefaz.pe.gov.br/nfe-service/services/CadConsultaCadastro4"
-	urlHomConsCadPR = "https://homologacao.nfe.sefa.pr.gov.br/nfe/CadConsultaCadastro4"
-	urlHomConsCadRS = "https://nfe-homologacao.sefazrs.rs.gov.br/ws/cadconsultacadastro/cadconsultacadastro4.asmx"
-	urlHomConsCadSP = "https://homologacao.nfe.fazenda.sp.gov.br/ws/cadconsultacadastro4.asmx"
-	//urlHomConsCadSVAN  = "https://hom.sefazvirtual.fazenda.gov.br/CadConsultaCadastro4/CadConsultaCadastro4.asmx"
-	urlHomConsCadSVRS = "https://nfe-homologacao.svrs.rs.gov.br/ws/cadconsultacadastro/cadconsultacadastro4.asmx"
-	//urlHomConsCadSVCAN = "https://hom.svc.fazenda.gov.br/CadConsultaCadastro4/CadConsultaCadastro4.asmx"
-	//urlHomConsCadSVCRS = "https://nfe-homologacao.svrs.rs.gov.br/ws/CadConsultaCadastro/CadConsultaCadastro4.asmx"
+	envelopeConsCadMT = "ConsCadMT"
+	envelopeConsCadMG = "ConsCadMG"
 )
 
-// getURLWS obtem a URL para o serviço e a UF informados.
-func getURLWS(cUF int, tpAmb TAmb, ws TWebService) (string, error) {
-	switch tpAmb {
-	case Producao:
-		switch ws {
-		case ConsultaProtocolo:
-			switch cUF {
-			case 11, 12, 14, 15, 16, 17, 22, 23, 24, 25, 27, 28, 32, 33, 42, 53:
-				return urlConsSitNFeSVRS, nil
-			case 13:
-				return urlConsSitNFeAM, nil
-			case 21:
-				return urlConsSitNFeSVAN, nil
-			case 26:
-				return urlConsSitNFePE, nil
-			case 29:
-				return urlConsSitNFeBA, nil
-			case 31:
-				return urlConsSitNFeMG, nil
-			case 35:
-				return urlConsSitNFeSP, nil
-			case 41:
-				return urlConsSitNFePR, nil
-			case 43:
-				return urlConsSitNFeRS, nil
-			case 50:
-				return urlConsSitNFeMS, nil
-			case 51:
-				return urlConsSitNFeMT, nil
-			case 52:
-				return urlConsSitNFeGO, nil
-			}
-		case ConsultaStatus:
-			switch cUF {
-			case 11, 12, 14, 15, 16, 17, 22, 23, 24, 25, 27, 28, 32, 33, 42, 53:
-				return urlConsStatServSVRS, nil
-			case 13:
-				return urlConsStatServAM, nil
-			case 21:
-				return urlConsStatServSVAN, nil
-			case 26:
-				return urlConsStatServPE, nil
-			case 29:
-				return urlConsStatServBA, nil
-			case 31:
-				return urlConsStatServMG, nil
-			case 35:
-				return urlConsStatServSP, nil
-			case 41:
-				return urlConsStatServPR, nil
-			case 43:
-				return urlConsStatServRS, nil
-			case 50:
-				return urlConsStatServMS, nil
-			case 51:
-				return urlConsStatServMT, nil
-			case 52:
-				return urlConsStatServGO, nil
-			}
-		case ConsultaCadastro:
-			switch cUF {
-			case 11, 12, 14, 15, 16, 17, 22, 23, 24, 25, 27, 28, 32, 33, 42, 53:
-				return urlConsCadSVRS, nil
-			case 26:
-				return urlConsCadPE, nil
-			case 29:
-				return urlConsCadBA, nil
-			case 31:
-				return urlConsCadMG, nil
-			case 35:
-				return urlConsCadSP, nil
-			case 41:
-				return urlConsCadPR, nil
-			case 43:
-				return urlConsCadRS, nil
-			case 50:
-				return urlConsCadMS, nil
-			case 51:
-				return urlConsCadMT, nil
-			case 52:
-				return urlConsCadGO, nil
+// Endpoint representa um WebService de um autorizador: a URL e as informações necessárias para a comunicação.
+type Endpoint struct {
+	CUF         int         `json:"uf,omitempty"`
+	Autorizador string      `json:"autorizador"`
+	Modelo      string      `json:"modelo,omitempty"`
+	TpAmb       TAmb        `json:"ambiente"`
+	Servico     TWebService `json:"servico"`
+	Versao      string      `json:"versao"`
+	SOAP        string      `json:"soap"`
+	URL         string      `json:"url"`
+	Envelope    string      `json:"envelope,omitempty"`
+}
+
+// registryData representa o formato da tabela de WebServices (urls.json): o autorizador de cada UF (pelo código IBGE, ou 91 para o Ambiente Nacional), o autorizador de contingência (SVC-AN ou SVC-RS), os serviços não oferecidos em algumas UFs (com a fonte da informação) e a lista de WebServices de cada autorizador.
+//
+// Um WebService com "uf" preenchido vale apenas para aquela UF e tem precedência sobre o do autorizador. Um WebService com "modelo" preenchido (ex.: "65", para os autorizadores que atendem a NFC-e em endereços próprios) só é usado pela LookupModelo.
+type registryData struct {
+	Autorizadores map[int]string `json:"autorizadores"`
+	Contingencia  map[int]string `json:"contingencia"`
+	Indisponiveis []struct {
+		Servico TWebService `json:"servico"`
+		TpAmb   TAmb        `json:"ambiente"`
+		UFs     []int       `json:"ufs"`
+		Fonte   string      `json:"fonte"`
+	} `json:"indisponiveis"`
+	WebServices []Endpoint `json:"webservices"`
+	NFCe        []URLsNFCe `json:"nfce"`
+}
+
+// URLsNFCe representa os endereços de consulta da NFC-e publicados por uma UF: a URL do QR Code e a URL de consulta pela chave de acesso (urlChave), informadas no grupo infNFeSupl.
+type URLsNFCe struct {
+	CUF      int    `json:"uf"`
+	TpAmb    TAmb   `json:"ambiente"`
+	QRCode   string `json:"qrcode"`
+	URLChave string `json:"urlChave"`
+}
+
+// ErrServicoIndisponivelNaUF é retornado pelo Registry quando a UF não oferece o serviço solicitado (por exemplo, várias UFs não disponibilizam a consulta de cadastro).
+type ErrServicoIndisponivelNaUF struct {
+	CUF     int
+	TpAmb   TAmb
+	Servico TWebService
+}
+
+func (e *ErrServicoIndisponivelNaUF) Error() string {
+	return fmt.Sprintf("Serviço %v não é oferecido pela UF %s (tpAmb %d)", e.Servico, siglaOrgao(e.CUF), e.TpAmb)
+}
+
+type endpointKey struct {
+	autorizador string
+	tpAmb       TAmb
+	ws          TWebService
+}
+
+type modeloKey struct {
+	autorizador string
+	cUF         int
+	tpAmb       TAmb
+	ws          TWebService
+	modelo      string
+}
+
+type overrideKey struct {
+	cUF   int
+	tpAmb TAmb
+	ws    TWebService
+}
+
+// Registry é a tabela de WebServices usada por todos os serviços da biblioteca para determinar a URL de cada requisição, a partir da UF, do ambiente e do serviço. Permite substituir URLs em tempo de execução (ver Override), por exemplo para apontar um serviço para um simulador local.
+//
+// O DefaultRegistry é carregado da tabela embutida na biblioteca. Pode ser usado concorrentemente.
+type Registry struct {
+	mu            sync.RWMutex
+	autorizadores map[int]string
+	contingencia  map[int]string
+	endpoints     map[endpointKey]Endpoint
+	modelos       map[modeloKey]Endpoint
+	ufEndpoints   map[overrideKey]Endpoint
+	indisponiveis map[overrideKey]bool
+	overrides     map[overrideKey]string
+	nfce          map[estadoKey]URLsNFCe
+}
+
+// DefaultRegistry é o Registry usado por todas as consultas da biblioteca.
+var DefaultRegistry = mustNewRegistry(urlsJSON)
+
+func mustNewRegistry(data []byte) *Registry {
+	r, err := NewRegistry(data)
+	if err != nil {
+		panic(err)
+	}
+	return r
+}
+
+// NewRegistry cria um Registry a partir de uma tabela de WebServices em JSON, no mesmo formato da tabela embutida (urls.json).
+func NewRegistry(data []byte) (*Registry, error) {
+	var d registryData
+	if err := json.Unmarshal(data, &d); err != nil {
+		return nil, fmt.Errorf("Erro na leitura da tabela de WebServices. Detalhes: %w", err)
+	}
+
+	r := &Registry{
+		autorizadores: d.Autorizadores,
+		contingencia:  d.Contingencia,
+		endpoints:     make(map[endpointKey]Endpoint, len(d.WebServices)),
+		modelos:       map[modeloKey]Endpoint{},
+		ufEndpoints:   map[overrideKey]Endpoint{},
+		indisponiveis: map[overrideKey]bool{},
+		overrides:     map[overrideKey]string{},
+		nfce:          make(map[estadoKey]URLsNFCe, len(d.NFCe)),
+	}
+	vistos := make(map[modeloKey]bool, len(d.WebServices))
+	for _, ep := range d.WebServices {
+		if ep.Autorizador == "" || ep.URL == "" {
+			return nil, fmt.Errorf("Erro na leitura da tabela de WebServices: autorizador e url são obrigatórios (%v)", ep)
+		}
+		k := modeloKey{ep.Autorizador, ep.CUF, ep.TpAmb, ep.Servico, ep.Modelo}
+		if vistos[k] {
+			return nil, fmt.Errorf("Erro na leitura da tabela de WebServices: WebService duplicado (%v)", ep)
+		}
+		vistos[k] = true
+		if ep.SOAP == "" {
+			ep.SOAP = SOAP12
+		}
+		if ep.Modelo != "" {
+			autorizador := ep.Autorizador
+			if ep.CUF != 0 {
+				autorizador = ""
 			}
+			r.modelos[modeloKey{autorizador, ep.CUF, ep.TpAmb, ep.Servico, ep.Modelo}] = ep
+		} else if ep.CUF != 0 {
+			r.ufEndpoints[overrideKey{ep.CUF, ep.TpAmb, ep.Servico}] = ep
+		} else {
+			r.endpoints[endpointKey{ep.Autorizador, ep.TpAmb, ep.Servico}] = ep
 		}
-	case Homologacao:
-		switch ws {
-		case ConsultaProtocolo:
-			switch cUF {
-			case 11, 12, 14, 15, 16, 17, 22, 23, 24, 25, 27, 28, 32, 33, 42, 53:
-				return urlHomConsSitNFeSVRS, nil
-			case 13:
-				return urlHomConsSitNFeAM, nil
-			case 21:
-				return urlHomConsSitNFeSVAN, nil
-			case 26:
-				return urlHomConsSitNFePE, nil
-			case 29:
-				return urlHomConsSitNFeBA, nil
-			case 31:
-				return urlHomConsSitNFeMG, nil
-			case 35:
-				return urlHomConsSitNFeSP, nil
-			case 41:
-				return urlHomConsSitNFePR, nil
-			case 43:
-				return urlHomConsSitNFeRS, nil
-			case 50:
-				return urlHomConsSitNFeMS, nil
-			case 51:
-				return urlHomConsSitNFeMT, nil
-			case 52:
-				return urlHomConsSitNFeGO, nil
+	}
+	for _, ind := range d.Indisponiveis {
+		for _, cUF := range ind.UFs {
+			if ind.TpAmb == 0 {
+				r.indisponiveis[overrideKey{cUF, Producao, ind.Servico}] = true
+				r.indisponiveis[overrideKey{cUF, Homologacao, ind.Servico}] = true
+			} else {
+				r.indisponiveis[overrideKey{cUF, ind.TpAmb, ind.Servico}] = true
 			}
-		case ConsultaStatus:
-			switch cUF {
-			case 11, 12, 14, 15, 16, 17, 22, 23, 24, 25, 27, 28, 32, 33, 42, 53:
-				return urlHomConsStatServSVRS, nil
-			case 13:
-				return urlHomConsStatServAM, nil
-			case 21:
-				return urlHomConsStatServSVAN, nil
-			case 26:
-				return urlHomConsStatServPE, nil
-			case 29:
-				return urlHomConsStatServBA, nil
-			case 31:
-				return urlHomConsStatServMG, nil
-			case 35:
-				return urlHomConsStatServSP, nil
-			case 41:
-				return urlHomConsStatServPR, nil
-			case 43:
-				return urlHomConsStatServRS, nil
-			case 50:
-				return urlHomConsStatServMS, nil
-			case 51:
-				return urlHomConsStatServMT, nil
-			case 52:
-				return urlHomConsStatServGO, nil
+		}
+	}
+
+	for _, u := range d.NFCe {
+		r.nfce[estadoKey{u.CUF, u.TpAmb}] = u
+	}
+
+	return r, nil
+}
+
+// LoadRegistry cria um Registry a partir de um arquivo JSON (ver NewRegistry).
+func LoadRegistry(path string) (*Registry, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("Erro na leitura da tabela de WebServices (%s). Detalhes: %w", path, err)
+	}
+	return NewRegistry(data)
+}
+
+// Autorizador retorna o autorizador da UF informada (ex.: "SP", "SVRS", "SVAN"), ou "" se a UF não for conhecida.
+func (r *Registry) Autorizador(cUF int) string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.autorizadores[cUF]
+}
+
+// AutorizadorContingencia retorna o autorizador de contingência (SVC-AN ou SVC-RS) da UF informada, ou "" se a UF não for conhecida.
+func (r *Registry) AutorizadorContingencia(cUF int) string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.contingencia[cUF]
+}
+
+// Autorizadores retorna, em ordem alfabética, os autorizadores das UFs (ex.: "SP", "SVRS", "SVAN", inclusive "AN", o Ambiente Nacional) e os autorizadores de contingência (SVC-AN e SVC-RS).
+func (r *Registry) Autorizadores() []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	vistos := map[string]bool{}
+	var lista []string
+	for _, m := range []map[int]string{r.autorizadores, r.contingencia} {
+		for _, a := range m {
+			if !vistos[a] {
+				vistos[a] = true
+				lista = append(lista, a)
 			}
-		case ConsultaCadastro:
-			switch cUF {
-			case 11, 12, 14, 15, 16, 17, 22, 23, 24, 25, 27, 28, 32, 33, 42, 53:
-				return urlHomConsCadSVRS, nil
-			case 26:
-				return urlHomConsCadPE, nil
-			case 29:
-				return urlHomConsCadBA, nil
-			case 35:
-				return urlHomConsCadSP, nil
-			case 41:
-				return urlHomConsCadPR, nil
-			case 43:
-				return urlHomConsCadRS, nil
-			case 50:
-				return urlHomConsCadMS, nil
-			case 51:
-				return urlHomConsCadMT, nil
-			case 52:
-				return urlHomConsCadGO, nil
+		}
+	}
+	sort.Strings(lista)
+	return lista
+}
+
+// UFs retorna, em ordem crescente, as UFs atendidas pelo autorizador informado, seja como autorizador normal ou de contingência (SVC-AN e SVC-RS).
+func (r *Registry) UFs(autorizador string) []int {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	var ufs []int
+	for _, m := range []map[int]string{r.autorizadores, r.contingencia} {
+		for cUF, a := range m {
+			if a == autorizador {
+				ufs = append(ufs, cUF)
 			}
 		}
 	}
+	sort.Ints(ufs)
+	return ufs
+}
+
+// Lookup retorna o WebService do serviço informado para a UF e o ambiente, considerando as substituições registradas com Override. Se a UF não oferecer o serviço, retorna *ErrServicoIndisponivelNaUF.
+func (r *Registry) Lookup(cUF int, tpAmb TAmb, ws TWebService) (Endpoint, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	ep, ok := r.ufEndpoints[overrideKey{cUF, tpAmb, ws}]
+	if !ok {
+		ep, ok = r.endpoints[endpointKey{r.autorizadores[cUF], tpAmb, ws}]
+	}
+	if !ok {
+		ep = Endpoint{Autorizador: r.autorizadores[cUF], TpAmb: tpAmb, Servico: ws, SOAP: SOAP12}
+	}
+	ep.CUF = cUF
+
+	if url, ok := r.override(cUF, tpAmb, ws); ok {
+		ep.URL = url
+		return ep, nil
+	}
+	if r.indisponiveis[overrideKey{cUF, tpAmb, ws}] {
+		return Endpoint{}, &ErrServicoIndisponivelNaUF{CUF: cUF, TpAmb: tpAmb, Servico: ws}
+	}
+	if ep.URL == "" {
+		return Endpoint{}, fmt.Errorf("WebService não encontrado: %v em %v", ws, cUF)
+	}
+
+	return ep, nil
+}
+
+// LookupModelo retorna o WebService do serviço para o modelo de documento informado ("55" ou "65"). Se não houver um WebService específico do modelo na UF, retorna o mesmo da Lookup.
+func (r *Registry) LookupModelo(cUF int, tpAmb TAmb, ws TWebService, mod string) (Endpoint, error) {
+	r.mu.RLock()
+	ep, ok := r.modelos[modeloKey{"", cUF, tpAmb, ws, mod}]
+	if !ok {
+		ep, ok = r.modelos[modeloKey{r.autorizadores[cUF], 0, tpAmb, ws, mod}]
+	}
+	if ok {
+		ep.CUF = cUF
+		if url, ok := r.override(cUF, tpAmb, ws); ok {
+			ep.URL = url
+		}
+	}
+	r.mu.RUnlock()
+
+	if ok {
+		return ep, nil
+	}
+	return r.Lookup(cUF, tpAmb, ws)
+}
+
+// LookupAutorizador retorna o WebService do serviço informado diretamente pelo nome do autorizador (ex.: "SVC-AN"). Como o autorizador não identifica uma UF, apenas as substituições registradas com Override para todas as UFs (cUF igual a zero) são consideradas.
+func (r *Registry) LookupAutorizador(autorizador string, tpAmb TAmb, ws TWebService) (Endpoint, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	ep, ok := r.endpoints[endpointKey{autorizador, tpAmb, ws}]
+	if !ok {
+		return Endpoint{}, fmt.Errorf("WebService não encontrado: %v em %s", ws, autorizador)
+	}
+	if url, ok := r.overrides[overrideKey{0, tpAmb, ws}]; ok {
+		ep.URL = url
+	}
+	return ep, nil
+}
+
+func (r *Registry) override(cUF int, tpAmb TAmb, ws TWebService) (string, bool) {
+	if url, ok := r.overrides[overrideKey{cUF, tpAmb, ws}]; ok {
+		return url, true
+	}
+	url, ok := r.overrides[overrideKey{0, tpAmb, ws}]
+	return url, ok
+}
+
+// Override substitui a URL do serviço informado para a UF e o ambiente. Com cUF igual a zero, a substituição vale para todas as UFs. As demais informações do WebService (versão, SOAP, envelope) são mantidas.
+func (r *Registry) Override(cUF int, tpAmb TAmb, ws TWebService, url string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.overrides[overrideKey{cUF, tpAmb, ws}] = url
+}
+
+// RemoveOverride desfaz uma substituição registrada com Override.
+func (r *Registry) RemoveOverride(cUF int, tpAmb TAmb, ws TWebService) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.overrides, overrideKey{cUF, tpAmb, ws})
+}
+
+// ClearOverrides desfaz todas as substituições registradas com Override.
+func (r *Registry) ClearOverrides() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.overrides = map[overrideKey]string{}
+}
+
+// URLsNFCe retorna as URLs do QR Code e de consulta pela chave de acesso da NFC-e na UF e no ambiente informados.
+func (r *Registry) URLsNFCe(cUF int, tpAmb TAmb) (URLsNFCe, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	u, ok := r.nfce[estadoKey{cUF, tpAmb}]
+	if !ok {
+		return URLsNFCe{}, fmt.Errorf("URLs da NFC-e não encontradas: UF %v, tpAmb %d", cUF, tpAmb)
+	}
+	return u, nil
+}
+
+// SetURLsNFCe substitui as URLs do QR Code e de consulta da NFC-e na UF e no ambiente informados, por exemplo quando a UF publica novos endereços.
+func (r *Registry) SetURLsNFCe(u URLsNFCe) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.nfce[estadoKey{u.CUF, u.TpAmb}] = u
+}
 
-	return "", fmt.Errorf("WebService não encontrado: %v em %v", ws, cUF)
+// getEndpoint obtem o WebService para o serviço e a UF informados, a partir do DefaultRegistry.
+func getEndpoint(cUF int, tpAmb TAmb, ws TWebService) (Endpoint, error) {
+	return DefaultRegistry.Lookup(cUF, tpAmb, ws)
 }
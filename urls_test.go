@@ -0,0 +1,169 @@
+package nfe
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+)
+
+var todasUFs = []int{11, 12, 13, 14, 15, 16, 17, 21, 22, 23, 24, 25, 26, 27, 28, 29, 31, 32, 33, 35, 41, 42, 43, 50, 51, 52, 53}
+
+func TestRegistryCobertura(t *testing.T) {
+	// Os serviços indisponíveis são os declarados em urls.json, cada um com a fonte da informação; todos os demais pares UF/serviço devem ter um WebService.
+	var d registryData
+	if err := json.Unmarshal(urlsJSON, &d); err != nil {
+		t.Fatal(err)
+	}
+	declarados := map[overrideKey]bool{}
+	for _, ind := range d.Indisponiveis {
+		if ind.Fonte == "" {
+			t.Errorf("indisponiveis %v %v: fonte não informada", ind.Servico, ind.UFs)
+		}
+		if ind.Servico != ConsultaCadastro {
+			t.Errorf("indisponiveis %v %v: apenas a consulta de cadastro é opcional para as UFs", ind.Servico, ind.UFs)
+		}
+		for _, cUF := range ind.UFs {
+			for _, tpAmb := range []TAmb{Producao, Homologacao} {
+				if ind.TpAmb == 0 || ind.TpAmb == tpAmb {
+					declarados[overrideKey{cUF, tpAmb, ind.Servico}] = true
+				}
+			}
+		}
+	}
+	servicos := []TWebService{ConsultaStatus, ConsultaProtocolo, ConsultaCadastro, Autorizacao, RetAutorizacao, Evento, Inutilizacao}
+
+	for _, tpAmb := range []TAmb{Producao, Homologacao} {
+		for _, ws := range servicos {
+			for _, cUF := range todasUFs {
+				ep, err := DefaultRegistry.Lookup(cUF, tpAmb, ws)
+
+				if declarados[overrideKey{cUF, tpAmb, ws}] {
+					var errInd *ErrServicoIndisponivelNaUF
+					if !errors.As(err, &errInd) {
+						t.Errorf("%s/%v/%d: esperado ErrServicoIndisponivelNaUF, obtido %v (%s)", GetUF(cUF), ws, tpAmb, err, ep.URL)
+					}
+					continue
+				}
+
+				if err != nil {
+					t.Errorf("%s/%v/%d: %v", GetUF(cUF), ws, tpAmb, err)
+					continue
+				}
+				if !strings.HasPrefix(ep.URL, "https://") {
+					t.Errorf("%s/%v/%d: URL inválida: %q", GetUF(cUF), ws, tpAmb, ep.URL)
+				}
+				if ep.CUF != cUF || ep.TpAmb != tpAmb || ep.Servico != ws || ep.Versao == "" || ep.Autorizador == "" {
+					t.Errorf("%s/%v/%d: WebService incompleto: %+v", GetUF(cUF), ws, tpAmb, ep)
+				}
+			}
+		}
+
+		for _, ws := range []TWebService{Evento, DistribuicaoDFe} {
+			if ep, err := DefaultRegistry.Lookup(91, tpAmb, ws); err != nil || ep.Autorizador != "AN" {
+				t.Errorf("AN/%v/%d: %v (%+v)", ws, tpAmb, err, ep)
+			}
+		}
+	}
+}
+
+func TestRegistryContingencia(t *testing.T) {
+	for _, cUF := range todasUFs {
+		svc := DefaultRegistry.AutorizadorContingencia(cUF)
+		if svc != "SVC-AN" && svc != "SVC-RS" {
+			t.Errorf("%s: autorizador de contingência inválido: %q", GetUF(cUF), svc)
+			continue
+		}
+		for _, tpAmb := range []TAmb{Producao, Homologacao} {
+			for _, ws := range []TWebService{ConsultaStatus, ConsultaProtocolo, Autorizacao, RetAutorizacao, Evento} {
+				if _, err := DefaultRegistry.LookupAutorizador(svc, tpAmb, ws); err != nil {
+					t.Errorf("%s/%v/%d: %v", svc, ws, tpAmb, err)
+				}
+			}
+		}
+	}
+}
+
+func TestRegistryAutorizadores(t *testing.T) {
+	esperados := "AM AN BA GO MG MS MT PE PR RS SP SVAN SVC-AN SVC-RS SVRS"
+	if got := strings.Join(DefaultRegistry.Autorizadores(), " "); got != esperados {
+		t.Errorf("Autorizadores() = %s", got)
+	}
+
+	total := 0
+	for _, a := range DefaultRegistry.Autorizadores() {
+		ufs := DefaultRegistry.UFs(a)
+		if len(ufs) == 0 {
+			t.Errorf("%s: nenhuma UF", a)
+		}
+		if !strings.HasPrefix(a, "SVC-") {
+			total += len(ufs)
+		}
+	}
+	if total != len(todasUFs)+1 {
+		t.Errorf("%d UFs nos autorizadores normais, esperado %d", total, len(todasUFs)+1)
+	}
+	if got := DefaultRegistry.UFs("SVAN"); len(got) != 1 || got[0] != 21 {
+		t.Errorf("UFs(SVAN) = %v", got)
+	}
+}
+
+func TestRegistryOverride(t *testing.T) {
+	r := mustNewRegistry(urlsJSON)
+
+	r.Override(0, Homologacao, ConsultaCadastro, "http://localhost/cad")
+	ep, err := r.Lookup(13, Homologacao, ConsultaCadastro)
+	if err != nil || ep.URL != "http://localhost/cad" {
+		t.Errorf("override para todas as UFs não aplicado: %v (%+v)", err, ep)
+	}
+
+	r.Override(51, Homologacao, ConsultaCadastro, "http://localhost/cad-mt")
+	ep, err = r.Lookup(51, Homologacao, ConsultaCadastro)
+	if err != nil || ep.URL != "http://localhost/cad-mt" || ep.Envelope != envelopeConsCadMT {
+		t.Errorf("override da UF deveria manter o envelope: %v (%+v)", err, ep)
+	}
+
+	r.ClearOverrides()
+	if _, err := r.Lookup(13, Homologacao, ConsultaCadastro); err == nil {
+		t.Errorf("ClearOverrides não desfez a substituição")
+	}
+}
+
+func TestRegistryLookupAutorizadorOverride(t *testing.T) {
+	r := mustNewRegistry(urlsJSON)
+
+	r.Override(35, Homologacao, ConsultaStatus, "http://localhost/sp")
+	ep, err := r.LookupAutorizador("SVC-AN", Homologacao, ConsultaStatus)
+	if err != nil || ep.URL == "http://localhost/sp" {
+		t.Errorf("override de uma UF não deveria valer para o autorizador: %v (%+v)", err, ep)
+	}
+
+	r.Override(0, Homologacao, ConsultaStatus, "http://localhost/status")
+	ep, err = r.LookupAutorizador("SVC-AN", Homologacao, ConsultaStatus)
+	if err != nil || ep.URL != "http://localhost/status" || ep.Autorizador != "SVC-AN" {
+		t.Errorf("override para todas as UFs não aplicado: %v (%+v)", err, ep)
+	}
+	if ep, _ := r.LookupAutorizador("SVC-AN", Producao, ConsultaStatus); ep.URL == "http://localhost/status" {
+		t.Errorf("override de homologação aplicado em produção: %+v", ep)
+	}
+
+	r.RemoveOverride(0, Homologacao, ConsultaStatus)
+	if ep, _ := r.LookupAutorizador("SVC-AN", Homologacao, ConsultaStatus); !strings.HasPrefix(ep.URL, "https://") {
+		t.Errorf("RemoveOverride não desfez a substituição: %+v", ep)
+	}
+}
+
+func TestRegistryDuplicado(t *testing.T) {
+	tabela := `{"autorizadores": {"31": "MG"}, "webservices": [
+		{"autorizador": "MG", "ambiente": 2, "servico": "ConsultaCadastro", "versao": "2.00", "url": "https://hnfe.fazenda.mg.gov.br/nfe2/services/CadConsultaCadastro4"},
+		{"autorizador": "MG", "ambiente": 2, "servico": "ConsultaCadastro", "versao": "2.00", "url": "https://hnfe.fazenda.mg.gov.br/nfe2/services/CadConsultaCadastro4"}
+	]}`
+	if _, err := NewRegistry([]byte(tabela)); err == nil || !strings.Contains(err.Error(), "duplicado") {
+		t.Errorf("NewRegistry com WebService duplicado = %v", err)
+	}
+
+	ep, err := DefaultRegistry.Lookup(31, Homologacao, ConsultaCadastro)
+	if err != nil || ep.Envelope != envelopeConsCadMG {
+		t.Errorf("MG/ConsultaCadastro/2: %v (%+v)", err, ep)
+	}
+}
@@ -2,13 +2,42 @@ package nfe
 
 import "fmt"
 
+// WSError representa uma falha na comunicação com o WebService da Sefaz: um status HTTP diferente de 200 ou um SOAP Fault (versões 1.1 e 1.2), que pode vir inclusive em respostas com status 200.
 type WSError struct {
 	Url           string
 	StatusCode    int
 	StatusMessage string
 	Body          string
+
+	// Code, Reason e Detail são preenchidos quando a resposta contém um SOAP Fault (faultcode/faultstring/detail no SOAP 1.1 e Code/Reason/Detail no SOAP 1.2).
+	Code   string
+	Reason string
+	Detail string
+
+	// CUF e Servico identificam a UF (ou 91 para o Ambiente Nacional) e o serviço que produziram o erro.
+	CUF     int
+	Servico TWebService
 }
 
 func (e *WSError) Error() string {
-	return fmt.Sprintf("Falha na consulta à receita (url '%s'): %d - %s", e.Url, e.StatusCode, e.StatusMessage)
+	if e.Reason != "" || e.Code != "" {
+		return fmt.Sprintf("Falha na consulta à receita (url '%s', serviço %v, UF %s): %d - %s. SOAP Fault %s: %s", e.Url, e.Servico, siglaOrgao(e.CUF), e.StatusCode, e.StatusMessage, e.Code, e.Reason)
+	}
+	return fmt.Sprintf("Falha na consulta à receita (url '%s', serviço %v, UF %s): %d - %s", e.Url, e.Servico, siglaOrgao(e.CUF), e.StatusCode, e.StatusMessage)
+}
+
+// IsFault indica se o erro foi originado por um SOAP Fault retornado pelo WebService.
+func (e *WSError) IsFault() bool {
+	return e.Code != "" || e.Reason != ""
+}
+
+// siglaOrgao retorna a sigla da UF ou "AN" para o código 91 (Ambiente Nacional), usado apenas para formatação de mensagens.
+func siglaOrgao(cUF int) string {
+	if cUF == 91 {
+		return "AN"
+	}
+	if uf := GetUF(cUF); uf != "" {
+		return uf
+	}
+	return fmt.Sprint(cUF)
 }